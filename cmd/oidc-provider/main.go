@@ -0,0 +1,26 @@
+// oidc-provider 在本地启动一个OpenID Connect身份提供方，用于在开发环境中测试单点登录。
+//
+// 默认参数与 config/config.json 中的 oidc 配置一致，启动后将 oidc.enabled 改为 true 即可：
+//
+//	go run ./cmd/oidc-provider -addr :9000
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/xiaohan1995/Gin-blog/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "监听地址")
+	issuer := flag.String("issuer", "http://localhost:9000", "签发者地址，需要与博客配置的 oidc.issuer 一致")
+	clientID := flag.String("client-id", "gin-blog", "客户端ID")
+	clientSecret := flag.String("client-secret", "gin-blog-secret", "客户端密钥")
+	flag.Parse()
+
+	provider := oidctest.New(*issuer, *clientID, *clientSecret)
+	log.Println("本地身份提供方已启动:", *issuer)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -0,0 +1,27 @@
+// s3-server 在本地启动一个S3兼容的对象存储，文件保存在内存中，用于在开发环境中测试对象存储。
+//
+// 默认参数与 config/config.json 中的 storage.s3 配置一致，启动后将 storage.driver 改为 s3 即可：
+//
+//	go run ./cmd/s3-server -addr :9100
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/xiaohan1995/Gin-blog/s3test"
+)
+
+func main() {
+	addr := flag.String("addr", ":9100", "监听地址")
+	accessKey := flag.String("access-key", "gin-blog", "访问密钥ID")
+	secretKey := flag.String("secret-key", "gin-blog-secret", "访问密钥")
+	region := flag.String("region", "us-east-1", "区域")
+	bucket := flag.String("bucket", "gin-blog", "预先创建的存储桶")
+	flag.Parse()
+
+	server := s3test.New(*accessKey, *secretKey, *region, *bucket)
+	log.Println("本地对象存储已启动:", *addr, "存储桶:", *bucket)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -0,0 +1,47 @@
+// static-export 将公开页面导出为静态站点，用于在只支持静态文件的托管服务上部署只读镜像。
+//
+// 输出目录、静态站点地址读取 config/config.json 中的 static，主题读取 theme：
+//
+//	go run ./cmd/static-export -base-url https://mirror.example.com/blog
+//	go run ./cmd/static-export -full
+//
+// 再次运行时只重新渲染上次生成之后有修改的文章，删除已经不存在的页面。
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/service"
+	"github.com/xiaohan1995/Gin-blog/staticsite"
+)
+
+func main() {
+	baseURL := config.Conf.Static.BaseURL
+	if baseURL == "" {
+		baseURL = config.Conf.Site.BaseURL
+	}
+	out := flag.String("out", config.Conf.Static.Dir, "输出目录")
+	flag.StringVar(&baseURL, "base-url", baseURL, "静态站点的访问地址，页面中的链接、订阅源和sitemap使用该地址")
+	statics := flag.String("statics", "statics", "复制到静态站点的statics目录，为空时不复制")
+	full := flag.Bool("full", false, "忽略上次生成的结果，重新生成全部页面")
+	flag.Parse()
+
+	models.InitDB()
+	service.InitStorage()
+	result, err := staticsite.Build(staticsite.Options{
+		Dir:        *out,
+		BaseURL:    baseURL,
+		ThemeDir:   filepath.Join(config.Conf.Theme.Dir, config.Conf.Theme.Name),
+		StaticsDir: *statics,
+		Full:       *full,
+	})
+	log.Printf("渲染页面 %d 个，保留未修改的文章 %d 篇，写入文件 %d 个，删除文件 %d 个", result.Pages, result.Skipped, result.Written, result.Removed)
+	if err != nil {
+		log.Fatalln("导出静态站点失败:", err)
+	}
+	log.Println("静态站点已导出到", *out)
+}
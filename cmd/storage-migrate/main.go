@@ -0,0 +1,66 @@
+// storage-migrate 将上传的文件（头像、媒体文件和数据导出）从一种存储迁移到另一种存储。
+//
+// 两种存储的参数分别读取 config/config.json 中的 storage.local 和 storage.s3，迁移完成后修改 storage.driver 并重启服务：
+//
+//	go run ./cmd/storage-migrate -from local -to s3 -dry-run
+//	go run ./cmd/storage-migrate -from local -to s3
+//
+// 目标存储中已存在且大小相同的文件会跳过，中断后可以重新运行。
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/xiaohan1995/Gin-blog/service"
+	"github.com/xiaohan1995/Gin-blog/storage"
+)
+
+func main() {
+	from := flag.String("from", "local", "源存储：local、s3")
+	to := flag.String("to", "s3", "目标存储：local、s3")
+	prefix := flag.String("prefix", "", "只迁移键名以此开头的文件，如 media/，默认迁移全部文件")
+	remove := flag.Bool("delete", false, "复制成功后删除源存储中的文件")
+	dryRun := flag.Bool("dry-run", false, "只列出需要迁移的文件，不复制")
+	verbose := flag.Bool("v", false, "输出每个迁移的文件")
+	flag.Parse()
+
+	if *from == *to {
+		log.Fatalln("源存储和目标存储不能相同")
+	}
+	src, err := service.NewStorage(*from)
+	if err != nil {
+		log.Fatalln("源存储配置有误:", err)
+	}
+	dst, err := service.NewStorage(*to)
+	if err != nil {
+		log.Fatalln("目标存储配置有误:", err)
+	}
+	prefixes := service.StorageKeyPrefixes
+	if *prefix != "" {
+		prefixes = nil
+		for _, p := range service.StorageKeyPrefixes {
+			if strings.HasPrefix(*prefix, p) || strings.HasPrefix(p, *prefix) {
+				prefixes = append(prefixes, *prefix)
+				break
+			}
+		}
+		if prefixes == nil {
+			log.Fatalln("前缀不属于任何上传文件:", *prefix)
+		}
+	}
+
+	result, err := service.MigrateStorage(src, dst, prefixes, *remove, *dryRun, func(info storage.Info) {
+		if *verbose || *dryRun {
+			log.Println(info.Key, info.Size)
+		}
+	})
+	log.Printf("迁移文件 %d 个（%d 字节），跳过已存在的文件 %d 个", result.Files, result.Bytes, result.Skipped)
+	if err != nil {
+		log.Fatalln("迁移失败:", err)
+	}
+	if *dryRun {
+		log.Println("dry-run模式，没有复制任何文件")
+	}
+}
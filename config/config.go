@@ -0,0 +1,427 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// CommentConfig 评论相关配置
+type CommentConfig struct {
+	// 站点默认的评论审核模式：open(直接公开)、first_time(首次评论需审核)、all(全部需审核)
+	Moderation string `json:"moderation"`
+}
+
+// SpamConfig 垃圾评论过滤配置
+type SpamConfig struct {
+	Enabled bool `json:"enabled"`
+	// 评论中允许的最大链接数量
+	MaxLinks int `json:"max_links"`
+	// 屏蔽词列表，不区分大小写
+	Blocklist []string `json:"blocklist"`
+	// 重复内容检测的时间窗口（分钟），0表示不限时间
+	DuplicateWindow int `json:"duplicate_window"`
+	// 频率检测的时间窗口（秒）及窗口内每个IP、每个用户允许的评论数
+	VelocityWindow int `json:"velocity_window"`
+	IPLimit        int `json:"ip_limit"`
+	UserLimit      int `json:"user_limit"`
+	// 贝叶斯分类器生效所需的最少训练样本数（垃圾和正常评论各自）
+	BayesMinTrain int `json:"bayes_min_train"`
+	// 得分达到HoldScore时进入审核队列，达到SpamScore时直接标记为垃圾评论
+	HoldScore float64 `json:"hold_score"`
+	SpamScore float64 `json:"spam_score"`
+}
+
+// SiteConfig 站点配置
+type SiteConfig struct {
+	Name string `json:"name"`
+	// 站点的外部访问地址，用于生成邮件等场景中的完整链接
+	BaseURL string `json:"base_url"`
+	// 站点简介，显示在公开页面的首页
+	Description string `json:"description"`
+	// 公开接口和页面每页显示的文章数量
+	PostsPerPage int `json:"posts_per_page"`
+}
+
+// SMTPConfig SMTP服务器配置
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// MailConfig 邮件发送配置
+type MailConfig struct {
+	// 发送方式：smtp、file（写入目录，便于离线调试）、memory（保存在内存中，用于测试）
+	Transport string     `json:"transport"`
+	From      string     `json:"from"`
+	SMTP      SMTPConfig `json:"smtp"`
+	// file方式下邮件保存的目录
+	FileDir string `json:"file_dir"`
+}
+
+// SessionConfig 登录会话配置
+type SessionConfig struct {
+	// 登录方式：token（在响应中返回令牌）、cookie（使用HttpOnly Cookie）、both（两者同时使用）
+	Mode string `json:"mode"`
+	// Cookie是否只通过HTTPS发送，生产环境应开启
+	Secure bool `json:"secure"`
+	// Cookie的SameSite策略：lax、strict
+	SameSite string `json:"same_site"`
+	Domain   string `json:"domain"`
+}
+
+// UserConfig 用户相关配置
+type UserConfig struct {
+	// 新注册用户的默认角色：admin、editor、author、reader
+	DefaultRole string `json:"default_role"`
+	// 是否需要验证邮箱后才能发布文章
+	RequireVerification bool `json:"require_verification"`
+	// 邮箱验证链接和重置密码链接的有效期（分钟）
+	VerifyTTL int `json:"verify_ttl"`
+	ResetTTL  int `json:"reset_ttl"`
+	// 个人访问令牌的最长有效期（天），0表示允许创建永不过期的令牌
+	AccessTokenMaxDays int `json:"access_token_max_days"`
+	// 头像缩放后的边长（像素）
+	AvatarSize int `json:"avatar_size"`
+	// 上传头像的最大文件大小（KB）
+	AvatarMaxKB int `json:"avatar_max_kb"`
+	// 个人数据导出下载链接的有效期（小时）
+	ExportTTL int `json:"export_ttl"`
+}
+
+// PasswordConfig 密码哈希和密码策略配置
+type PasswordConfig struct {
+	// 新密码使用的哈希算法：argon2id、bcrypt。修改后旧密码仍然可以验证，并在用户下次登录时重新哈希
+	Algorithm string `json:"algorithm"`
+	// argon2id的内存（KB）、迭代次数和并行度
+	Argon2Memory  uint32 `json:"argon2_memory"`
+	Argon2Time    uint32 `json:"argon2_time"`
+	Argon2Threads uint8  `json:"argon2_threads"`
+	BcryptCost    int    `json:"bcrypt_cost"`
+	// 密码的最小长度（字符数）
+	MinLength int `json:"min_length"`
+	// 已泄露密码列表文件，每行一个密码的SHA-1哈希（可以带有Have I Been Pwned格式的":次数"后缀），为空时不检查
+	BreachedList string `json:"breached_list"`
+}
+
+// LoginConfig 登录失败限制配置，失败次数在Window时间窗口内统计，
+// 账号的失败次数在登录成功或管理员解锁后重新计算
+type LoginConfig struct {
+	// 统计失败次数的时间窗口（分钟）
+	Window int `json:"window"`
+	// 同一账号失败达到BackoffAfter次后，每次登录需要等待的时间从BackoffBase秒开始翻倍，最多BackoffMax秒
+	BackoffAfter int `json:"backoff_after"`
+	BackoffBase  int `json:"backoff_base"`
+	BackoffMax   int `json:"backoff_max"`
+	// 同一账号失败达到LockoutThreshold次后锁定LockoutDuration分钟
+	LockoutThreshold int `json:"lockout_threshold"`
+	LockoutDuration  int `json:"lockout_duration"`
+	// 同一IP的退避和锁定阈值，统计该IP对所有账号的失败次数
+	IPBackoffAfter     int `json:"ip_backoff_after"`
+	IPLockoutThreshold int `json:"ip_lockout_threshold"`
+	// 登录记录的保留天数
+	HistoryDays int `json:"history_days"`
+}
+
+// WebAuthnConfig 通行密钥配置
+type WebAuthnConfig struct {
+	// 依赖方ID，通常为站点的域名，不包含协议和端口
+	RPID string `json:"rp_id"`
+	// 依赖方名称，为空时使用站点名称
+	RPName string `json:"rp_name"`
+	// 允许发起请求的来源，为空时使用站点的访问地址
+	Origins []string `json:"origins"`
+}
+
+// OIDCConfig OpenID Connect单点登录配置，使用授权码模式和PKCE
+type OIDCConfig struct {
+	Enabled bool `json:"enabled"`
+	// 登录按钮上显示的身份提供方名称
+	Name string `json:"name"`
+	// 身份提供方的签发者地址，从 /.well-known/openid-configuration 获取其他地址
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// 回调地址，需要在身份提供方登记，为空时使用站点访问地址加 /auth/oidc/callback
+	RedirectURL string   `json:"redirect_url"`
+	Scopes      []string `json:"scopes"`
+	// ID令牌中用户组所在的声明
+	GroupsClaim string `json:"groups_claim"`
+	// 用户组对应的博客角色，配置后每次登录都按用户组同步角色，没有匹配的用户组时使用默认角色
+	GroupRoles map[string]string `json:"group_roles"`
+	// 没有对应账号时是否自动创建
+	AutoProvision bool `json:"auto_provision"`
+}
+
+// OAuthConfig 作为OAuth2授权服务器时的配置
+type OAuthConfig struct {
+	// 访问令牌有效期（分钟）
+	AccessTTL int `json:"access_ttl"`
+	// 刷新令牌有效期（小时）
+	RefreshTTL int `json:"refresh_ttl"`
+}
+
+// JWTConfig 令牌相关配置
+type JWTConfig struct {
+	// 签名算法：RS256、EdDSA，或使用共享密钥的HS256
+	Algorithm string `json:"algorithm"`
+	// HS256使用的共享密钥
+	Secret string `json:"secret"`
+	// RS256密钥长度
+	RSABits int `json:"rsa_bits"`
+	// 令牌的签发者和接收方，解析时会校验
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// 签名密钥的轮换周期（小时），0表示不自动轮换
+	RotationInterval int `json:"rotation_interval"`
+	// 轮换后旧密钥继续用于验证的宽限期（小时）
+	GracePeriod int `json:"grace_period"`
+	// 访问令牌有效期（分钟）
+	AccessTTL int `json:"access_ttl"`
+	// 刷新令牌有效期（小时）
+	RefreshTTL int `json:"refresh_ttl"`
+}
+
+// ThemeConfig 公开页面的主题配置
+type ThemeConfig struct {
+	// 主题所在的目录，每个主题为其中的一个子目录
+	Dir  string `json:"dir"`
+	Name string `json:"name"`
+	// 开发模式，每次请求时重新加载模板，修改模板后不需要重启服务
+	Reload bool `json:"reload"`
+}
+
+// FeedConfig 订阅源配置
+type FeedConfig struct {
+	// 订阅源中的文章数量，最多50篇
+	Items int `json:"items"`
+	// 订阅源中包含文章全文，为false时只包含摘要。请求时可以使用content=full或content=summary参数指定
+	FullContent bool `json:"full_content"`
+}
+
+// SEOConfig 搜索引擎相关配置
+type SEOConfig struct {
+	// robots.txt的完整内容，为空时根据RobotsDisallow生成，并附上sitemap的地址
+	Robots string `json:"robots"`
+	// 禁止搜索引擎抓取的路径前缀
+	RobotsDisallow []string `json:"robots_disallow"`
+	// 站点的Twitter账号，如@gin_blog，用于Twitter卡片
+	TwitterSite string `json:"twitter_site"`
+}
+
+// MediaConfig 媒体库配置
+type MediaConfig struct {
+	// 上传文件的最大大小（MB）
+	MaxMB int `json:"max_mb"`
+	// 缩略图的边长（像素），缩略图居中裁剪为正方形
+	ThumbnailSize int `json:"thumbnail_size"`
+	// 响应式图片的宽度（像素），只生成小于原图宽度的尺寸
+	Widths []int `json:"widths"`
+	// 没有被文章引用的媒体文件在上传多少小时后自动清理，0表示不自动清理
+	GCAfterHours int `json:"gc_after_hours"`
+}
+
+// StorageConfig 上传文件（头像、媒体文件和数据导出）的存储配置
+type StorageConfig struct {
+	// 存储方式：local（本地目录）、s3（兼容S3协议的对象存储）
+	Driver string             `json:"driver"`
+	Local  LocalStorageConfig `json:"local"`
+	S3     S3StorageConfig    `json:"s3"`
+	// 私有文件（数据导出）签名下载链接的有效期（分钟）
+	SignedURLTTL int `json:"signed_url_ttl"`
+}
+
+// LocalStorageConfig 本地存储配置
+type LocalStorageConfig struct {
+	// 文件保存的根目录，头像、媒体文件和数据导出分别保存在其中的avatars、media和exports目录
+	Dir string `json:"dir"`
+}
+
+// S3StorageConfig 对象存储配置
+type S3StorageConfig struct {
+	// 服务地址，如 https://s3.us-east-1.amazonaws.com
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// 使用 服务地址/存储桶/键名 形式的地址，MinIO等自建服务通常需要开启
+	PathStyle bool `json:"path_style"`
+}
+
+// StaticConfig 静态站点导出配置
+type StaticConfig struct {
+	// 生成的静态站点保存的目录
+	Dir string `json:"dir"`
+	// 静态站点的访问地址，页面中的链接、订阅源和sitemap使用该地址，为空时使用site.base_url
+	BaseURL string `json:"base_url"`
+}
+
+// Config 全局配置
+type Config struct {
+	Site     SiteConfig     `json:"site"`
+	Theme    ThemeConfig    `json:"theme"`
+	Feed     FeedConfig     `json:"feed"`
+	SEO      SEOConfig      `json:"seo"`
+	Media    MediaConfig    `json:"media"`
+	Storage  StorageConfig  `json:"storage"`
+	Static   StaticConfig   `json:"static"`
+	Mail     MailConfig     `json:"mail"`
+	JWT      JWTConfig      `json:"jwt"`
+	Session  SessionConfig  `json:"session"`
+	User     UserConfig     `json:"user"`
+	Password PasswordConfig `json:"password"`
+	Login    LoginConfig    `json:"login"`
+	WebAuthn WebAuthnConfig `json:"webauthn"`
+	OIDC     OIDCConfig     `json:"oidc"`
+	OAuth    OAuthConfig    `json:"oauth"`
+	Comment  CommentConfig  `json:"comment"`
+	Spam     SpamConfig     `json:"spam"`
+}
+
+// Conf 全局配置实例
+var Conf *Config
+
+func init() {
+	// 默认配置
+	Conf = &Config{
+		Site: SiteConfig{
+			Name:         "Gin Blog",
+			BaseURL:      "http://localhost:8081",
+			Description:  "一个使用Gin和GORM开发的博客",
+			PostsPerPage: 10,
+		},
+		Theme: ThemeConfig{
+			Dir:  "themes",
+			Name: "default",
+		},
+		Feed: FeedConfig{
+			Items:       20,
+			FullContent: true,
+		},
+		Media: MediaConfig{
+			MaxMB:         10,
+			ThumbnailSize: 150,
+			Widths:        []int{480, 960, 1600},
+			GCAfterHours:  168,
+		},
+		Storage: StorageConfig{
+			Driver: "local",
+			Local: LocalStorageConfig{
+				Dir: "uploads",
+			},
+			S3: S3StorageConfig{
+				Endpoint:  "http://localhost:9100",
+				Region:    "us-east-1",
+				Bucket:    "gin-blog",
+				AccessKey: "gin-blog",
+				SecretKey: "gin-blog-secret",
+				PathStyle: true,
+			},
+			SignedURLTTL: 10,
+		},
+		Static: StaticConfig{
+			Dir: "public",
+		},
+		SEO: SEOConfig{
+			RobotsDisallow: []string{
+				"/api/", "/admin", "/users", "/posts", "/comments", "/security", "/profile", "/post-detail/",
+				"/login", "/register", "/forgot-password", "/reset-password", "/oauth/", "/auth/", "/exports/", "/files/",
+			},
+		},
+		Mail: MailConfig{
+			Transport: "file",
+			From:      "Gin Blog <noreply@localhost>",
+			SMTP: SMTPConfig{
+				Port: 587,
+			},
+			FileDir: "mail",
+		},
+		JWT: JWTConfig{
+			Algorithm:        "RS256",
+			Secret:           "your_secret_key_here",
+			RSABits:          2048,
+			Issuer:           "gin-blog",
+			Audience:         "gin-blog",
+			RotationInterval: 30 * 24,
+			GracePeriod:      24,
+			AccessTTL:        15,
+			RefreshTTL:       30 * 24,
+		},
+		Session: SessionConfig{
+			Mode:     "both",
+			SameSite: "lax",
+		},
+		User: UserConfig{
+			DefaultRole:         "author",
+			RequireVerification: true,
+			VerifyTTL:           48 * 60,
+			ResetTTL:            60,
+			AccessTokenMaxDays:  365,
+			AvatarSize:          256,
+			AvatarMaxKB:         2048,
+			ExportTTL:           72,
+		},
+		Password: PasswordConfig{
+			Algorithm:     "argon2id",
+			Argon2Memory:  64 * 1024,
+			Argon2Time:    3,
+			Argon2Threads: 2,
+			BcryptCost:    10,
+			MinLength:     8,
+			BreachedList:  "config/breached-passwords.txt",
+		},
+		Login: LoginConfig{
+			Window:             15,
+			BackoffAfter:       3,
+			BackoffBase:        1,
+			BackoffMax:         300,
+			LockoutThreshold:   10,
+			LockoutDuration:    15,
+			IPBackoffAfter:     10,
+			IPLockoutThreshold: 50,
+			HistoryDays:        90,
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID: "localhost",
+		},
+		OIDC: OIDCConfig{
+			Name:          "企业账号",
+			Scopes:        []string{"openid", "profile", "email"},
+			GroupsClaim:   "groups",
+			AutoProvision: true,
+		},
+		OAuth: OAuthConfig{
+			AccessTTL:  60,
+			RefreshTTL: 30 * 24,
+		},
+		Comment: CommentConfig{
+			Moderation: "open",
+		},
+		Spam: SpamConfig{
+			Enabled:         true,
+			MaxLinks:        2,
+			DuplicateWindow: 24 * 60,
+			VelocityWindow:  60,
+			IPLimit:         5,
+			UserLimit:       3,
+			BayesMinTrain:   10,
+			HoldScore:       1,
+			SpamScore:       2,
+		},
+	}
+
+	// 如果存在配置文件，则使用配置文件覆盖默认值
+	data, err := os.ReadFile("config/config.json")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("读取配置文件失败:", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, Conf); err != nil {
+		log.Fatalln("解析配置文件失败:", err)
+	}
+}
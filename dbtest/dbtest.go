@@ -0,0 +1,36 @@
+// Package dbtest 使用SQLite临时数据库代替MySQL，用于在Go测试中运行依赖models.DB的代码。
+package dbtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open 在测试的临时目录中创建数据库并迁移全部数据表，将models.DB替换为该数据库，测试结束后恢复
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=1"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("打开测试数据库失败:", err)
+	}
+	if err := models.AutoMigrate(db); err != nil {
+		t.Fatal("迁移测试数据库失败:", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := models.DB
+	models.DB = db
+	t.Cleanup(func() {
+		models.DB = old
+		sqlDB.Close()
+	})
+	return db
+}
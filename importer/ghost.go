@@ -0,0 +1,231 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Ghost不同版本导出的ID分别为数字和字符串
+type ghostID string
+
+func (id *ghostID) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch x := v.(type) {
+	case string:
+		*id = ghostID(x)
+	case float64:
+		*id = ghostID(fmt.Sprintf("%.0f", x))
+	case nil:
+		*id = ""
+	default:
+		return fmt.Errorf("无效的ID: %s", data)
+	}
+	return nil
+}
+
+type ghostData struct {
+	Posts []struct {
+		ID          ghostID     `json:"id"`
+		Title       string      `json:"title"`
+		HTML        string      `json:"html"`
+		Mobiledoc   string      `json:"mobiledoc"`
+		Plaintext   string      `json:"plaintext"`
+		Status      string      `json:"status"`
+		Type        string      `json:"type"`
+		Page        interface{} `json:"page"`
+		AuthorID    ghostID     `json:"author_id"`
+		CreatedAt   interface{} `json:"created_at"`
+		UpdatedAt   interface{} `json:"updated_at"`
+		PublishedAt interface{} `json:"published_at"`
+		// 较早的版本中SEO设置保存在文章中，之后移到posts_meta
+		MetaDescription string `json:"meta_description"`
+		CustomExcerpt   string `json:"custom_excerpt"`
+		CanonicalURL    string `json:"canonical_url"`
+		FeatureImage    string `json:"feature_image"`
+		OGImage         string `json:"og_image"`
+	} `json:"posts"`
+	PostsMeta []struct {
+		PostID          ghostID `json:"post_id"`
+		MetaDescription string  `json:"meta_description"`
+		OGImage         string  `json:"og_image"`
+	} `json:"posts_meta"`
+	Users []struct {
+		ID      ghostID `json:"id"`
+		Name    string  `json:"name"`
+		Slug    string  `json:"slug"`
+		Email   string  `json:"email"`
+		Bio     string  `json:"bio"`
+		Website string  `json:"website"`
+	} `json:"users"`
+	Tags []struct {
+		ID         ghostID `json:"id"`
+		Name       string  `json:"name"`
+		Visibility string  `json:"visibility"`
+	} `json:"tags"`
+	PostsTags []struct {
+		PostID    ghostID `json:"post_id"`
+		TagID     ghostID `json:"tag_id"`
+		SortOrder int     `json:"sort_order"`
+	} `json:"posts_tags"`
+	PostsAuthors []struct {
+		PostID    ghostID `json:"post_id"`
+		AuthorID  ghostID `json:"author_id"`
+		SortOrder int     `json:"sort_order"`
+	} `json:"posts_authors"`
+	Settings []struct {
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+	} `json:"settings"`
+}
+
+type ghostExport struct {
+	Data ghostData `json:"data"`
+}
+
+// ParseGhostFile 解析Ghost导出的JSON文件
+func ParseGhostFile(path string) (*Site, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseGhost(f)
+}
+
+// ParseGhost 解析Ghost导出的JSON，只导入文章，已发布以外的状态导入为草稿。
+// 使用Markdown卡片编写的文章直接使用其中的Markdown，否则将HTML转换为Markdown。
+// 导出文件中不包含会员的评论，评论不会导入
+func ParseGhost(r io.Reader) (*Site, error) {
+	var raw struct {
+		DB []ghostExport `json:"db"`
+		ghostExport
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("解析Ghost导出文件失败: %w", err)
+	}
+	data := raw.Data
+	if len(raw.DB) > 0 {
+		data = raw.DB[0].Data
+	}
+
+	site := &Site{Source: "ghost"}
+	for _, s := range data.Settings {
+		if title, ok := s.Value.(string); ok && s.Key == "title" && title != "" {
+			site.Source += ":" + title
+		}
+	}
+	for _, u := range data.Users {
+		site.Authors = append(site.Authors, Author{
+			ID:          string(u.ID),
+			Username:    u.Slug,
+			Email:       u.Email,
+			DisplayName: u.Name,
+			Bio:         u.Bio,
+			Website:     u.Website,
+		})
+	}
+	// 内部标签以#开头，只用于主题，不导入
+	tagNames := map[ghostID]string{}
+	for _, t := range data.Tags {
+		if t.Visibility != "internal" && !strings.HasPrefix(t.Name, "#") {
+			tagNames[t.ID] = t.Name
+		}
+	}
+	sort.SliceStable(data.PostsTags, func(i, j int) bool { return data.PostsTags[i].SortOrder < data.PostsTags[j].SortOrder })
+	postTags := map[ghostID][]string{}
+	for _, pt := range data.PostsTags {
+		if name, ok := tagNames[pt.TagID]; ok {
+			postTags[pt.PostID] = append(postTags[pt.PostID], name)
+		}
+	}
+	// 多位作者时使用排在第一位的作者
+	postAuthors := map[ghostID]ghostID{}
+	sort.SliceStable(data.PostsAuthors, func(i, j int) bool { return data.PostsAuthors[i].SortOrder < data.PostsAuthors[j].SortOrder })
+	for _, pa := range data.PostsAuthors {
+		if _, ok := postAuthors[pa.PostID]; !ok {
+			postAuthors[pa.PostID] = pa.AuthorID
+		}
+	}
+	postMeta := map[ghostID]int{}
+	for i, m := range data.PostsMeta {
+		postMeta[m.PostID] = i
+	}
+
+	for _, p := range data.Posts {
+		if p.Type == "page" || p.Page == true || p.Page == float64(1) {
+			site.Ignored++
+			continue
+		}
+		author, ok := postAuthors[p.ID]
+		if !ok {
+			author = p.AuthorID
+		}
+		content := ghostMarkdown(p.Mobiledoc)
+		if content == "" {
+			content = HTMLToMarkdown(p.HTML)
+		}
+		if content == "" {
+			content = strings.TrimSpace(p.Plaintext)
+		}
+		post := Post{
+			ID:           string(p.ID),
+			Title:        strings.TrimSpace(p.Title),
+			Content:      content,
+			Draft:        p.Status != "published",
+			AuthorID:     string(author),
+			Tags:         postTags[p.ID],
+			CreatedAt:    parseTime(p.CreatedAt),
+			UpdatedAt:    parseTime(p.UpdatedAt),
+			PublishedAt:  parseTime(p.PublishedAt),
+			Description:  firstNonEmpty(p.MetaDescription, p.CustomExcerpt),
+			CanonicalURL: p.CanonicalURL,
+			Image:        firstNonEmpty(p.OGImage, p.FeatureImage),
+		}
+		if i, ok := postMeta[p.ID]; ok {
+			m := data.PostsMeta[i]
+			post.Description = firstNonEmpty(m.MetaDescription, post.Description)
+			post.Image = firstNonEmpty(m.OGImage, post.Image)
+		}
+		site.Posts = append(site.Posts, post)
+	}
+	return site, nil
+}
+
+// 全部由Markdown卡片组成的mobiledoc，返回其中的Markdown，否则返回空字符串
+func ghostMarkdown(mobiledoc string) string {
+	var doc struct {
+		Cards    [][]json.RawMessage `json:"cards"`
+		Sections [][]interface{}     `json:"sections"`
+	}
+	if mobiledoc == "" || json.Unmarshal([]byte(mobiledoc), &doc) != nil || len(doc.Sections) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, section := range doc.Sections {
+		// 卡片段落为 [10, 卡片序号]
+		if len(section) != 2 || section[0] != float64(10) {
+			return ""
+		}
+		index, ok := section[1].(float64)
+		if !ok || int(index) >= len(doc.Cards) || len(doc.Cards[int(index)]) != 2 {
+			return ""
+		}
+		card := doc.Cards[int(index)]
+		var name string
+		var payload struct {
+			Markdown string `json:"markdown"`
+		}
+		if json.Unmarshal(card[0], &name) != nil || (name != "markdown" && name != "card-markdown") || json.Unmarshal(card[1], &payload) != nil {
+			return ""
+		}
+		parts = append(parts, strings.TrimSpace(payload.Markdown))
+	}
+	return strings.Join(parts, "\n\n")
+}
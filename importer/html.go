@@ -0,0 +1,355 @@
+package importer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToMarkdown 将HTML转换为博客支持的Markdown：标题、段落、引用、列表、代码块、分隔线、强调、链接和图片。
+// 表格的每一行转换为以 | 分隔的一行文字，嵌套的列表展开为同一层，脚本、样式和注释被删除，其他标签只保留文字。
+// 没有段落标签的内容（如WordPress保存的文章）按空行分段
+func HTMLToMarkdown(src string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(src), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return strings.TrimSpace(src)
+	}
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	return strings.Join(mdBlocks(root), "\n\n")
+}
+
+// HTMLText 将HTML转换为纯文本，保留段落和换行，用于评论等不支持Markdown的内容
+func HTMLText(src string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(src), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return strings.TrimSpace(src)
+	}
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Script, atom.Style:
+				return
+			case atom.Br:
+				b.WriteString("\n")
+			case atom.P, atom.Div, atom.Blockquote, atom.Li:
+				b.WriteString("\n")
+				defer b.WriteString("\n")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(multipleBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+var multipleBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// 块级元素，其中的内容不与前后的文字合并为同一段落
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+	atom.Main: true, atom.Aside: true, atom.Nav: true, atom.Figure: true, atom.Figcaption: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Blockquote: true, atom.Ul: true, atom.Ol: true, atom.Pre: true, atom.Hr: true, atom.Table: true,
+	atom.Dl: true, atom.Details: true, atom.Iframe: true, atom.Video: true, atom.Audio: true,
+}
+
+// 转换子节点，返回以空行分隔的各个块
+func mdBlocks(n *html.Node) []string {
+	var blocks []string
+	var inline strings.Builder
+	flush := func() {
+		// 段落中的空行作为段落的分隔
+		for _, para := range strings.Split(inline.String(), "\n\n") {
+			if para = cleanInline(para); para != "" {
+				blocks = append(blocks, para)
+			}
+		}
+		inline.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || !blockElements[c.DataAtom] {
+			inline.WriteString(mdInline(c, true))
+			continue
+		}
+		flush()
+		blocks = append(blocks, mdBlock(c)...)
+	}
+	flush()
+	return blocks
+}
+
+func mdBlock(n *html.Node) []string {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := strings.Join(strings.Fields(mdInline(n, false)), " ")
+		if text == "" {
+			return nil
+		}
+		level, _ := strconv.Atoi(n.Data[1:])
+		return []string{strings.Repeat("#", level) + " " + text}
+	case atom.Hr:
+		return []string{"---"}
+	case atom.Pre:
+		return []string{mdCode(n)}
+	case atom.Blockquote:
+		inner := mdBlocks(n)
+		if len(inner) == 0 {
+			return nil
+		}
+		lines := strings.Split(strings.Join(inner, "\n\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return []string{strings.Join(lines, "\n")}
+	case atom.Ul, atom.Ol:
+		items := mdList(n)
+		if len(items) == 0 {
+			return nil
+		}
+		return []string{strings.Join(items, "\n")}
+	case atom.Table:
+		var rows []string
+		walkElements(n, atom.Tr, func(tr *html.Node) {
+			var cells []string
+			for c := tr.FirstChild; c != nil; c = c.NextSibling {
+				if c.DataAtom == atom.Td || c.DataAtom == atom.Th {
+					cells = append(cells, cleanInline(mdInline(c, false)))
+				}
+			}
+			if row := strings.TrimSpace(strings.Join(cells, " | ")); row != "" {
+				rows = append(rows, row)
+			}
+		})
+		return rows
+	case atom.Iframe, atom.Video, atom.Audio:
+		// 嵌入的视频等转换为链接
+		src := attr(n, "src")
+		if src == "" {
+			walkElements(n, atom.Source, func(s *html.Node) {
+				if src == "" {
+					src = attr(s, "src")
+				}
+			})
+		}
+		if src == "" {
+			return nil
+		}
+		return []string{"[" + src + "](" + mdURL(src) + ")"}
+	}
+	return mdBlocks(n)
+}
+
+// 列表项，嵌套的列表展开到同一层
+func mdList(n *html.Node) []string {
+	num := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil && start > 0 {
+		num = start
+	}
+	var items []string
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.DataAtom != atom.Li {
+			continue
+		}
+		var text strings.Builder
+		var nested []string
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Ul || c.DataAtom == atom.Ol {
+				nested = append(nested, mdList(c)...)
+				continue
+			}
+			text.WriteString(" " + mdInline(c, false))
+		}
+		if item := strings.Join(strings.Fields(text.String()), " "); item != "" {
+			if n.DataAtom == atom.Ol {
+				items = append(items, strconv.Itoa(num)+". "+item)
+				num++
+			} else {
+				items = append(items, "- "+item)
+			}
+		}
+		items = append(items, nested...)
+	}
+	return items
+}
+
+// 代码块，语言取pre或code的language-*类名
+func mdCode(n *html.Node) string {
+	lang := codeLanguage(n)
+	var code strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			code.WriteString(n.Data)
+		}
+		if n.DataAtom == atom.Br {
+			code.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if lang == "" && c.DataAtom == atom.Code {
+				lang = codeLanguage(c)
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	text := strings.Trim(code.String(), "\n")
+	fence := "```"
+	if strings.Contains(text, "```") {
+		fence = "~~~"
+	}
+	return fence + lang + "\n" + text + "\n" + fence
+}
+
+func codeLanguage(n *html.Node) string {
+	for _, class := range strings.Fields(attr(n, "class")) {
+		for _, prefix := range []string{"language-", "lang-"} {
+			if lang, ok := strings.CutPrefix(class, prefix); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// 转换行内元素，keepBreaks为true时保留文字中的换行，用于按空行分段
+func mdInline(n *html.Node, keepBreaks bool) string {
+	switch n.Type {
+	case html.TextNode:
+		if keepBreaks {
+			return n.Data
+		}
+		return strings.ReplaceAll(n.Data, "\n", " ")
+	case html.ElementNode:
+	default:
+		return ""
+	}
+	inner := func() string {
+		var b strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			b.WriteString(mdInline(c, keepBreaks))
+		}
+		return b.String()
+	}
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template:
+		return ""
+	case atom.Br:
+		return "\n"
+	case atom.Strong, atom.B:
+		return wrapInline(inner(), "**")
+	case atom.Em, atom.I:
+		return wrapInline(inner(), "*")
+	case atom.Del, atom.S, atom.Strike:
+		return wrapInline(inner(), "~~")
+	case atom.Code, atom.Kbd, atom.Samp:
+		text := strings.ReplaceAll(textContent(n), "`", "'")
+		if strings.TrimSpace(text) == "" {
+			return text
+		}
+		return "`" + text + "`"
+	case atom.Img:
+		src := attr(n, "src")
+		if src == "" {
+			return ""
+		}
+		alt := strings.NewReplacer("[", "", "]", "", "\n", " ").Replace(attr(n, "alt"))
+		return "![" + alt + "](" + mdURL(src) + ")"
+	case atom.A:
+		text := inner()
+		href := attr(n, "href")
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return text
+		}
+		label := strings.TrimSpace(text)
+		// 链接中只有图片时（通常链接到原图）只保留图片
+		if strings.HasPrefix(label, "![") && strings.HasSuffix(label, ")") && strings.Count(label, "](") == 1 {
+			return text
+		}
+		if label == "" {
+			label = href
+		}
+		return "[" + strings.NewReplacer("[", "", "]", "").Replace(label) + "](" + mdURL(href) + ")"
+	}
+	return inner()
+}
+
+// 强调的标记放在文字的首尾空白之内，没有文字时不添加
+func wrapInline(text, mark string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := text[:strings.Index(text, trimmed)]
+	end := text[len(start)+len(trimmed):]
+	return start + mark + trimmed + mark + end
+}
+
+// 合并段落中多余的空白，保留单个换行
+func cleanInline(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// Markdown中的链接地址不能包含空白和括号
+func mdURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "\n", "").Replace(strings.TrimSpace(u))
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// 按顺序遍历n之下标签为a的元素
+func walkElements(n *html.Node, a atom.Atom, fn func(*html.Node)) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.DataAtom == a {
+			fn(c)
+			continue
+		}
+		walkElements(c, a, fn)
+	}
+}
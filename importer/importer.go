@@ -0,0 +1,154 @@
+// Package importer 解析其他博客系统导出的内容，转换为统一的结构，由 service.ImportSite 导入数据库。
+//
+// 支持的格式：
+//
+//	wxr       WordPress导出的WXR文件（工具 → 导出），包括作者、文章、分类、标签和评论
+//	markdown  Hugo或Jekyll站点中带front matter的Markdown文件，front matter可以是YAML、TOML或JSON
+//	ghost     Ghost导出的JSON文件（Settings → Labs → Export），包括作者、文章和标签
+//
+// 文章内容统一转换为Markdown，HTML中博客不支持的标签只保留其中的文字。
+// 页面、附件等不是文章的条目不导入，只计入Ignored。
+package importer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持的导入格式
+const (
+	FormatWXR      = "wxr"
+	FormatMarkdown = "markdown"
+	FormatGhost    = "ghost"
+)
+
+// 评论状态
+const (
+	CommentApproved = "approved"
+	CommentPending  = "pending"
+	CommentSpam     = "spam"
+)
+
+// Site 解析后的博客内容
+type Site struct {
+	// 来源的标识，如原站点的地址。来源和条目的ID一起用于识别已导入的内容，重复导入时跳过
+	Source  string
+	Authors []Author
+	Posts   []Post
+	// 不支持导入而忽略的条目数量，如页面、附件和引用通告
+	Ignored int
+}
+
+// Author 文章作者或来源中注册用户的评论者
+type Author struct {
+	// 在来源中的唯一标识
+	ID          string
+	Username    string
+	Email       string
+	DisplayName string
+	Bio         string
+	Website     string
+}
+
+// Post 文章
+type Post struct {
+	ID    string
+	Title string
+	// Markdown格式的内容
+	Content string
+	Draft   bool
+	// 作者在Authors中的ID，为空时由导入时指定的默认作者发布
+	AuthorID string
+	// 标签，本博客没有分类，分类同样作为标签导入
+	Tags        []string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	PublishedAt time.Time
+	// SEO设置，为空时使用默认值
+	Description  string
+	CanonicalURL string
+	Image        string
+	Comments     []Comment
+}
+
+// Comment 评论，博客的评论只能由用户发表，导入时按作者或评论者的名称和邮箱对应到用户
+type Comment struct {
+	ID string
+	// 评论者是来源中的注册用户时为其在Authors中的ID
+	AuthorID    string
+	AuthorName  string
+	AuthorEmail string
+	AuthorURL   string
+	// 纯文本内容
+	Content   string
+	Status    string
+	CreatedAt time.Time
+}
+
+// Parse 按格式解析导出的内容，markdown格式的path为站点或内容目录，其他格式为导出的文件
+func Parse(format, path string) (*Site, error) {
+	switch format {
+	case FormatWXR:
+		return ParseWXRFile(path)
+	case FormatMarkdown:
+		return ParseMarkdownDir(path)
+	case FormatGhost:
+		return ParseGhostFile(path)
+	}
+	return nil, fmt.Errorf("不支持的导入格式: %s", format)
+}
+
+// 导出文件中常见的时间格式，没有时区的时间按UTC处理
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// 解析时间，支持字符串、time.Time和Unix毫秒时间戳，无法解析时返回零值
+func parseTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case time.Time:
+		return t
+	case fmt.Stringer:
+		return parseTime(t.String())
+	case float64:
+		return time.UnixMilli(int64(t))
+	case int64:
+		return time.UnixMilli(t)
+	case uint64:
+		return time.UnixMilli(int64(t))
+	case string:
+		s := strings.TrimSpace(t)
+		if s == "" || strings.HasPrefix(s, "0000-00-00") {
+			return time.Time{}
+		}
+		for _, layout := range timeLayouts {
+			if parsed, err := time.Parse(layout, s); err == nil {
+				return parsed
+			}
+		}
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.UnixMilli(ms)
+		}
+	}
+	return time.Time{}
+}
+
+// 第一个非零的时间
+func firstTime(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,234 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Jekyll文章的文件名以发布日期开头，如 2020-01-02-hello.md
+var jekyllDatePrefix = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-`)
+
+// ParseMarkdownDir 解析Hugo或Jekyll站点中的Markdown文章。
+//
+// dir为Jekyll站点时读取_posts和_drafts（导入为草稿），为Hugo站点时读取content，否则读取dir中的全部Markdown文件。
+// 文件在dir中的路径（不含扩展名）作为文章的ID，修改文件名后再次导入会作为新文章。
+// 没有front matter的文件、Hugo的_index.md和以.或_开头的目录不导入
+func ParseMarkdownDir(dir string) (*Site, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	site := &Site{Source: "markdown:" + filepath.Base(abs)}
+	type root struct {
+		dir    string
+		drafts bool
+	}
+	roots := []root{{"", false}}
+	if isDir(filepath.Join(dir, "_posts")) {
+		roots = []root{{"_posts", false}, {"_drafts", true}}
+	} else if isDir(filepath.Join(dir, "content")) {
+		roots = []root{{"content", false}}
+	}
+	authors := map[string]bool{}
+	for _, r := range roots {
+		base := filepath.Join(dir, r.dir)
+		if r.dir != "" && !isDir(base) {
+			continue
+		}
+		err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() {
+				if p != base && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(name))
+			if (ext != ".md" && ext != ".markdown") || strings.HasPrefix(name, "_index.") {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			post, ok, err := parseMarkdownPost(filepath.ToSlash(rel), data)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				site.Ignored++
+				return nil
+			}
+			post.Draft = post.Draft || r.drafts
+			if post.CreatedAt.IsZero() {
+				if info, err := d.Info(); err == nil {
+					post.CreatedAt = info.ModTime()
+				}
+			}
+			if !post.Draft && post.PublishedAt.IsZero() {
+				post.PublishedAt = post.CreatedAt
+			}
+			if post.AuthorID != "" && !authors[post.AuthorID] {
+				authors[post.AuthorID] = true
+				site.Authors = append(site.Authors, Author{ID: post.AuthorID, Username: post.AuthorID, DisplayName: post.AuthorID})
+			}
+			site.Posts = append(site.Posts, post)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return site, nil
+}
+
+// 解析带front matter的Markdown文件，没有front matter时返回false
+func parseMarkdownPost(rel string, data []byte) (Post, bool, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	meta, body, err := splitFrontMatter(data)
+	if err != nil {
+		return Post{}, false, fmt.Errorf("解析%s的front matter失败: %w", rel, err)
+	}
+	if meta == nil {
+		return Post{}, false, nil
+	}
+	post := Post{
+		ID:      strings.TrimSuffix(rel, path.Ext(rel)),
+		Title:   metaString(meta, "title"),
+		Content: strings.TrimSpace(body),
+		// Hugo使用draft，Jekyll使用published: false
+		Draft:        metaBool(meta, "draft") || meta["published"] == false,
+		CreatedAt:    parseTime(meta["date"]),
+		UpdatedAt:    firstTime(parseTime(meta["lastmod"]), parseTime(meta["last_modified_at"]), parseTime(meta["updated"])),
+		PublishedAt:  parseTime(meta["publishDate"]),
+		Description:  firstNonEmpty(metaString(meta, "description"), metaString(meta, "summary"), metaString(meta, "excerpt")),
+		CanonicalURL: firstNonEmpty(metaString(meta, "canonicalURL"), metaString(meta, "canonical_url"), metaString(meta, "canonical")),
+		Image:        firstNonEmpty(metaString(meta, "image"), firstOf(metaList(meta, "images"))),
+		AuthorID:     firstNonEmpty(metaString(meta, "author"), firstOf(metaList(meta, "authors"))),
+	}
+	if post.CreatedAt.IsZero() {
+		if m := jekyllDatePrefix.FindStringSubmatch(path.Base(rel)); m != nil {
+			post.CreatedAt = parseTime(m[1])
+		}
+	}
+	if post.Title == "" {
+		post.Title = jekyllDatePrefix.ReplaceAllString(path.Base(post.ID), "")
+		if post.Title == "index" {
+			post.Title = path.Base(path.Dir(post.ID))
+		}
+	}
+	// Jekyll的category为单个分类，categories和tags可以是以空格分隔的字符串
+	post.Tags = metaList(meta, "categories")
+	if category := metaString(meta, "category"); category != "" {
+		post.Tags = append(post.Tags, category)
+	}
+	post.Tags = append(post.Tags, metaList(meta, "tags")...)
+	return post, true, nil
+}
+
+// 拆分front matter和正文：---之间为YAML，+++之间为TOML，以{开头的为JSON
+func splitFrontMatter(data []byte) (map[string]interface{}, string, error) {
+	meta := map[string]interface{}{}
+	for _, fm := range []struct {
+		delim     string
+		unmarshal func([]byte, interface{}) error
+	}{{"---", yaml.Unmarshal}, {"+++", toml.Unmarshal}} {
+		if !bytes.HasPrefix(data, []byte(fm.delim+"\n")) {
+			continue
+		}
+		rest := data[len(fm.delim)+1:]
+		end := bytes.Index(append([]byte("\n"), rest...), []byte("\n"+fm.delim))
+		if end < 0 {
+			return nil, "", fmt.Errorf("front matter没有结束")
+		}
+		header := rest[:max(end-1, 0)]
+		body := rest[min(end+len(fm.delim), len(rest)):]
+		if err := fm.unmarshal(header, &meta); err != nil {
+			return nil, "", err
+		}
+		return meta, string(body), nil
+	}
+	if bytes.HasPrefix(data, []byte("{")) {
+		d := json.NewDecoder(bytes.NewReader(data))
+		if err := d.Decode(&meta); err != nil {
+			return nil, "", err
+		}
+		return meta, string(data[d.InputOffset():]), nil
+	}
+	return nil, "", nil
+}
+
+func metaString(meta map[string]interface{}, key string) string {
+	switch v := meta[key].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case nil:
+		return ""
+	case map[string]interface{}:
+		// 如 image: {path: ...}
+		for _, k := range []string{"path", "url", "src", "name"} {
+			if s, ok := v[k].(string); ok {
+				return s
+			}
+		}
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func metaBool(meta map[string]interface{}, key string) bool {
+	switch v := meta[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// 列表形式的字段，Jekyll中也可以是以空格分隔的字符串
+func metaList(meta map[string]interface{}, key string) []string {
+	var list []string
+	switch v := meta[key].(type) {
+	case string:
+		list = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s := strings.TrimSpace(fmt.Sprint(item)); item != nil && s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+func firstOf(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
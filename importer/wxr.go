@@ -0,0 +1,175 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+)
+
+type wxrFile struct {
+	Channel struct {
+		Link        string      `xml:"link"`
+		BaseBlogURL string      `xml:"base_blog_url"`
+		Authors     []wxrAuthor `xml:"author"`
+		Items       []wxrItem   `xml:"item"`
+	} `xml:"channel"`
+}
+
+type wxrAuthor struct {
+	ID          string `xml:"author_id"`
+	Login       string `xml:"author_login"`
+	Email       string `xml:"author_email"`
+	DisplayName string `xml:"author_display_name"`
+}
+
+type wxrItem struct {
+	Title       string `xml:"title"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PostID      string `xml:"post_id"`
+	PostDate    string `xml:"post_date"`
+	PostDateGMT string `xml:"post_date_gmt"`
+	Modified    string `xml:"post_modified"`
+	ModifiedGMT string `xml:"post_modified_gmt"`
+	Status      string `xml:"status"`
+	PostType    string `xml:"post_type"`
+	Categories  []struct {
+		Domain   string `xml:"domain,attr"`
+		Nicename string `xml:"nicename,attr"`
+		Name     string `xml:",chardata"`
+	} `xml:"category"`
+	Meta []struct {
+		Key   string `xml:"meta_key"`
+		Value string `xml:"meta_value"`
+	} `xml:"postmeta"`
+	Comments []wxrComment `xml:"comment"`
+}
+
+type wxrComment struct {
+	ID       string `xml:"comment_id"`
+	Author   string `xml:"comment_author"`
+	Email    string `xml:"comment_author_email"`
+	URL      string `xml:"comment_author_url"`
+	Date     string `xml:"comment_date"`
+	DateGMT  string `xml:"comment_date_gmt"`
+	Content  string `xml:"comment_content"`
+	Approved string `xml:"comment_approved"`
+	Type     string `xml:"comment_type"`
+	UserID   string `xml:"user_id"`
+}
+
+// WordPress的文章状态，其他状态（回收站、修订版本等）不导入
+var wxrPostStatus = map[string]bool{
+	"publish": false, "draft": true, "pending": true, "future": true, "private": true,
+}
+
+// WordPress的评论状态，回收站中的评论不导入
+var wxrCommentStatus = map[string]string{
+	"1": CommentApproved, "0": CommentPending, "spam": CommentSpam,
+}
+
+// ParseWXRFile 解析WordPress导出的WXR文件
+func ParseWXRFile(path string) (*Site, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseWXR(f)
+}
+
+// ParseWXR 解析WXR格式的内容，只导入文章（post_type为post），发布以外的状态导入为草稿
+func ParseWXR(r io.Reader) (*Site, error) {
+	var wxr wxrFile
+	d := xml.NewDecoder(r)
+	// 导出文件中可能有未放在CDATA中的HTML实体
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	if err := d.Decode(&wxr); err != nil {
+		return nil, fmt.Errorf("解析WXR文件失败: %w", err)
+	}
+	ch := wxr.Channel
+	site := &Site{Source: "wordpress"}
+	if base := strings.TrimRight(firstNonEmpty(ch.BaseBlogURL, ch.Link), "/"); base != "" {
+		site.Source += ":" + base
+	}
+	// 评论中的user_id对应作者的author_id
+	logins := map[string]string{}
+	for _, a := range ch.Authors {
+		logins[a.ID] = a.Login
+		site.Authors = append(site.Authors, Author{
+			ID:          a.Login,
+			Username:    a.Login,
+			Email:       a.Email,
+			DisplayName: html.UnescapeString(a.DisplayName),
+		})
+	}
+
+	for _, item := range ch.Items {
+		draft, ok := wxrPostStatus[item.Status]
+		if item.PostType != "post" || !ok {
+			site.Ignored++
+			continue
+		}
+		post := Post{
+			ID:        item.PostID,
+			Title:     strings.TrimSpace(html.UnescapeString(item.Title)),
+			Content:   HTMLToMarkdown(item.Content),
+			Draft:     draft,
+			AuthorID:  item.Creator,
+			CreatedAt: firstTime(parseTime(item.PostDateGMT), parseTime(item.PostDate)),
+			UpdatedAt: firstTime(parseTime(item.ModifiedGMT), parseTime(item.Modified)),
+		}
+		if !draft {
+			post.PublishedAt = post.CreatedAt
+		}
+		for _, c := range item.Categories {
+			// 未分类是WordPress的默认分类，不作为标签导入
+			if (c.Domain == "category" && c.Nicename == "uncategorized") || (c.Domain != "category" && c.Domain != "post_tag") {
+				continue
+			}
+			post.Tags = append(post.Tags, html.UnescapeString(strings.TrimSpace(c.Name)))
+		}
+		// Yoast SEO插件保存的描述和规范地址
+		for _, m := range item.Meta {
+			switch m.Key {
+			case "_yoast_wpseo_metadesc":
+				post.Description = m.Value
+			case "_yoast_wpseo_canonical":
+				post.CanonicalURL = m.Value
+			}
+		}
+		for _, c := range item.Comments {
+			status, ok := wxrCommentStatus[c.Approved]
+			// 引用通告不是评论
+			if !ok || (c.Type != "" && c.Type != "comment") {
+				site.Ignored++
+				continue
+			}
+			post.Comments = append(post.Comments, Comment{
+				ID:          c.ID,
+				AuthorID:    logins[c.UserID],
+				AuthorName:  html.UnescapeString(c.Author),
+				AuthorEmail: c.Email,
+				AuthorURL:   c.URL,
+				Content:     HTMLText(c.Content),
+				Status:      status,
+				CreatedAt:   firstTime(parseTime(c.DateGMT), parseTime(c.Date)),
+			})
+		}
+		site.Posts = append(site.Posts, post)
+	}
+	return site, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/xiaohan1995/Gin-blog/models"
 	"github.com/xiaohan1995/Gin-blog/routers"
+	"github.com/xiaohan1995/Gin-blog/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,6 +11,14 @@ import (
 func main() {
 	// 初始化数据库
 	models.InitDB()
+	// 初始化上传文件的存储
+	service.InitStorage()
+	// 确保系统中至少有一个管理员
+	service.EnsureAdmin()
+	// 启动签名密钥的定期轮换
+	service.StartKeyRotation()
+	// 启动未使用媒体文件的定期清理
+	service.StartMediaGC()
 	//初始化gin
 	r := gin.Default()
 	//设置静态资源和模版路径
@@ -4,12 +4,20 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
 	"github.com/xiaohan1995/Gin-blog/service"
 )
 
-// 添加JWT中间件示例
+// 添加JWT中间件示例，优先使用Authorization头，没有时使用会话Cookie
 func AuthMiddleware(c *gin.Context) {
 	tokenString := c.GetHeader("Authorization")
+	if tokenString == "" {
+		tokenString, _ = c.Cookie(AccessCookieName)
+		if tokenString != "" {
+			c.Set("auth_source", "cookie")
+		}
+	}
 	if tokenString == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少访问令牌"})
 		c.Abort()
@@ -21,15 +29,250 @@ func AuthMiddleware(c *gin.Context) {
 		tokenString = tokenString[7:]
 	}
 
-	claims, err := service.ParseJWT(tokenString)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的访问令牌"})
+	if service.IsPersonalAccessToken(tokenString) || service.IsOAuthAccessToken(tokenString) {
+		scopedTokenAuth(c, tokenString)
+		return
+	}
+
+	claims, code, message := verifyAccessToken(tokenString)
+	if claims == nil {
+		c.JSON(code, gin.H{"error": message})
 		c.Abort()
 		return
 	}
 
 	// 将用户信息存储在上下文中供后续使用
+	setClaims(c, claims)
+	c.Next()
+}
+
+// OptionalAuthMiddleware 可选的认证，用于公开接口：携带有效令牌时和AuthMiddleware一样存储用户信息，
+// 没有令牌或令牌无效时按匿名用户继续处理，不返回错误
+func OptionalAuthMiddleware(c *gin.Context) {
+	// 响应内容与当前用户有关，缓存时需要区分
+	c.Header("Vary", "Authorization, Cookie")
+	tokenString := c.GetHeader("Authorization")
+	if tokenString == "" {
+		tokenString, _ = c.Cookie(AccessCookieName)
+	}
+	if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
+		tokenString = tokenString[7:]
+	}
+	if tokenString == "" {
+		c.Next()
+		return
+	}
+
+	switch {
+	case service.IsPersonalAccessToken(tokenString):
+		if _, user, apiErr := service.VerifyPersonalAccessToken(tokenString, c.ClientIP()); apiErr == nil {
+			c.Set("user_id", user.ID)
+			c.Set("username", user.UserName)
+		}
+	case service.IsOAuthAccessToken(tokenString):
+		if _, user, apiErr := service.VerifyOAuthAccessToken(tokenString); apiErr == nil {
+			c.Set("user_id", user.ID)
+			c.Set("username", user.UserName)
+		}
+	default:
+		if claims, _, _ := verifyAccessToken(tokenString); claims != nil {
+			setClaims(c, claims)
+		}
+	}
+	c.Next()
+}
+
+// 个人访问令牌和第三方客户端的访问令牌在各权限范围可以访问的接口，不在列表中的接口（如修改密码、管理令牌）只能使用登录令牌访问
+var scopeRoutes = map[string][]string{
+	models.ScopePostsWrite: {
+		"GET /api/protected/posts",
+		"POST /api/protected/posts",
+		"GET /api/protected/post/:id",
+		"PUT /api/protected/post/:id",
+		"DELETE /api/protected/post/:id",
+		"GET /api/protected/media",
+		"POST /api/protected/media",
+		"DELETE /api/protected/media/:id",
+	},
+	models.ScopeCommentsModerate: {
+		"GET /api/protected/comments",
+		"GET /api/protected/post/:id/comments",
+		"GET /api/protected/moderation/comments",
+		"POST /api/protected/moderation/comments",
+		"PUT /api/protected/post/:id/moderation",
+	},
+	models.ScopeUsersRead: {
+		"GET /api/protected/users",
+	},
+}
+
+// 任意权限范围都可以访问的接口
+var scopeFreeRoutes = map[string]bool{
+	"GET /api/protected/profile": true,
+}
+
+// 判断令牌的权限范围是否允许访问当前接口
+func scopeAllows(scopes []string, route string) bool {
+	if scopeFreeRoutes[route] {
+		return true
+	}
+	for _, scope := range scopes {
+		for _, r := range scopeRoutes[scope] {
+			if r == route {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 使用个人访问令牌或第三方客户端的访问令牌认证，只能访问权限范围内的接口
+func scopedTokenAuth(c *gin.Context, tokenString string) {
+	var userID uint
+	var username, source string
+	var scopes []string
+	if service.IsPersonalAccessToken(tokenString) {
+		token, user, apiErr := service.VerifyPersonalAccessToken(tokenString, c.ClientIP())
+		if apiErr != nil {
+			c.JSON(apiErr.Code, gin.H{"error": apiErr.Message})
+			c.Abort()
+			return
+		}
+		userID, username, scopes, source = user.ID, user.UserName, token.Scopes, "access_token"
+	} else {
+		token, user, apiErr := service.VerifyOAuthAccessToken(tokenString)
+		if apiErr != nil {
+			c.JSON(apiErr.Code, gin.H{"error": apiErr.Message})
+			c.Abort()
+			return
+		}
+		userID, username, scopes, source = user.ID, user.UserName, token.Scopes, "oauth"
+	}
+	if !scopeAllows(scopes, c.Request.Method+" "+c.FullPath()) {
+		models.Log.Warning("访问令牌权限范围不足:", userID, source, c.Request.Method, c.FullPath())
+		c.JSON(http.StatusForbidden, gin.H{"error": "访问令牌的权限范围不足"})
+		c.Abort()
+		return
+	}
+	c.Set("user_id", userID)
+	c.Set("username", username)
+	c.Set("auth_source", source)
+	c.Set("token_scopes", scopes)
+	c.Next()
+}
+
+// 校验访问令牌并检查吊销列表，失败时返回状态码和错误信息
+func verifyAccessToken(tokenString string) (*service.JWTClaims, int, string) {
+	claims, err := service.ParseJWT(tokenString)
+	if err != nil || claims.ID == "" {
+		return nil, http.StatusUnauthorized, "无效的访问令牌"
+	}
+
+	// 检查令牌是否已被吊销
+	revoked, err := service.IsTokenRevoked(claims)
+	if err != nil {
+		models.Log.Error("查询令牌吊销列表失败:", err)
+		return nil, http.StatusInternalServerError, models.ErrInternalServer.Message
+	}
+	if revoked {
+		return nil, http.StatusUnauthorized, "访问令牌已失效"
+	}
+	return claims, http.StatusOK, ""
+}
+
+// 将令牌中的用户信息存储在上下文中
+func setClaims(c *gin.Context, claims *service.JWTClaims) {
 	c.Set("user_id", claims.UserID)
 	c.Set("username", claims.Username)
+	c.Set("claims", claims)
+}
+
+// RequirePermission 权限校验中间件，需要在AuthMiddleware之后使用，用户必须拥有全部指定权限
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+			c.Abort()
+			return
+		}
+		role, apiErr := service.GetUserRole(userID.(uint))
+		if apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			c.Abort()
+			return
+		}
+		for _, permission := range permissions {
+			if !models.HasPermission(role, permission) {
+				models.Log.Warning("权限不足:", userID, role, permission)
+				c.JSON(models.ErrForbidden.Code, models.ErrForbidden)
+				c.Abort()
+				return
+			}
+		}
+		c.Set("role", role)
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail 要求用户已完成邮箱验证，需要在AuthMiddleware之后使用
+func RequireVerifiedEmail(c *gin.Context) {
+	if !config.Conf.User.RequireVerification {
+		c.Next()
+		return
+	}
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+		c.Abort()
+		return
+	}
+	verified, apiErr := service.IsEmailVerified(userID.(uint))
+	if apiErr != nil {
+		c.JSON(apiErr.Code, apiErr)
+		c.Abort()
+		return
+	}
+	if !verified {
+		c.JSON(models.ErrEmailNotVerified.Code, models.ErrEmailNotVerified)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// 所在角色要求两步验证但尚未启用时，仍然可以访问的接口
+var twoFactorSetupPaths = map[string]bool{
+	"/api/protected/2fa":        true,
+	"/api/protected/2fa/setup":  true,
+	"/api/protected/2fa/qr.png": true,
+	"/api/protected/2fa/enable": true,
+	"/api/protected/profile":    true,
+}
+
+// RequireTwoFactorSetup 所在角色要求两步验证的用户，启用两步验证前只能访问设置相关的接口，
+// 需要在AuthMiddleware之后使用
+func RequireTwoFactorSetup(c *gin.Context) {
+	if twoFactorSetupPaths[c.FullPath()] {
+		c.Next()
+		return
+	}
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+		c.Abort()
+		return
+	}
+	required, apiErr := service.TwoFactorSetupRequired(userID.(uint))
+	if apiErr != nil {
+		c.JSON(apiErr.Code, apiErr)
+		c.Abort()
+		return
+	}
+	if required {
+		c.JSON(models.ErrTwoFactorRequired.Code, models.ErrTwoFactorRequired)
+		c.Abort()
+		return
+	}
 	c.Next()
 }
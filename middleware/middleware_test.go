@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaohan1995/Gin-blog/dbtest"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/service"
+)
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		route  string
+		want   bool
+	}{
+		{[]string{models.ScopePostsWrite}, "POST /api/protected/posts", true},
+		{[]string{models.ScopePostsWrite}, "DELETE /api/protected/post/:id", true},
+		{[]string{models.ScopePostsWrite}, "GET /api/protected/users", false},
+		{[]string{models.ScopePostsWrite}, "POST /api/protected/moderation/comments", false},
+		{[]string{models.ScopeCommentsModerate}, "POST /api/protected/moderation/comments", true},
+		{[]string{models.ScopeCommentsModerate, models.ScopeUsersRead}, "GET /api/protected/users", true},
+		{[]string{models.ScopeUsersRead}, "GET /api/protected/profile", true},
+		{nil, "GET /api/protected/profile", true},
+		{nil, "GET /api/protected/posts", false},
+		// 修改密码、管理令牌等接口只能使用登录令牌
+		{[]string{models.ScopePostsWrite, models.ScopeCommentsModerate, models.ScopeUsersRead}, "PUT /api/protected/password", false},
+		{[]string{models.ScopePostsWrite, models.ScopeCommentsModerate, models.ScopeUsersRead}, "POST /api/protected/tokens", false},
+		{[]string{"unknown"}, "GET /api/protected/posts", false},
+	}
+	for _, tt := range tests {
+		if got := scopeAllows(tt.scopes, tt.route); got != tt.want {
+			t.Errorf("scopeAllows(%v, %q) = %v, want %v", tt.scopes, tt.route, got, tt.want)
+		}
+	}
+}
+
+func TestScopedTokenAuth(t *testing.T) {
+	dbtest.Open(t)
+	user := models.User{UserName: "alice", Email: "alice@example.com", Password: "x", Role: models.RoleEditor, EmailVerified: true}
+	if err := models.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, record, apiErr := service.CreatePersonalAccessToken(user.ID, "deploy", []string{models.ScopePostsWrite}, 30)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+
+	r := gin.New()
+	protected := r.Group("/api/protected", AuthMiddleware)
+	ok := func(c *gin.Context) {
+		if c.GetUint("user_id") != user.ID || c.GetString("auth_source") != "access_token" {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	}
+	protected.GET("/posts", ok)
+	protected.GET("/profile", ok)
+	protected.GET("/users", ok)
+	protected.PUT("/password", ok)
+
+	request := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/protected/posts", http.StatusOK},
+		{http.MethodGet, "/api/protected/profile", http.StatusOK},
+		{http.MethodGet, "/api/protected/users", http.StatusForbidden},
+		{http.MethodPut, "/api/protected/password", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := request(tt.method, tt.path, token); got != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	if got := request(http.MethodGet, "/api/protected/posts", service.PersonalAccessTokenPrefix+"unknown"); got != http.StatusUnauthorized {
+		t.Errorf("不存在的令牌 = %d", got)
+	}
+	if apiErr := service.RevokePersonalAccessToken(user.ID, record.ID); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if got := request(http.MethodGet, "/api/protected/posts", token); got != http.StatusUnauthorized {
+		t.Errorf("吊销的令牌 = %d", got)
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/service"
+)
+
+// 会话Cookie名称
+const (
+	AccessCookieName  = "access_token"
+	RefreshCookieName = "refresh_token"
+	CSRFCookieName    = "csrf_token"
+	CSRFHeaderName    = "X-CSRF-Token"
+	CSRFFormField     = "csrf_token"
+	// 单点登录的state，回调时校验
+	OIDCStateCookieName = "oidc_state"
+)
+
+// CookieSessionEnabled 是否启用Cookie会话
+func CookieSessionEnabled() bool {
+	return config.Conf.Session.Mode == "cookie" || config.Conf.Session.Mode == "both"
+}
+
+// TokenResponseEnabled 是否在响应中返回令牌
+func TokenResponseEnabled() bool {
+	return config.Conf.Session.Mode != "cookie"
+}
+
+func sameSite() http.SameSite {
+	if config.Conf.Session.SameSite == "strict" {
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteLaxMode
+}
+
+func setCookie(c *gin.Context, name, value string, maxAge time.Duration, httpOnly bool) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.Conf.Session.Domain,
+		Secure:   config.Conf.Session.Secure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite(),
+	}
+	if maxAge > 0 {
+		cookie.MaxAge = int(maxAge.Seconds())
+	} else {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+// SetSessionCookies 将令牌写入HttpOnly Cookie，并确保存在CSRF令牌
+func SetSessionCookies(c *gin.Context, tokens service.TokenPair) {
+	if !CookieSessionEnabled() {
+		return
+	}
+	refreshTTL := time.Duration(config.Conf.JWT.RefreshTTL) * time.Hour
+	setCookie(c, AccessCookieName, tokens.AccessToken, time.Duration(tokens.ExpiresIn)*time.Second, true)
+	setCookie(c, RefreshCookieName, tokens.RefreshToken, refreshTTL, true)
+	ensureCSRFCookie(c)
+}
+
+// 浏览器没有CSRF令牌时生成一个，已有的令牌保持不变，避免正在进行的请求校验失败
+func ensureCSRFCookie(c *gin.Context) {
+	if value, err := c.Cookie(CSRFCookieName); err == nil && value != "" {
+		return
+	}
+	// CSRF令牌需要被页面脚本读取，不能设置HttpOnly
+	refreshTTL := time.Duration(config.Conf.JWT.RefreshTTL) * time.Hour
+	setCookie(c, CSRFCookieName, service.RandomToken(32), refreshTTL, false)
+}
+
+// ClearSessionCookies 清除会话Cookie
+func ClearSessionCookies(c *gin.Context) {
+	for _, name := range []string{AccessCookieName, RefreshCookieName} {
+		setCookie(c, name, "", 0, true)
+	}
+	setCookie(c, CSRFCookieName, "", 0, false)
+}
+
+// 请求是否携带会话Cookie且没有使用Authorization头，只有这类请求会受到CSRF攻击
+func cookieAuthenticated(c *gin.Context) bool {
+	if c.GetHeader("Authorization") != "" {
+		return false
+	}
+	for _, name := range []string{AccessCookieName, RefreshCookieName} {
+		if value, err := c.Cookie(name); err == nil && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// CSRFMiddleware 对使用Cookie会话的状态修改请求（POST、PUT、PATCH、DELETE）校验CSRF令牌，
+// 令牌可以通过X-CSRF-Token请求头或csrf_token表单字段提交，必须与csrf_token Cookie一致
+func CSRFMiddleware(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		c.Next()
+		return
+	}
+	if !cookieAuthenticated(c) {
+		c.Next()
+		return
+	}
+	expected, err := c.Cookie(CSRFCookieName)
+	token := c.GetHeader(CSRFHeaderName)
+	if token == "" {
+		token = c.PostForm(CSRFFormField)
+	}
+	if err != nil || expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+		models.Log.Warning("CSRF令牌校验失败:", c.Request.Method, c.Request.URL.Path, c.ClientIP())
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "CSRF令牌无效",
+			"details": "请刷新页面后重试",
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// PageAuthMiddleware 管理页面的登录校验，访问令牌过期时使用刷新令牌Cookie自动续期，
+// 未登录时跳转到登录页面。仅使用令牌登录时页面由前端脚本校验
+func PageAuthMiddleware(c *gin.Context) {
+	if !CookieSessionEnabled() {
+		c.Next()
+		return
+	}
+	var claims *service.JWTClaims
+	if tokenString, err := c.Cookie(AccessCookieName); err == nil && tokenString != "" {
+		claims, _, _ = verifyAccessToken(tokenString)
+	}
+	if claims == nil {
+		if refreshToken, err := c.Cookie(RefreshCookieName); err == nil && refreshToken != "" {
+			tokens, apiErr := service.RefreshTokens(refreshToken, c.ClientIP(), c.Request.UserAgent())
+			if apiErr == nil {
+				SetSessionCookies(c, tokens)
+				claims, _, _ = verifyAccessToken(tokens.AccessToken)
+			}
+		}
+	}
+	if claims == nil {
+		c.Redirect(http.StatusFound, "/login?redirect="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+		return
+	}
+	setClaims(c, claims)
+	c.Next()
+}
+
+// CSRFCookieMiddleware 确保浏览器持有CSRF令牌Cookie，用于登录、注册等页面
+func CSRFCookieMiddleware(c *gin.Context) {
+	if CookieSessionEnabled() {
+		ensureCSRFCookie(c)
+	}
+	c.Next()
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func csrfRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(CSRFMiddleware)
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	r.GET("/page", CSRFCookieMiddleware, ok)
+	r.POST("/api", ok)
+	return r
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	r := csrfRouter()
+	session := []*http.Cookie{{Name: AccessCookieName, Value: "access"}, {Name: CSRFCookieName, Value: "csrf-secret"}}
+	tests := []struct {
+		name    string
+		method  string
+		cookies []*http.Cookie
+		header  map[string]string
+		form    url.Values
+		want    int
+	}{
+		{name: "读取请求不校验", method: http.MethodGet, cookies: session, want: http.StatusOK},
+		{name: "没有会话Cookie", method: http.MethodPost, want: http.StatusOK},
+		{name: "使用Authorization头", method: http.MethodPost, cookies: session, header: map[string]string{"Authorization": "Bearer token"}, want: http.StatusOK},
+		{name: "缺少CSRF令牌", method: http.MethodPost, cookies: session, want: http.StatusForbidden},
+		{name: "请求头中的令牌", method: http.MethodPost, cookies: session, header: map[string]string{CSRFHeaderName: "csrf-secret"}, want: http.StatusOK},
+		{name: "表单中的令牌", method: http.MethodPost, cookies: session, form: url.Values{CSRFFormField: {"csrf-secret"}}, want: http.StatusOK},
+		{name: "令牌不一致", method: http.MethodPost, cookies: session, header: map[string]string{CSRFHeaderName: "other"}, want: http.StatusForbidden},
+		{name: "只有刷新令牌Cookie", method: http.MethodPost, cookies: []*http.Cookie{{Name: RefreshCookieName, Value: "refresh"}}, header: map[string]string{CSRFHeaderName: ""}, want: http.StatusForbidden},
+		{name: "没有CSRF Cookie", method: http.MethodPost, cookies: session[:1], header: map[string]string{CSRFHeaderName: "csrf-secret"}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/api"
+			if tt.method == http.MethodGet {
+				path = "/page"
+			}
+			req := httptest.NewRequest(tt.method, path, strings.NewReader(tt.form.Encode()))
+			if tt.form != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for _, cookie := range tt.cookies {
+				req.AddCookie(cookie)
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("状态码为%d，应为%d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFCookieMiddleware(t *testing.T) {
+	r := csrfRouter()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	var token *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == CSRFCookieName {
+			token = cookie
+		}
+	}
+	if token == nil || token.Value == "" {
+		t.Fatal("页面应设置CSRF令牌Cookie")
+	}
+	if token.HttpOnly {
+		t.Fatal("CSRF令牌需要被页面脚本读取，不能设置HttpOnly")
+	}
+
+	// 已有的令牌保持不变
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.AddCookie(token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == CSRFCookieName {
+			t.Fatal("已有CSRF令牌时不应重新生成")
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -10,6 +12,25 @@ type User struct {
 	UserName string `gorm:"unique;not null" json:"username" binding:"required,min=3"`
 	Email    string `gorm:"not null" json:"email" binding:"required,email"`
 	Password string `gorm:"not null" json:"password" binding:"required,min=6"`
+	// 用户角色，历史用户默认为作者
+	Role string `gorm:"size:20;default:author;index" json:"role"`
+	// 邮箱是否已验证
+	EmailVerified bool `gorm:"not null;default:false" json:"email_verified"`
+	// 两步验证的TOTP密钥，启用前为待确认的密钥
+	TOTPSecret  string `gorm:"size:64" json:"-"`
+	TOTPEnabled bool   `gorm:"not null;default:false" json:"totp_enabled"`
+	// 最近一次使用的TOTP时间步，防止验证码被重复使用
+	TOTPLastStep int64 `gorm:"not null;default:0" json:"-"`
+	// 通行密钥使用的用户标识，首次注册通行密钥时生成
+	WebAuthnHandle string `gorm:"size:64;index" json:"-"`
+	// 个人资料，在作者主页公开显示
+	DisplayName string `gorm:"size:64" json:"display_name"`
+	Bio         string `gorm:"size:1024" json:"bio"`
+	Website     string `gorm:"size:255" json:"website"`
+	// 社交账号链接，键为平台名称，例如github、twitter
+	SocialLinks map[string]string `gorm:"type:text;serializer:json" json:"social_links"`
+	// 上传的头像文件名，为空时使用自动生成的头像
+	Avatar string `gorm:"size:128" json:"-"`
 }
 
 type Post struct {
@@ -18,8 +39,40 @@ type Post struct {
 	Content string `gorm:"not null"`
 	UserID  uint
 	User    User
+	// 文章的评论审核模式，为空时使用站点默认配置
+	CommentModeration string `gorm:"size:20"`
+	// 文章状态，草稿只有作者和编辑可以看到，历史文章默认为已发布
+	Status string `gorm:"size:20;default:published;index"`
+	// 首次发布的时间，公开页面按发布时间排序
+	PublishedAt *time.Time `gorm:"index"`
+	Tags        []Tag      `gorm:"many2many:post_tags"`
+	// SEO设置，为空时分别使用文章摘要、文章页面的地址和文章中的第一张图片
+	MetaDescription string `gorm:"size:300"`
+	CanonicalURL    string `gorm:"size:500"`
+	SocialImage     string `gorm:"size:500"`
 }
 
+// 文章状态
+const (
+	PostStatusDraft     = "draft"     // 草稿
+	PostStatusPublished = "published" // 已发布
+)
+
+// 评论审核模式
+const (
+	ModerationOpen      = "open"       // 评论直接公开
+	ModerationFirstTime = "first_time" // 首次评论的用户需要审核
+	ModerationAll       = "all"        // 所有评论都需要审核
+)
+
+// 评论状态
+const (
+	CommentStatusPending  = "pending"  // 待审核
+	CommentStatusApproved = "approved" // 已通过
+	CommentStatusRejected = "rejected" // 已拒绝
+	CommentStatusSpam     = "spam"     // 垃圾评论
+)
+
 type Comment struct {
 	gorm.Model
 	Content string `gorm:"not null"`
@@ -27,6 +80,26 @@ type Comment struct {
 	PostID  uint
 	User    User
 	Post    Post
+	// 审核状态，历史评论默认视为已通过
+	Status      string `gorm:"size:20;default:approved;index"`
+	ModeratedBy uint
+	ModeratedAt *time.Time
+	// 垃圾评论检测信息，评论者的IP等信息不对外返回，检测得分只在审核队列中返回
+	IP          string  `gorm:"size:64;index" json:"-"`
+	UserAgent   string  `gorm:"size:255" json:"-"`
+	ContentHash string  `gorm:"size:64;index" json:"-"`
+	SpamScore   float64 `gorm:"default:0" json:"-"`
+	SpamReasons string  `gorm:"size:1024" json:"-"`
+	// 用于训练贝叶斯分类器时的类别：spam、ham，为空表示未参与训练
+	TrainedAs string `gorm:"size:10;index" json:"-"`
+}
+
+// SpamToken 贝叶斯分类器的词频统计，记录包含该词的垃圾评论和正常评论数量
+type SpamToken struct {
+	ID        uint   `gorm:"primarykey"`
+	Token     string `gorm:"size:64;uniqueIndex;not null"`
+	SpamCount int    `gorm:"not null;default:0"`
+	HamCount  int    `gorm:"not null;default:0"`
 }
 
 var DB *gorm.DB
@@ -39,6 +112,22 @@ func InitDB() {
 		Log.Error("数据库链接错误:", err)
 	}
 	Log.Info("数据库链接成功")
-	DB.AutoMigrate(&User{}, &Post{}, &Comment{})
+	// 邮箱验证功能上线前注册的用户视为已验证
+	verifiedColumnExists := DB.Migrator().HasColumn(&User{}, "EmailVerified")
+	if err := AutoMigrate(DB); err != nil {
+		Log.Error("数据库迁移失败:", err)
+	}
+	if !verifiedColumnExists {
+		DB.Model(&User{}).Where("1 = 1").Update("email_verified", true)
+	}
+	// 注册接口曾允许客户端设置totp_enabled，启用了两步验证却没有密钥的账号恢复为未启用，需要重新设置
+	DB.Model(&User{}).Where("totp_enabled = ? AND (totp_secret IS NULL OR totp_secret = '')", true).Update("totp_enabled", false)
+	// 发布状态上线前的文章以创建时间作为发布时间
+	DB.Model(&Post{}).Where("status = ? AND published_at IS NULL", PostStatusPublished).Update("published_at", gorm.Expr("created_at"))
 	Log.Info("数据库迁移成功")
 }
+
+// AutoMigrate 创建或更新全部数据表
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&User{}, &Post{}, &Comment{}, &SpamToken{}, &RefreshToken{}, &RevokedToken{}, &SigningKey{}, &UserToken{}, &LoginAttempt{}, &RecoveryCode{}, &Setting{}, &WebAuthnCredential{}, &WebAuthnSession{}, &PersonalAccessToken{}, &UserIdentity{}, &OIDCState{}, &OAuthClient{}, &OAuthCode{}, &OAuthToken{}, &DataExport{}, &Tag{}, &Media{}, &PostMedia{}, &ImportedItem{})
+}
@@ -36,12 +36,60 @@ var (
 		Details: "用户名或密码错误",
 	}
 
+	ErrTooManyAttempts = &APIError{
+		Code:    http.StatusTooManyRequests, //429
+		Message: "登录尝试过于频繁",
+		Details: "请稍后重试",
+	}
+
+	ErrAccountLocked = &APIError{
+		Code:    http.StatusTooManyRequests, //429
+		Message: "账号已被临时锁定",
+		Details: "登录失败次数过多，请稍后重试或联系管理员解锁",
+	}
+
+	ErrInvalidChallenge = &APIError{
+		Code:    http.StatusUnauthorized, //401
+		Message: "登录验证已过期",
+		Details: "请重新输入用户名和密码",
+	}
+
+	ErrInvalidTwoFactorCode = &APIError{
+		Code:    http.StatusUnauthorized, //401
+		Message: "验证码错误",
+		Details: "两步验证码或恢复码错误",
+	}
+
+	ErrTwoFactorRequired = &APIError{
+		Code:    http.StatusForbidden, //403
+		Message: "需要启用两步验证",
+		Details: "您所在的角色要求启用两步验证，请先完成设置",
+	}
+
 	ErrUnauthorized = &APIError{
 		Code:    http.StatusUnauthorized, //401
 		Message: "未授权访问",
 		Details: "需要有效的身份验证令牌",
 	}
 
+	ErrInvalidRefreshToken = &APIError{
+		Code:    http.StatusUnauthorized, //401
+		Message: "刷新令牌无效",
+		Details: "刷新令牌不存在、已过期或已被吊销，请重新登录",
+	}
+
+	ErrInvalidUserToken = &APIError{
+		Code:    http.StatusBadRequest, //400
+		Message: "链接无效或已过期",
+		Details: "请重新获取链接",
+	}
+
+	ErrEmailNotVerified = &APIError{
+		Code:    http.StatusForbidden, //403
+		Message: "邮箱尚未验证",
+		Details: "请先点击验证邮件中的链接完成邮箱验证",
+	}
+
 	ErrForbidden = &APIError{
 		Code:    http.StatusForbidden, //403
 		Message: "您没有权限执行此操作",
@@ -54,6 +102,24 @@ var (
 		Details: "指定的资源未找到",
 	}
 
+	ErrCommentNotFound = &APIError{
+		Code:    http.StatusNotFound, //404
+		Message: "评论不存在",
+		Details: "指定的评论未找到",
+	}
+
+	ErrTagNotFound = &APIError{
+		Code:    http.StatusNotFound, //404
+		Message: "标签不存在",
+		Details: "指定的标签未找到",
+	}
+
+	ErrMediaNotFound = &APIError{
+		Code:    http.StatusNotFound, //404
+		Message: "媒体文件不存在",
+		Details: "指定的媒体文件未找到",
+	}
+
 	ErrPostCreated = &APIError{
 		Code:    http.StatusBadRequest, //400
 		Message: "内容发布失败",
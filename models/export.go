@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// 个人数据导出的状态
+const (
+	ExportStatusPending = "pending" // 等待生成
+	ExportStatusReady   = "ready"   // 已生成，可以下载
+	ExportStatusFailed  = "failed"  // 生成失败
+)
+
+// DataExport 用户申请的个人数据导出，生成的压缩包保存在导出目录中，过期后删除
+type DataExport struct {
+	ID     uint   `gorm:"primarykey" json:"id"`
+	UserID uint   `gorm:"index;not null" json:"-"`
+	Status string `gorm:"size:20;not null" json:"status"`
+	// 压缩包在导出目录中的文件名
+	FileName    string     `gorm:"size:128" json:"-"`
+	Size        int64      `json:"size"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// 下载链接的过期时间，生成完成后开始计算
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
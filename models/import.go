@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// 导入内容的类型
+const (
+	ImportKindUser    = "user"
+	ImportKindPost    = "post"
+	ImportKindComment = "comment"
+)
+
+// ImportedItem 从其他博客系统导入的内容与本地记录的对应关系，重复导入同一来源时跳过已导入的内容
+type ImportedItem struct {
+	ID uint `gorm:"primarykey"`
+	// 来源的标识，如原站点的地址
+	Source string `gorm:"size:191;uniqueIndex:idx_imported_item;not null"`
+	Kind   string `gorm:"size:20;uniqueIndex:idx_imported_item;not null"`
+	// 内容在来源中的ID
+	ExternalID string `gorm:"size:191;uniqueIndex:idx_imported_item;not null"`
+	// 对应的本地用户、文章或评论的ID
+	LocalID   uint `gorm:"not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// 登录记录的结果
+const (
+	LoginResultSuccess  = "success"  // 登录成功
+	LoginResultFailed   = "failed"   // 用户名或密码错误
+	LoginResultBlocked  = "blocked"  // 处于退避或锁定期间，未校验密码
+	LoginResultUnlocked = "unlocked" // 管理员解锁账号
+)
+
+// LoginAttempt 登录记录，用于登录失败次数统计和用户查看登录历史。
+// UserName为尝试登录的用户名（小写），用户不存在时UserID为0
+type LoginAttempt struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UserID    uint      `gorm:"index" json:"-"`
+	UserName  string    `gorm:"size:64;index:idx_login_user_time;not null" json:"-"`
+	IP        string    `gorm:"size:64;index:idx_login_ip_time" json:"ip"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	Result    string    `gorm:"size:20;not null" json:"result"`
+	Success   bool      `gorm:"not null;default:false" json:"success"`
+	CreatedAt time.Time `gorm:"index:idx_login_user_time;index:idx_login_ip_time;index" json:"created_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Media 用户上传的媒体文件。文件按内容的SHA-256保存，同一文件被多个用户上传时共用一份文件
+type Media struct {
+	ID     uint   `gorm:"primarykey" json:"id"`
+	UserID uint   `gorm:"uniqueIndex:idx_media_user_hash;not null" json:"user_id"`
+	Hash   string `gorm:"size:64;uniqueIndex:idx_media_user_hash;index;not null" json:"hash"`
+	// 上传时的文件名，只用于显示
+	FileName string `gorm:"size:255" json:"file_name"`
+	MimeType string `gorm:"size:50" json:"mime_type"`
+	Size     int64  `json:"size"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	// 原图在媒体目录中的路径
+	Path string `gorm:"size:255;not null" json:"-"`
+	// 缩略图和不同宽度的图片
+	Variants  []MediaVariant `gorm:"type:text;serializer:json" json:"-"`
+	CreatedAt time.Time      `gorm:"index" json:"created_at"`
+}
+
+// MediaVariant 根据原图生成的图片，Name为thumb（缩略图）或w<宽度>
+type MediaVariant struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// PostMedia 文章中引用的媒体文件，保存文章时根据内容更新，没有被引用的媒体文件可以被清理
+type PostMedia struct {
+	PostID  uint `gorm:"primaryKey;autoIncrement:false"`
+	MediaID uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// OAuthClient 第三方客户端，如外部编辑器或移动应用。公开客户端（Public）无法保存密钥，只能依靠PKCE
+type OAuthClient struct {
+	ID       uint   `gorm:"primarykey" json:"id"`
+	ClientID string `gorm:"size:64;uniqueIndex;not null" json:"client_id"`
+	// 客户端密钥的哈希值，公开客户端为空
+	SecretHash string `gorm:"size:64" json:"-"`
+	Name       string `gorm:"size:64;not null" json:"name"`
+	// 允许的回调地址，授权时必须完全一致
+	RedirectURIs []string `gorm:"type:text;serializer:json" json:"redirect_uris"`
+	// 客户端可以申请的权限范围
+	Scopes    []string  `gorm:"size:255;serializer:json" json:"scopes"`
+	Public    bool      `gorm:"not null;default:false" json:"public"`
+	UserID    uint      `gorm:"index;not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OAuthCode 授权码，只保存哈希值，只能使用一次
+type OAuthCode struct {
+	ID            uint      `gorm:"primarykey"`
+	CodeHash      string    `gorm:"size:64;uniqueIndex;not null"`
+	ClientID      uint      `gorm:"index;not null"`
+	UserID        uint      `gorm:"not null"`
+	RedirectURI   string    `gorm:"size:255;not null"`
+	Scopes        []string  `gorm:"size:255;serializer:json"`
+	CodeChallenge string    `gorm:"size:128;not null"`
+	ExpiresAt     time.Time `gorm:"index;not null"`
+	CreatedAt     time.Time
+}
+
+// OAuthToken 用户授权给客户端的令牌，访问令牌和刷新令牌只保存哈希值。
+// 刷新时两个令牌都会更换，删除记录即吊销授权
+type OAuthToken struct {
+	ID               uint        `gorm:"primarykey" json:"id"`
+	ClientID         uint        `gorm:"index;not null" json:"-"`
+	Client           OAuthClient `json:"client"`
+	UserID           uint        `gorm:"index;not null" json:"-"`
+	Scopes           []string    `gorm:"size:255;serializer:json" json:"scopes"`
+	AccessHash       string      `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	AccessExpiresAt  time.Time   `gorm:"not null" json:"-"`
+	RefreshHash      string      `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	RefreshExpiresAt time.Time   `gorm:"index;not null" json:"expires_at"`
+	LastUsedAt       *time.Time  `json:"last_used_at"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"-"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// UserIdentity 用户在外部身份提供方中的身份，Issuer和Subject唯一确定一个外部账号
+type UserIdentity struct {
+	ID      uint   `gorm:"primarykey" json:"id"`
+	UserID  uint   `gorm:"index;not null" json:"-"`
+	Issuer  string `gorm:"size:255;uniqueIndex:idx_identity_subject;not null" json:"issuer"`
+	Subject string `gorm:"size:255;uniqueIndex:idx_identity_subject;not null" json:"subject"`
+	// 最近一次登录时身份提供方返回的邮箱
+	Email       string     `gorm:"size:255" json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// OIDCState 单点登录跳转到身份提供方前保存的状态，回调时校验并删除，只能使用一次
+type OIDCState struct {
+	ID        uint   `gorm:"primarykey"`
+	StateHash string `gorm:"size:64;uniqueIndex;not null"`
+	Nonce     string `gorm:"size:64;not null"`
+	// PKCE的code_verifier
+	CodeVerifier string    `gorm:"size:128;not null"`
+	Redirect     string    `gorm:"size:255"`
+	ExpiresAt    time.Time `gorm:"index;not null"`
+	CreatedAt    time.Time
+}
@@ -0,0 +1,79 @@
+package models
+
+// 用户角色
+const (
+	RoleAdmin  = "admin"  // 管理员，拥有全部权限
+	RoleEditor = "editor" // 编辑，可以管理所有文章和评论
+	RoleAuthor = "author" // 作者，可以发布和管理自己的文章
+	RoleReader = "reader" // 读者，只能发表评论
+)
+
+// 权限
+const (
+	PermPostCreate      = "posts:create"      // 发布文章
+	PermPostEditAny     = "posts:edit_any"    // 修改任意文章
+	PermPostDeleteAny   = "posts:delete_any"  // 删除任意文章
+	PermCommentCreate   = "comments:create"   // 发表评论
+	PermCommentModerate = "comments:moderate" // 审核任意文章下的评论
+	PermUserRead        = "users:read"        // 查看用户列表
+	PermUserManage      = "users:manage"      // 分配用户角色
+	PermSystemManage    = "system:manage"     // 系统管理，如轮换签名密钥
+)
+
+// 个人访问令牌的权限范围
+const (
+	ScopePostsWrite       = "posts:write"       // 发布、修改和删除文章
+	ScopeCommentsModerate = "comments:moderate" // 审核评论
+	ScopeUsersRead        = "users:read"        // 查看用户列表
+)
+
+// ScopePermissions 申请权限范围需要用户角色拥有的权限
+var ScopePermissions = map[string]string{
+	ScopePostsWrite:       PermPostCreate,
+	ScopeCommentsModerate: PermCommentModerate,
+	ScopeUsersRead:        PermUserRead,
+}
+
+// ScopeDescriptions 权限范围的说明，显示在授权页面上
+var ScopeDescriptions = map[string]string{
+	ScopePostsWrite:       "发布、修改和删除文章",
+	ScopeCommentsModerate: "查看和审核评论",
+	ScopeUsersRead:        "查看用户列表",
+}
+
+// RolePermissions 角色拥有的权限
+var RolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermPostCreate, PermPostEditAny, PermPostDeleteAny,
+		PermCommentCreate, PermCommentModerate,
+		PermUserRead, PermUserManage,
+		PermSystemManage,
+	},
+	RoleEditor: {
+		PermPostCreate, PermPostEditAny, PermPostDeleteAny,
+		PermCommentCreate, PermCommentModerate,
+		PermUserRead,
+	},
+	RoleAuthor: {
+		PermPostCreate, PermCommentCreate,
+	},
+	RoleReader: {
+		PermCommentCreate,
+	},
+}
+
+// IsValidRole 判断角色是否存在
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// HasPermission 判断角色是否拥有指定权限
+func HasPermission(role, permission string) bool {
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// 系统设置项的名称
+const (
+	SettingTwoFactorRoles    = "two_factor_roles"    // 必须启用两步验证的角色
+	SettingStorageSigningKey = "storage_signing_key" // 本地存储签名链接使用的密钥
+	SettingRegistrationLock  = "registration_lock"   // 注册时加锁的记录，不保存设置
+)
+
+// Setting 可在运行时由管理员修改的系统设置，Value为JSON格式
+type Setting struct {
+	Name      string `gorm:"size:64;primarykey"`
+	Value     string `gorm:"type:text"`
+	UpdatedAt time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Tag 文章标签，Slug用于URL，名称不同但Slug相同的标签视为同一个标签
+type Tag struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Name      string    `gorm:"size:64;not null" json:"name"`
+	Slug      string    `gorm:"size:64;uniqueIndex;not null" json:"slug"`
+	CreatedAt time.Time `json:"-"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken 刷新令牌，只保存令牌的哈希值。每次刷新都会生成同一家族（FamilyID）的新令牌，
+// 旧令牌被再次使用时视为泄露，整个家族都会被吊销
+type RefreshToken struct {
+	gorm.Model
+	UserID     uint      `gorm:"index;not null"`
+	TokenHash  string    `gorm:"size:64;uniqueIndex;not null"`
+	FamilyID   string    `gorm:"size:64;index;not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	UsedAt     *time.Time
+	RevokedAt  *time.Time
+	ReplacedBy uint
+	IP         string `gorm:"size:64"`
+	UserAgent  string `gorm:"size:255"`
+}
+
+// RevokedToken 令牌吊销列表，TokenID为访问令牌的jti或会话（令牌家族）ID，过期后可以清理
+type RevokedToken struct {
+	ID        uint      `gorm:"primarykey"`
+	TokenID   string    `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
+
+// SigningKey JWT签名密钥，使用PEM格式保存。ExpiresAt为空表示密钥仍用于签名，
+// 轮换后旧密钥在宽限期内只用于验证，过期后不再出现在JWKS中
+type SigningKey struct {
+	gorm.Model
+	Kid        string     `gorm:"size:64;uniqueIndex;not null"`
+	Algorithm  string     `gorm:"size:20;index;not null"`
+	PrivateKey string     `gorm:"type:text;not null"`
+	PublicKey  string     `gorm:"type:text;not null"`
+	ExpiresAt  *time.Time `gorm:"index"`
+}
+
+// 一次性用户令牌的用途
+const (
+	TokenPurposeVerifyEmail    = "verify_email"
+	TokenPurposeResetPassword  = "reset_password"
+	TokenPurposeLoginChallenge = "login_challenge"
+	TokenPurposeSSOLogin       = "sso_login"
+	TokenPurposeChangeEmail    = "change_email"
+	TokenPurposeDataExport     = "data_export"
+)
+
+// UserToken 邮件中发送的一次性令牌，如邮箱验证、重置密码，只保存令牌的哈希值
+type UserToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index;not null"`
+	Purpose   string `gorm:"size:32;index;not null"`
+	TokenHash string `gorm:"size:64;uniqueIndex;not null"`
+	// 令牌对应的邮箱，邮箱变更后旧的验证令牌失效
+	Email     string    `gorm:"size:255"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// RecoveryCode 两步验证的恢复码，只保存哈希值，每个恢复码只能使用一次
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"size:64;index;not null"`
+	UsedAt   *time.Time
+}
+
+// PersonalAccessToken 个人访问令牌，用于CI等自动化场景，只保存令牌的哈希值。
+// 令牌只能访问Scopes范围内的接口，并且不会超出用户角色本身的权限
+type PersonalAccessToken struct {
+	ID        uint   `gorm:"primarykey" json:"id"`
+	UserID    uint   `gorm:"index;not null" json:"-"`
+	Name      string `gorm:"size:64;not null" json:"name"`
+	TokenHash string `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	// 令牌的前几位，用于在列表中区分令牌
+	Prefix     string     `gorm:"size:16" json:"prefix"`
+	Scopes     []string   `gorm:"size:255;serializer:json" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"size:64" json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// WebAuthnCredential 用户注册的通行密钥，一个用户可以有多个
+type WebAuthnCredential struct {
+	ID     uint   `gorm:"primarykey" json:"id"`
+	UserID uint   `gorm:"index;not null" json:"-"`
+	Name   string `gorm:"size:64;not null" json:"name"`
+	// 凭证ID的Base64URL编码
+	CredentialID string `gorm:"size:255;uniqueIndex;not null" json:"-"`
+	// 序列化的凭证数据，包括公钥和签名计数
+	Data       string     `gorm:"type:text;not null" json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+}
+
+// WebAuthnSession 注册或登录过程中保存的挑战信息，只能使用一次
+type WebAuthnSession struct {
+	ID        uint   `gorm:"primarykey"`
+	TokenHash string `gorm:"size:64;uniqueIndex;not null"`
+	// 注册时为当前用户，登录时为0
+	UserID  uint   `gorm:"index"`
+	Purpose string `gorm:"size:20;not null"`
+	// 注册时用户填写的通行密钥名称
+	Name      string    `gorm:"size:64"`
+	Data      string    `gorm:"type:text;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
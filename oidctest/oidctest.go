@@ -0,0 +1,336 @@
+// Package oidctest 本地的OpenID Connect身份提供方，用于在Go测试和开发环境中代替真实的身份提供方测试单点登录。
+//
+// 只实现授权码模式：/.well-known/openid-configuration、/authorize、/token、/userinfo、/jwks。
+// 授权时强制使用PKCE（S256）。设置了User时授权请求直接通过，否则显示表单由开发者填写用户信息。
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// 授权码和访问令牌的有效期
+const (
+	codeTTL  = time.Minute
+	tokenTTL = time.Hour
+)
+
+// User 身份提供方中的用户
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// 对应preferred_username声明
+	Username string
+	Groups   []string
+}
+
+// 已签发但尚未使用的授权码
+type authCode struct {
+	user        User
+	redirectURI string
+	nonce       string
+	challenge   string
+	expiresAt   time.Time
+}
+
+// Provider 身份提供方，Issuer需要与依赖方配置的签发者一致
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// 不为空时授权请求直接使用该用户
+	User *User
+
+	key          *rsa.PrivateKey
+	kid          string
+	mu           sync.Mutex
+	codes        map[string]authCode
+	accessTokens map[string]User
+}
+
+// New 创建身份提供方，每次创建都会生成新的RSA签名密钥
+func New(issuer, clientID, clientSecret string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return &Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          randomString(8),
+		codes:        map[string]authCode{},
+		accessTokens: map[string]User{},
+	}
+}
+
+// NewServer 创建身份提供方并在本地随机端口启动，签发者为服务地址，使用完毕后需要关闭服务
+func NewServer(clientID, clientSecret string) (*Provider, *httptest.Server) {
+	p := New("", clientID, clientSecret)
+	server := httptest.NewServer(p)
+	p.Issuer = server.URL
+	return p, server
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// 按OAuth2规范返回错误
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// ServeHTTP 处理身份提供方的各个接口
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		p.discovery(w)
+	case "/jwks":
+		p.jwks(w)
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	case "/userinfo":
+		p.userinfo(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Provider) discovery(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"userinfo_endpoint":                     p.Issuer + "/userinfo",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "profile", "email", "groups"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": p.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// 没有设置User时显示的表单
+var authorizeForm = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head><meta charset="UTF-8"><title>本地身份提供方</title></head>
+<body>
+<h2>本地身份提供方</h2>
+<form method="post">
+{{range $k, $v := .}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+{{end}}<p><label>邮箱 <input name="email" value="user@example.com"></label></p>
+<p><label>用户名 <input name="username" value="user"></label></p>
+<p><label>用户组（逗号分隔） <input name="groups" value=""></label></p>
+<p><label><input type="checkbox" name="email_verified" value="true" checked> 邮箱已验证</label></p>
+<button type="submit">登录</button>
+</form>
+</body>
+</html>
+`))
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 客户端和回调地址无效时不能跳转，直接显示错误
+	redirectURI := r.Form.Get("redirect_uri")
+	callback, err := url.Parse(redirectURI)
+	if r.Form.Get("client_id") != p.ClientID || redirectURI == "" || err != nil {
+		http.Error(w, "invalid client_id or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := callback.Query()
+	query.Set("state", r.Form.Get("state"))
+	fail := func(code string) {
+		query.Set("error", code)
+		callback.RawQuery = query.Encode()
+		http.Redirect(w, r, callback.String(), http.StatusFound)
+	}
+	if r.Form.Get("response_type") != "code" {
+		fail("unsupported_response_type")
+		return
+	}
+	if r.Form.Get("code_challenge") == "" || r.Form.Get("code_challenge_method") != "S256" {
+		fail("invalid_request")
+		return
+	}
+
+	user := p.User
+	if user == nil {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			authorizeForm.Execute(w, r.URL.Query())
+			return
+		}
+		user = &User{
+			Subject:       r.Form.Get("email"),
+			Email:         r.Form.Get("email"),
+			EmailVerified: r.Form.Get("email_verified") == "true",
+			Username:      r.Form.Get("username"),
+		}
+		for _, group := range strings.Split(r.Form.Get("groups"), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				user.Groups = append(user.Groups, group)
+			}
+		}
+	}
+
+	code := randomString(32)
+	p.mu.Lock()
+	p.codes[code] = authCode{
+		user:        *user,
+		redirectURI: redirectURI,
+		nonce:       r.Form.Get("nonce"),
+		challenge:   r.Form.Get("code_challenge"),
+		expiresAt:   time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+	query.Set("code", code)
+	callback.RawQuery = query.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+// 校验客户端身份，支持client_secret_basic和client_secret_post
+func (p *Provider) authenticateClient(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	return id == p.ClientID && subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) == 1
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+	if !p.authenticateClient(r) {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+	// 授权码只能使用一次
+	p.mu.Lock()
+	code, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if !ok || time.Now().After(code.expiresAt) || code.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	claims := p.claims(code.user)
+	claims["iss"] = p.Issuer
+	claims["aud"] = p.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(tokenTTL).Unix()
+	if code.nonce != "" {
+		claims["nonce"] = code.nonce
+	}
+	idToken, err := p.Sign(claims)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	accessToken := randomString(32)
+	p.mu.Lock()
+	p.accessTokens[accessToken] = code.user
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	user, ok := p.accessTokens[token]
+	p.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid_token", "")
+		return
+	}
+	writeJSON(w, http.StatusOK, p.claims(user))
+}
+
+// 用户对应的声明
+func (p *Provider) claims(user User) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub":            user.Subject,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"groups":         user.Groups,
+	}
+	if user.Username != "" {
+		claims["preferred_username"] = user.Username
+	}
+	return claims
+}
+
+// Sign 使用身份提供方的密钥签名声明，测试中可以用来构造过期或nonce错误的ID令牌
+func (p *Provider) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	return token.SignedString(p.key)
+}
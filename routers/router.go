@@ -1,36 +1,288 @@
 package routers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xiaohan1995/Gin-blog/config"
 	"github.com/xiaohan1995/Gin-blog/middleware"
 	"github.com/xiaohan1995/Gin-blog/models"
 	"github.com/xiaohan1995/Gin-blog/service"
+	"github.com/xiaohan1995/Gin-blog/storage"
+	"github.com/xiaohan1995/Gin-blog/theme"
 )
 
 func InitRouter(r *gin.Engine) {
-	r.GET("/", func(c *gin.Context) {
+	// 使用Cookie会话的状态修改请求需要校验CSRF令牌
+	r.Use(middleware.CSRFMiddleware)
+
+	// 公开页面的主题，开发模式下修改模板后不需要重启
+	site, err := theme.New(filepath.Join(config.Conf.Theme.Dir, config.Conf.Theme.Name), config.Conf.Theme.Reload, "")
+	if err != nil {
+		models.Log.Error("加载主题失败:", err)
+		panic(err)
+	}
+	r.Static(theme.AssetsPath, site.AssetsDir())
+
+	//首页，分页显示已发布的文章
+	r.GET("/", middleware.OptionalAuthMiddleware, func(c *gin.Context) {
+		viewerID, _ := c.Get("user_id")
+		page, apiErr := service.SiteHome(1, viewerID)
+		renderSite(c, site, page, apiErr)
+	})
+	r.GET("/page/:page", middleware.OptionalAuthMiddleware, func(c *gin.Context) {
+		pageNum, ok := pageParam(c, site, "")
+		if !ok {
+			return
+		}
+		viewerID, _ := c.Get("user_id")
+		page, apiErr := service.SiteHome(pageNum, viewerID)
+		renderSite(c, site, page, apiErr)
+	})
+
+	//文章页面
+	r.GET("/post/:id", middleware.OptionalAuthMiddleware, func(c *gin.Context) {
+		postID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			renderSite(c, site, service.SitePage{}, models.ErrPostNotFound)
+			return
+		}
+		viewerID, _ := c.Get("user_id")
+		page, apiErr := service.SitePost(uint(postID), viewerID)
+		renderSite(c, site, page, apiErr)
+	})
+
+	//标签列表和标签页面
+	r.GET("/tags", func(c *gin.Context) {
+		page, apiErr := service.SiteTags()
+		renderSite(c, site, page, apiErr)
+	})
+	r.GET("/tags/:slug", middleware.OptionalAuthMiddleware, func(c *gin.Context) {
+		viewerID, _ := c.Get("user_id")
+		page, apiErr := service.SiteTag(c.Param("slug"), 1, viewerID)
+		renderSite(c, site, page, apiErr)
+	})
+	r.GET("/tags/:slug/page/:page", middleware.OptionalAuthMiddleware, func(c *gin.Context) {
+		pageNum, ok := pageParam(c, site, service.TagPath(c.Param("slug")))
+		if !ok {
+			return
+		}
+		viewerID, _ := c.Get("user_id")
+		page, apiErr := service.SiteTag(c.Param("slug"), pageNum, viewerID)
+		renderSite(c, site, page, apiErr)
+	})
+
+	//归档页面
+	r.GET("/archive", func(c *gin.Context) {
+		page, apiErr := service.SiteArchive()
+		renderSite(c, site, page, apiErr)
+	})
+
+	//订阅源：全站、标签和作者，每种都有RSS 2.0（feed.xml）、Atom 1.0（atom.xml）和JSON Feed 1.1（feed.json）三种格式
+	for _, format := range []string{service.FeedRSS, service.FeedAtom, service.FeedJSON} {
+		r.GET(service.FeedPath("", format), func(c *gin.Context) {
+			feed, apiErr := service.GetSiteFeed(format, feedFullContent(c))
+			serveFeed(c, site, feed, apiErr)
+		})
+		r.GET(service.FeedPath("/tags/:slug", format), func(c *gin.Context) {
+			feed, apiErr := service.GetTagFeed(c.Param("slug"), format, feedFullContent(c))
+			serveFeed(c, site, feed, apiErr)
+		})
+		r.GET(service.FeedPath("/authors/:username", format), func(c *gin.Context) {
+			feed, apiErr := service.GetAuthorFeed(c.Param("username"), format, feedFullContent(c))
+			serveFeed(c, site, feed, apiErr)
+		})
+	}
+
+	//搜索引擎：robots.txt和sitemap，地址数量超过上限时sitemap.xml为索引，指向/sitemaps/下拆分后的文件
+	r.GET("/robots.txt", func(c *gin.Context) {
+		c.String(http.StatusOK, service.RobotsTxt())
+	})
+	r.GET(service.SitemapPath, func(c *gin.Context) {
+		data, modTime, apiErr := service.Sitemap()
+		if apiErr != nil {
+			c.String(apiErr.Code, apiErr.Message)
+			return
+		}
+		serveConditional(c, "application/xml; charset=utf-8", modTime, data)
+	})
+	r.GET("/sitemaps/:file", func(c *gin.Context) {
+		n, err := strconv.Atoi(strings.TrimSuffix(c.Param("file"), ".xml"))
+		if err != nil || !strings.HasSuffix(c.Param("file"), ".xml") {
+			renderSite(c, site, service.SitePage{}, models.ErrPostNotFound)
+			return
+		}
+		data, modTime, apiErr := service.SitemapPart(n)
+		if apiErr != nil {
+			renderSite(c, site, service.SitePage{}, apiErr)
+			return
+		}
+		serveConditional(c, "application/xml; charset=utf-8", modTime, data)
+	})
+
+	//其他页面不存在时，接口返回JSON，页面显示主题的404页面
+	r.NoRoute(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.JSON(models.ErrPostNotFound.Code, models.ErrPostNotFound)
+			return
+		}
+		renderSite(c, site, service.SitePage{}, models.ErrPostNotFound)
+	})
+
+	//JWT公钥集合，供其他服务验证令牌
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		jwks, err := service.JWKS()
+		if err != nil {
+			models.Log.Error("获取JWKS失败:", err)
+			c.JSON(models.ErrInternalServer.Code, models.ErrInternalServer)
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, jwks)
+	})
+
+	//OAuth2授权服务器元数据（RFC 8414）
+	r.GET("/.well-known/oauth-authorization-server", func(c *gin.Context) {
+		base := strings.TrimRight(config.Conf.Site.BaseURL, "/")
+		scopes := []string{}
+		for scope := range models.ScopePermissions {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
 		c.JSON(http.StatusOK, gin.H{
-			"message": "Welcome to Gin Blog API",
+			"issuer":                                base,
+			"authorization_endpoint":                base + "/oauth/authorize",
+			"token_endpoint":                        base + "/oauth/token",
+			"introspection_endpoint":                base + "/oauth/introspect",
+			"revocation_endpoint":                   base + "/oauth/revoke",
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"scopes_supported":                      scopes,
 		})
 	})
 
+	//OAuth2授权页面，用户登录后确认是否允许第三方客户端访问
+	r.GET("/oauth/authorize", middleware.PageAuthMiddleware, func(c *gin.Context) {
+		var authReq service.AuthorizeRequest
+		c.ShouldBindQuery(&authReq)
+		renderAuthorize(c, authReq)
+	})
+
+	//OAuth2授权确认，同意时跳转回客户端并携带授权码
+	r.POST("/oauth/authorize", middleware.PageAuthMiddleware, func(c *gin.Context) {
+		var authReq service.AuthorizeRequest
+		c.ShouldBind(&authReq)
+		authCtx, oauthErr := service.ValidateAuthorizeRequest(authReq)
+		if oauthErr != nil {
+			renderAuthorizeError(c, authCtx, oauthErr)
+			return
+		}
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.HTML(http.StatusBadRequest, "oauth-authorize.html", gin.H{"error": "请先登录"})
+			return
+		}
+		params := url.Values{}
+		if authCtx.State != "" {
+			params.Set("state", authCtx.State)
+		}
+		if c.PostForm("action") != "approve" {
+			params.Set("error", "access_denied")
+			c.Redirect(http.StatusFound, service.OAuthRedirect(authCtx.RedirectURI, params))
+			return
+		}
+		code, apiErr := service.ApproveAuthorization(userID.(uint), authCtx)
+		if apiErr != nil {
+			params.Set("error", "server_error")
+			c.Redirect(http.StatusFound, service.OAuthRedirect(authCtx.RedirectURI, params))
+			return
+		}
+		params.Set("code", code)
+		c.Redirect(http.StatusFound, service.OAuthRedirect(authCtx.RedirectURI, params))
+	})
+
+	//OAuth2令牌接口，支持授权码和刷新令牌两种方式
+	r.POST("/oauth/token", func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		client, oauthErr := oauthClient(c)
+		if oauthErr != nil {
+			c.JSON(oauthErr.Status, oauthErr)
+			return
+		}
+		var res map[string]interface{}
+		switch c.PostForm("grant_type") {
+		case "authorization_code":
+			res, oauthErr = service.ExchangeAuthorizationCode(client, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+		case "refresh_token":
+			res, oauthErr = service.RefreshOAuthToken(client, c.PostForm("refresh_token"))
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+			return
+		}
+		if oauthErr != nil {
+			c.JSON(oauthErr.Status, oauthErr)
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	//OAuth2令牌内省（RFC 7662），客户端只能查询自己的令牌
+	r.POST("/oauth/introspect", func(c *gin.Context) {
+		client, oauthErr := oauthClient(c)
+		if oauthErr != nil {
+			c.JSON(oauthErr.Status, oauthErr)
+			return
+		}
+		c.JSON(http.StatusOK, service.IntrospectOAuthToken(client, c.PostForm("token")))
+	})
+
+	//OAuth2令牌吊销（RFC 7009），访问令牌和刷新令牌都可以吊销，对应的授权同时失效
+	r.POST("/oauth/revoke", func(c *gin.Context) {
+		client, oauthErr := oauthClient(c)
+		if oauthErr != nil {
+			c.JSON(oauthErr.Status, oauthErr)
+			return
+		}
+		if oauthErr := service.RevokeOAuthToken(client, c.PostForm("token")); oauthErr != nil {
+			c.JSON(oauthErr.Status, oauthErr)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
 	//注册页面
-	r.GET("/register", func(c *gin.Context) {
+	r.GET("/register", middleware.CSRFCookieMiddleware, func(c *gin.Context) {
 		c.HTML(http.StatusOK, "register.html", nil)
 	})
 
 	//注册api
 	r.POST("/api/register", func(c *gin.Context) {
-		var user models.User
-		if err := c.ShouldBind(&user); err != nil {
+		// 只接收用户名、邮箱和密码，角色、邮箱验证状态等字段由服务端设置
+		var registerReq struct {
+			Username string `json:"username" form:"username" binding:"required,min=3"`
+			Email    string `json:"email" form:"email" binding:"required,email"`
+			Password string `json:"password" form:"password" binding:"required,min=6"`
+		}
+		if err := c.ShouldBind(&registerReq); err != nil {
 			models.Log.Warning("注册请求无效:", err)
 			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
 			return
 		}
 
+		user := models.User{UserName: registerReq.Username, Email: registerReq.Email, Password: registerReq.Password}
 		if apiErr := service.RegisterUser(user); apiErr != nil {
 			c.JSON(apiErr.Code, apiErr)
 			return
@@ -41,8 +293,66 @@ func InitRouter(r *gin.Engine) {
 	})
 
 	//登录页面
-	r.GET("/login", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "login.html", nil)
+	r.GET("/login", middleware.CSRFCookieMiddleware, func(c *gin.Context) {
+		c.HTML(http.StatusOK, "login.html", gin.H{
+			"redirect": safeRedirect(c.Query("redirect")),
+			"oidc":     config.Conf.OIDC.Enabled,
+			"oidcName": config.Conf.OIDC.Name,
+		})
+	})
+
+	//单点登录，跳转到身份提供方
+	r.GET("/auth/oidc/login", func(c *gin.Context) {
+		authURL, state, apiErr := service.BeginOIDCLogin(safeRedirect(c.Query("redirect")))
+		if apiErr != nil {
+			c.Redirect(http.StatusFound, "/login?sso_error="+url.QueryEscape(apiErr.Message))
+			return
+		}
+		// 回调时校验state是否与发起登录的浏览器一致，跨站跳转回来时需要携带Cookie，只能使用Lax
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(middleware.OIDCStateCookieName, state, 600, "/auth/oidc", "", config.Conf.Session.Secure, true)
+		c.Redirect(http.StatusFound, authURL)
+	})
+
+	//单点登录回调，校验通过后跳转到登录页面，由页面脚本使用一次性令牌完成登录
+	r.GET("/auth/oidc/callback", func(c *gin.Context) {
+		cookieState, _ := c.Cookie(middleware.OIDCStateCookieName)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(middleware.OIDCStateCookieName, "", -1, "/auth/oidc", "", config.Conf.Session.Secure, true)
+		if errMsg := c.Query("error"); errMsg != "" {
+			models.Log.Warning("身份提供方返回错误:", errMsg, c.Query("error_description"))
+			c.Redirect(http.StatusFound, "/login?sso_error="+url.QueryEscape("身份提供方拒绝了登录请求"))
+			return
+		}
+		token, redirect, apiErr := service.FinishOIDCLogin(c.Query("state"), cookieState, c.Query("code"))
+		if apiErr != nil {
+			c.Redirect(http.StatusFound, "/login?sso_error="+url.QueryEscape(apiErr.Details))
+			return
+		}
+		c.Redirect(http.StatusFound, "/login?"+url.Values{"sso": {token}, "redirect": {safeRedirect(redirect)}}.Encode())
+	})
+
+	//单点登录api，使用回调得到的一次性令牌换取访问令牌
+	r.POST("/api/login/sso", func(c *gin.Context) {
+		var ssoReq struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&ssoReq); err != nil {
+			models.Log.Warning("单点登录请求无效:", err)
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		res, apiErr := service.CompleteSSOLogin(ssoReq.Token, c.ClientIP(), c.Request.UserAgent())
+		if apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		// 启用两步验证时返回挑战令牌，此时还没有签发令牌
+		if _, ok := res["challenge_token"]; ok {
+			c.JSON(http.StatusOK, res)
+			return
+		}
+		respondTokens(c, res)
 	})
 
 	//登录api
@@ -62,37 +372,315 @@ func InitRouter(r *gin.Engine) {
 				password = loginReq.Password
 			}
 		}
-		res, apiErr := service.LoginUser(username, password)
+		res, apiErr := service.LoginUser(username, password, c.ClientIP(), c.Request.UserAgent())
 		if apiErr != nil {
 			c.JSON(apiErr.Code, apiErr)
 			return
 		}
-		c.JSON(http.StatusOK, res)
+		// 启用两步验证时返回挑战令牌，此时还没有签发令牌
+		if _, ok := res["challenge_token"]; ok {
+			c.JSON(http.StatusOK, res)
+			return
+		}
+		respondTokens(c, res)
+
+	})
+
+	//两步验证登录api，使用登录返回的挑战令牌和验证码（或恢复码）完成登录
+	r.POST("/api/login/2fa", func(c *gin.Context) {
+		var twoFactorReq struct {
+			ChallengeToken string `json:"challenge_token" form:"challenge_token" binding:"required"`
+			Code           string `json:"code" form:"code" binding:"required"`
+		}
+		if err := c.ShouldBind(&twoFactorReq); err != nil {
+			models.Log.Warning("两步验证请求无效:", err)
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		res, apiErr := service.CompleteTwoFactorLogin(twoFactorReq.ChallengeToken, twoFactorReq.Code, c.ClientIP(), c.Request.UserAgent())
+		if apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		respondTokens(c, res)
+	})
+
+	//通行密钥登录api，第一步获取登录选项，用户名可以为空
+	r.POST("/api/login/webauthn/begin", func(c *gin.Context) {
+		var beginReq struct {
+			Username string `json:"username" form:"username"`
+		}
+		c.ShouldBind(&beginReq)
+		begin, apiErr := service.BeginWebAuthnLogin(beginReq.Username)
+		if apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		c.JSON(http.StatusOK, begin)
+	})
+
+	//通行密钥登录api，第二步提交浏览器返回的签名结果，session为第一步返回的会话
+	r.POST("/api/login/webauthn/finish", func(c *gin.Context) {
+		body, err := c.GetRawData()
+		if err != nil || c.Query("session") == "" {
+			models.Log.Warning("通行密钥登录请求无效:", err)
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		res, apiErr := service.FinishWebAuthnLogin(c.Query("session"), body, c.ClientIP(), c.Request.UserAgent())
+		if apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		respondTokens(c, res)
+	})
+
+	//刷新令牌api
+	r.POST("/api/token/refresh", func(c *gin.Context) {
+		var refreshReq struct {
+			RefreshToken string `json:"refresh_token" form:"refresh_token"`
+		}
+		c.ShouldBind(&refreshReq)
+		// 请求中没有刷新令牌时使用Cookie中的刷新令牌
+		if refreshReq.RefreshToken == "" {
+			refreshReq.RefreshToken, _ = c.Cookie(middleware.RefreshCookieName)
+		}
+		if refreshReq.RefreshToken == "" {
+			models.Log.Warning("刷新令牌请求无效")
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		tokens, apiErr := service.RefreshTokens(refreshReq.RefreshToken, c.ClientIP(), c.Request.UserAgent())
+		if apiErr != nil {
+			middleware.ClearSessionCookies(c)
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		respondTokens(c, map[string]interface{}{
+			"message":       "刷新成功",
+			"token":         tokens.AccessToken,
+			"refresh_token": tokens.RefreshToken,
+			"expires_in":    tokens.ExpiresIn,
+		})
+	})
+
+	//退出登录api
+	r.POST("/api/logout", middleware.AuthMiddleware, func(c *gin.Context) {
+		claims, _ := c.Get("claims")
+		if apiErr := service.Logout(claims.(*service.JWTClaims)); apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		middleware.ClearSessionCookies(c)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "已退出登录",
+		})
+	})
+
+	//退出全部会话api
+	r.POST("/api/logout/all", middleware.AuthMiddleware, func(c *gin.Context) {
+		claims, _ := c.Get("claims")
+		if apiErr := service.LogoutAll(claims.(*service.JWTClaims)); apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		middleware.ClearSessionCookies(c)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "已退出全部会话",
+		})
+	})
+
+	//邮箱验证api，验证邮件中的链接
+	r.GET("/api/verify-email", func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		if apiErr := service.VerifyEmail(token); apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "邮箱验证成功",
+		})
+	})
+
+	//确认修改邮箱api，新邮箱收到的邮件中的链接
+	r.GET("/api/confirm-email", func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		if apiErr := service.ConfirmEmailChange(token); apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "邮箱修改成功",
+		})
+	})
+
+	//下载个人数据导出，邮件中的链接，有效期内可以多次下载
+	r.GET("/exports/download", func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		link, apiErr := service.DownloadDataExport(token)
+		if apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		// 跳转到有效期较短的签名链接，使用对象存储时直接从对象存储下载
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, link)
+	})
+
+	//本地存储的签名下载链接
+	r.GET(service.StorageFilesPath+"/*key", func(c *gin.Context) {
+		name := c.Query("name")
+		file, apiErr := service.OpenSignedFile(strings.TrimPrefix(c.Param("key"), "/"), c.Query("expires"), name, c.Query("signature"))
+		if apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		c.Header("Cache-Control", "private, no-store")
+		c.Header("Content-Disposition", storage.Attachment(name))
+		serveStoredFile(c, file)
+	})
+
+	//忘记密码页面
+	r.GET("/forgot-password", middleware.CSRFCookieMiddleware, func(c *gin.Context) {
+		c.HTML(http.StatusOK, "forgot-password.html", nil)
+	})
+
+	//发送重置密码邮件api
+	r.POST("/api/password/forgot", func(c *gin.Context) {
+		var forgotReq struct {
+			Email string `json:"email" form:"email" binding:"required,email"`
+		}
+		if err := c.ShouldBind(&forgotReq); err != nil {
+			models.Log.Warning("重置密码请求无效:", err)
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		if apiErr := service.RequestPasswordReset(forgotReq.Email); apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "如果该邮箱已注册，您将收到一封重置密码的邮件",
+		})
+	})
+
+	//重置密码页面
+	r.GET("/reset-password", middleware.CSRFCookieMiddleware, func(c *gin.Context) {
+		c.HTML(http.StatusOK, "reset-password.html", gin.H{"token": c.Query("token")})
+	})
 
+	//重置密码api
+	r.POST("/api/password/reset", func(c *gin.Context) {
+		var resetReq struct {
+			Token    string `json:"token" form:"token" binding:"required"`
+			Password string `json:"password" form:"password" binding:"required"`
+		}
+		if err := c.ShouldBind(&resetReq); err != nil {
+			models.Log.Warning("重置密码请求无效:", err)
+			c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			return
+		}
+		if apiErr := service.ResetPassword(resetReq.Token, resetReq.Password); apiErr != nil {
+			c.JSON(apiErr.Code, apiErr)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "密码已重置，请重新登录",
+		})
 	})
 
 	// //管理主页
-	r.GET("/admin", func(c *gin.Context) {
+	r.GET("/admin", middleware.PageAuthMiddleware, func(c *gin.Context) {
 		c.HTML(http.StatusOK, "admin.html", nil)
 	})
 
 	//用户列表
-	r.GET("/users", func(c *gin.Context) {
+	r.GET("/users", middleware.PageAuthMiddleware, func(c *gin.Context) {
 		c.HTML(http.StatusOK, "users.html", nil)
 	})
 
 	//文章列表
-	r.GET("/posts", func(c *gin.Context) {
+	r.GET("/posts", middleware.PageAuthMiddleware, func(c *gin.Context) {
 		c.HTML(http.StatusOK, "posts.html", nil)
 	})
 
 	//评论列表
-	r.GET("/comments", func(c *gin.Context) {
+	r.GET("/comments", middleware.PageAuthMiddleware, func(c *gin.Context) {
 		c.HTML(http.StatusOK, "comments.html", nil)
 	})
 
+	//账号安全页面
+	r.GET("/security", middleware.PageAuthMiddleware, func(c *gin.Context) {
+		c.HTML(http.StatusOK, "security.html", nil)
+	})
+
+	//个人资料页面
+	r.GET("/profile", middleware.PageAuthMiddleware, func(c *gin.Context) {
+		c.HTML(http.StatusOK, "profile.html", nil)
+	})
+
+	//作者主页，公开显示作者资料和已发布的文章
+	r.GET("/authors/:username", middleware.OptionalAuthMiddleware, func(c *gin.Context) {
+		viewerID, _ := c.Get("user_id")
+		page, apiErr := service.SiteAuthor(c.Param("username"), 1, viewerID)
+		renderSite(c, site, page, apiErr)
+	})
+	r.GET("/authors/:username/page/:page", middleware.OptionalAuthMiddleware, func(c *gin.Context) {
+		pageNum, ok := pageParam(c, site, service.AuthorPath(c.Param("username")))
+		if !ok {
+			return
+		}
+		viewerID, _ := c.Get("user_id")
+		page, apiErr := service.SiteAuthor(c.Param("username"), pageNum, viewerID)
+		renderSite(c, site, page, apiErr)
+	})
+
+	//用户头像，没有上传头像时返回自动生成的头像
+	r.GET("/avatars/:id", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		file, data, apiErr := service.GetAvatar(uint(id))
+		if apiErr != nil {
+			c.Status(apiErr.Code)
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=3600")
+		if file != nil {
+			serveStoredFile(c, *file)
+			return
+		}
+		c.Data(http.StatusOK, "image/png", data)
+	})
+
+	//媒体文件，文件名包含内容的哈希，内容不会变化
+	r.GET(service.MediaPathPrefix+"/*filepath", func(c *gin.Context) {
+		file, apiErr := service.MediaFile(strings.TrimPrefix(c.Param("filepath"), "/"))
+		if apiErr != nil {
+			c.Status(apiErr.Code)
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("X-Content-Type-Options", "nosniff")
+		serveStoredFile(c, file)
+	})
+
 	//文章详情页面
-	r.GET("/post-detail/:id", func(c *gin.Context) {
+	r.GET("/post-detail/:id", middleware.PageAuthMiddleware, func(c *gin.Context) {
 		postID := c.Param("id")
 		if postID == "" {
 			models.Log.Error("文章ID为空")
@@ -102,22 +690,131 @@ func InitRouter(r *gin.Engine) {
 
 	})
 
-	// 受保护的路由示例
-	protected := r.Group("/api/protected")
-	protected.Use(middleware.AuthMiddleware)
+	// 公开的只读接口，只返回已发布的内容，不需要登录。携带令牌时返回当前用户相关的信息
+	public := r.Group("/api/public")
+	public.Use(middleware.OptionalAuthMiddleware)
 	{
-		//用户列表
-		protected.GET("/users", func(c *gin.Context) {
-			users, err := service.GetUsers()
-			if err != nil {
-				c.JSON(models.ErrDatabaseConnection.Code, models.ErrDatabaseConnection)
+		//已发布的文章列表，支持按标签和作者筛选
+		public.GET("/posts", func(c *gin.Context) {
+			viewerID, _ := c.Get("user_id")
+			page, apiErr := service.ListPublishedPosts(postQuery(c), viewerID)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
 				return
 			}
-			c.JSON(http.StatusOK, users)
+			c.JSON(http.StatusOK, gin.H{
+				"data": page,
+			})
 		})
-		//获取文章列表
-		protected.GET("/posts", func(c *gin.Context) {
-			posts, count, apiErr := service.GetPosts()
+
+		//已发布的文章详情
+		public.GET("/posts/:id", func(c *gin.Context) {
+			postID, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				c.JSON(models.ErrPostNotFound.Code, models.ErrPostNotFound)
+				return
+			}
+			viewerID, _ := c.Get("user_id")
+			post, apiErr := service.GetPublishedPost(uint(postID), viewerID)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"data": post,
+			})
+		})
+
+		//已发布文章的评论
+		public.GET("/posts/:id/comments", func(c *gin.Context) {
+			postID, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				c.JSON(models.ErrPostNotFound.Code, models.ErrPostNotFound)
+				return
+			}
+			viewerID, _ := c.Get("user_id")
+			comments, apiErr := service.GetPublicComments(uint(postID), viewerID)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"data": comments,
+			})
+		})
+
+		//标签列表
+		public.GET("/tags", func(c *gin.Context) {
+			tags, apiErr := service.GetPublicTags()
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"data": tags,
+			})
+		})
+
+		//标签详情和标签下的文章
+		public.GET("/tags/:slug", func(c *gin.Context) {
+			tag, apiErr := service.GetTag(c.Param("slug"))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			query := postQuery(c)
+			query.Tag = tag.Slug
+			viewerID, _ := c.Get("user_id")
+			page, apiErr := service.ListPublishedPosts(query, viewerID)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"data":  tag,
+				"posts": page,
+			})
+		})
+
+		//作者的公开资料和已发布的文章
+		public.GET("/authors/:username", func(c *gin.Context) {
+			profile, apiErr := service.GetPublicAuthor(c.Param("username"))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			query := postQuery(c)
+			query.Author = profile.Username
+			viewerID, _ := c.Get("user_id")
+			page, apiErr := service.ListPublishedPosts(query, viewerID)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"data":  profile,
+				"posts": page,
+			})
+		})
+	}
+
+	// 受保护的路由示例
+	protected := r.Group("/api/protected")
+	protected.Use(middleware.AuthMiddleware, middleware.RequireTwoFactorSetup)
+	{
+		//用户列表
+		protected.GET("/users", middleware.RequirePermission(models.PermUserRead), func(c *gin.Context) {
+			users, err := service.GetUsers()
+			if err != nil {
+				c.JSON(models.ErrDatabaseConnection.Code, models.ErrDatabaseConnection)
+				return
+			}
+			c.JSON(http.StatusOK, users)
+		})
+		//获取文章列表，包括草稿
+		protected.GET("/posts", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			posts, count, apiErr := service.GetPosts(UserID)
 			if apiErr != nil {
 				c.JSON(http.StatusOK, gin.H{
 					"message": apiErr.Message,
@@ -126,217 +823,1216 @@ func InitRouter(r *gin.Engine) {
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
-				"message": "获取文章列表成功",
-				"data":    posts,
-				"count":   count,
+				"message": "获取文章列表成功",
+				"data":    posts,
+				"count":   count,
+			})
+		})
+		//添加文章
+		protected.POST("/posts", middleware.RequirePermission(models.PermPostCreate), middleware.RequireVerifiedEmail, func(c *gin.Context) {
+			var postReq struct {
+				Title   string   `json:"title" binding:"required"`
+				Content string   `json:"content" binding:"required"`
+				Status  string   `json:"status"`
+				Tags    []string `json:"tags"`
+				service.PostSEO
+			}
+			if err := c.ShouldBind(&postReq); err != nil {
+				models.Log.Error(err.Error())
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			tags, apiErr := service.ResolveTags(postReq.Tags)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			// 从上下文中获取用户ID
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			post := models.Post{
+				Title:   postReq.Title,
+				Content: postReq.Content,
+				UserID:  UserID.(uint),
+				Status:  postReq.Status,
+				Tags:    tags,
+			}
+			postReq.PostSEO.Apply(&post)
+			if apiErr := service.CreatePost(post); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "文章创建成功",
+			})
+		})
+		//获取文章信息
+		protected.GET("/post/:id", func(c *gin.Context) {
+			postID := c.Param("id")
+			if postID == "" {
+				models.Log.Error("文章ID为空")
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			}
+			postIDInt, err := strconv.Atoi(postID)
+			if err != nil {
+				models.Log.Error("文章ID转换失败:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			}
+			// 从上下文中获取用户ID
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+				return
+			}
+			post, apiErr := service.GetPost(uint(postIDInt), UserID)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "获取文章成功",
+				"data":    post,
+			})
+		})
+
+		//更新文章
+		protected.PUT("/post/:id", func(c *gin.Context) {
+			var postReq struct {
+				Title   string `json:"title" binding:"required"`
+				Content string `json:"content" binding:"required"`
+				Status  string `json:"status"`
+				// 不传时不改变文章的标签，传空数组时清除标签
+				Tags []string `json:"tags"`
+				// SEO设置同样不传时不改变，传空字符串时清除
+				service.PostSEO
+			}
+			if err := c.ShouldBind(&postReq); err != nil {
+				models.Log.Error(err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			postID := c.Param("id")
+			postIDInt, err := strconv.Atoi(postID)
+			if err != nil {
+				models.Log.Error("文章ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+				return
+			}
+			post := models.Post{
+				Title:   postReq.Title,
+				Content: postReq.Content,
+				UserID:  UserID.(uint),
+				Status:  postReq.Status,
+			}
+			if postReq.Tags != nil {
+				tags, apiErr := service.ResolveTags(postReq.Tags)
+				if apiErr != nil {
+					c.JSON(apiErr.Code, apiErr)
+					return
+				}
+				post.Tags = tags
+			}
+			apiErr := service.UpdatePost(uint(postIDInt), UserID, post, postReq.PostSEO)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "更新成功",
+			})
+
+		})
+		//删除文章
+		protected.DELETE("/post/:id", func(c *gin.Context) {
+			postID := c.Param("id")
+			if postID == "" {
+				models.Log.Error("文章ID为空")
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			}
+			postIDInt, err := strconv.Atoi(postID)
+			if err != nil {
+				models.Log.Error("文章ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			}
+			// 从上下文中获取用户ID
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+				return
+			}
+			apiErr := service.DeletePost(uint(postIDInt), UserID)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "删除成功",
+			})
+		})
+
+		//上传媒体文件，表单字段为file
+		protected.POST("/media", middleware.RequirePermission(models.PermPostCreate), middleware.RequireVerifiedEmail, func(c *gin.Context) {
+			// 多留出1MB给表单的其他部分
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, service.MediaMaxBytes()+1<<20)
+			file, err := c.FormFile("file")
+			if err != nil {
+				models.Log.Warning("参数错误:", err)
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					c.JSON(service.ErrMediaTooLarge.Code, service.ErrMediaTooLarge)
+					return
+				}
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			f, err := file.Open()
+			if err != nil {
+				models.Log.Error("读取上传文件失败:", err)
+				c.JSON(models.ErrInternalServer.Code, models.ErrInternalServer)
+				return
+			}
+			defer f.Close()
+			userID, _ := c.Get("user_id")
+			media, duplicate, apiErr := service.UploadMedia(userID.(uint), file.Filename, f)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			if duplicate {
+				c.JSON(http.StatusOK, gin.H{"message": "文件已存在", "data": media, "duplicate": true})
+				return
+			}
+			c.JSON(http.StatusCreated, gin.H{"message": "上传成功", "data": media})
+		})
+
+		//媒体文件列表
+		protected.GET("/media", func(c *gin.Context) {
+			page, _ := strconv.Atoi(c.Query("page"))
+			pageSize, _ := strconv.Atoi(c.Query("page_size"))
+			userID, _ := c.Get("user_id")
+			media, apiErr := service.GetMediaList(userID, page, pageSize)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": media})
+		})
+
+		//删除媒体文件，被文章引用时不能删除
+		protected.DELETE("/media/:id", func(c *gin.Context) {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			userID, _ := c.Get("user_id")
+			if apiErr := service.DeleteMedia(uint(id), userID); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+		})
+
+		//清理没有被文章引用的媒体文件，older_than_hours默认为配置的时间，dry_run为true时只统计不删除
+		protected.POST("/media/gc", middleware.RequirePermission(models.PermSystemManage), func(c *gin.Context) {
+			var gcReq struct {
+				OlderThanHours *int `json:"older_than_hours"`
+				DryRun         bool `json:"dry_run"`
+			}
+			if err := c.ShouldBindJSON(&gcReq); err != nil && !errors.Is(err, io.EOF) {
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			hours := config.Conf.Media.GCAfterHours
+			if gcReq.OlderThanHours != nil && *gcReq.OlderThanHours >= 0 {
+				hours = *gcReq.OlderThanHours
+			}
+			result, apiErr := service.CollectUnusedMedia(time.Duration(hours)*time.Hour, gcReq.DryRun)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": result})
+		})
+
+		//获取文章的评论列表
+		protected.GET("/post/:id/comments", func(c *gin.Context) {
+			postID := c.Param("id")
+			if postID == "" {
+				models.Log.Error("文章ID为空")
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			}
+			postIDInt, err := strconv.Atoi(postID)
+			if err != nil {
+				models.Log.Error("文章ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			}
+			res, apiErr := service.GetPostComments(postIDInt)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "获取评论列表成功",
+				"data":    res,
+			})
+
+		})
+
+		//创建评论
+		protected.POST("/comments", middleware.RequirePermission(models.PermCommentCreate), func(c *gin.Context) {
+			var commentReq struct {
+				Content string `json:"content" binding:"required"`
+				PostID  uint   `json:"post_id" binding:"required"`
+			}
+
+			err := c.ShouldBindJSON(&commentReq)
+			if err != nil {
+				models.Log.Error("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			// 从上下文中获取用户ID
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			comment := models.Comment{
+				Content:   commentReq.Content,
+				PostID:    commentReq.PostID,
+				UserID:    UserID.(uint),
+				IP:        c.ClientIP(),
+				UserAgent: c.Request.UserAgent(),
+			}
+			comment, apiErr := service.CreateComment(comment)
+			if apiErr != nil {
+				models.Log.Error("创建评论失败:", apiErr)
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			if comment.Status != models.CommentStatusApproved {
+				c.JSON(http.StatusOK, gin.H{"message": "评论已提交，等待审核", "status": comment.Status})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "评论成功", "status": comment.Status})
+		})
+
+		//获取评论列表
+		protected.GET("/comments", func(c *gin.Context) {
+			comments, apiErr := service.GetComments()
+			if apiErr != nil {
+				models.Log.Error("获取评论列表失败:", apiErr)
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": comments, "message": "获取评论列表成功"})
+		})
+
+		//评论审核队列
+		protected.GET("/moderation/comments", func(c *gin.Context) {
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+				return
+			}
+			comments, apiErr := service.GetModerationQueue(UserID.(uint), c.Query("status"))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "获取审核队列成功",
+				"data":    comments,
+				"count":   len(comments),
+			})
+		})
+
+		//批量审核评论
+		protected.POST("/moderation/comments", func(c *gin.Context) {
+			var moderateReq struct {
+				IDs    []uint `json:"ids" binding:"required"`
+				Action string `json:"action" binding:"required,oneof=approve reject spam"`
+			}
+			if err := c.ShouldBindJSON(&moderateReq); err != nil {
+				models.Log.Error("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+				return
+			}
+			count, apiErr := service.ModerateComments(moderateReq.IDs, moderateReq.Action, UserID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "审核成功",
+				"count":   count,
+			})
+		})
+
+		//设置文章的评论审核模式
+		protected.PUT("/post/:id/moderation", func(c *gin.Context) {
+			var modeReq struct {
+				Mode string `json:"mode"`
+			}
+			if err := c.ShouldBindJSON(&modeReq); err != nil {
+				models.Log.Error("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			postIDInt, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				models.Log.Error("文章ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, exists := c.Get("user_id")
+			if !exists {
+				models.Log.Error("无法获取用户信息")
+				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+				return
+			}
+			apiErr := service.SetPostCommentModeration(uint(postIDInt), UserID.(uint), modeReq.Mode)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "审核模式更新成功",
+			})
+		})
+
+		//分配用户角色
+		protected.PUT("/users/:id/role", middleware.RequirePermission(models.PermUserManage), func(c *gin.Context) {
+			var roleReq struct {
+				Role string `json:"role" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&roleReq); err != nil {
+				models.Log.Error("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			targetID, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				models.Log.Error("用户ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.SetUserRole(UserID.(uint), uint(targetID), roleReq.Role); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "角色分配成功",
+			})
+		})
+
+		//解除账号的登录锁定
+		protected.POST("/users/:id/unlock", middleware.RequirePermission(models.PermUserManage), func(c *gin.Context) {
+			targetID, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				models.Log.Error("用户ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.UnlockUser(UserID.(uint), uint(targetID)); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "账号已解锁",
+			})
+		})
+
+		//当前用户的登录历史
+		protected.GET("/login-history", func(c *gin.Context) {
+			limit, _ := strconv.Atoi(c.Query("limit"))
+			UserID, _ := c.Get("user_id")
+			history, apiErr := service.GetLoginHistory(UserID.(uint), limit)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "获取登录历史成功",
+				"data":    history,
+			})
+		})
+
+		//两步验证状态
+		protected.GET("/2fa", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			user, apiErr := service.GetUserSecurity(UserID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, user)
+		})
+
+		//生成两步验证密钥
+		protected.POST("/2fa/setup", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			setup, apiErr := service.SetupTwoFactor(UserID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "请使用身份验证器扫描二维码，并输入验证码完成设置",
+				"data":    setup,
+			})
+		})
+
+		//两步验证密钥的二维码图片
+		protected.GET("/2fa/qr.png", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			image, apiErr := service.TwoFactorQRCode(UserID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.Header("Cache-Control", "no-store")
+			c.Data(http.StatusOK, "image/png", image)
+		})
+
+		//确认并启用两步验证
+		protected.POST("/2fa/enable", func(c *gin.Context) {
+			var codeReq struct {
+				Code string `json:"code" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&codeReq); err != nil {
+				models.Log.Warning("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			codes, apiErr := service.EnableTwoFactor(UserID.(uint), codeReq.Code)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message":        "两步验证已启用，请妥善保存恢复码",
+				"recovery_codes": codes,
+			})
+		})
+
+		//关闭两步验证
+		protected.POST("/2fa/disable", func(c *gin.Context) {
+			var codeReq struct {
+				Code string `json:"code" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&codeReq); err != nil {
+				models.Log.Warning("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.DisableTwoFactor(UserID.(uint), codeReq.Code); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "两步验证已关闭",
+			})
+		})
+
+		//重新生成恢复码
+		protected.POST("/2fa/recovery-codes", func(c *gin.Context) {
+			var codeReq struct {
+				Code string `json:"code" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&codeReq); err != nil {
+				models.Log.Warning("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			codes, apiErr := service.RegenerateRecoveryCodes(UserID.(uint), codeReq.Code)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message":        "恢复码已重新生成，之前的恢复码已失效",
+				"recovery_codes": codes,
+			})
+		})
+
+		//开始注册通行密钥
+		protected.POST("/webauthn/register/begin", func(c *gin.Context) {
+			var beginReq struct {
+				Name string `json:"name"`
+			}
+			c.ShouldBindJSON(&beginReq)
+			UserID, _ := c.Get("user_id")
+			begin, apiErr := service.BeginWebAuthnRegistration(UserID.(uint), beginReq.Name)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, begin)
+		})
+
+		//完成注册通行密钥，请求体为浏览器返回的凭证
+		protected.POST("/webauthn/register/finish", func(c *gin.Context) {
+			body, err := c.GetRawData()
+			if err != nil || c.Query("session") == "" {
+				models.Log.Warning("通行密钥注册请求无效:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			credential, apiErr := service.FinishWebAuthnRegistration(UserID.(uint), c.Query("session"), body)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "通行密钥注册成功",
+				"data":    credential,
+			})
+		})
+
+		//通行密钥列表
+		protected.GET("/webauthn/credentials", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			credentials, apiErr := service.GetWebAuthnCredentials(UserID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "获取通行密钥成功",
+				"data":    credentials,
+			})
+		})
+
+		//修改通行密钥名称
+		protected.PUT("/webauthn/credentials/:id", func(c *gin.Context) {
+			var renameReq struct {
+				Name string `json:"name" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&renameReq); err != nil {
+				models.Log.Warning("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				models.Log.Warning("通行密钥ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.RenameWebAuthnCredential(UserID.(uint), uint(id), renameReq.Name); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "修改成功",
+			})
+		})
+
+		//删除通行密钥
+		protected.DELETE("/webauthn/credentials/:id", func(c *gin.Context) {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				models.Log.Warning("通行密钥ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.DeleteWebAuthnCredential(UserID.(uint), uint(id)); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "删除成功",
+			})
+		})
+
+		//个人访问令牌列表
+		protected.GET("/tokens", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			tokens, apiErr := service.GetPersonalAccessTokens(UserID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "获取访问令牌成功",
+				"data":    tokens,
+			})
+		})
+
+		//创建个人访问令牌，令牌只在创建时返回一次
+		protected.POST("/tokens", func(c *gin.Context) {
+			var tokenReq struct {
+				Name        string   `json:"name" binding:"required"`
+				Scopes      []string `json:"scopes" binding:"required"`
+				ExpiresDays int      `json:"expires_days"`
+			}
+			if err := c.ShouldBindJSON(&tokenReq); err != nil {
+				models.Log.Warning("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			token, record, apiErr := service.CreatePersonalAccessToken(UserID.(uint), tokenReq.Name, tokenReq.Scopes, tokenReq.ExpiresDays)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "访问令牌创建成功，请立即复制保存，之后将无法再次查看",
+				"token":   token,
+				"data":    record,
+			})
+		})
+
+		//吊销个人访问令牌
+		protected.DELETE("/tokens/:id", func(c *gin.Context) {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				models.Log.Warning("访问令牌ID错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.RevokePersonalAccessToken(UserID.(uint), uint(id)); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "访问令牌已吊销",
 			})
 		})
-		//添加文章
-		protected.POST("/posts", func(c *gin.Context) {
-			var postReq struct {
-				Title   string `json:"title" binding:"required"`
-				Content string `json:"content" binding:"required"`
+
+		//注册第三方客户端，客户端密钥只在创建时返回一次
+		protected.POST("/oauth/clients", func(c *gin.Context) {
+			var clientReq struct {
+				Name         string   `json:"name" binding:"required"`
+				RedirectURIs []string `json:"redirect_uris" binding:"required"`
+				Scopes       []string `json:"scopes" binding:"required"`
+				Public       bool     `json:"public"`
 			}
-			if err := c.ShouldBind(&postReq); err != nil {
-				models.Log.Error(err.Error())
+			if err := c.ShouldBindJSON(&clientReq); err != nil {
+				models.Log.Warning("参数错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
 				return
 			}
-			// 从上下文中获取用户ID
-			UserID, exists := c.Get("user_id")
-			if !exists {
-				models.Log.Error("无法获取用户信息")
-				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			UserID, _ := c.Get("user_id")
+			secret, client, apiErr := service.CreateOAuthClient(UserID.(uint), clientReq.Name, clientReq.RedirectURIs, clientReq.Scopes, clientReq.Public)
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
 				return
 			}
-			post := models.Post{
-				Title:   postReq.Title,
-				Content: postReq.Content,
-				UserID:  UserID.(uint),
+			res := gin.H{
+				"message": "客户端注册成功",
+				"data":    client,
 			}
-			if apiErr := service.CreatePost(post); apiErr != nil {
+			if secret != "" {
+				res["client_secret"] = secret
+			}
+			c.JSON(http.StatusOK, res)
+		})
+
+		//已注册的第三方客户端
+		protected.GET("/oauth/clients", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			clients, apiErr := service.GetOAuthClients(UserID.(uint))
+			if apiErr != nil {
 				c.JSON(apiErr.Code, apiErr)
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
-				"message": "文章创建成功",
+				"message": "获取客户端成功",
+				"data":    clients,
 			})
 		})
-		//获取文章信息
-		protected.GET("/post/:id", func(c *gin.Context) {
-			postID := c.Param("id")
-			if postID == "" {
-				models.Log.Error("文章ID为空")
+
+		//删除第三方客户端，已签发的令牌同时失效
+		protected.DELETE("/oauth/clients/:id", func(c *gin.Context) {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				models.Log.Warning("客户端ID错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
 			}
-			postIDInt, err := strconv.Atoi(postID)
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.DeleteOAuthClient(UserID.(uint), uint(id)); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "删除成功",
+			})
+		})
+
+		//已授权的第三方客户端
+		protected.GET("/oauth/authorizations", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			tokens, apiErr := service.GetOAuthAuthorizations(UserID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "获取授权成功",
+				"data":    tokens,
+			})
+		})
+
+		//取消对第三方客户端的授权
+		protected.DELETE("/oauth/authorizations/:id", func(c *gin.Context) {
+			id, err := strconv.Atoi(c.Param("id"))
 			if err != nil {
-				models.Log.Error("文章ID转换失败:", err)
+				models.Log.Warning("授权ID错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
 			}
-			// 从上下文中获取用户ID
-			UserID, exists := c.Get("user_id")
-			if !exists {
-				models.Log.Error("无法获取用户信息")
-				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.RevokeOAuthAuthorization(UserID.(uint), uint(id)); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
 				return
 			}
-			post, apiErr := service.GetPost(uint(postIDInt), UserID)
+			c.JSON(http.StatusOK, gin.H{
+				"message": "已取消授权",
+			})
+		})
+
+		//获取必须启用两步验证的角色
+		protected.GET("/2fa/roles", middleware.RequirePermission(models.PermSystemManage), func(c *gin.Context) {
+			roles, apiErr := service.GetTwoFactorRoles()
 			if apiErr != nil {
 				c.JSON(apiErr.Code, apiErr)
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
-				"message": "获取文章成功",
-				"data":    post,
+				"roles": roles,
 			})
 		})
 
-		//更新文章
-		protected.PUT("/post/:id", func(c *gin.Context) {
-			var postReq struct {
-				Title   string `json:"title" binding:"required"`
-				Content string `json:"content" binding:"required"`
+		//设置必须启用两步验证的角色
+		protected.PUT("/2fa/roles", middleware.RequirePermission(models.PermSystemManage), func(c *gin.Context) {
+			var rolesReq struct {
+				Roles []string `json:"roles"`
 			}
-			if err := c.ShouldBind(&postReq); err != nil {
-				models.Log.Error(err)
+			if err := c.ShouldBindJSON(&rolesReq); err != nil {
+				models.Log.Warning("参数错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
 				return
 			}
-			postID := c.Param("id")
-			postIDInt, err := strconv.Atoi(postID)
-			if err != nil {
-				models.Log.Error("文章ID错误:", err)
-				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.SetTwoFactorRoles(UserID.(uint), rolesReq.Roles); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
 				return
 			}
-			UserID, exists := c.Get("user_id")
-			if !exists {
-				models.Log.Error("无法获取用户信息")
-				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
+			c.JSON(http.StatusOK, gin.H{
+				"message": "设置成功",
+			})
+		})
+
+		//立即轮换签名密钥
+		protected.POST("/keys/rotate", middleware.RequirePermission(models.PermSystemManage), func(c *gin.Context) {
+			if err := service.RotateSigningKey(); err != nil {
+				models.Log.Error("轮换签名密钥失败:", err)
+				c.JSON(models.ErrInternalServer.Code, models.ErrInternalServer)
 				return
 			}
-			post := models.Post{
-				Title:   postReq.Title,
-				Content: postReq.Content,
-				UserID:  UserID.(uint),
+			c.JSON(http.StatusOK, gin.H{
+				"message": "签名密钥已轮换",
+			})
+		})
+
+		//重新发送验证邮件
+		protected.POST("/verify-email/resend", func(c *gin.Context) {
+			UserID, _ := c.Get("user_id")
+			if apiErr := service.ResendVerificationEmail(UserID.(uint)); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "验证邮件已发送",
+			})
+		})
+
+		//用户信息
+		protected.GET("/profile", func(c *gin.Context) {
+			userID, _ := c.Get("user_id")
+			username, _ := c.Get("username")
+			role, apiErr := service.GetUserRole(userID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
 			}
-			apiErr := service.UpdatePost(uint(postIDInt), UserID, post)
+			profile, apiErr := service.GetProfile(userID.(uint))
 			if apiErr != nil {
 				c.JSON(apiErr.Code, apiErr)
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
-				"message": "更新成功",
+				"user_id":     userID,
+				"username":    username,
+				"role":        role,
+				"permissions": models.RolePermissions[role],
+				"profile":     profile,
+				"message":     "这是受保护的资源",
 			})
-
 		})
-		//删除文章
-		protected.DELETE("/post/:id", func(c *gin.Context) {
-			postID := c.Param("id")
-			if postID == "" {
-				models.Log.Error("文章ID为空")
-				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
-			}
-			postIDInt, err := strconv.Atoi(postID)
-			if err != nil {
-				models.Log.Error("文章ID错误:", err)
+
+		//修改个人资料
+		protected.PUT("/profile", func(c *gin.Context) {
+			var update service.ProfileUpdate
+			if err := c.ShouldBindJSON(&update); err != nil {
+				models.Log.Warning("参数错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
-			}
-			// 从上下文中获取用户ID
-			UserID, exists := c.Get("user_id")
-			if !exists {
-				models.Log.Error("无法获取用户信息")
-				c.JSON(models.ErrUnauthorized.Code, models.ErrUnauthorized)
 				return
 			}
-			apiErr := service.DeletePost(uint(postIDInt), UserID)
+			userID, _ := c.Get("user_id")
+			profile, apiErr := service.UpdateProfile(userID.(uint), update)
 			if apiErr != nil {
 				c.JSON(apiErr.Code, apiErr)
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{
-				"message": "删除成功",
+				"message": "个人资料已保存",
+				"data":    profile,
 			})
 		})
 
-		//获取文章的评论列表
-		protected.GET("/post/:id/comments", func(c *gin.Context) {
-			postID := c.Param("id")
-			if postID == "" {
-				models.Log.Error("文章ID为空")
+		//上传头像，表单字段为avatar
+		protected.POST("/profile/avatar", func(c *gin.Context) {
+			file, err := c.FormFile("avatar")
+			if err != nil {
+				models.Log.Warning("参数错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
 			}
-			postIDInt, err := strconv.Atoi(postID)
+			f, err := file.Open()
 			if err != nil {
-				models.Log.Error("文章ID错误:", err)
-				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				models.Log.Error("读取上传文件失败:", err)
+				c.JSON(models.ErrInternalServer.Code, models.ErrInternalServer)
+				return
 			}
-			res, apiErr := service.GetPostComments(postIDInt)
+			defer f.Close()
+			userID, _ := c.Get("user_id")
+			profile, apiErr := service.UploadAvatar(userID.(uint), f)
 			if apiErr != nil {
 				c.JSON(apiErr.Code, apiErr)
+				return
 			}
 			c.JSON(http.StatusOK, gin.H{
-				"message": "获取评论列表成功",
-				"data":    res,
+				"message": "头像已更新",
+				"data":    profile,
 			})
-
 		})
 
-		//创建评论
-		protected.POST("/comments", func(c *gin.Context) {
-			var commentReq struct {
-				Content string `json:"content" binding:"required"`
-				PostID  uint   `json:"post_id" binding:"required"`
+		//删除头像，恢复为自动生成的头像
+		protected.DELETE("/profile/avatar", func(c *gin.Context) {
+			userID, _ := c.Get("user_id")
+			profile, apiErr := service.DeleteAvatar(userID.(uint))
+			if apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
 			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "头像已删除",
+				"data":    profile,
+			})
+		})
 
-			err := c.ShouldBindJSON(&commentReq)
-			if err != nil {
-				models.Log.Error("参数错误:", err)
+		//修改密码，其他设备上的会话同时退出
+		protected.POST("/account/password", func(c *gin.Context) {
+			var passwordReq struct {
+				CurrentPassword string `json:"current_password" binding:"required"`
+				NewPassword     string `json:"new_password" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&passwordReq); err != nil {
+				models.Log.Warning("参数错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
 				return
 			}
-			// 从上下文中获取用户ID
-			UserID, exists := c.Get("user_id")
-			if !exists {
-				models.Log.Error("无法获取用户信息")
+			claims, _ := c.Get("claims")
+			jwtClaims := claims.(*service.JWTClaims)
+			if apiErr := service.ChangePassword(jwtClaims.UserID, jwtClaims.SessionID, passwordReq.CurrentPassword, passwordReq.NewPassword); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message": "密码已修改，其他设备上的登录已失效",
+			})
+		})
+
+		//修改邮箱，新邮箱验证后生效
+		protected.POST("/account/email", func(c *gin.Context) {
+			var emailReq struct {
+				CurrentPassword string `json:"current_password" binding:"required"`
+				Email           string `json:"email" binding:"required,email"`
+			}
+			if err := c.ShouldBindJSON(&emailReq); err != nil {
+				models.Log.Warning("参数错误:", err)
 				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
 				return
 			}
-			comment := models.Comment{
-				Content: commentReq.Content,
-				PostID:  commentReq.PostID,
-				UserID:  UserID.(uint),
+			userID, _ := c.Get("user_id")
+			if apiErr := service.RequestEmailChange(userID.(uint), emailReq.CurrentPassword, emailReq.Email); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
 			}
-			apiErr := service.CreateComment(comment)
+			c.JSON(http.StatusOK, gin.H{
+				"message": "确认邮件已发送到新邮箱，点击邮件中的链接后生效",
+			})
+		})
+
+		//申请导出个人数据，后台生成后通过邮件发送下载链接
+		protected.POST("/account/export", func(c *gin.Context) {
+			userID, _ := c.Get("user_id")
+			export, apiErr := service.RequestDataExport(userID.(uint))
 			if apiErr != nil {
-				models.Log.Error("创建评论失败:", apiErr)
 				c.JSON(apiErr.Code, apiErr)
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"message": "评论成功"})
+			c.JSON(http.StatusAccepted, gin.H{
+				"message": "正在生成导出文件，完成后下载链接将发送到您的邮箱",
+				"data":    export,
+			})
 		})
 
-		//获取评论列表
-		protected.GET("/comments", func(c *gin.Context) {
-			comments, apiErr := service.GetComments()
+		//个人数据导出记录
+		protected.GET("/account/exports", func(c *gin.Context) {
+			userID, _ := c.Get("user_id")
+			exports, apiErr := service.GetDataExports(userID.(uint))
 			if apiErr != nil {
-				models.Log.Error("获取评论列表失败:", apiErr)
 				c.JSON(apiErr.Code, apiErr)
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"data": comments, "message": "获取评论列表成功"})
+			c.JSON(http.StatusOK, gin.H{
+				"data": exports,
+			})
 		})
 
-		//用户信息
-		protected.GET("/profile", func(c *gin.Context) {
+		//删除账号，mode为anonymize时保留文章和评论，为delete时一并删除
+		protected.POST("/account/delete", func(c *gin.Context) {
+			var deleteReq struct {
+				CurrentPassword string `json:"current_password" binding:"required"`
+				Mode            string `json:"mode" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&deleteReq); err != nil {
+				models.Log.Warning("参数错误:", err)
+				c.JSON(models.ErrInvalidRequest.Code, models.ErrInvalidRequest)
+				return
+			}
 			userID, _ := c.Get("user_id")
-			username, _ := c.Get("username")
+			if apiErr := service.DeleteAccount(userID.(uint), deleteReq.CurrentPassword, deleteReq.Mode); apiErr != nil {
+				c.JSON(apiErr.Code, apiErr)
+				return
+			}
+			middleware.ClearSessionCookies(c)
 			c.JSON(http.StatusOK, gin.H{
-				"user_id":  userID,
-				"username": username,
-				"message":  "这是受保护的资源",
+				"message": "账号已删除",
 			})
 		})
 	}
 }
+
+// 返回登录或刷新得到的令牌，根据会话模式写入Cookie或在响应中返回
+func respondTokens(c *gin.Context, res map[string]interface{}) {
+	middleware.SetSessionCookies(c, service.TokenPair{
+		AccessToken:  res["token"].(string),
+		RefreshToken: res["refresh_token"].(string),
+		ExpiresIn:    res["expires_in"].(int),
+	})
+	if !middleware.TokenResponseEnabled() {
+		delete(res, "token")
+		delete(res, "refresh_token")
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// 只允许跳转到站内地址，防止开放重定向
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/admin"
+	}
+	return target
+}
+
+// 显示授权页面，请求无效时显示错误或跳转回客户端
+func renderAuthorize(c *gin.Context, authReq service.AuthorizeRequest) {
+	authCtx, oauthErr := service.ValidateAuthorizeRequest(authReq)
+	if oauthErr != nil {
+		renderAuthorizeError(c, authCtx, oauthErr)
+		return
+	}
+	// 授权需要使用Cookie会话，仅使用令牌登录时无法识别当前用户
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.HTML(http.StatusBadRequest, "oauth-authorize.html", gin.H{"error": "授权第三方应用需要启用Cookie会话"})
+		return
+	}
+	granted, apiErr := service.GrantableScopes(userID.(uint), authCtx.Scopes)
+	if apiErr != nil {
+		c.HTML(apiErr.Code, "oauth-authorize.html", gin.H{"error": apiErr.Message})
+		return
+	}
+	username, _ := c.Get("username")
+	scopes := []gin.H{}
+	for _, scope := range granted {
+		scopes = append(scopes, gin.H{"name": scope, "description": models.ScopeDescriptions[scope]})
+	}
+	csrfToken, _ := c.Cookie(middleware.CSRFCookieName)
+	// 防止授权页面被嵌入其他网站诱导用户点击
+	c.Header("X-Frame-Options", "DENY")
+	c.HTML(http.StatusOK, "oauth-authorize.html", gin.H{
+		"client":   authCtx.Client.Name,
+		"username": username,
+		"scopes":   scopes,
+		"request":  authReq,
+		"csrf":     csrfToken,
+	})
+}
+
+// 客户端和回调地址有效时通过回调地址返回错误，否则直接显示错误
+func renderAuthorizeError(c *gin.Context, authCtx service.AuthorizeContext, oauthErr *service.OAuthError) {
+	if authCtx.RedirectURI == "" {
+		c.HTML(http.StatusBadRequest, "oauth-authorize.html", gin.H{"error": oauthErr.Description})
+		return
+	}
+	params := url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}}
+	if authCtx.State != "" {
+		params.Set("state", authCtx.State)
+	}
+	c.Redirect(http.StatusFound, service.OAuthRedirect(authCtx.RedirectURI, params))
+}
+
+// 校验第三方客户端身份，支持HTTP Basic认证和表单参数两种方式
+func oauthClient(c *gin.Context) (models.OAuthClient, *service.OAuthError) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		// Basic认证中的客户端ID和密钥需要先进行表单编码
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	return service.AuthenticateOAuthClient(clientID, secret)
+}
+
+// 从查询参数中读取公开文章列表的分页和筛选条件，参数无效时使用默认值
+func postQuery(c *gin.Context) service.PostQuery {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	return service.PostQuery{
+		Page:     page,
+		PageSize: pageSize,
+		Tag:      c.Query("tag"),
+		Author:   c.Query("author"),
+	}
+}
+
+// 使用主题渲染公开页面，内容不存在时显示404页面
+func renderSite(c *gin.Context, site *theme.Theme, page service.SitePage, apiErr *models.APIError) {
+	code := http.StatusOK
+	if apiErr != nil {
+		if apiErr.Code != http.StatusNotFound {
+			c.String(apiErr.Code, apiErr.Message)
+			return
+		}
+		code, page = http.StatusNotFound, service.SiteNotFound()
+	}
+	var buf bytes.Buffer
+	if err := site.Render(&buf, page.Template, page.Data); err != nil {
+		models.Log.Error("渲染页面失败:", page.Template, err)
+		c.String(http.StatusInternalServerError, models.ErrInternalServer.Message)
+		return
+	}
+	c.Data(code, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// 读取分页地址中的页码，第1页重定向到列表本身的地址，页码无效时显示404页面
+func pageParam(c *gin.Context, site *theme.Theme, base string) (int, bool) {
+	pageNum, err := strconv.Atoi(c.Param("page"))
+	if err != nil || pageNum < 1 {
+		renderSite(c, site, service.SitePage{}, models.ErrPostNotFound)
+		return 0, false
+	}
+	if pageNum == 1 {
+		c.Redirect(http.StatusMovedPermanently, service.PagedPath(base, 1))
+		return 0, false
+	}
+	return pageNum, true
+}
+
+// 订阅源是否包含文章全文，content参数为full或summary时覆盖配置
+func feedFullContent(c *gin.Context) bool {
+	switch c.Query("content") {
+	case "full":
+		return true
+	case "summary":
+		return false
+	}
+	return config.Conf.Feed.FullContent
+}
+
+// 输出订阅源，支持If-None-Match和If-Modified-Since条件请求
+func serveFeed(c *gin.Context, site *theme.Theme, feed service.Feed, apiErr *models.APIError) {
+	if apiErr != nil {
+		renderSite(c, site, service.SitePage{}, apiErr)
+		return
+	}
+	data, err := feed.Encode()
+	if err != nil {
+		models.Log.Error("生成订阅源失败:", feed.Format, feed.Path, err)
+		c.String(http.StatusInternalServerError, models.ErrInternalServer.Message)
+		return
+	}
+	serveConditional(c, feed.ContentType(), feed.Updated, data)
+}
+
+// 输出生成的内容，带有ETag和Last-Modified头，支持条件请求，内容未变化时返回304
+func serveConditional(c *gin.Context, contentType string, modTime time.Time, data []byte) {
+	// 删除或撤回文章不会改变最近的修改时间，ETag按内容计算
+	sum := sha256.Sum256(data)
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	c.Header("Cache-Control", "no-cache")
+	http.ServeContent(c.Writer, c.Request, "", modTime, bytes.NewReader(data))
+}
+
+// 输出存储中的文件并关闭。本地文件支持条件请求和范围请求，对象存储中的文件直接转发内容
+func serveStoredFile(c *gin.Context, file service.StoredFile) {
+	defer file.Close()
+	c.Header("Content-Type", file.ContentType)
+	if rs, ok := file.ReadCloser.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", file.ModTime, rs)
+		return
+	}
+	if !file.ModTime.IsZero() {
+		c.Header("Last-Modified", file.ModTime.UTC().Format(http.TimeFormat))
+	}
+	c.DataFromReader(http.StatusOK, file.Size, file.ContentType, file, nil)
+}
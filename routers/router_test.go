@@ -0,0 +1,67 @@
+package routers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/dbtest"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/service"
+)
+
+// 使用临时数据库创建完整的路由
+func setupRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	dbtest.Open(t)
+	service.SetMailer(&service.MemoryMailer{})
+	gin.SetMode(gin.TestMode)
+	config.Conf.Theme.Dir = "../themes"
+	r := gin.New()
+	r.LoadHTMLGlob("../templates/*")
+	InitRouter(r)
+	return r
+}
+
+func postJSON(r *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRegisterIgnoresServerOwnedFields(t *testing.T) {
+	r := setupRouter(t)
+	for _, name := range []string{"alice", "mallory"} {
+		w := postJSON(r, "/api/register", map[string]interface{}{
+			"username":       name,
+			"email":          name + "@example.com",
+			"password":       "correct-horse-" + name,
+			"role":           models.RoleAdmin,
+			"email_verified": true,
+			"totp_enabled":   true,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("注册%s失败: %d %s", name, w.Code, w.Body)
+		}
+	}
+	var user models.User
+	if err := models.DB.Where("user_name = ?", "mallory").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailVerified {
+		t.Fatal("注册请求不能设置邮箱已验证")
+	}
+	if user.TOTPEnabled {
+		t.Fatal("注册请求不能设置已启用两步验证")
+	}
+	if user.Role == models.RoleAdmin {
+		t.Fatal("注册请求不能设置角色")
+	}
+}
@@ -0,0 +1,323 @@
+// Package s3test 本地的S3兼容对象存储，文件保存在内存中，用于在Go测试和开发环境中代替MinIO等服务测试对象存储。
+//
+// 只支持 服务地址/存储桶/键名 形式的地址，实现创建存储桶、上传、下载、获取元数据、删除和ListObjectsV2。
+// 全部请求都需要使用AWS Signature Version 4签名，支持在请求头中签名和预签名链接。
+package s3test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求头签名中的时间与服务器时间允许的最大误差
+const maxClockSkew = 15 * time.Minute
+
+// 每次列举返回的最大文件数量
+const maxListKeys = 1000
+
+type object struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+	etag        string
+}
+
+// Server 对象存储服务
+type Server struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	mu      sync.Mutex
+	buckets map[string]map[string]object
+}
+
+// New 创建对象存储服务，buckets为预先创建的存储桶
+func New(accessKey, secretKey, region string, buckets ...string) *Server {
+	s := &Server{AccessKey: accessKey, SecretKey: secretKey, Region: region, buckets: map[string]map[string]object{}}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = map[string]object{}
+	}
+	return s
+}
+
+// NewServer 创建对象存储服务并在本地随机端口启动，区域为us-east-1，使用完毕后需要关闭服务
+func NewServer(accessKey, secretKey string, buckets ...string) (*Server, *httptest.Server) {
+	s := New(accessKey, secretKey, "us-east-1", buckets...)
+	return s, httptest.NewServer(s)
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "bucket is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if key == "" {
+		switch {
+		case r.Method == http.MethodPut:
+			if !ok {
+				s.buckets[bucket] = map[string]object{}
+			}
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && ok:
+			s.list(w, r, objects)
+		case !ok:
+			writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+		}
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		sum := sha256.Sum256(body)
+		obj := object{data: body, contentType: r.Header.Get("Content-Type"), modTime: time.Now().UTC().Truncate(time.Second), etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+		if obj.contentType == "" {
+			obj.contentType = "binary/octet-stream"
+		}
+		objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		w.Header().Set("ETag", obj.etag)
+		// 预签名链接可以指定响应头
+		if v := r.URL.Query().Get("response-content-disposition"); v != "" {
+			w.Header().Set("Content-Disposition", v)
+		}
+		if v := r.URL.Query().Get("response-content-type"); v != "" {
+			w.Header().Set("Content-Type", v)
+		}
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+type listResult struct {
+	XMLName               xml.Name      `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Prefix                string        `xml:"Prefix"`
+	KeyCount              int           `xml:"KeyCount"`
+	MaxKeys               int           `xml:"MaxKeys"`
+	IsTruncated           bool          `xml:"IsTruncated"`
+	ContinuationToken     string        `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string        `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContent `xml:"Contents"`
+}
+
+type listContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+// ListObjectsV2，继续列举的令牌为上一页最后一个键名
+func (s *Server) list(w http.ResponseWriter, r *http.Request, objects map[string]object) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported")
+		return
+	}
+	maxKeys := maxListKeys
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil && n > 0 && n < maxKeys {
+		maxKeys = n
+	}
+	prefix, after := q.Get("prefix"), q.Get("continuation-token")
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := listResult{Prefix: prefix, MaxKeys: maxKeys, ContinuationToken: after}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		obj := objects[key]
+		result.Contents = append(result.Contents, listContent{
+			Key:          key,
+			LastModified: obj.modTime.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// 验证请求的签名，返回请求内容
+func (s *Server) authenticate(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	var expires time.Duration
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		fields := map[string]string{}
+		rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+		if !ok {
+			return nil, fmt.Errorf("unsupported authorization")
+		}
+		for _, part := range strings.Split(rest, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			fields[k] = v
+		}
+		credential, signedHeaders, signature = fields["Credential"], fields["SignedHeaders"], fields["Signature"]
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return nil, fmt.Errorf("missing x-amz-content-sha256")
+		}
+		if payloadHash != "UNSIGNED-PAYLOAD" {
+			sum := sha256.Sum256(body)
+			if payloadHash != hex.EncodeToString(sum[:]) {
+				return nil, fmt.Errorf("payload hash mismatch")
+			}
+		}
+	} else if q.Get("X-Amz-Algorithm") == "AWS4-HMAC-SHA256" {
+		credential, signedHeaders, signature = q.Get("X-Amz-Credential"), q.Get("X-Amz-SignedHeaders"), q.Get("X-Amz-Signature")
+		amzDate = q.Get("X-Amz-Date")
+		payloadHash = "UNSIGNED-PAYLOAD"
+		seconds, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid X-Amz-Expires")
+		}
+		expires = time.Duration(seconds) * time.Second
+		q.Del("X-Amz-Signature")
+	} else {
+		return nil, fmt.Errorf("request is not signed")
+	}
+
+	t, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x-amz-date")
+	}
+	now := time.Now()
+	if expires > 0 {
+		if now.After(t.Add(expires)) {
+			return nil, fmt.Errorf("request has expired")
+		}
+	} else if now.Sub(t) > maxClockSkew || t.Sub(now) > maxClockSkew {
+		return nil, fmt.Errorf("request time too skewed")
+	}
+	scope := t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	if credential != s.AccessKey+"/"+scope {
+		return nil, fmt.Errorf("invalid credential")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method, escape(r.URL.Path, true), canonicalQuery(q), canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{t.Format("20060102"), s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, fmt.Errorf("signature does not match")
+	}
+	return body, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func escape(s string, keepSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || keepSlash && c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func canonicalQuery(q url.Values) string {
+	var parts []string
+	for k, values := range q {
+		for _, v := range values {
+			parts = append(parts, escape(k, false)+"="+escape(v, false))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// PersonalAccessTokenPrefix 个人访问令牌的前缀，用于和JWT区分，也便于在代码仓库中扫描泄露的令牌
+const PersonalAccessTokenPrefix = "gbp_"
+
+// 最近使用时间的更新间隔，避免每个请求都写数据库
+const accessTokenTouchInterval = time.Minute
+
+var errAccessTokenNotFound = &models.APIError{
+	Code:    404,
+	Message: "访问令牌不存在",
+	Details: "指定的访问令牌未找到或已吊销",
+}
+
+var errInvalidAccessToken = &models.APIError{
+	Code:    401,
+	Message: "无效的访问令牌",
+	Details: "访问令牌不存在、已吊销或已过期",
+}
+
+// IsPersonalAccessToken 判断令牌是否为个人访问令牌
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// CreatePersonalAccessToken 创建个人访问令牌，令牌明文只在创建时返回一次。
+// expiresDays为0时使用配置的最长有效期，最长有效期也为0时永不过期
+func CreatePersonalAccessToken(userID uint, name string, scopes []string, expiresDays int) (string, models.PersonalAccessToken, *models.APIError) {
+	var record models.PersonalAccessToken
+	name = truncateUTF8(strings.TrimSpace(name), 64)
+	maxDays := config.Conf.User.AccessTokenMaxDays
+	if name == "" || len(scopes) == 0 || expiresDays < 0 || (maxDays > 0 && expiresDays > maxDays) {
+		models.Log.Warning("访问令牌参数错误:", userID, expiresDays)
+		return "", record, models.ErrInvalidRequest
+	}
+	role, apiErr := GetUserRole(userID)
+	if apiErr != nil {
+		return "", record, apiErr
+	}
+	// 权限范围不能超出用户角色本身的权限
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		permission, ok := models.ScopePermissions[scope]
+		if !ok {
+			models.Log.Warning("访问令牌权限范围不存在:", scope)
+			return "", record, models.ErrInvalidRequest
+		}
+		if !models.HasPermission(role, permission) {
+			models.Log.Warning("访问令牌权限范围超出角色权限:", userID, role, scope)
+			return "", record, models.ErrForbidden
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			record.Scopes = append(record.Scopes, scope)
+		}
+	}
+	if expiresDays == 0 {
+		expiresDays = maxDays
+	}
+	if expiresDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, expiresDays)
+		record.ExpiresAt = &expiresAt
+	}
+
+	token := PersonalAccessTokenPrefix + RandomToken(32)
+	record.UserID = userID
+	record.Name = name
+	record.TokenHash = HashToken(token)
+	record.Prefix = token[:len(PersonalAccessTokenPrefix)+6]
+	if err := models.DB.Create(&record).Error; err != nil {
+		models.Log.Error("创建访问令牌失败:", err)
+		return "", record, models.ErrInternalServer
+	}
+	models.Log.Info("用户创建了访问令牌:", userID, record.ID, record.Scopes)
+	return token, record, nil
+}
+
+// GetPersonalAccessTokens 获取用户的个人访问令牌
+func GetPersonalAccessTokens(userID uint) ([]models.PersonalAccessToken, *models.APIError) {
+	var tokens []models.PersonalAccessToken
+	if err := models.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		models.Log.Error("获取访问令牌失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return tokens, nil
+}
+
+// RevokePersonalAccessToken 吊销个人访问令牌，立即生效
+func RevokePersonalAccessToken(userID, id uint) *models.APIError {
+	res := models.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.PersonalAccessToken{})
+	if res.Error != nil {
+		models.Log.Error("吊销访问令牌失败:", res.Error)
+		return models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 {
+		return errAccessTokenNotFound
+	}
+	models.Log.Info("用户吊销了访问令牌:", userID, id)
+	return nil
+}
+
+// VerifyPersonalAccessToken 校验个人访问令牌，返回令牌记录和所属用户，并记录最近使用时间
+func VerifyPersonalAccessToken(token, ip string) (models.PersonalAccessToken, models.User, *models.APIError) {
+	var record models.PersonalAccessToken
+	var user models.User
+	err := models.DB.Where("token_hash = ?", HashToken(token)).First(&record).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("访问令牌不存在, IP:", ip)
+			return record, user, errInvalidAccessToken
+		}
+		models.Log.Error("查询访问令牌失败:", err)
+		return record, user, models.ErrInternalServer
+	}
+	if record.ExpiresAt != nil && time.Now().After(*record.ExpiresAt) {
+		models.Log.Warning("访问令牌已过期:", record.ID)
+		return record, user, errInvalidAccessToken
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return record, user, errInvalidAccessToken
+	}
+
+	if record.LastUsedAt == nil || time.Since(*record.LastUsedAt) > accessTokenTouchInterval || record.LastUsedIP != ip {
+		now := time.Now()
+		err := models.DB.Model(&models.PersonalAccessToken{}).Where("id = ?", record.ID).
+			Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip}).Error
+		if err != nil {
+			models.Log.Error("更新访问令牌使用时间失败:", err)
+		}
+	}
+	return record, user, nil
+}
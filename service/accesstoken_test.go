@@ -0,0 +1,89 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+func TestCreatePersonalAccessTokenScopes(t *testing.T) {
+	setupTestDB(t)
+	author := createTestUser(t, "alice", models.RoleAuthor)
+	reader := createTestUser(t, "bob", models.RoleReader)
+
+	tests := []struct {
+		name   string
+		userID uint
+		scopes []string
+		days   int
+		want   *models.APIError
+	}{
+		{name: "角色拥有的权限", userID: author.ID, scopes: []string{models.ScopePostsWrite}},
+		{name: "没有权限范围", userID: author.ID, want: models.ErrInvalidRequest},
+		{name: "不存在的权限范围", userID: author.ID, scopes: []string{"posts:admin"}, want: models.ErrInvalidRequest},
+		{name: "超出角色权限", userID: author.ID, scopes: []string{models.ScopePostsWrite, models.ScopeUsersRead}, want: models.ErrForbidden},
+		{name: "读者不能发布文章", userID: reader.ID, scopes: []string{models.ScopePostsWrite}, want: models.ErrForbidden},
+		{name: "超过最长有效期", userID: author.ID, scopes: []string{models.ScopePostsWrite}, days: 366, want: models.ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, apiErr := CreatePersonalAccessToken(tt.userID, "token", tt.scopes, tt.days)
+			if apiErr != tt.want {
+				t.Fatalf("CreatePersonalAccessToken = %v, want %v", apiErr, tt.want)
+			}
+		})
+	}
+
+	// 重复的权限范围只保存一次，未指定有效期时使用最长有效期
+	token, record, apiErr := CreatePersonalAccessToken(author.ID, "deploy", []string{models.ScopePostsWrite, models.ScopePostsWrite}, 0)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if len(record.Scopes) != 1 || record.ExpiresAt == nil {
+		t.Fatalf("保存的令牌 = %+v", record)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Fatal("令牌缺少前缀:", token)
+	}
+}
+
+func TestVerifyPersonalAccessToken(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	token, record, apiErr := CreatePersonalAccessToken(user.ID, "deploy", []string{models.ScopePostsWrite}, 30)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+
+	verified, owner, apiErr := VerifyPersonalAccessToken(token, "10.0.0.1")
+	if apiErr != nil || owner.ID != user.ID || len(verified.Scopes) != 1 || verified.Scopes[0] != models.ScopePostsWrite {
+		t.Fatalf("VerifyPersonalAccessToken = %+v, %v", verified, apiErr)
+	}
+	models.DB.First(&record, record.ID)
+	if record.LastUsedAt == nil || record.LastUsedIP != "10.0.0.1" {
+		t.Fatal("没有记录最近使用时间和IP")
+	}
+	if _, _, apiErr := VerifyPersonalAccessToken(token+"x", "10.0.0.1"); apiErr != errInvalidAccessToken {
+		t.Fatalf("错误的令牌 = %v", apiErr)
+	}
+
+	// 过期的令牌
+	models.DB.Model(&record).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, _, apiErr := VerifyPersonalAccessToken(token, "10.0.0.1"); apiErr != errInvalidAccessToken {
+		t.Fatalf("过期的令牌 = %v", apiErr)
+	}
+
+	// 吊销后立即失效，其他用户不能吊销
+	token, record, _ = CreatePersonalAccessToken(user.ID, "ci", []string{models.ScopePostsWrite}, 30)
+	other := createTestUser(t, "bob", models.RoleAuthor)
+	if apiErr := RevokePersonalAccessToken(other.ID, record.ID); apiErr != errAccessTokenNotFound {
+		t.Fatalf("吊销其他用户的令牌 = %v", apiErr)
+	}
+	if apiErr := RevokePersonalAccessToken(user.ID, record.ID); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, _, apiErr := VerifyPersonalAccessToken(token, "10.0.0.1"); apiErr != errInvalidAccessToken {
+		t.Fatalf("吊销的令牌 = %v", apiErr)
+	}
+}
@@ -0,0 +1,459 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 生成站点内的完整链接
+func siteURL(path string, query url.Values) string {
+	return strings.TrimRight(config.Conf.Site.BaseURL, "/") + path + "?" + query.Encode()
+}
+
+// 创建一次性令牌，同一用户同一用途之前未使用的令牌会失效
+func createUserToken(userID uint, purpose, email string, ttl time.Duration) (string, error) {
+	token := RandomToken(32)
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: HashToken(token),
+			Email:     email,
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	return token, err
+}
+
+// 查找未使用且未过期的一次性令牌
+func findUserToken(token, purpose string) (models.UserToken, *models.APIError) {
+	var record models.UserToken
+	err := models.DB.Where("token_hash = ? AND purpose = ?", HashToken(token), purpose).First(&record).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("一次性令牌不存在:", purpose)
+			return record, models.ErrInvalidUserToken
+		}
+		models.Log.Error("查询一次性令牌失败:", err)
+		return record, models.ErrInternalServer
+	}
+	if record.UsedAt != nil || time.Now().After(record.ExpiresAt) {
+		models.Log.Warning("一次性令牌已使用或已过期:", record.ID)
+		return record, models.ErrInvalidUserToken
+	}
+	return record, nil
+}
+
+// 使用一次性令牌，令牌只能成功使用一次
+func consumeUserToken(token, purpose string) (models.UserToken, *models.APIError) {
+	record, apiErr := findUserToken(token, purpose)
+	if apiErr != nil {
+		return record, apiErr
+	}
+	res := models.DB.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		models.Log.Error("更新一次性令牌失败:", res.Error)
+		return record, models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 {
+		return record, models.ErrInvalidUserToken
+	}
+	return record, nil
+}
+
+// SendVerificationEmail 发送邮箱验证邮件
+func SendVerificationEmail(user models.User) error {
+	ttl := time.Duration(config.Conf.User.VerifyTTL) * time.Minute
+	token, err := createUserToken(user.ID, models.TokenPurposeVerifyEmail, user.Email, ttl)
+	if err != nil {
+		return err
+	}
+	link := siteURL("/api/verify-email", url.Values{"token": {token}})
+	return SendMail(MailMessage{
+		To:      user.Email,
+		Subject: fmt.Sprintf("[%s] 请验证您的邮箱", config.Conf.Site.Name),
+		Body: fmt.Sprintf("%s，您好：\n\n请在%d小时内点击以下链接完成邮箱验证：\n\n%s\n\n如果您没有注册过账号，请忽略此邮件。\n",
+			user.UserName, config.Conf.User.VerifyTTL/60, link),
+	})
+}
+
+// ResendVerificationEmail 重新发送邮箱验证邮件
+func ResendVerificationEmail(userID uint) *models.APIError {
+	var user models.User
+	if err := models.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		models.Log.Warning("用户不存在:", userID)
+		return models.ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return &models.APIError{
+			Code:    409,
+			Message: "邮箱已验证",
+			Details: "邮箱已验证，无需重复验证",
+		}
+	}
+	if err := SendVerificationEmail(user); err != nil {
+		return models.ErrInternalServer
+	}
+	return nil
+}
+
+// VerifyEmail 使用验证链接中的令牌完成邮箱验证
+func VerifyEmail(token string) *models.APIError {
+	record, apiErr := consumeUserToken(token, models.TokenPurposeVerifyEmail)
+	if apiErr != nil {
+		return apiErr
+	}
+	// 邮箱已变更时旧的验证链接无效
+	res := models.DB.Model(&models.User{}).
+		Where("id = ? AND email = ?", record.UserID, record.Email).
+		Update("email_verified", true)
+	if res.Error != nil {
+		models.Log.Error("更新邮箱验证状态失败:", res.Error)
+		return models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 {
+		models.Log.Warning("验证令牌对应的邮箱已变更:", record.UserID)
+		return models.ErrInvalidUserToken
+	}
+	models.Log.Info("邮箱验证成功:", record.UserID, record.Email)
+	return nil
+}
+
+// IsEmailVerified 查询用户邮箱是否已验证
+func IsEmailVerified(userID uint) (bool, *models.APIError) {
+	var user models.User
+	if err := models.DB.Select("id,email_verified").Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, models.ErrUserNotFound
+		}
+		models.Log.Error("查询用户失败:", err)
+		return false, models.ErrInternalServer
+	}
+	return user.EmailVerified, nil
+}
+
+// RequestPasswordReset 发送重置密码邮件，邮箱不存在时同样返回成功，避免泄露注册信息
+func RequestPasswordReset(email string) *models.APIError {
+	var user models.User
+	err := models.DB.Where("email = ?", email).First(&user).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("重置密码的邮箱不存在:", email)
+			return nil
+		}
+		models.Log.Error("查询用户失败:", err)
+		return models.ErrInternalServer
+	}
+	ttl := time.Duration(config.Conf.User.ResetTTL) * time.Minute
+	token, err := createUserToken(user.ID, models.TokenPurposeResetPassword, user.Email, ttl)
+	if err != nil {
+		models.Log.Error("创建重置密码令牌失败:", err)
+		return models.ErrInternalServer
+	}
+	link := siteURL("/reset-password", url.Values{"token": {token}})
+	// 发送失败同样返回成功，避免通过错误信息判断邮箱是否注册
+	SendMail(MailMessage{
+		To:      user.Email,
+		Subject: fmt.Sprintf("[%s] 重置密码", config.Conf.Site.Name),
+		Body: fmt.Sprintf("%s，您好：\n\n我们收到了重置您账号密码的请求，请在%d分钟内点击以下链接设置新密码：\n\n%s\n\n如果这不是您本人的操作，请忽略此邮件，您的密码不会改变。\n",
+			user.UserName, config.Conf.User.ResetTTL, link),
+	})
+	return nil
+}
+
+// ResetPassword 使用重置链接中的令牌设置新密码，并退出该用户的全部会话
+func ResetPassword(token, password string) *models.APIError {
+	// 先检查新密码，不符合密码策略时重置链接仍然可以继续使用
+	record, apiErr := findUserToken(token, models.TokenPurposeResetPassword)
+	if apiErr != nil {
+		return apiErr
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if apiErr := CheckPasswordPolicy(user.UserName, password); apiErr != nil {
+		return apiErr
+	}
+	record, apiErr = consumeUserToken(token, models.TokenPurposeResetPassword)
+	if apiErr != nil {
+		return apiErr
+	}
+	// 能收到重置邮件说明邮箱属于该用户，同时视为邮箱已验证
+	err := models.DB.Model(&models.User{}).Where("id = ? AND email = ?", record.UserID, record.Email).
+		Updates(map[string]interface{}{
+			"password":       EncryptPassword(password),
+			"email_verified": true,
+		}).Error
+	if err != nil {
+		models.Log.Error("重置密码失败:", err)
+		return models.ErrInternalServer
+	}
+	if err := RevokeUserTokens(record.UserID, ""); err != nil {
+		models.Log.Error("吊销用户会话失败:", err)
+	}
+	models.Log.Info("用户重置了密码:", record.UserID)
+	return nil
+}
+
+// 删除账号时对文章和评论的处理方式
+const (
+	AccountDeleteAnonymize = "anonymize" // 保留文章和评论，作者显示为已注销用户
+	AccountDeleteContent   = "delete"    // 同时删除用户的文章、文章下的评论和用户发表的评论
+)
+
+var errWrongPassword = &models.APIError{
+	Code:    403,
+	Message: "当前密码错误",
+	Details: "请输入正确的当前密码，通过单点登录创建的账号可以先通过找回密码设置密码",
+}
+
+var errEmailTaken = &models.APIError{
+	Code:    409,
+	Message: "邮箱已被使用",
+	Details: "该邮箱已被其他账号使用",
+}
+
+// 修改账号信息前验证当前密码
+func checkCurrentPassword(user models.User, password string) *models.APIError {
+	if ok, _ := VerifyPassword(user.Password, password); !ok {
+		models.Log.Warning("当前密码错误:", user.ID)
+		return errWrongPassword
+	}
+	return nil
+}
+
+// 发送账号变更通知，发送失败只记录日志
+func sendAccountNotice(email, subject, body string) {
+	err := SendMail(MailMessage{
+		To:      email,
+		Subject: fmt.Sprintf("[%s] %s", config.Conf.Site.Name, subject),
+		Body:    body,
+	})
+	if err != nil {
+		models.Log.Error("发送账号变更通知失败:", err)
+	}
+}
+
+// ChangePassword 修改密码，需要验证当前密码，修改后退出除当前会话以外的全部会话
+func ChangePassword(userID uint, sessionID, current, password string) *models.APIError {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if apiErr := checkCurrentPassword(user, current); apiErr != nil {
+		return apiErr
+	}
+	if apiErr := CheckPasswordPolicy(user.UserName, password); apiErr != nil {
+		return apiErr
+	}
+	if err := models.DB.Model(&user).Update("password", EncryptPassword(password)).Error; err != nil {
+		models.Log.Error("修改密码失败:", err)
+		return models.ErrInternalServer
+	}
+	if err := RevokeUserTokens(userID, sessionID); err != nil {
+		models.Log.Error("吊销用户会话失败:", err)
+	}
+	models.Log.Info("用户修改了密码:", userID)
+	sendAccountNotice(user.Email, "密码已修改",
+		fmt.Sprintf("%s，您好：\n\n您的账号密码刚刚被修改，其他设备上的登录已失效。\n\n如果这不是您本人的操作，请立即通过找回密码重置密码。\n", user.UserName))
+	return nil
+}
+
+// RequestEmailChange 修改邮箱，需要验证当前密码。验证邮件发送到新邮箱，点击链接后新邮箱才会生效
+func RequestEmailChange(userID uint, current, email string) *models.APIError {
+	email = strings.TrimSpace(email)
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if apiErr := checkCurrentPassword(user, current); apiErr != nil {
+		return apiErr
+	}
+	if strings.EqualFold(email, user.Email) {
+		models.Log.Warning("新邮箱与当前邮箱相同:", userID)
+		return models.ErrInvalidRequest
+	}
+	var count int64
+	if err := models.DB.Model(&models.User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+		models.Log.Error("查询邮箱失败:", err)
+		return models.ErrInternalServer
+	}
+	if count > 0 {
+		models.Log.Warning("邮箱已被使用:", email)
+		return errEmailTaken
+	}
+
+	ttl := time.Duration(config.Conf.User.VerifyTTL) * time.Minute
+	token, err := createUserToken(userID, models.TokenPurposeChangeEmail, email, ttl)
+	if err != nil {
+		models.Log.Error("创建邮箱变更令牌失败:", err)
+		return models.ErrInternalServer
+	}
+	link := siteURL("/api/confirm-email", url.Values{"token": {token}})
+	err = SendMail(MailMessage{
+		To:      email,
+		Subject: fmt.Sprintf("[%s] 请确认您的新邮箱", config.Conf.Site.Name),
+		Body: fmt.Sprintf("%s，您好：\n\n您正在将账号邮箱修改为此邮箱，请在%d小时内点击以下链接确认：\n\n%s\n\n如果这不是您本人的操作，请忽略此邮件。\n",
+			user.UserName, config.Conf.User.VerifyTTL/60, link),
+	})
+	if err != nil {
+		models.Log.Error("发送邮箱确认邮件失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("用户申请修改邮箱:", userID)
+	return nil
+}
+
+// ConfirmEmailChange 使用新邮箱收到的链接完成邮箱修改，新邮箱同时视为已验证
+func ConfirmEmailChange(token string) *models.APIError {
+	record, apiErr := consumeUserToken(token, models.TokenPurposeChangeEmail)
+	if apiErr != nil {
+		return apiErr
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return apiErr
+	}
+	// 申请后到确认前邮箱可能已被其他账号注册
+	var count int64
+	if err := models.DB.Model(&models.User{}).Where("email = ? AND id <> ?", record.Email, user.ID).Count(&count).Error; err != nil {
+		models.Log.Error("查询邮箱失败:", err)
+		return models.ErrInternalServer
+	}
+	if count > 0 {
+		models.Log.Warning("邮箱已被使用:", record.Email)
+		return errEmailTaken
+	}
+	oldEmail := user.Email
+	err := models.DB.Model(&user).Updates(map[string]interface{}{
+		"email":          record.Email,
+		"email_verified": true,
+	}).Error
+	if err != nil {
+		models.Log.Error("修改邮箱失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("用户修改了邮箱:", user.ID)
+	sendAccountNotice(oldEmail, "邮箱已修改",
+		fmt.Sprintf("%s，您好：\n\n您的账号邮箱已修改为 %s，此邮箱将不再接收账号相关的邮件。\n\n如果这不是您本人的操作，请立即联系管理员。\n", user.UserName, record.Email))
+	return nil
+}
+
+// DeleteAccount 删除账号，需要验证当前密码。mode为anonymize时保留文章和评论并清除个人信息，
+// 为delete时同时删除文章和评论。两种方式都会删除会话、令牌、通行密钥等登录凭证，不能删除最后一个管理员
+func DeleteAccount(userID uint, current, mode string) *models.APIError {
+	if mode != AccountDeleteAnonymize && mode != AccountDeleteContent {
+		models.Log.Warning("删除账号的方式无效:", mode)
+		return models.ErrInvalidRequest
+	}
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if apiErr := checkCurrentPassword(user, current); apiErr != nil {
+		return apiErr
+	}
+	if user.Role == models.RoleAdmin {
+		var count int64
+		if err := models.DB.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count).Error; err != nil {
+			models.Log.Error("查询管理员失败:", err)
+			return models.ErrInternalServer
+		}
+		if count <= 1 {
+			models.Log.Warning("不能删除最后一个管理员:", userID)
+			return &models.APIError{
+				Code:    409,
+				Message: "不能删除最后一个管理员",
+				Details: "请先将其他用户设置为管理员",
+			}
+		}
+	}
+	// 先吊销会话，已签发的访问令牌加入吊销列表
+	if err := RevokeUserTokens(userID, ""); err != nil {
+		models.Log.Error("吊销用户会话失败:", err)
+		return models.ErrInternalServer
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		// 登录凭证和授权
+		credentials := []interface{}{
+			&models.RefreshToken{}, &models.UserToken{}, &models.RecoveryCode{}, &models.WebAuthnCredential{},
+			&models.WebAuthnSession{}, &models.PersonalAccessToken{}, &models.UserIdentity{}, &models.OAuthCode{},
+			&models.OAuthToken{}, &models.LoginAttempt{},
+		}
+		for _, model := range credentials {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		// 用户注册的第三方客户端及其签发的令牌
+		clients := tx.Model(&models.OAuthClient{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("client_id IN (?)", clients).Delete(&models.OAuthToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id IN (?)", clients).Delete(&models.OAuthCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.OAuthClient{}).Error; err != nil {
+			return err
+		}
+
+		if mode == AccountDeleteContent {
+			posts := tx.Model(&models.Post{}).Unscoped().Select("id").Where("user_id = ?", userID)
+			if err := tx.Unscoped().Where("post_id IN (?) OR user_id = ?", posts, userID).Delete(&models.Comment{}).Error; err != nil {
+				return err
+			}
+			// 文章的标签和媒体引用，残留的记录会影响标签的文章数，并使媒体文件一直被视为在使用中
+			if err := tx.Table("post_tags").Where("post_id IN (?)", posts).Delete(map[string]interface{}{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("post_id IN (?)", posts).Delete(&models.PostMedia{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.Post{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&models.User{}, userID).Error
+		}
+		// 保留用户记录以便文章和评论仍然关联到该用户，清除个人信息后无法再登录
+		return tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"user_name":        fmt.Sprintf("deleted-%d", userID),
+			"email":            "",
+			"password":         "",
+			"role":             models.RoleReader,
+			"email_verified":   false,
+			"totp_secret":      "",
+			"totp_enabled":     false,
+			"web_authn_handle": "",
+			"display_name":     "已注销用户",
+			"bio":              "",
+			"website":          "",
+			"social_links":     nil,
+			"avatar":           "",
+		}).Error
+	})
+	if err != nil {
+		models.Log.Error("删除账号失败:", err)
+		return models.ErrInternalServer
+	}
+	removeAvatarFile(user.Avatar)
+	removeUserExports(userID)
+	removeUserMedia(userID)
+	models.Log.Info("用户删除了账号:", userID, user.UserName, mode)
+	return nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+func TestDeleteAccountContent(t *testing.T) {
+	setupTestDB(t)
+	createTestUser(t, "admin", models.RoleAdmin)
+	alice := createTestUser(t, "alice", models.RoleAuthor)
+	bob := createTestUser(t, "bob", models.RoleAuthor)
+	tag := models.Tag{Name: "Go", Slug: "go"}
+	models.DB.Create(&tag)
+	var posts []models.Post
+	for _, user := range []models.User{alice, bob} {
+		post := models.Post{Title: user.UserName, Content: "内容", UserID: user.ID, Tags: []models.Tag{tag}}
+		models.DB.Create(&post)
+		media := models.Media{UserID: user.ID, Hash: user.UserName, Path: user.UserName + ".png"}
+		models.DB.Create(&media)
+		models.DB.Create(&models.PostMedia{PostID: post.ID, MediaID: media.ID})
+		posts = append(posts, post)
+	}
+
+	if apiErr := DeleteAccount(alice.ID, "alice-password", AccountDeleteContent); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	for table, column := range map[string]string{"posts": "user_id", "users": "id"} {
+		var count int64
+		models.DB.Table(table).Where(column+" = ?", alice.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%s中还有%d条用户的记录", table, count)
+		}
+	}
+	// 删除的文章的标签和媒体引用一并删除，其他用户的文章不受影响
+	for _, table := range []string{"post_tags", "post_media"} {
+		var deleted, kept int64
+		models.DB.Table(table).Where("post_id = ?", posts[0].ID).Count(&deleted)
+		models.DB.Table(table).Where("post_id = ?", posts[1].ID).Count(&kept)
+		if deleted != 0 || kept != 1 {
+			t.Errorf("%s中删除的文章还有%d条记录，其他文章有%d条记录", table, deleted, kept)
+		}
+	}
+}
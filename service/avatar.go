@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 上传图片允许的最大边长，防止解码超大图片耗尽内存
+const maxAvatarDimension = 4096
+
+var errInvalidAvatar = &models.APIError{
+	Code:    400,
+	Message: "图片格式不支持",
+	Details: "头像仅支持PNG、JPEG和GIF格式，边长不能超过4096像素",
+}
+
+var errAvatarTooLarge = &models.APIError{
+	Code:    413,
+	Message: "图片过大",
+	Details: "头像文件大小超出限制",
+}
+
+// AvatarMaxBytes 上传头像允许的最大文件大小
+func AvatarMaxBytes() int64 {
+	return int64(config.Conf.User.AvatarMaxKB) * 1024
+}
+
+// UploadAvatar 上传头像，图片居中裁剪为正方形并缩放到配置的大小后保存为PNG，替换原有头像
+func UploadAvatar(userID uint, file io.Reader) (PublicProfile, *models.APIError) {
+	data, err := io.ReadAll(io.LimitReader(file, AvatarMaxBytes()+1))
+	if err != nil {
+		models.Log.Warning("读取头像失败:", err)
+		return PublicProfile{}, models.ErrInvalidRequest
+	}
+	if int64(len(data)) > AvatarMaxBytes() {
+		models.Log.Warning("头像文件过大:", userID, len(data))
+		return PublicProfile{}, errAvatarTooLarge
+	}
+	// 先读取图片尺寸，尺寸过大时不解码
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		models.Log.Warning("头像图片无效:", userID, err)
+		return PublicProfile{}, errInvalidAvatar
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		models.Log.Warning("解码头像失败:", userID, err)
+		return PublicProfile{}, errInvalidAvatar
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, resizeSquare(src, config.Conf.User.AvatarSize)); err != nil {
+		models.Log.Error("编码头像失败:", err)
+		return PublicProfile{}, models.ErrInternalServer
+	}
+
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return PublicProfile{}, apiErr
+	}
+	// 文件名包含随机部分，更换头像后访问地址随之变化
+	name := fmt.Sprintf("%d-%s.png", userID, strings.ToLower(RandomToken(6)))
+	if err := putFile(avatarKeyPrefix+name, buf.Bytes(), "image/png"); err != nil {
+		models.Log.Error("保存头像失败:", err)
+		return PublicProfile{}, models.ErrInternalServer
+	}
+	old := user.Avatar
+	user.Avatar = name
+	if err := models.DB.Model(&user).Update("avatar", name).Error; err != nil {
+		models.Log.Error("更新头像失败:", err)
+		deleteFile(avatarKeyPrefix + name)
+		return PublicProfile{}, models.ErrInternalServer
+	}
+	removeAvatarFile(old)
+	models.Log.Info("用户上传了头像:", userID, name)
+	return NewPublicProfile(user), nil
+}
+
+// DeleteAvatar 删除上传的头像，恢复为自动生成的头像
+func DeleteAvatar(userID uint) (PublicProfile, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return PublicProfile{}, apiErr
+	}
+	if user.Avatar == "" {
+		return NewPublicProfile(user), nil
+	}
+	old := user.Avatar
+	user.Avatar = ""
+	if err := models.DB.Model(&user).Update("avatar", "").Error; err != nil {
+		models.Log.Error("删除头像失败:", err)
+		return PublicProfile{}, models.ErrInternalServer
+	}
+	removeAvatarFile(old)
+	models.Log.Info("用户删除了头像:", userID)
+	return NewPublicProfile(user), nil
+}
+
+func removeAvatarFile(name string) {
+	if name == "" {
+		return
+	}
+	if err := deleteFile(avatarKeyPrefix + name); err != nil {
+		models.Log.Warning("删除头像文件失败:", err)
+	}
+}
+
+// GetAvatar 获取用户头像，上传过头像时返回头像文件，否则返回根据用户名生成的PNG图片
+func GetAvatar(userID uint) (*StoredFile, []byte, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	if user.Avatar != "" {
+		file, apiErr := openFile(avatarKeyPrefix+user.Avatar, models.ErrUserNotFound)
+		if apiErr == nil {
+			return &file, nil, nil
+		}
+		models.Log.Warning("头像文件不存在:", user.Avatar)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, Identicon(user.UserName, config.Conf.User.AvatarSize)); err != nil {
+		models.Log.Error("生成头像失败:", err)
+		return nil, nil, models.ErrInternalServer
+	}
+	return nil, buf.Bytes(), nil
+}
+
+// 居中裁剪为正方形后缩放，每个目标像素取对应源区域的平均值
+func resizeSquare(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), src, origin, draw.Src)
+	return resizeRGBA(square, size, size)
+}
+
+// Identicon 根据名称生成左右对称的5x5方块头像，同一名称生成的头像始终相同
+func Identicon(name string, size int) *image.RGBA {
+	sum := sha256.Sum256([]byte(strings.ToLower(name)))
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{240, 240, 240, 255}), image.Point{}, draw.Src)
+
+	fg := image.NewUniform(hslColor(float64(int(sum[0])<<8|int(sum[1]))/65536, 0.55, 0.55))
+	// 四周各留半个方块的边距
+	cell := size / 6
+	margin := (size - cell*5) / 2
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 3; col++ {
+			if sum[2+row*3+col]%2 != 0 {
+				continue
+			}
+			for _, c := range []int{col, 4 - col} {
+				rect := image.Rect(margin+c*cell, margin+row*cell, margin+(c+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, rect, fg, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// HSL颜色转换为RGB，h、s、l的取值范围均为[0, 1)
+func hslColor(h, s, l float64) color.RGBA {
+	hue := func(p, q, t float64) float64 {
+		if t < 0 {
+			t++
+		}
+		if t > 1 {
+			t--
+		}
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 1.0/2:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	q := l * (1 + s)
+	if l >= 0.5 {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	return color.RGBA{
+		R: uint8(hue(p, q, h+1.0/3) * 255),
+		G: uint8(hue(p, q, h) * 255),
+		B: uint8(hue(p, q, h-1.0/3) * 255),
+		A: 255,
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 贝叶斯分类器的训练类别
+const (
+	BayesSpam = "spam"
+	BayesHam  = "ham"
+)
+
+// 单条评论最多参与统计的词数
+const maxBayesTokens = 200
+
+// Tokenize 将评论内容切分为去重后的词：英文和数字按单词切分，中文按相邻两字切分
+func Tokenize(content string) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) {
+		// 过长的词截断到数据库字段长度以内
+		token = truncateUTF8(token, 64)
+		if len(tokens) >= maxBayesTokens || seen[token] {
+			return
+		}
+		seen[token] = true
+		tokens = append(tokens, token)
+	}
+
+	var word []rune
+	var han []rune
+	flushWord := func() {
+		if len(word) > 1 {
+			add(string(word))
+		}
+		word = word[:0]
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			add(string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			add(string(han[i : i+2]))
+		}
+		han = han[:0]
+	}
+
+	for _, r := range strings.ToLower(content) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return tokens
+}
+
+// 统计已训练的垃圾评论和正常评论数量
+func bayesDocCounts() (int64, int64, error) {
+	var spamDocs, hamDocs int64
+	if err := models.DB.Model(&models.Comment{}).Where("trained_as = ?", BayesSpam).Count(&spamDocs).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := models.DB.Model(&models.Comment{}).Where("trained_as = ?", BayesHam).Count(&hamDocs).Error; err != nil {
+		return 0, 0, err
+	}
+	return spamDocs, hamDocs, nil
+}
+
+// SpamProbability 计算内容为垃圾评论的概率，训练样本不足时trained返回false
+func SpamProbability(content string) (float64, bool, error) {
+	spamDocs, hamDocs, err := bayesDocCounts()
+	if err != nil {
+		return 0, false, err
+	}
+	minTrain := int64(config.Conf.Spam.BayesMinTrain)
+	if spamDocs < minTrain || hamDocs < minTrain || spamDocs == 0 || hamDocs == 0 {
+		return 0, false, nil
+	}
+
+	tokens := Tokenize(content)
+	if len(tokens) == 0 {
+		return 0, true, nil
+	}
+	var stats []models.SpamToken
+	if err := models.DB.Where("token IN ?", tokens).Find(&stats).Error; err != nil {
+		return 0, false, err
+	}
+
+	// 对数几率 = 先验 + 各词的似然比（拉普拉斯平滑），未出现过的词不提供信息
+	logOdds := math.Log(float64(spamDocs)) - math.Log(float64(hamDocs))
+	for _, stat := range stats {
+		pSpam := (float64(stat.SpamCount) + 1) / (float64(spamDocs) + 2)
+		pHam := (float64(stat.HamCount) + 1) / (float64(hamDocs) + 2)
+		logOdds += math.Log(pSpam) - math.Log(pHam)
+	}
+	return 1 / (1 + math.Exp(-logOdds)), true, nil
+}
+
+// 调整词频统计，delta为1表示训练，-1表示撤销训练
+func adjustBayesTokens(tx *gorm.DB, tokens []string, class string, delta int) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	column := "ham_count"
+	if class == BayesSpam {
+		column = "spam_count"
+	}
+	if delta < 0 {
+		return tx.Model(&models.SpamToken{}).
+			Where("token IN ? AND "+column+" > 0", tokens).
+			Update(column, gorm.Expr(column+" - 1")).Error
+	}
+	rows := make([]models.SpamToken, 0, len(tokens))
+	for _, token := range tokens {
+		row := models.SpamToken{Token: token}
+		if class == BayesSpam {
+			row.SpamCount = 1
+		} else {
+			row.HamCount = 1
+		}
+		rows = append(rows, row)
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{column: gorm.Expr(column + " + 1")}),
+	}).Create(&rows).Error
+}
+
+// TrainComment 用审核结果训练分类器，评论之前以其他类别训练过时会先撤销
+func TrainComment(comment models.Comment, class string) error {
+	if comment.TrainedAs == class {
+		return nil
+	}
+	tokens := Tokenize(comment.Content)
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		if comment.TrainedAs != "" {
+			if err := adjustBayesTokens(tx, tokens, comment.TrainedAs, -1); err != nil {
+				return err
+			}
+		}
+		if class != "" {
+			if err := adjustBayesTokens(tx, tokens, class, 1); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Comment{}).Where("id = ?", comment.ID).Update("trained_as", class).Error
+	})
+}
@@ -1,17 +1,117 @@
 package service
 
 import (
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
 	"github.com/xiaohan1995/Gin-blog/models"
 	"gorm.io/gorm"
 )
 
-func CreateComment(comment models.Comment) *models.APIError {
+// 审核操作与评论状态的对应关系
+var moderationActions = map[string]string{
+	"approve": models.CommentStatusApproved,
+	"reject":  models.CommentStatusRejected,
+	"spam":    models.CommentStatusSpam,
+}
+
+// IsValidModeration 判断审核模式是否合法
+func IsValidModeration(mode string) bool {
+	switch mode {
+	case models.ModerationOpen, models.ModerationFirstTime, models.ModerationAll:
+		return true
+	}
+	return false
+}
+
+// 获取文章实际生效的审核模式，文章未设置时使用站点默认配置
+func commentModeration(post models.Post) string {
+	if post.CommentModeration != "" {
+		return post.CommentModeration
+	}
+	if IsValidModeration(config.Conf.Comment.Moderation) {
+		return config.Conf.Comment.Moderation
+	}
+	return models.ModerationOpen
+}
+
+// 判断评论者是否为文章作者或有审核权限的用户
+func isTrustedCommenter(comment models.Comment, post models.Post) bool {
+	return comment.UserID == post.UserID || UserCan(comment.UserID, models.PermCommentModerate)
+}
+
+// 根据审核模式决定新评论的初始状态
+func initialCommentStatus(comment models.Comment, post models.Post) (string, error) {
+	// 文章作者和有审核权限的用户的评论无需审核
+	if isTrustedCommenter(comment, post) {
+		return models.CommentStatusApproved, nil
+	}
+	switch commentModeration(post) {
+	case models.ModerationAll:
+		return models.CommentStatusPending, nil
+	case models.ModerationFirstTime:
+		var count int64
+		err := models.DB.Model(&models.Comment{}).
+			Where("user_id = ? AND status = ?", comment.UserID, models.CommentStatusApproved).
+			Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return models.CommentStatusPending, nil
+		}
+	}
+	return models.CommentStatusApproved, nil
+}
+
+func CreateComment(comment models.Comment) (models.Comment, *models.APIError) {
 	DB := models.DB
+	var post models.Post
+	if err := DB.Where("id = ?", comment.PostID).First(&post).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("评论的文章不存在:", comment.PostID)
+			return comment, models.ErrPostNotFound
+		}
+		models.Log.Error("查询文章失败:", err)
+		return comment, models.ErrInternalServer
+	}
+	// 草稿不能评论
+	if post.Status == models.PostStatusDraft {
+		models.Log.Warning("评论的文章未发布:", comment.PostID)
+		return comment, models.ErrPostNotFound
+	}
+	status, err := initialCommentStatus(comment, post)
+	if err != nil {
+		models.Log.Error("获取评论审核状态失败:", err)
+		return comment, models.ErrInternalServer
+	}
+	comment.ContentHash = ContentHash(comment.Content)
+	// 文章作者和有审核权限的用户的评论不做垃圾评论检测
+	if !isTrustedCommenter(comment, post) {
+		result := CheckSpam(&comment)
+		comment.SpamScore = result.Score
+		comment.SpamReasons = truncateUTF8(strings.Join(result.Reasons, "; "), 1024)
+		// 检测结果只会让评论状态更严格
+		switch spamStatus(result.Score) {
+		case models.CommentStatusSpam:
+			status = models.CommentStatusSpam
+		case models.CommentStatusPending:
+			if status == models.CommentStatusApproved {
+				status = models.CommentStatusPending
+			}
+		}
+		if result.Score > 0 {
+			models.Log.Warning("评论疑似垃圾内容:", comment.UserID, comment.IP, result.Score, comment.SpamReasons)
+		}
+	}
+	comment.Status = status
 	if err := DB.Create(&comment).Error; err != nil {
 		models.Log.Error("发布评论失败", err)
-		return models.ErrPostCreated
+		return comment, models.ErrPostCreated
 	}
-	return nil
+	models.Log.Info("新评论被创建:", comment.ID, "状态:", comment.Status)
+	return comment, nil
 }
 
 func GetComments() ([]models.Comment, *models.APIError) {
@@ -19,9 +119,110 @@ func GetComments() ([]models.Comment, *models.APIError) {
 	var comments []models.Comment
 	if err := DB.Preload("Post").Preload("User", func(db *gorm.DB) *gorm.DB {
 		return db.Omit("password")
-	}).Find(&comments).Error; err != nil {
+	}).Where("status = ?", models.CommentStatusApproved).Find(&comments).Error; err != nil {
 		models.Log.Error("获取评论失败", err)
 		return nil, models.ErrInternalServer
 	}
 	return comments, nil
 }
+
+// ModerationComment 审核队列中的评论，包含垃圾评论检测的得分和原因
+type ModerationComment struct {
+	models.Comment
+	SpamScore   float64
+	SpamReasons string
+}
+
+// GetModerationQueue 获取当前用户文章下指定状态的评论（默认待审核），有审核权限的用户可以看到全部文章的评论
+func GetModerationQueue(userID uint, status string) ([]ModerationComment, *models.APIError) {
+	if status == "" {
+		status = models.CommentStatusPending
+	}
+	var comments []models.Comment
+	query := models.DB.Preload("Post").Preload("User", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id,user_name")
+	}).Joins("JOIN posts ON posts.id = comments.post_id AND posts.deleted_at IS NULL").
+		Where("comments.status = ?", status)
+	if !UserCan(userID, models.PermCommentModerate) {
+		query = query.Where("posts.user_id = ?", userID)
+	}
+	err := query.Order("comments.created_at").Find(&comments).Error
+	if err != nil {
+		models.Log.Error("获取审核队列失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	queue := make([]ModerationComment, len(comments))
+	for i, comment := range comments {
+		queue[i] = ModerationComment{Comment: comment, SpamScore: comment.SpamScore, SpamReasons: comment.SpamReasons}
+	}
+	return queue, nil
+}
+
+// ModerateComments 批量审核评论，没有审核权限的用户只能审核自己文章下的评论
+func ModerateComments(ids []uint, action string, moderatorID uint) (int64, *models.APIError) {
+	status, ok := moderationActions[action]
+	if !ok || len(ids) == 0 {
+		models.Log.Warning("无效的审核请求:", action, ids)
+		return 0, models.ErrInvalidRequest
+	}
+	ids = uniqueIDs(ids)
+	DB := models.DB
+	var comments []models.Comment
+	if err := DB.Preload("Post").Where("id IN ?", ids).Find(&comments).Error; err != nil {
+		models.Log.Error("查询评论失败:", err)
+		return 0, models.ErrInternalServer
+	}
+	if len(comments) != len(ids) {
+		models.Log.Warning("部分评论不存在:", ids)
+		return 0, models.ErrCommentNotFound
+	}
+	canModerateAll := UserCan(moderatorID, models.PermCommentModerate)
+	for _, comment := range comments {
+		if comment.Post.UserID != moderatorID && !canModerateAll {
+			models.Log.Error("无权审核该评论:", comment.ID, "Current user:", moderatorID)
+			return 0, models.ErrForbidden
+		}
+	}
+	now := time.Now()
+	res := DB.Model(&models.Comment{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"status":       status,
+		"moderated_by": moderatorID,
+		"moderated_at": now,
+	})
+	if res.Error != nil {
+		models.Log.Error("审核评论失败:", res.Error)
+		return 0, models.ErrInternalServer
+	}
+	models.Log.Info("评论审核完成:", ids, "状态:", status, "审核人:", moderatorID)
+
+	// 用审核结果训练贝叶斯分类器，拒绝的评论不一定是垃圾评论，不参与训练
+	class := ""
+	switch status {
+	case models.CommentStatusSpam:
+		class = BayesSpam
+	case models.CommentStatusApproved:
+		class = BayesHam
+	}
+	for _, comment := range comments {
+		if class == "" && comment.TrainedAs == "" {
+			continue
+		}
+		if err := TrainComment(comment, class); err != nil {
+			models.Log.Error("训练垃圾评论分类器失败:", comment.ID, err)
+		}
+	}
+	return res.RowsAffected, nil
+}
+
+// 去除重复的ID
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	res := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
@@ -0,0 +1,463 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 超过该时间仍未完成的导出视为已中断（例如生成期间服务重启），允许重新申请
+const exportStaleAfter = time.Hour
+
+var errExportInProgress = &models.APIError{
+	Code:    409,
+	Message: "数据导出正在生成",
+	Details: "请等待当前的导出完成后再申请",
+}
+
+var errExportNotFound = &models.APIError{
+	Code:    404,
+	Message: "导出文件不存在",
+	Details: "导出文件已过期或已被新的导出替换，请重新申请",
+}
+
+// 导出文件中的个人资料，不包含密码哈希和两步验证密钥
+type exportProfile struct {
+	ID               uint              `json:"id"`
+	Username         string            `json:"username"`
+	Email            string            `json:"email"`
+	EmailVerified    bool              `json:"email_verified"`
+	Role             string            `json:"role"`
+	DisplayName      string            `json:"display_name"`
+	Bio              string            `json:"bio"`
+	Website          string            `json:"website"`
+	SocialLinks      map[string]string `json:"social_links"`
+	TwoFactorEnabled bool              `json:"two_factor_enabled"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+type exportPost struct {
+	ID                uint       `json:"id"`
+	Title             string     `json:"title"`
+	Content           string     `json:"content"`
+	Status            string     `json:"status"`
+	Tags              []string   `json:"tags"`
+	CommentModeration string     `json:"comment_moderation"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	DeletedAt         *time.Time `json:"deleted_at"`
+	// 压缩包中对应的Markdown文件
+	File string `json:"file"`
+}
+
+type exportComment struct {
+	ID        uint       `json:"id"`
+	PostID    uint       `json:"post_id"`
+	Content   string     `json:"content"`
+	Status    string     `json:"status"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type exportSession struct {
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// 导出文件中的令牌和登录凭证，只包含元数据，不包含令牌哈希和密钥
+type exportTokens struct {
+	Sessions             []exportSession              `json:"sessions"`
+	PersonalAccessTokens []models.PersonalAccessToken `json:"personal_access_tokens"`
+	Passkeys             []models.WebAuthnCredential  `json:"passkeys"`
+	OAuthAuthorizations  []models.OAuthToken          `json:"oauth_authorizations"`
+	OAuthClients         []models.OAuthClient         `json:"oauth_clients"`
+	ExternalIdentities   []models.UserIdentity        `json:"external_identities"`
+}
+
+// 导出文件在存储中的键名
+func exportKey(name string) string {
+	return exportKeyPrefix + name
+}
+
+// 删除已过期的导出文件和记录
+func cleanupExpiredExports() {
+	var exports []models.DataExport
+	if err := models.DB.Where("expires_at < ?", time.Now()).Find(&exports).Error; err != nil {
+		models.Log.Error("查询过期导出失败:", err)
+		return
+	}
+	for _, export := range exports {
+		removeExport(export)
+	}
+}
+
+func removeExport(export models.DataExport) {
+	if export.FileName != "" {
+		if err := deleteFile(exportKey(export.FileName)); err != nil {
+			models.Log.Warning("删除导出文件失败:", err)
+		}
+	}
+	if err := models.DB.Delete(&export).Error; err != nil {
+		models.Log.Error("删除导出记录失败:", err)
+	}
+}
+
+// RequestDataExport 申请导出个人数据，压缩包在后台生成，完成后将下载链接发送到用户邮箱
+func RequestDataExport(userID uint) (models.DataExport, *models.APIError) {
+	cleanupExpiredExports()
+	export := models.DataExport{UserID: userID, Status: models.ExportStatusPending}
+	var count int64
+	err := models.DB.Model(&models.DataExport{}).
+		Where("user_id = ? AND status = ? AND created_at > ?", userID, models.ExportStatusPending, time.Now().Add(-exportStaleAfter)).
+		Count(&count).Error
+	if err != nil {
+		models.Log.Error("查询导出记录失败:", err)
+		return export, models.ErrInternalServer
+	}
+	if count > 0 {
+		models.Log.Warning("数据导出正在生成:", userID)
+		return export, errExportInProgress
+	}
+	if err := models.DB.Create(&export).Error; err != nil {
+		models.Log.Error("创建导出记录失败:", err)
+		return export, models.ErrInternalServer
+	}
+	models.Log.Info("用户申请导出个人数据:", userID, export.ID)
+	go buildDataExport(export)
+	return export, nil
+}
+
+// GetDataExports 获取用户的导出记录
+func GetDataExports(userID uint) ([]models.DataExport, *models.APIError) {
+	var exports []models.DataExport
+	if err := models.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&exports).Error; err != nil {
+		models.Log.Error("获取导出记录失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return exports, nil
+}
+
+// 生成导出文件，完成后删除该用户之前的导出并发送下载链接
+func buildDataExport(export models.DataExport) {
+	user, apiErr := findUser(export.UserID)
+	if apiErr != nil {
+		models.DB.Model(&export).Update("status", models.ExportStatusFailed)
+		return
+	}
+	name := fmt.Sprintf("%d-%s.zip", user.ID, RandomToken(12))
+	size, err := writeDataExport(user, name)
+	if err != nil {
+		models.Log.Error("生成导出文件失败:", export.ID, err)
+		deleteFile(exportKey(name))
+		models.DB.Model(&export).Update("status", models.ExportStatusFailed)
+		return
+	}
+
+	ttl := time.Duration(config.Conf.User.ExportTTL) * time.Hour
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	err = models.DB.Model(&export).Updates(map[string]interface{}{
+		"status":       models.ExportStatusReady,
+		"file_name":    name,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error
+	if err != nil {
+		models.Log.Error("更新导出记录失败:", err)
+		deleteFile(exportKey(name))
+		return
+	}
+	// 下载链接只对应最新的导出，之前的导出不再保留
+	var previous []models.DataExport
+	models.DB.Where("user_id = ? AND id <> ? AND status <> ?", user.ID, export.ID, models.ExportStatusPending).Find(&previous)
+	for _, old := range previous {
+		removeExport(old)
+	}
+	models.Log.Info("个人数据导出完成:", user.ID, export.ID, size)
+
+	token, err := createUserToken(user.ID, models.TokenPurposeDataExport, user.Email, ttl)
+	if err != nil {
+		models.Log.Error("创建下载令牌失败:", err)
+		return
+	}
+	link := siteURL("/exports/download", url.Values{"token": {token}})
+	err = SendMail(MailMessage{
+		To:      user.Email,
+		Subject: fmt.Sprintf("[%s] 您的个人数据导出已完成", config.Conf.Site.Name),
+		Body: fmt.Sprintf("%s，您好：\n\n您申请导出的个人数据已经生成，请在%d小时内通过以下链接下载：\n\n%s\n\n压缩包中包含您的个人资料、文章、评论、登录记录和令牌信息，请妥善保管。\n",
+			user.UserName, config.Conf.User.ExportTTL, link),
+	})
+	if err != nil {
+		models.Log.Error("发送导出邮件失败:", err)
+	}
+}
+
+// DownloadDataExport 使用邮件中的链接下载导出文件，返回有效期较短的签名下载链接
+func DownloadDataExport(token string) (string, *models.APIError) {
+	record, apiErr := findUserToken(token, models.TokenPurposeDataExport)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	var export models.DataExport
+	err := models.DB.Where("user_id = ? AND status = ? AND expires_at > ?", record.UserID, models.ExportStatusReady, time.Now()).
+		Order("id DESC").First(&export).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", errExportNotFound
+		}
+		models.Log.Error("查询导出记录失败:", err)
+		return "", models.ErrInternalServer
+	}
+	name := fmt.Sprintf("%s-data-%s.zip", config.Conf.Site.Name, export.CreatedAt.Format("20060102"))
+	link, err := signedFileURL(exportKey(export.FileName), name)
+	if err != nil {
+		models.Log.Error("生成下载链接失败:", err)
+		return "", models.ErrInternalServer
+	}
+	models.Log.Info("用户下载了个人数据导出:", record.UserID, export.ID)
+	return link, nil
+}
+
+// 删除用户的全部导出文件，用于删除账号
+func removeUserExports(userID uint) {
+	var exports []models.DataExport
+	models.DB.Where("user_id = ?", userID).Find(&exports)
+	for _, export := range exports {
+		removeExport(export)
+	}
+}
+
+// 生成压缩包并写入存储，返回文件大小。压缩包先写入临时文件，避免占用过多内存
+func writeDataExport(user models.User, name string) (int64, error) {
+	f, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	if err := writeExportEntries(zw, user); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	s, err := getStorage()
+	if err != nil {
+		return 0, err
+	}
+	return size, s.Put(exportKey(name), f, size, "application/zip")
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeZipFile(zw, name, data)
+}
+
+func deletedTime(deletedAt gorm.DeletedAt) *time.Time {
+	if !deletedAt.Valid {
+		return nil
+	}
+	return &deletedAt.Time
+}
+
+// 文章的Markdown文件，开头为YAML格式的元数据
+func postMarkdown(post exportPost) []byte {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %d\n", post.ID)
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(post.Title))
+	fmt.Fprintf(&b, "status: %s\n", post.Status)
+	if len(post.Tags) > 0 {
+		quoted := make([]string, 0, len(post.Tags))
+		for _, tag := range post.Tags {
+			quoted = append(quoted, strconv.Quote(tag))
+		}
+		fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(quoted, ", "))
+	}
+	fmt.Fprintf(&b, "created_at: %s\n", post.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "updated_at: %s\n", post.UpdatedAt.Format(time.RFC3339))
+	if post.DeletedAt != nil {
+		fmt.Fprintf(&b, "deleted_at: %s\n", post.DeletedAt.Format(time.RFC3339))
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(post.Content)
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// 写入导出的全部内容，已删除（软删除）的文章和评论同样导出
+func writeExportEntries(zw *zip.Writer, user models.User) error {
+	DB := models.DB
+	profile := exportProfile{
+		ID:               user.ID,
+		Username:         user.UserName,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		Role:             user.Role,
+		DisplayName:      user.DisplayName,
+		Bio:              user.Bio,
+		Website:          user.Website,
+		SocialLinks:      user.SocialLinks,
+		TwoFactorEnabled: user.TOTPEnabled,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+	}
+	if err := writeZipJSON(zw, "profile.json", profile); err != nil {
+		return err
+	}
+	if user.Avatar != "" {
+		if data, err := readFile(avatarKeyPrefix + user.Avatar); err == nil {
+			if err := writeZipFile(zw, "avatar.png", data); err != nil {
+				return err
+			}
+		}
+	}
+
+	var posts []models.Post
+	if err := DB.Unscoped().Preload("Tags").Where("user_id = ?", user.ID).Order("id").Find(&posts).Error; err != nil {
+		return err
+	}
+	items := make([]exportPost, 0, len(posts))
+	for _, post := range posts {
+		item := exportPost{
+			ID:                post.ID,
+			Title:             post.Title,
+			Content:           post.Content,
+			Status:            post.Status,
+			Tags:              []string{},
+			CommentModeration: post.CommentModeration,
+			CreatedAt:         post.CreatedAt,
+			UpdatedAt:         post.UpdatedAt,
+			DeletedAt:         deletedTime(post.DeletedAt),
+			File:              fmt.Sprintf("posts/%d.md", post.ID),
+		}
+		for _, tag := range post.Tags {
+			item.Tags = append(item.Tags, tag.Name)
+		}
+		if err := writeZipFile(zw, item.File, postMarkdown(item)); err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	if err := writeZipJSON(zw, "posts.json", items); err != nil {
+		return err
+	}
+
+	var comments []models.Comment
+	if err := DB.Unscoped().Where("user_id = ?", user.ID).Order("id").Find(&comments).Error; err != nil {
+		return err
+	}
+	commentItems := make([]exportComment, 0, len(comments))
+	for _, comment := range comments {
+		commentItems = append(commentItems, exportComment{
+			ID:        comment.ID,
+			PostID:    comment.PostID,
+			Content:   comment.Content,
+			Status:    comment.Status,
+			IP:        comment.IP,
+			UserAgent: comment.UserAgent,
+			CreatedAt: comment.CreatedAt,
+			UpdatedAt: comment.UpdatedAt,
+			DeletedAt: deletedTime(comment.DeletedAt),
+		})
+	}
+	if err := writeZipJSON(zw, "comments.json", commentItems); err != nil {
+		return err
+	}
+
+	var attempts []models.LoginAttempt
+	if err := DB.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&attempts).Error; err != nil {
+		return err
+	}
+	if err := writeZipJSON(zw, "login_history.json", attempts); err != nil {
+		return err
+	}
+
+	tokens := exportTokens{}
+	var refreshTokens []models.RefreshToken
+	if err := DB.Where("user_id = ?", user.ID).Order("id").Find(&refreshTokens).Error; err != nil {
+		return err
+	}
+	for _, t := range refreshTokens {
+		tokens.Sessions = append(tokens.Sessions, exportSession{
+			IP:        t.IP,
+			UserAgent: t.UserAgent,
+			CreatedAt: t.CreatedAt,
+			ExpiresAt: t.ExpiresAt,
+			RevokedAt: t.RevokedAt,
+		})
+	}
+	queries := []struct {
+		db   *gorm.DB
+		dest interface{}
+	}{
+		{DB.Where("user_id = ?", user.ID), &tokens.PersonalAccessTokens},
+		{DB.Where("user_id = ?", user.ID), &tokens.Passkeys},
+		{DB.Preload("Client").Where("user_id = ?", user.ID), &tokens.OAuthAuthorizations},
+		{DB.Where("user_id = ?", user.ID), &tokens.OAuthClients},
+		{DB.Where("user_id = ?", user.ID), &tokens.ExternalIdentities},
+	}
+	for _, q := range queries {
+		if err := q.db.Order("id").Find(q.dest).Error; err != nil {
+			return err
+		}
+	}
+	if err := writeZipJSON(zw, "tokens.json", tokens); err != nil {
+		return err
+	}
+
+	return writeZipFile(zw, "README.md", exportReadme(user, len(items), len(commentItems)))
+}
+
+// 压缩包的说明文件
+func exportReadme(user models.User, posts, comments int) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s 个人数据导出\n\n", config.Conf.Site.Name)
+	fmt.Fprintf(&b, "- 用户：%s（%s）\n", user.UserName, user.Email)
+	fmt.Fprintf(&b, "- 导出时间：%s\n\n", time.Now().Format(time.RFC3339))
+	b.WriteString("| 文件 | 内容 |\n| --- | --- |\n")
+	b.WriteString("| profile.json | 个人资料，avatar.png 为上传的头像 |\n")
+	fmt.Fprintf(&b, "| posts.json、posts/*.md | 文章（%d篇），包括已删除的文章；博客不保存文章的历史版本，导出的是当前内容 |\n", posts)
+	fmt.Fprintf(&b, "| comments.json | 发表的评论（%d条），包括发表时记录的IP和浏览器信息 |\n", comments)
+	b.WriteString("| login_history.json | 登录记录 |\n")
+	b.WriteString("| tokens.json | 登录会话、个人访问令牌、通行密钥、已授权的应用、注册的OAuth2客户端和外部账号关联，只包含元数据 |\n")
+	return []byte(b.String())
+}
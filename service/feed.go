@@ -0,0 +1,438 @@
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 订阅源格式
+const (
+	FeedRSS  = "rss"
+	FeedAtom = "atom"
+	FeedJSON = "json"
+)
+
+// 各格式订阅源的文件名，订阅源地址为对应页面的地址加上文件名
+var feedFiles = map[string]string{
+	FeedRSS:  "feed.xml",
+	FeedAtom: "atom.xml",
+	FeedJSON: "feed.json",
+}
+
+var feedContentTypes = map[string]string{
+	FeedRSS:  "application/rss+xml; charset=utf-8",
+	FeedAtom: "application/atom+xml; charset=utf-8",
+	FeedJSON: "application/feed+json; charset=utf-8",
+}
+
+// 自动发现链接中使用的类型和名称，按显示顺序排列
+var feedLinkTypes = []struct{ Format, Type, Name string }{
+	{FeedRSS, "application/rss+xml", "RSS"},
+	{FeedAtom, "application/atom+xml", "Atom"},
+	{FeedJSON, "application/feed+json", "JSON Feed"},
+}
+
+// FeedLink 公开页面中订阅源的自动发现链接
+type FeedLink struct {
+	Title string
+	Type  string
+	Path  string
+}
+
+// Feed 订阅源的内容
+type Feed struct {
+	Format      string
+	Title       string
+	Description string
+	// 订阅源对应页面的站内地址
+	Path string
+	// 订阅源中最近一次更新的时间，没有文章时为零值
+	Updated time.Time
+	// 包含文章全文，否则只包含摘要
+	Full  bool
+	Posts []PublicPost
+	// 作者的订阅源中为作者的资料
+	Author *PublicProfile
+}
+
+// FeedPath 页面对应的订阅源地址，base为页面的站内地址，首页为空
+func FeedPath(base, format string) string {
+	return strings.TrimSuffix(base, "/") + "/" + feedFiles[format]
+}
+
+// 页面的自动发现链接，title为订阅源的名称
+func feedLinks(base, title string) []FeedLink {
+	links := make([]FeedLink, 0, len(feedLinkTypes))
+	for _, t := range feedLinkTypes {
+		links = append(links, FeedLink{Title: title + " (" + t.Name + ")", Type: t.Type, Path: FeedPath(base, t.Format)})
+	}
+	return links
+}
+
+// 站内地址转换为完整地址
+func absoluteURL(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
+	return strings.TrimRight(config.Conf.Site.BaseURL, "/") + path
+}
+
+// 按条件获取订阅源中的文章，订阅源的更新时间为其中文章最近的修改时间
+func newFeed(format string, full bool, q PostQuery) (Feed, *models.APIError) {
+	q.Page = 1
+	q.PageSize = config.Conf.Feed.Items
+	q.Content = full
+	posts, apiErr := ListPublishedPosts(q, nil)
+	if apiErr != nil {
+		return Feed{}, apiErr
+	}
+	feed := Feed{Format: format, Full: full, Posts: posts.Posts}
+	for _, post := range posts.Posts {
+		if post.UpdatedAt.After(feed.Updated) {
+			feed.Updated = post.UpdatedAt
+		}
+	}
+	return feed, nil
+}
+
+// GetSiteFeed 全站的订阅源
+func GetSiteFeed(format string, full bool) (Feed, *models.APIError) {
+	feed, apiErr := newFeed(format, full, PostQuery{})
+	if apiErr != nil {
+		return Feed{}, apiErr
+	}
+	feed.Title = config.Conf.Site.Name
+	feed.Description = config.Conf.Site.Description
+	feed.Path = "/"
+	return feed, nil
+}
+
+// GetTagFeed 标签的订阅源
+func GetTagFeed(slug, format string, full bool) (Feed, *models.APIError) {
+	tag, apiErr := GetTag(slug)
+	if apiErr != nil {
+		return Feed{}, apiErr
+	}
+	feed, apiErr := newFeed(format, full, PostQuery{Tag: tag.Slug})
+	if apiErr != nil {
+		return Feed{}, apiErr
+	}
+	feed.Title = config.Conf.Site.Name + " - 标签：" + tag.Name
+	feed.Description = "标签" + tag.Name + "下的文章"
+	feed.Path = TagPath(tag.Slug)
+	return feed, nil
+}
+
+// GetAuthorFeed 作者的订阅源
+func GetAuthorFeed(username, format string, full bool) (Feed, *models.APIError) {
+	author, apiErr := GetPublicAuthor(username)
+	if apiErr != nil {
+		return Feed{}, apiErr
+	}
+	feed, apiErr := newFeed(format, full, PostQuery{Author: author.Username})
+	if apiErr != nil {
+		return Feed{}, apiErr
+	}
+	feed.Title = config.Conf.Site.Name + " - " + author.DisplayName
+	feed.Description = author.Bio
+	if feed.Description == "" {
+		feed.Description = author.DisplayName + "的文章"
+	}
+	feed.Path = AuthorPath(author.Username)
+	feed.Author = &author
+	return feed, nil
+}
+
+// ContentType 订阅源的Content-Type
+func (f Feed) ContentType() string {
+	return feedContentTypes[f.Format]
+}
+
+// 订阅源自身的站内地址
+func (f Feed) selfPath() string {
+	if f.Path == "/" {
+		return FeedPath("", f.Format)
+	}
+	return FeedPath(f.Path, f.Format)
+}
+
+// 文章在订阅源中的内容，全文为渲染后的HTML
+func (f Feed) postContent(post PublicPost) string {
+	if !f.Full {
+		return ""
+	}
+	return string(RenderMarkdown(post.Content))
+}
+
+// Encode 按订阅源的格式生成内容
+func (f Feed) Encode() ([]byte, error) {
+	switch f.Format {
+	case FeedAtom:
+		return f.atom()
+	case FeedJSON:
+		return f.jsonFeed()
+	default:
+		return f.rss()
+	}
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	DCNS      string     `xml:"xmlns:dc,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	Language      string      `xml:"language"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Generator     string      `xml:"generator"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"content:encoded,omitempty"`
+}
+
+// RSS 2.0，没有修改时间字段，频道的lastBuildDate为最近的修改时间
+func (f Feed) rss() ([]byte, error) {
+	channel := rssChannel{
+		Title:       f.Title,
+		Link:        absoluteURL(f.Path),
+		Description: f.Description,
+		AtomLink:    rssAtomLink{Href: absoluteURL(f.selfPath()), Rel: "self", Type: "application/rss+xml"},
+		Language:    "zh-CN",
+		Generator:   "Gin Blog",
+		Items:       []rssItem{},
+	}
+	if !f.Updated.IsZero() {
+		channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+	for _, post := range f.Posts {
+		link := absoluteURL(PostPath(post.ID))
+		item := rssItem{
+			Title:       post.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     post.PublishedAt.Format(time.RFC1123Z),
+			Creator:     post.Author.DisplayName,
+			Description: post.Excerpt,
+			Content:     f.postContent(post),
+		}
+		for _, tag := range post.Tags {
+			item.Categories = append(item.Categories, tag.Name)
+		}
+		channel.Items = append(channel.Items, item)
+	}
+	return marshalXML(rssFeed{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		DCNS:      "http://purl.org/dc/elements/1.1/",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel:   channel,
+	})
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang      string      `xml:"xml:lang,attr"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Subtitle  string      `xml:"subtitle,omitempty"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    atomText       `xml:"summary"`
+	Content    *atomText      `xml:"content,omitempty"`
+}
+
+// Atom 1.0，文章地址作为条目的ID
+func (f Feed) atom() ([]byte, error) {
+	updated := f.Updated
+	if updated.IsZero() {
+		// updated为必填字段，没有文章时使用固定的时间，保证内容不变时ETag不变
+		updated = time.Unix(0, 0)
+	}
+	feed := atomFeed{
+		Lang:     "zh-CN",
+		ID:       absoluteURL(f.Path),
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: absoluteURL(f.Path), Rel: "alternate", Type: "text/html"},
+			{Href: absoluteURL(f.selfPath()), Rel: "self", Type: "application/atom+xml"},
+		},
+		Generator: "Gin Blog",
+		Entries:   []atomEntry{},
+	}
+	if f.Author != nil {
+		feed.Author = &atomPerson{Name: f.Author.DisplayName, URI: absoluteURL(AuthorPath(f.Author.Username))}
+	}
+	for _, post := range f.Posts {
+		link := absoluteURL(PostPath(post.ID))
+		entry := atomEntry{
+			ID:        link,
+			Title:     post.Title,
+			Links:     []atomLink{{Href: link, Rel: "alternate", Type: "text/html"}},
+			Published: post.PublishedAt.UTC().Format(time.RFC3339),
+			Updated:   post.UpdatedAt.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: post.Author.DisplayName, URI: absoluteURL(AuthorPath(post.Author.Username))},
+			Summary:   atomText{Type: "text", Body: post.Excerpt},
+		}
+		for _, tag := range post.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag.Slug, Label: tag.Name})
+		}
+		if content := f.postContent(post); content != "" {
+			entry.Content = &atomText{Type: "html", Body: content}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshalXML(feed)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+type jsonFeedDoc struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Language    string           `json:"language"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name   string `json:"name"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html,omitempty"`
+	ContentText   string           `json:"content_text,omitempty"`
+	Summary       string           `json:"summary,omitempty"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+func newJSONFeedAuthor(profile PublicProfile) jsonFeedAuthor {
+	return jsonFeedAuthor{
+		Name:   profile.DisplayName,
+		URL:    absoluteURL(AuthorPath(profile.Username)),
+		Avatar: absoluteURL(profile.AvatarURL),
+	}
+}
+
+// JSON Feed 1.1，只包含摘要时摘要作为content_text
+func (f Feed) jsonFeed() ([]byte, error) {
+	feed := jsonFeedDoc{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: absoluteURL(f.Path),
+		FeedURL:     absoluteURL(f.selfPath()),
+		Description: f.Description,
+		Language:    "zh-CN",
+		Items:       []jsonFeedItem{},
+	}
+	if f.Author != nil {
+		feed.Authors = []jsonFeedAuthor{newJSONFeedAuthor(*f.Author)}
+	}
+	for _, post := range f.Posts {
+		link := absoluteURL(PostPath(post.ID))
+		item := jsonFeedItem{
+			ID:            link,
+			URL:           link,
+			Title:         post.Title,
+			Summary:       post.Excerpt,
+			DatePublished: post.PublishedAt.Format(time.RFC3339),
+			DateModified:  post.UpdatedAt.Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{newJSONFeedAuthor(post.Author)},
+		}
+		if content := f.postContent(post); content != "" {
+			item.ContentHTML = content
+		} else {
+			item.ContentText = post.Excerpt
+		}
+		for _, tag := range post.Tags {
+			item.Tags = append(item.Tags, tag.Name)
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return json.MarshalIndent(feed, "", "  ")
+}
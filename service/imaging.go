@@ -0,0 +1,197 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// 去除JPEG中的EXIF、XMP（APP1）、IPTC（APP13）和注释，保留JFIF和ICC颜色配置等其他数据，
+// 不重新编码图片。同时返回EXIF中记录的方向，没有记录时为1
+func stripJPEGMetadata(data []byte) ([]byte, int, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, false
+	}
+	orientation := 1
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, false
+		}
+		marker := data[i+1]
+		// 填充字节
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		// 扫描数据开始后不再有元数据，剩余部分原样保留
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), orientation, true
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, 0, false
+		}
+		segment := data[i : i+2+length]
+		switch marker {
+		case 0xE1:
+			if o := exifOrientation(segment[4:]); o != 0 {
+				orientation = o
+			}
+		case 0xED, 0xFE:
+		default:
+			out.Write(segment)
+		}
+		i += 2 + length
+	}
+	return nil, 0, false
+}
+
+// 读取EXIF中的方向（0x0112），读取失败时返回0
+func exifOrientation(exif []byte) int {
+	if len(exif) < 14 || string(exif[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := exif[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// PNG中记录元数据的数据块：EXIF、文本和修改时间
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// 去除PNG中的元数据块，不重新编码图片
+func stripPNGMetadata(data []byte) ([]byte, bool) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return nil, false
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(signature)
+	for i := len(signature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, false
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), true
+}
+
+// 按EXIF方向旋转或翻转图片，使其以正确的方向显示
+func applyOrientation(src image.Image, orientation int) *image.RGBA {
+	img := toRGBA(src)
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], img.Pix[sy*img.Stride+sx*4:sy*img.Stride+sx*4+4])
+		}
+	}
+	return dst
+}
+
+// 转换为从(0, 0)开始的RGBA图片
+func toRGBA(src image.Image) *image.RGBA {
+	if img, ok := src.(*image.RGBA); ok && img.Bounds().Min == (image.Point{}) {
+		return img
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+	return img
+}
+
+// 缩放图片，每个目标像素取对应源区域的平均值
+func resizeRGBA(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for i := 0; i < 4; i++ {
+						sum[i] += int(row[sx*4+i])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for i := 0; i < 4; i++ {
+				dst.Pix[offset+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,423 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/importer"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 来源和ID的最大长度，超出时使用哈希
+const maxImportKeyLen = 191
+
+var errImportNoAuthor = &models.APIError{
+	Code:    400,
+	Message: "文章没有作者",
+	Details: "部分文章没有作者信息或作者不在导出文件中，需要指定默认作者",
+}
+
+// ImportOptions 导入的参数
+type ImportOptions struct {
+	// 没有作者信息的文章由该用户名的用户发布
+	DefaultAuthor string
+	// 只统计将要导入的内容，不写入数据库
+	DryRun bool
+}
+
+// ImportCount 一类内容的导入数量
+type ImportCount struct {
+	// 新建的数量
+	Created int `json:"created"`
+	// 对应到已有记录的数量，如邮箱相同的已有用户
+	Matched int `json:"matched"`
+	// 之前已经导入而跳过的数量
+	Skipped int `json:"skipped"`
+}
+
+// ImportReport 导入的结果，dry-run时为将要导入的内容
+type ImportReport struct {
+	Source   string      `json:"source"`
+	DryRun   bool        `json:"dry_run"`
+	Users    ImportCount `json:"users"`
+	Posts    ImportCount `json:"posts"`
+	Comments ImportCount `json:"comments"`
+	// 新建的标签数量
+	Tags int `json:"tags"`
+	// 不支持导入而忽略的条目数量，如页面、引用通告和空评论
+	Ignored int `json:"ignored"`
+	// 导入时调整或丢弃的内容，如无效的规范地址
+	Warnings []string `json:"warnings"`
+}
+
+type siteImporter struct {
+	site    *importer.Site
+	opts    ImportOptions
+	report  *ImportReport
+	authors map[string]importer.Author
+	// 用户在来源中的标识对应的本地用户，dry-run时新建的用户为0
+	users map[string]uint
+	// 将要新建的标签
+	newTags map[string]bool
+}
+
+// ImportSite 导入从其他博客系统解析的内容。
+//
+// 作者按邮箱对应到已有用户，没有对应的用户时以作者角色创建；评论者是来源中的作者时对应到该作者，
+// 否则按邮箱（没有邮箱时按名称）对应到已有用户或以读者角色创建。新建的用户使用随机密码，可以通过找回密码设置密码。
+// 导入的内容按来源和ID记录，再次导入同一来源时跳过已导入的用户、文章和评论，只导入新增的内容
+func ImportSite(site *importer.Site, opts ImportOptions) (ImportReport, *models.APIError) {
+	report := ImportReport{Source: site.Source, DryRun: opts.DryRun, Ignored: site.Ignored}
+	im := &siteImporter{
+		site:    site,
+		opts:    opts,
+		report:  &report,
+		authors: map[string]importer.Author{},
+		users:   map[string]uint{},
+		newTags: map[string]bool{},
+	}
+	for _, a := range site.Authors {
+		im.authors[a.ID] = a
+	}
+
+	// 先确认全部文章都有作者，避免导入到一半失败
+	var defaultAuthor uint
+	for _, p := range site.Posts {
+		if _, ok := im.authors[p.AuthorID]; ok || defaultAuthor != 0 {
+			continue
+		}
+		if opts.DefaultAuthor == "" {
+			models.Log.Warning("导入的文章没有作者:", p.ID)
+			return report, errImportNoAuthor
+		}
+		var user models.User
+		if err := models.DB.Where("user_name = ?", opts.DefaultAuthor).First(&user).Error; err != nil {
+			models.Log.Warning("默认作者不存在:", opts.DefaultAuthor)
+			return report, models.ErrUserNotFound
+		}
+		defaultAuthor = user.ID
+	}
+
+	// 先处理文章作者，同时作为评论者的作者不会被创建为读者
+	postAuthors := make([]uint, len(site.Posts))
+	for i, p := range site.Posts {
+		postAuthors[i] = defaultAuthor
+		if a, ok := im.authors[p.AuthorID]; ok {
+			id, err := im.user("author:"+a.ID, a, models.RoleAuthor)
+			if err != nil {
+				models.Log.Error("导入用户失败:", err)
+				return report, models.ErrInternalServer
+			}
+			postAuthors[i] = id
+		}
+	}
+	for i, p := range site.Posts {
+		if err := im.importPost(p, postAuthors[i]); err != nil {
+			models.Log.Error("导入文章失败:", p.ID, err)
+			return report, models.ErrInternalServer
+		}
+	}
+	models.Log.Info("导入完成:", report.Source, "新建用户", report.Users.Created, "文章", report.Posts.Created, "评论", report.Comments.Created, "dry-run:", opts.DryRun)
+	return report, nil
+}
+
+func (im *siteImporter) warn(format string, args ...interface{}) {
+	im.report.Warnings = append(im.report.Warnings, fmt.Sprintf(format, args...))
+}
+
+// 记录中的来源和ID，超出长度时使用哈希
+func importKey(s string) string {
+	if len(s) <= maxImportKeyLen {
+		return s
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// 查找已导入内容对应的本地记录
+func (im *siteImporter) imported(kind, externalID string) (uint, bool, error) {
+	var item models.ImportedItem
+	err := models.DB.Where("source = ? AND kind = ? AND external_id = ?", importKey(im.site.Source), kind, importKey(externalID)).
+		First(&item).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return item.LocalID, true, nil
+}
+
+func (im *siteImporter) record(tx *gorm.DB, kind, externalID string, localID uint) error {
+	return tx.Create(&models.ImportedItem{
+		Source:     importKey(im.site.Source),
+		Kind:       kind,
+		ExternalID: importKey(externalID),
+		LocalID:    localID,
+	}).Error
+}
+
+// 来源中的用户对应的本地用户，key为用户在来源中的标识
+func (im *siteImporter) user(key string, a importer.Author, role string) (uint, error) {
+	if id, ok := im.users[key]; ok {
+		return id, nil
+	}
+	id, found, err := im.imported(models.ImportKindUser, key)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		im.report.Users.Skipped++
+		im.users[key] = id
+		return id, nil
+	}
+
+	var user models.User
+	if a.Email != "" {
+		err := models.DB.Where("email = ?", a.Email).First(&user).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return 0, err
+		}
+	}
+	if user.ID != 0 {
+		im.report.Users.Matched++
+	} else {
+		im.report.Users.Created++
+	}
+	if im.opts.DryRun {
+		im.users[key] = user.ID
+		return user.ID, nil
+	}
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if user.ID == 0 {
+			// 名称中没有可以用作用户名的字符时使用邮箱的用户名部分
+			username := a.Username
+			if usernameInvalidChars.ReplaceAllString(username, "") == "" {
+				username = ""
+				if a.Email == "" {
+					username = "user"
+				}
+			}
+			name, err := uniqueUsername(oidcIdentity{Username: username, Email: a.Email})
+			if err != nil {
+				return err
+			}
+			// 随机密码无法用于登录，需要时可以通过找回密码设置
+			user = models.User{
+				UserName:    name,
+				Email:       a.Email,
+				Password:    EncryptPassword(RandomToken(32)),
+				Role:        role,
+				DisplayName: truncateUTF8(strings.TrimSpace(a.DisplayName), 64),
+				Bio:         truncateUTF8(a.Bio, 1024),
+			}
+			if isAbsoluteHTTPURL(a.Website) && len(a.Website) <= 255 {
+				user.Website = a.Website
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		}
+		return im.record(tx, models.ImportKindUser, key, user.ID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	im.users[key] = user.ID
+	return user.ID, nil
+}
+
+// 评论者对应的本地用户
+func (im *siteImporter) commenter(c importer.Comment) (uint, error) {
+	if a, ok := im.authors[c.AuthorID]; ok {
+		return im.user("author:"+a.ID, a, models.RoleAuthor)
+	}
+	name := strings.TrimSpace(c.AuthorName)
+	key := "guest-name:" + name
+	if c.AuthorEmail != "" {
+		key = "guest:" + strings.ToLower(c.AuthorEmail)
+	}
+	return im.user(key, importer.Author{Username: name, Email: c.AuthorEmail, DisplayName: name, Website: c.AuthorURL}, models.RoleReader)
+}
+
+// 文章的标签名称：去掉重复和无效的名称，超出长度的截断，最多保留maxPostTags个
+func (im *siteImporter) tagNames(p importer.Post) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range p.Tags {
+		name = truncateUTF8(strings.TrimSpace(name), maxTagNameLen)
+		slug := TagSlug(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		if len(names) == maxPostTags {
+			im.warn("文章%s的标签超过%d个，多余的标签没有导入", p.ID, maxPostTags)
+			break
+		}
+		seen[slug] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// 统计将要新建的标签
+func (im *siteImporter) countNewTags(names []string) error {
+	for _, name := range names {
+		slug := truncateUTF8(TagSlug(name), 64)
+		if im.newTags[slug] {
+			continue
+		}
+		var count int64
+		if err := models.DB.Model(&models.Tag{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			im.newTags[slug] = true
+			im.report.Tags++
+		}
+	}
+	return nil
+}
+
+// 根据导入的文章生成本地文章，无效的SEO设置不导入
+func (im *siteImporter) newPost(p importer.Post, authorID uint) models.Post {
+	post := models.Post{
+		Title:           strings.TrimSpace(p.Title),
+		Content:         p.Content,
+		UserID:          authorID,
+		Status:          models.PostStatusPublished,
+		MetaDescription: truncateUTF8(strings.TrimSpace(p.Description), maxMetaDescriptionLen),
+	}
+	if post.Title == "" {
+		post.Title = "无标题"
+	}
+	post.CreatedAt = p.CreatedAt
+	post.UpdatedAt = firstNonZeroTime(p.UpdatedAt, p.CreatedAt)
+	if p.Draft {
+		post.Status = models.PostStatusDraft
+	} else {
+		published := firstNonZeroTime(p.PublishedAt, p.CreatedAt, time.Now())
+		post.PublishedAt = &published
+	}
+	if p.CanonicalURL != "" {
+		if post.CanonicalURL = p.CanonicalURL; !validPostSEO(post) {
+			im.warn("文章%s的规范地址无效，没有导入: %s", p.ID, p.CanonicalURL)
+			post.CanonicalURL = ""
+		}
+	}
+	if p.Image != "" {
+		if post.SocialImage = p.Image; !validPostSEO(post) {
+			im.warn("文章%s的分享图片地址无效，没有导入: %s", p.ID, p.Image)
+			post.SocialImage = ""
+		}
+	}
+	return post
+}
+
+// 导入文章及其评论，文章已导入时只导入新增的评论
+func (im *siteImporter) importPost(p importer.Post, authorID uint) error {
+	postID, found, err := im.imported(models.ImportKindPost, p.ID)
+	if err != nil {
+		return err
+	}
+	type pendingComment struct {
+		externalID string
+		comment    models.Comment
+	}
+	var comments []pendingComment
+	for i, c := range p.Comments {
+		externalID := c.ID
+		if externalID == "" {
+			externalID = fmt.Sprintf("%s#%d", p.ID, i)
+		}
+		if found {
+			_, done, err := im.imported(models.ImportKindComment, externalID)
+			if err != nil {
+				return err
+			}
+			if done {
+				im.report.Comments.Skipped++
+				continue
+			}
+		}
+		content := strings.TrimSpace(c.Content)
+		if content == "" {
+			im.report.Ignored++
+			continue
+		}
+		userID, err := im.commenter(c)
+		if err != nil {
+			return err
+		}
+		status := c.Status
+		if status == "" {
+			status = models.CommentStatusApproved
+		}
+		comment := models.Comment{Content: content, UserID: userID, Status: status, ContentHash: ContentHash(content)}
+		comment.CreatedAt = c.CreatedAt
+		comment.UpdatedAt = c.CreatedAt
+		comments = append(comments, pendingComment{externalID, comment})
+		im.report.Comments.Created++
+	}
+
+	var post models.Post
+	var tags []string
+	if found {
+		im.report.Posts.Skipped++
+	} else {
+		im.report.Posts.Created++
+		post = im.newPost(p, authorID)
+		tags = im.tagNames(p)
+		if err := im.countNewTags(tags); err != nil {
+			return err
+		}
+	}
+	if im.opts.DryRun {
+		return nil
+	}
+	if !found {
+		resolved, apiErr := ResolveTags(tags)
+		if apiErr != nil {
+			return fmt.Errorf("%s", apiErr.Message)
+		}
+		post.Tags = resolved
+	}
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		if !found {
+			if err := tx.Create(&post).Error; err != nil {
+				return err
+			}
+			if err := syncPostMedia(tx, post.ID, post.Content, post.SocialImage); err != nil {
+				return err
+			}
+			if err := im.record(tx, models.ImportKindPost, p.ID, post.ID); err != nil {
+				return err
+			}
+			postID = post.ID
+		}
+		for _, c := range comments {
+			c.comment.PostID = postID
+			if err := tx.Create(&c.comment).Error; err != nil {
+				return err
+			}
+			if err := im.record(tx, models.ImportKindComment, c.externalID, c.comment.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func firstNonZeroTime(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/importer"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 测试用的WordPress导出文件，extra为true时包含之后新增的文章和评论
+func testWXR(t *testing.T, extra bool) *importer.Site {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+<link>https://old.example.com</link>
+<wp:author><wp:author_id>1</wp:author_id><wp:author_login>writer</wp:author_login><wp:author_email>writer@example.com</wp:author_email><wp:author_display_name>Writer</wp:author_display_name></wp:author>
+<item>
+<title>第一篇文章</title><dc:creator>writer</dc:creator>
+<content:encoded><![CDATA[<p>Hello <strong>world</strong></p>]]></content:encoded>
+<wp:post_id>10</wp:post_id><wp:post_date_gmt>2020-01-02 03:04:05</wp:post_date_gmt><wp:status>publish</wp:status><wp:post_type>post</wp:post_type>
+<category domain="post_tag" nicename="go"><![CDATA[Go]]></category>
+<wp:comment><wp:comment_id>100</wp:comment_id><wp:comment_author>Writer</wp:comment_author><wp:comment_content>作者的回复</wp:comment_content><wp:comment_approved>1</wp:comment_approved><wp:user_id>1</wp:user_id></wp:comment>
+<wp:comment><wp:comment_id>101</wp:comment_id><wp:comment_author>Guest</wp:comment_author><wp:comment_author_email>guest@example.com</wp:comment_author_email><wp:comment_content>访客的评论</wp:comment_content><wp:comment_approved>0</wp:comment_approved></wp:comment>
+<wp:comment><wp:comment_id>102</wp:comment_id><wp:comment_author>Ping</wp:comment_author><wp:comment_content>pingback</wp:comment_content><wp:comment_approved>1</wp:comment_approved><wp:comment_type>pingback</wp:comment_type></wp:comment>`)
+	if extra {
+		b.WriteString(`
+<wp:comment><wp:comment_id>103</wp:comment_id><wp:comment_author>Guest</wp:comment_author><wp:comment_author_email>GUEST@example.com</wp:comment_author_email><wp:comment_content>新的评论</wp:comment_content><wp:comment_approved>1</wp:comment_approved></wp:comment>`)
+	}
+	b.WriteString(`
+</item>
+<item><title>关于</title><wp:post_id>11</wp:post_id><wp:status>publish</wp:status><wp:post_type>page</wp:post_type></item>`)
+	if extra {
+		b.WriteString(`
+<item><title>第二篇文章</title><dc:creator>writer</dc:creator><content:encoded>草稿</content:encoded><wp:post_id>12</wp:post_id><wp:status>draft</wp:status><wp:post_type>post</wp:post_type>
+<category domain="post_tag" nicename="go"><![CDATA[Go]]></category></item>`)
+	}
+	b.WriteString(`
+</channel>
+</rss>`)
+	site, err := importer.ParseWXR(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return site
+}
+
+// 各表的记录数量
+func importCounts(t *testing.T) [4]int64 {
+	t.Helper()
+	var counts [4]int64
+	for i, model := range []interface{}{&models.User{}, &models.Post{}, &models.Comment{}, &models.Tag{}} {
+		if err := models.DB.Model(model).Count(&counts[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return counts
+}
+
+func TestImportSiteRerun(t *testing.T) {
+	setupTestDB(t)
+
+	report, apiErr := ImportSite(testWXR(t, false), ImportOptions{})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if report.Source != "wordpress:https://old.example.com" || report.Users.Created != 2 || report.Posts.Created != 1 ||
+		report.Comments.Created != 2 || report.Tags != 1 || report.Ignored != 2 {
+		t.Fatalf("首次导入 = %+v", report)
+	}
+	first := importCounts(t)
+	if first != [4]int64{2, 1, 2, 1} {
+		t.Fatalf("首次导入后的记录数量 = %v", first)
+	}
+	var writer models.User
+	models.DB.Where("email = ?", "writer@example.com").First(&writer)
+	if writer.UserName != "writer" || writer.Role != models.RoleAuthor || writer.DisplayName != "Writer" {
+		t.Fatalf("导入的作者 = %+v", writer)
+	}
+	var post models.Post
+	models.DB.Preload("Tags").First(&post)
+	if post.Title != "第一篇文章" || post.Content != "Hello **world**" || post.UserID != writer.ID || len(post.Tags) != 1 {
+		t.Fatalf("导入的文章 = %+v", post)
+	}
+
+	// 再次导入同一文件时全部跳过
+	report, apiErr = ImportSite(testWXR(t, false), ImportOptions{})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if report.Users.Created != 0 || report.Posts.Created != 0 || report.Comments.Created != 0 || report.Tags != 0 ||
+		report.Posts.Skipped != 1 || report.Comments.Skipped != 2 {
+		t.Fatalf("再次导入 = %+v", report)
+	}
+	if counts := importCounts(t); counts != first {
+		t.Fatalf("再次导入后的记录数量 = %v", counts)
+	}
+
+	// 来源中新增的文章和评论在之后的导入中补充，邮箱不区分大小写对应到已导入的访客
+	report, apiErr = ImportSite(testWXR(t, true), ImportOptions{})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if report.Users.Created != 0 || report.Posts.Created != 1 || report.Posts.Skipped != 1 ||
+		report.Comments.Created != 1 || report.Comments.Skipped != 2 || report.Tags != 0 {
+		t.Fatalf("导入新增内容 = %+v", report)
+	}
+	if counts := importCounts(t); counts != [4]int64{2, 2, 3, 1} {
+		t.Fatalf("导入新增内容后的记录数量 = %v", counts)
+	}
+	var draft models.Post
+	models.DB.Where("title = ?", "第二篇文章").First(&draft)
+	if draft.Status != models.PostStatusDraft || draft.PublishedAt != nil {
+		t.Fatalf("导入的草稿 = %+v", draft)
+	}
+}
+
+func TestImportSiteDryRun(t *testing.T) {
+	setupTestDB(t)
+	existing := createTestUser(t, "alice", models.RoleEditor)
+	models.DB.Model(&existing).Update("email", "writer@example.com")
+
+	report, apiErr := ImportSite(testWXR(t, true), ImportOptions{DryRun: true})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if !report.DryRun || report.Users.Matched != 1 || report.Users.Created != 1 || report.Posts.Created != 2 ||
+		report.Comments.Created != 3 || report.Tags != 1 {
+		t.Fatalf("dry-run = %+v", report)
+	}
+	if counts := importCounts(t); counts != [4]int64{1, 0, 0, 0} {
+		t.Fatalf("dry-run写入了数据库: %v", counts)
+	}
+
+	// 实际导入的结果与dry-run一致，作者对应到邮箱相同的已有用户
+	imported, apiErr := ImportSite(testWXR(t, true), ImportOptions{})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	imported.DryRun = false
+	report.DryRun = false
+	if imported.Users != report.Users || imported.Posts != report.Posts || imported.Comments != report.Comments || imported.Tags != report.Tags {
+		t.Fatalf("导入结果 = %+v，dry-run = %+v", imported, report)
+	}
+	var count int64
+	models.DB.Model(&models.Post{}).Where("user_id = ?", existing.ID).Count(&count)
+	if count != 2 {
+		t.Fatalf("已有用户发布了%d篇导入的文章", count)
+	}
+	models.DB.First(&existing, existing.ID)
+	if existing.Role != models.RoleEditor {
+		t.Fatal("导入修改了已有用户的角色")
+	}
+}
+
+func TestImportSiteDefaultAuthor(t *testing.T) {
+	setupTestDB(t)
+	site := &importer.Site{Source: "markdown", Posts: []importer.Post{{ID: "hello.md", Title: "Hello", Content: "内容"}}}
+
+	if _, apiErr := ImportSite(site, ImportOptions{}); apiErr != errImportNoAuthor {
+		t.Fatalf("没有作者 = %v", apiErr)
+	}
+	if _, apiErr := ImportSite(site, ImportOptions{DefaultAuthor: "nobody"}); apiErr != models.ErrUserNotFound {
+		t.Fatalf("默认作者不存在 = %v", apiErr)
+	}
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	for i := 0; i < 2; i++ {
+		if _, apiErr := ImportSite(site, ImportOptions{DefaultAuthor: "alice"}); apiErr != nil {
+			t.Fatal(apiErr)
+		}
+	}
+	var posts []models.Post
+	models.DB.Find(&posts)
+	if len(posts) != 1 || posts[0].UserID != user.ID {
+		t.Fatalf("导入的文章 = %+v", posts)
+	}
+}
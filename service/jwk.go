@@ -0,0 +1,357 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 缓存的密钥多久从数据库重新加载一次，多实例部署时用于同步轮换结果
+const keyReloadInterval = time.Minute
+
+// 已解析的签名密钥
+type signingKey struct {
+	Kid        string
+	Algorithm  string
+	PrivateKey interface{}
+	PublicKey  interface{}
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+}
+
+// 内存中的密钥缓存，按创建时间从新到旧排列
+var keyStore struct {
+	sync.RWMutex
+	keys     []signingKey
+	loadedAt time.Time
+}
+
+// 当前配置的签名算法
+func jwtAlgorithm() string {
+	switch config.Conf.JWT.Algorithm {
+	case "HS256", "EdDSA":
+		return config.Conf.JWT.Algorithm
+	}
+	return "RS256"
+}
+
+func jwtSigningMethod() jwt.SigningMethod {
+	switch jwtAlgorithm() {
+	case "HS256":
+		return jwt.SigningMethodHS256
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// 旧密钥的宽限期，至少覆盖访问令牌的有效期
+func keyGracePeriod() time.Duration {
+	grace := time.Duration(config.Conf.JWT.GracePeriod) * time.Hour
+	if grace < accessTTL() {
+		grace = accessTTL()
+	}
+	return grace
+}
+
+// 生成指定算法的密钥对
+func generateKeyPair(algorithm string) (interface{}, interface{}, error) {
+	if algorithm == "EdDSA" {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, public, err
+	}
+	bits := config.Conf.JWT.RSABits
+	if bits < 2048 {
+		bits = 2048
+	}
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return private, &private.PublicKey, nil
+}
+
+// 将数据库记录解析为签名密钥
+func parseSigningKey(record models.SigningKey) (signingKey, error) {
+	key := signingKey{
+		Kid:       record.Kid,
+		Algorithm: record.Algorithm,
+		CreatedAt: record.CreatedAt,
+		ExpiresAt: record.ExpiresAt,
+	}
+	block, _ := pem.Decode([]byte(record.PrivateKey))
+	if block == nil {
+		return key, fmt.Errorf("私钥格式错误: %s", record.Kid)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return key, err
+	}
+	block, _ = pem.Decode([]byte(record.PublicKey))
+	if block == nil {
+		return key, fmt.Errorf("公钥格式错误: %s", record.Kid)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return key, err
+	}
+	key.PrivateKey = private
+	key.PublicKey = public
+	return key, nil
+}
+
+// 从数据库加载当前算法下未过期的密钥
+func loadSigningKeys() error {
+	var records []models.SigningKey
+	err := models.DB.Where("algorithm = ? AND (expires_at IS NULL OR expires_at > ?)", jwtAlgorithm(), time.Now()).
+		Order("created_at DESC").Find(&records).Error
+	if err != nil {
+		return err
+	}
+	keys := make([]signingKey, 0, len(records))
+	for _, record := range records {
+		key, err := parseSigningKey(record)
+		if err != nil {
+			models.Log.Error("解析签名密钥失败:", record.Kid, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	keyStore.Lock()
+	keyStore.keys = keys
+	keyStore.loadedAt = time.Now()
+	keyStore.Unlock()
+	return nil
+}
+
+// 获取缓存的密钥，缓存过期时重新加载
+func cachedSigningKeys(forceReload bool) ([]signingKey, error) {
+	keyStore.RLock()
+	keys, loadedAt := keyStore.keys, keyStore.loadedAt
+	keyStore.RUnlock()
+	if forceReload || time.Since(loadedAt) > keyReloadInterval {
+		if err := loadSigningKeys(); err != nil {
+			return nil, err
+		}
+		keyStore.RLock()
+		keys = keyStore.keys
+		keyStore.RUnlock()
+	}
+	return keys, nil
+}
+
+// 同一实例中的密钥轮换依次进行，多个实例之间通过锁定数据库中的当前密钥同步
+var rotateMu sync.Mutex
+
+// RotateSigningKey 生成新的签名密钥，之前仍在使用的密钥进入宽限期
+func RotateSigningKey() error {
+	return rotateSigningKey(func(active *models.SigningKey) bool { return true })
+}
+
+// 在持有锁的情况下重新读取当前的签名密钥，due返回true时才生成新密钥，
+// 避免并发请求或多个实例各自生成密钥并让对方的密钥提前进入宽限期
+func rotateSigningKey(due func(active *models.SigningKey) bool) error {
+	algorithm := jwtAlgorithm()
+	if algorithm == "HS256" {
+		return fmt.Errorf("HS256使用配置中的共享密钥，无需轮换")
+	}
+	rotateMu.Lock()
+	defer rotateMu.Unlock()
+	var record models.SigningKey
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		var active []models.SigningKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("algorithm = ? AND expires_at IS NULL", algorithm).
+			Order("created_at DESC").Find(&active).Error
+		if err != nil {
+			return err
+		}
+		var current *models.SigningKey
+		if len(active) > 0 {
+			current = &active[0]
+		}
+		if !due(current) {
+			return nil
+		}
+		private, public, err := generateKeyPair(algorithm)
+		if err != nil {
+			return err
+		}
+		privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return err
+		}
+		publicDER, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return err
+		}
+		record = models.SigningKey{
+			Kid:        RandomToken(12),
+			Algorithm:  algorithm,
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})),
+			PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.SigningKey{}).
+			Where("id <> ? AND expires_at IS NULL", record.ID).
+			Update("expires_at", time.Now().Add(keyGracePeriod())).Error
+	})
+	if err != nil {
+		return err
+	}
+	if record.ID != 0 {
+		models.Log.Info("签名密钥已轮换:", record.Kid, algorithm)
+	}
+	return loadSigningKeys()
+}
+
+// 从密钥列表中找出当前用于签名的密钥
+func activeSigningKey(keys []signingKey) (signingKey, bool) {
+	for _, key := range keys {
+		if key.ExpiresAt == nil {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+// 获取当前用于签名的密钥，没有可用密钥时自动生成
+func currentSigningKey() (signingKey, error) {
+	keys, err := cachedSigningKeys(false)
+	if err != nil {
+		return signingKey{}, err
+	}
+	if key, ok := activeSigningKey(keys); ok {
+		return key, nil
+	}
+	// 其他请求或实例可能已经生成了密钥，加锁后只在仍然没有密钥时生成
+	if err := rotateSigningKey(func(active *models.SigningKey) bool { return active == nil }); err != nil {
+		return signingKey{}, err
+	}
+	keys, err = cachedSigningKeys(false)
+	if err != nil {
+		return signingKey{}, err
+	}
+	if key, ok := activeSigningKey(keys); ok {
+		return key, nil
+	}
+	return signingKey{}, fmt.Errorf("没有可用的签名密钥")
+}
+
+// 根据kid查找验证密钥，找不到时重新加载一次，以便识别其他实例新生成的密钥
+func verificationKey(kid string) (interface{}, error) {
+	for _, reload := range []bool{false, true} {
+		keys, err := cachedSigningKeys(reload)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if key.Kid == kid && (key.ExpiresAt == nil || time.Now().Before(*key.ExpiresAt)) {
+				return key.PublicKey, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+}
+
+// 签名JWT，非对称算法在头部写入kid
+func signJWT(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwtSigningMethod(), claims)
+	if jwtAlgorithm() == "HS256" {
+		return token.SignedString([]byte(config.Conf.JWT.Secret))
+	}
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.PrivateKey)
+}
+
+// 解析JWT时使用的密钥查找函数
+func jwtKeyFunc(token *jwt.Token) (interface{}, error) {
+	if jwtAlgorithm() == "HS256" {
+		return []byte(config.Conf.JWT.Secret), nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("缺少kid")
+	}
+	return verificationKey(kid)
+}
+
+// StartKeyRotation 启动后台任务，定期检查签名密钥是否需要轮换
+func StartKeyRotation() {
+	if jwtAlgorithm() == "HS256" {
+		return
+	}
+	check := func() {
+		interval := time.Duration(config.Conf.JWT.RotationInterval) * time.Hour
+		err := rotateSigningKey(func(active *models.SigningKey) bool {
+			return active == nil || (interval > 0 && time.Since(active.CreatedAt) >= interval)
+		})
+		if err != nil {
+			models.Log.Error("轮换签名密钥失败:", err)
+		}
+	}
+	check()
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			check()
+		}
+	}()
+}
+
+// 大整数的Base64URL编码
+func base64URLUint(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// JWKS 返回当前可用于验证的公钥集合，HS256模式下为空
+func JWKS() (map[string]interface{}, error) {
+	keys := []map[string]interface{}{}
+	if jwtAlgorithm() != "HS256" {
+		cached, err := cachedSigningKeys(false)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range cached {
+			jwk := map[string]interface{}{
+				"kid": key.Kid,
+				"use": "sig",
+				"alg": key.Algorithm,
+			}
+			switch public := key.PublicKey.(type) {
+			case *rsa.PublicKey:
+				jwk["kty"] = "RSA"
+				jwk["n"] = base64URLUint(public.N)
+				jwk["e"] = base64URLUint(big.NewInt(int64(public.E)))
+			case ed25519.PublicKey:
+				jwk["kty"] = "OKP"
+				jwk["crv"] = "Ed25519"
+				jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+			default:
+				continue
+			}
+			keys = append(keys, jwk)
+		}
+	}
+	return map[string]interface{}{"keys": keys}, nil
+}
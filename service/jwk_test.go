@@ -0,0 +1,130 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 在测试中临时使用指定的签名算法，EdDSA密钥的生成速度较快
+func useJWTAlgorithm(t *testing.T, algorithm string) {
+	t.Helper()
+	old := config.Conf.JWT.Algorithm
+	config.Conf.JWT.Algorithm = algorithm
+	t.Cleanup(func() { config.Conf.JWT.Algorithm = old })
+}
+
+// JWKS中的kid
+func jwksKids(t *testing.T) map[string]bool {
+	t.Helper()
+	set, err := JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kids := map[string]bool{}
+	for _, key := range set["keys"].([]map[string]interface{}) {
+		kids[key["kid"].(string)] = true
+	}
+	return kids
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			setupTestDB(t)
+			useJWTAlgorithm(t, algorithm)
+			oldToken, err := GenerateJWT(1, "alice", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			oldKey, err := currentSigningKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := RotateSigningKey(); err != nil {
+				t.Fatal(err)
+			}
+			newKey, _ := currentSigningKey()
+			if newKey.Kid == oldKey.Kid {
+				t.Fatal("轮换后应使用新的密钥签名")
+			}
+			newToken, _ := GenerateJWT(1, "alice", "")
+			// 宽限期内旧密钥签名的令牌仍然有效，JWKS中同时包含新旧密钥
+			for _, token := range []string{oldToken, newToken} {
+				if _, err := ParseJWT(token); err != nil {
+					t.Fatal("令牌验证失败:", err)
+				}
+			}
+			if kids := jwksKids(t); !kids[oldKey.Kid] || !kids[newKey.Kid] {
+				t.Fatal("JWKS中应包含新旧两个密钥:", kids)
+			}
+
+			// 宽限期结束后旧密钥不再用于验证，也不再出现在JWKS中
+			models.DB.Model(&models.SigningKey{}).Where("kid = ?", oldKey.Kid).Update("expires_at", time.Now().Add(-time.Second))
+			if err := loadSigningKeys(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ParseJWT(oldToken); err == nil {
+				t.Fatal("过期密钥签名的令牌应该无效")
+			}
+			if kids := jwksKids(t); kids[oldKey.Kid] || !kids[newKey.Kid] {
+				t.Fatal("JWKS中应只包含新密钥:", kids)
+			}
+		})
+	}
+}
+
+func TestScheduledRotationOnlyWhenDue(t *testing.T) {
+	setupTestDB(t)
+	useJWTAlgorithm(t, "EdDSA")
+	key, _ := currentSigningKey()
+	interval := time.Hour
+	due := func(active *models.SigningKey) bool {
+		return active == nil || time.Since(active.CreatedAt) >= interval
+	}
+	if err := rotateSigningKey(due); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := currentSigningKey(); current.Kid != key.Kid {
+		t.Fatal("未到轮换时间时不应生成新密钥")
+	}
+	models.DB.Model(&models.SigningKey{}).Where("kid = ?", key.Kid).Update("created_at", time.Now().Add(-2*interval))
+	if err := rotateSigningKey(due); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := currentSigningKey(); current.Kid == key.Kid {
+		t.Fatal("到达轮换时间后应生成新密钥")
+	}
+}
+
+func TestConcurrentSigningKeyCreation(t *testing.T) {
+	setupTestDB(t)
+	useJWTAlgorithm(t, "EdDSA")
+	start := make(chan struct{})
+	tokens := make([]string, 10)
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			tokens[i], _ = GenerateJWT(1, "alice", "")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	var keys int64
+	models.DB.Model(&models.SigningKey{}).Count(&keys)
+	if keys != 1 {
+		t.Fatalf("并发签名时生成了%d个密钥", keys)
+	}
+	for _, token := range tokens {
+		if _, err := ParseJWT(token); err != nil {
+			t.Fatal("令牌验证失败:", err)
+		}
+	}
+}
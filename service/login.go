@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 用户不存在时用于比较的密码哈希，使响应时间与用户存在时一致，避免通过耗时判断用户名是否注册
+var dummyPasswordHash = sync.OnceValue(func() string {
+	return EncryptPassword(RandomToken(16))
+})
+
+// 登录失败统计使用的用户名，不区分大小写
+func loginKey(username string) string {
+	return truncateUTF8(strings.ToLower(strings.TrimSpace(username)), 64)
+}
+
+// 统计指定时间之后的登录失败次数和最后一次失败的时间
+func countLoginFailures(query *gorm.DB, since time.Time) (int64, time.Time, error) {
+	var count int64
+	query = query.Where("result = ? AND created_at > ?", models.LoginResultFailed, since)
+	if err := query.Session(&gorm.Session{}).Model(&models.LoginAttempt{}).Count(&count).Error; err != nil {
+		return 0, time.Time{}, err
+	}
+	if count == 0 {
+		return 0, time.Time{}, nil
+	}
+	var last models.LoginAttempt
+	if err := query.Session(&gorm.Session{}).Order("created_at DESC").First(&last).Error; err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, last.CreatedAt, nil
+}
+
+// 根据失败次数计算还需要等待的时间，locked表示处于锁定期
+func loginWait(failures int64, last time.Time, backoffAfter, lockoutThreshold int) (wait time.Duration, locked bool) {
+	conf := config.Conf.Login
+	if lockoutThreshold > 0 && failures >= int64(lockoutThreshold) {
+		// 锁定期结束后允许再次尝试，再次失败会重新锁定
+		wait = time.Until(last.Add(time.Duration(conf.LockoutDuration) * time.Minute))
+		return wait, wait > 0
+	}
+	if backoffAfter > 0 && failures >= int64(backoffAfter) {
+		// 每多失败一次等待时间翻倍
+		exp := math.Min(float64(failures)-float64(backoffAfter), 30)
+		delay := time.Duration(float64(conf.BackoffBase)*math.Pow(2, exp)) * time.Second
+		if limit := time.Duration(conf.BackoffMax) * time.Second; delay > limit {
+			delay = limit
+		}
+		return time.Until(last.Add(delay)), false
+	}
+	return 0, false
+}
+
+// 生成带有等待时间的错误
+func loginThrottleError(base *models.APIError, wait time.Duration) *models.APIError {
+	return &models.APIError{
+		Code:    base.Code,
+		Message: base.Message,
+		Details: fmt.Sprintf("%s，请在%d秒后重试", base.Message, int(math.Ceil(wait.Seconds()))),
+	}
+}
+
+// 检查账号和IP是否处于退避或锁定期，用户不存在的账号同样统计，避免通过锁定行为判断用户名是否注册
+func checkLoginAllowed(key, ip string) *models.APIError {
+	conf := config.Conf.Login
+	windowStart := time.Now().Add(-time.Duration(conf.Window) * time.Minute)
+
+	// 账号的失败次数从最近一次登录成功或解锁后开始计算
+	since := windowStart
+	var reset models.LoginAttempt
+	err := models.DB.Where("user_name = ? AND result IN ?", key, []string{models.LoginResultSuccess, models.LoginResultUnlocked}).
+		Order("created_at DESC").First(&reset).Error
+	if err == nil && reset.CreatedAt.After(since) {
+		since = reset.CreatedAt
+	} else if err != nil && err != gorm.ErrRecordNotFound {
+		models.Log.Error("查询登录记录失败:", err)
+		return models.ErrInternalServer
+	}
+	failures, last, err := countLoginFailures(models.DB.Where("user_name = ?", key), since)
+	if err != nil {
+		models.Log.Error("统计登录失败次数失败:", err)
+		return models.ErrInternalServer
+	}
+	if wait, locked := loginWait(failures, last, conf.BackoffAfter, conf.LockoutThreshold); locked {
+		models.Log.Warning("账号已锁定:", key, "IP:", ip)
+		return loginThrottleError(models.ErrAccountLocked, wait)
+	} else if wait > 0 {
+		models.Log.Warning("账号登录退避中:", key, "IP:", ip)
+		return loginThrottleError(models.ErrTooManyAttempts, wait)
+	}
+
+	failures, last, err = countLoginFailures(models.DB.Where("ip = ?", ip), windowStart)
+	if err != nil {
+		models.Log.Error("统计登录失败次数失败:", err)
+		return models.ErrInternalServer
+	}
+	if wait, _ := loginWait(failures, last, conf.IPBackoffAfter, conf.IPLockoutThreshold); wait > 0 {
+		models.Log.Warning("IP登录退避中:", ip)
+		return loginThrottleError(models.ErrTooManyAttempts, wait)
+	}
+	return nil
+}
+
+// 保存登录记录
+func recordLoginAttempt(userID uint, key, ip, userAgent, result string) {
+	attempt := models.LoginAttempt{
+		UserID:    userID,
+		UserName:  key,
+		IP:        ip,
+		UserAgent: truncateUTF8(userAgent, 255),
+		Result:    result,
+		Success:   result == models.LoginResultSuccess,
+	}
+	if err := models.DB.Create(&attempt).Error; err != nil {
+		models.Log.Error("保存登录记录失败:", err)
+	}
+	// 登录成功时顺便清理过期的登录记录
+	if attempt.Success && config.Conf.Login.HistoryDays > 0 {
+		expired := time.Now().AddDate(0, 0, -config.Conf.Login.HistoryDays)
+		if err := models.DB.Where("created_at < ?", expired).Delete(&models.LoginAttempt{}).Error; err != nil {
+			models.Log.Error("清理登录记录失败:", err)
+		}
+	}
+}
+
+// GetLoginHistory 获取用户的登录历史，包括使用该账号登录失败的记录
+func GetLoginHistory(userID uint, limit int) ([]models.LoginAttempt, *models.APIError) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	var attempts []models.LoginAttempt
+	err := models.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&attempts).Error
+	if err != nil {
+		models.Log.Error("获取登录历史失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return attempts, nil
+}
+
+// UnlockUser 解除账号的登录锁定，之前的失败次数不再计算
+func UnlockUser(operatorID uint, userID uint) *models.APIError {
+	var user models.User
+	if err := models.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("用户不存在:", userID)
+			return models.ErrUserNotFound
+		}
+		models.Log.Error("查询用户失败:", err)
+		return models.ErrInternalServer
+	}
+	err := models.DB.Create(&models.LoginAttempt{
+		UserID:   user.ID,
+		UserName: loginKey(user.UserName),
+		Result:   models.LoginResultUnlocked,
+	}).Error
+	if err != nil {
+		models.Log.Error("解锁账号失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("账号已解锁:", user.UserName, "操作人:", operatorID)
+	return nil
+}
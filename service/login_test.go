@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+func TestLoginWait(t *testing.T) {
+	conf := config.Conf.Login
+	now := time.Now()
+	tests := []struct {
+		name     string
+		failures int64
+		want     time.Duration
+		locked   bool
+	}{
+		{name: "未达到退避次数", failures: 2, want: 0},
+		{name: "开始退避", failures: 3, want: time.Duration(conf.BackoffBase) * time.Second},
+		{name: "等待时间翻倍", failures: 5, want: time.Duration(conf.BackoffBase) * 4 * time.Second},
+		{name: "不超过最长等待时间", failures: 15, want: time.Duration(conf.BackoffMax) * time.Second},
+		{name: "达到锁定次数", failures: 20, want: time.Duration(conf.LockoutDuration) * time.Minute, locked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, locked := loginWait(tt.failures, now, 3, 20)
+			if locked != tt.locked {
+				t.Fatalf("locked为%v，应为%v", locked, tt.locked)
+			}
+			// wait根据当前时间计算，允许少量误差
+			if diff := tt.want - wait; diff < 0 || diff > time.Second {
+				t.Fatalf("等待%v，应为%v", wait, tt.want)
+			}
+		})
+	}
+	// 锁定期结束后允许再次尝试
+	if wait, locked := loginWait(20, now.Add(-time.Duration(conf.LockoutDuration+1)*time.Minute), 3, 20); locked || wait > 0 {
+		t.Fatal("锁定期结束后应允许登录")
+	}
+}
+
+// 插入登录失败记录
+func addLoginFailures(t *testing.T, username, ip string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		recordLoginAttempt(0, loginKey(username), ip, "test", models.LoginResultFailed)
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	setupTestDB(t)
+	createTestUser(t, "alice", models.RoleAuthor)
+	for i := 0; i < config.Conf.Login.BackoffAfter; i++ {
+		if _, apiErr := LoginUser("alice", "wrong-password", "10.0.0.1", "test"); apiErr != models.ErrInvalidCredentials {
+			t.Fatalf("第%d次密码错误返回了%v", i+1, apiErr)
+		}
+	}
+	// 退避期间即使密码正确也不能登录
+	if _, apiErr := LoginUser("Alice", "alice-password", "10.0.0.2", "test"); apiErr == nil || apiErr.Message != models.ErrTooManyAttempts.Message {
+		t.Fatal("连续失败后应要求等待:", apiErr)
+	}
+	// 不存在的用户名同样统计，避免通过退避行为判断用户名是否注册
+	addLoginFailures(t, "nobody", "10.0.0.3", config.Conf.Login.BackoffAfter)
+	if _, apiErr := LoginUser("nobody", "whatever", "10.0.0.3", "test"); apiErr == nil || apiErr.Message != models.ErrTooManyAttempts.Message {
+		t.Fatal("不存在的用户名也应退避:", apiErr)
+	}
+}
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	alice := createTestUser(t, "alice", models.RoleAuthor)
+	addLoginFailures(t, "alice", "10.0.0.1", config.Conf.Login.LockoutThreshold)
+	if _, apiErr := LoginUser("alice", "alice-password", "10.0.0.2", "test"); apiErr == nil || apiErr.Message != models.ErrAccountLocked.Message {
+		t.Fatal("失败次数过多时账号应被锁定:", apiErr)
+	}
+	var blocked int64
+	models.DB.Model(&models.LoginAttempt{}).Where("user_id = ? AND result = ?", alice.ID, models.LoginResultBlocked).Count(&blocked)
+	if blocked != 1 {
+		t.Fatal("被拒绝的登录应记录在登录历史中")
+	}
+
+	if apiErr := UnlockUser(admin.ID, alice.ID); apiErr != nil {
+		t.Fatal(apiErr.Message)
+	}
+	if _, apiErr := LoginUser("alice", "alice-password", "10.0.0.2", "test"); apiErr != nil {
+		t.Fatal("解锁后应可以登录:", apiErr.Message)
+	}
+}
+
+func TestLoginIPBackoff(t *testing.T) {
+	setupTestDB(t)
+	createTestUser(t, "alice", models.RoleAuthor)
+	// 同一IP尝试不同的用户名
+	for i := 0; i < config.Conf.Login.IPBackoffAfter; i++ {
+		addLoginFailures(t, fmt.Sprintf("user%d", i), "10.0.0.1", 1)
+	}
+	if _, apiErr := LoginUser("alice", "alice-password", "10.0.0.1", "test"); apiErr == nil || apiErr.Message != models.ErrTooManyAttempts.Message {
+		t.Fatal("同一IP连续失败后应要求等待:", apiErr)
+	}
+	if _, apiErr := LoginUser("alice", "alice-password", "10.0.0.2", "test"); apiErr != nil {
+		t.Fatal("其他IP不受影响:", apiErr.Message)
+	}
+}
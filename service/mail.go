@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// MailMessage 待发送的邮件，正文为纯文本
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 邮件发送接口
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+// 当前使用的邮件发送器
+var mailer Mailer
+
+func init() {
+	switch config.Conf.Mail.Transport {
+	case "smtp":
+		mailer = &SMTPMailer{Config: config.Conf.Mail.SMTP, From: config.Conf.Mail.From}
+	case "memory":
+		mailer = &MemoryMailer{}
+	default:
+		mailer = &FileMailer{Dir: config.Conf.Mail.FileDir, From: config.Conf.Mail.From}
+	}
+}
+
+// SetMailer 替换邮件发送器，用于测试或自定义发送方式
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// GetMailer 获取当前的邮件发送器
+func GetMailer() Mailer {
+	return mailer
+}
+
+// SendMail 使用当前的邮件发送器发送邮件
+func SendMail(msg MailMessage) error {
+	if err := mailer.Send(msg); err != nil {
+		models.Log.Error("发送邮件失败:", msg.To, msg.Subject, err)
+		return err
+	}
+	models.Log.Info("邮件已发送:", msg.To, msg.Subject)
+	return nil
+}
+
+// 生成符合RFC 5322的邮件内容
+func buildMail(from string, msg MailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	headers := []struct{ key, value string }{
+		{"From", from},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", RandomToken(16), mailDomain(from))},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=UTF-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(msg.Body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 从发件人地址中取出域名，用于生成Message-ID
+func mailDomain(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			return addr.Address[at+1:]
+		}
+	}
+	return "localhost"
+}
+
+// SMTPMailer 通过SMTP服务器发送邮件，465端口使用TLS直连，其他端口在服务器支持时使用STARTTLS
+type SMTPMailer struct {
+	Config config.SMTPConfig
+	From   string
+}
+
+func (m *SMTPMailer) Send(msg MailMessage) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	data, err := buildMail(m.From, msg)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.Config.Host, strconv.Itoa(m.Config.Port))
+	var auth smtp.Auth
+	if m.Config.Username != "" {
+		auth = smtp.PlainAuth("", m.Config.Username, m.Config.Password, m.Config.Host)
+	}
+	if m.Config.Port != 465 {
+		return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, data)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: m.Config.Host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.Config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileMailer 将邮件写入目录中的.eml文件，不实际发送
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(msg MailMessage) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	data, err := buildMail(m.From, msg)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), RandomToken(6))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0644)
+}
+
+// MemoryMailer 将邮件保存在内存中，用于测试
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []MailMessage
+}
+
+func (m *MemoryMailer) Send(msg MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages 返回已发送的邮件
+func (m *MemoryMailer) Messages() []MailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MailMessage(nil), m.messages...)
+}
+
+// Last 返回最后一封邮件，没有邮件时ok为false
+func (m *MemoryMailer) Last() (MailMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		return MailMessage{}, false
+	}
+	return m.messages[len(m.messages)-1], true
+}
+
+// Reset 清空已发送的邮件
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/dbtest"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 测试中发送的邮件
+var testMailer = &MemoryMailer{}
+
+func TestMain(m *testing.M) {
+	SetMailer(testMailer)
+	// 降低argon2id的参数，加快测试中的密码哈希
+	config.Conf.Password.Argon2Memory = 1024
+	config.Conf.Password.Argon2Time = 1
+	os.Exit(m.Run())
+}
+
+// 使用临时数据库运行测试
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dbtest.Open(t)
+	testMailer.Reset()
+	// 缓存的签名密钥属于之前的数据库
+	keyStore.Lock()
+	keyStore.keys = nil
+	keyStore.loadedAt = time.Time{}
+	keyStore.Unlock()
+}
+
+// 创建测试用户，密码为 用户名+"-password"
+func createTestUser(t *testing.T, name, role string) models.User {
+	t.Helper()
+	user := models.User{
+		UserName:      name,
+		Email:         name + "@example.com",
+		Password:      EncryptPassword(name + "-password"),
+		Role:          role,
+		EmailVerified: true,
+	}
+	if err := models.DB.Create(&user).Error; err != nil {
+		t.Fatal("创建测试用户失败:", err)
+	}
+	return user
+}
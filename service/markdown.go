@@ -0,0 +1,216 @@
+package service
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 支持的Markdown语法：标题、段落、引用、有序和无序列表、代码块、分隔线，以及行内代码、粗体、斜体、删除线、链接和图片。
+// 文章中的HTML一律转义，链接只允许http、https、mailto和站内地址
+
+var (
+	mdHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRule        = regexp.MustCompile(`^ {0,3}(?:(?:\* *){3,}|(?:- *){3,}|(?:_ *){3,})$`)
+	mdFence       = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*([\\w+#.-]*)")
+	mdUnordered   = regexp.MustCompile(`^ {0,3}[-*+]\s+(.*)$`)
+	mdOrdered     = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)]\s+(.*)$`)
+	mdQuote       = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	mdImage       = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+	mdLink        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong      = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	mdEmphasis    = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*`)
+	mdUnderscore  = regexp.MustCompile(`(^|[^\w])_(\S(?:.*?\S)?)_([^\w]|$)`)
+	mdStrike      = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	mdSpan        = regexp.MustCompile("\x00\\d+\x00")
+	mdTag         = regexp.MustCompile(`<[^>]*>`)
+	mdWhitespaces = regexp.MustCompile(`\s+`)
+)
+
+// RenderMarkdown 将文章内容从Markdown转换为HTML
+func RenderMarkdown(src string) template.HTML {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	var b strings.Builder
+	renderBlocks(&b, lines)
+	return template.HTML(b.String())
+}
+
+// MarkdownText 将Markdown转换为纯文本，用于摘要等不显示格式的场景
+func MarkdownText(src string) string {
+	text := mdTag.ReplaceAllString(string(RenderMarkdown(src)), " ")
+	return strings.TrimSpace(mdWhitespaces.ReplaceAllString(html.UnescapeString(text), " "))
+}
+
+// 是否为新的块级元素的开始，用于结束段落
+func isBlockStart(line string) bool {
+	return mdHeading.MatchString(line) || mdRule.MatchString(line) || mdFence.MatchString(line) ||
+		mdUnordered.MatchString(line) || mdOrdered.MatchString(line) || mdQuote.MatchString(line)
+}
+
+func renderBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case mdFence.MatchString(line):
+			m := mdFence.FindStringSubmatch(line)
+			fence := m[1]
+			i++
+			var code []string
+			for ; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					i++
+					break
+				}
+				code = append(code, lines[i])
+			}
+			if m[2] != "" {
+				b.WriteString(`<pre><code class="language-` + html.EscapeString(m[2]) + `">`)
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+		case mdHeading.MatchString(line):
+			m := mdHeading.FindStringSubmatch(line)
+			level := string(rune('0' + len(m[1])))
+			b.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+			i++
+		case mdRule.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+		case mdQuote.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				if m := mdQuote.FindStringSubmatch(lines[i]); m != nil {
+					quoted = append(quoted, m[1])
+				} else {
+					quoted = append(quoted, lines[i])
+				}
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+		case mdUnordered.MatchString(line), mdOrdered.MatchString(line):
+			i = renderList(b, lines, i)
+		default:
+			var para []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(para) == 0 || !isBlockStart(lines[i])); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			b.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+		}
+	}
+}
+
+// 渲染从第start行开始的列表，返回列表之后的行号。缩进的行视为上一项的继续
+func renderList(b *strings.Builder, lines []string, start int) int {
+	ordered := mdOrdered.MatchString(lines[start])
+	pattern := mdUnordered
+	tag := "ul"
+	if ordered {
+		pattern, tag = mdOrdered, "ol"
+	}
+	if m := mdOrdered.FindStringSubmatch(lines[start]); ordered && m[1] != "1" {
+		b.WriteString(`<ol start="` + strings.TrimLeft(m[1], "0") + `">` + "\n")
+	} else {
+		b.WriteString("<" + tag + ">\n")
+	}
+	var items []string
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if m := pattern.FindStringSubmatch(line); m != nil {
+			items = append(items, m[len(m)-1])
+			continue
+		}
+		if strings.TrimSpace(line) == "" || isBlockStart(line) || !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			break
+		}
+		items[len(items)-1] += "\n" + strings.TrimSpace(line)
+	}
+	for _, item := range items {
+		b.WriteString("<li>" + renderInline(item) + "</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// 渲染行内元素，代码中的内容不做处理
+func renderInline(text string) string {
+	var b strings.Builder
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		// 没有配对的反引号按普通文本处理
+		if i%2 == 1 && i < len(parts)-1 {
+			b.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			b.WriteString("`")
+		}
+		b.WriteString(renderSpans(part))
+	}
+	return b.String()
+}
+
+// 链接和图片的占位符，占位符中不含任何Markdown语法，不会被后续的替换匹配
+const mdPlaceholder = "\x00"
+
+// 处理链接、图片和强调。链接和图片先替换为占位符，各部分单独转义后生成HTML，
+// 强调只在转义后的普通文本上处理，不会匹配到已生成的HTML
+func renderSpans(s string) string {
+	s = strings.ReplaceAll(s, mdPlaceholder, "")
+	var spans []string
+	placeholder := func(rendered string) string {
+		spans = append(spans, rendered)
+		return mdPlaceholder + strconv.Itoa(len(spans)-1) + mdPlaceholder
+	}
+	s = mdImage.ReplaceAllStringFunc(s, func(m string) string {
+		sub := mdImage.FindStringSubmatch(m)
+		if !safeMarkdownURL(sub[2]) {
+			return placeholder(html.EscapeString(sub[1]))
+		}
+		return placeholder(`<img src="` + html.EscapeString(sub[2]) + `" alt="` + html.EscapeString(sub[1]) + `">`)
+	})
+	s = mdLink.ReplaceAllStringFunc(s, func(m string) string {
+		sub := mdLink.FindStringSubmatch(m)
+		text := renderEmphasis(html.EscapeString(sub[1]))
+		if !safeMarkdownURL(sub[2]) {
+			return placeholder(text)
+		}
+		return placeholder(`<a href="` + html.EscapeString(sub[2]) + `">` + text + `</a>`)
+	})
+	s = renderEmphasis(html.EscapeString(s))
+	// 链接文字中可以包含图片，占位符对应的HTML中可能还有更早生成的占位符
+	var expand func(string) string
+	expand = func(s string) string {
+		return mdSpan.ReplaceAllStringFunc(s, func(m string) string {
+			i, _ := strconv.Atoi(strings.Trim(m, mdPlaceholder))
+			return expand(spans[i])
+		})
+	}
+	return expand(s)
+}
+
+// 在已转义的文本中处理强调
+func renderEmphasis(s string) string {
+	s = mdStrong.ReplaceAllString(s, "<strong>$1$2</strong>")
+	s = mdEmphasis.ReplaceAllString(s, "<em>$1</em>")
+	s = mdUnderscore.ReplaceAllString(s, "$1<em>$2</em>$3")
+	return mdStrike.ReplaceAllString(s, "<del>$1</del>")
+}
+
+// 链接地址是否安全：站内地址或http、https、mailto链接
+func safeMarkdownURL(raw string) bool {
+	u := strings.ToLower(html.UnescapeString(raw))
+	colon := strings.Index(u, ":")
+	if colon < 0 || strings.ContainsAny(u[:colon], "/?#") {
+		return true
+	}
+	scheme := u[:colon]
+	return scheme == "http" || scheme == "https" || scheme == "mailto"
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"# 标题", "<h1>标题</h1>\n"},
+		{"**粗体** *斜体* ~~删除~~ `<b>`", "<p><strong>粗体</strong> <em>斜体</em> <del>删除</del> <code>&lt;b&gt;</code></p>\n"},
+		{"[链接](https://example.com/?a=1&b=2)", `<p><a href="https://example.com/?a=1&amp;b=2">链接</a></p>` + "\n"},
+		{"**[粗体链接](/posts/1)**", `<p><strong><a href="/posts/1">粗体链接</a></strong></p>` + "\n"},
+		{"[*斜体*链接](/posts/1)", `<p><a href="/posts/1"><em>斜体</em>链接</a></p>` + "\n"},
+		{"![图片 \"1\"](/uploads/a.png)", `<p><img src="/uploads/a.png" alt="图片 &#34;1&#34;"></p>` + "\n"},
+		{"[危险](javascript:alert(1))", "<p>危险)</p>\n"},
+		{"![危险](javascript:alert(1))", "<p>危险)</p>\n"},
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(RenderMarkdown(tt.src)); got != tt.want {
+			t.Errorf("RenderMarkdown(%q) = %q，want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+var (
+	mdTestTag       = regexp.MustCompile(`<(?:img|a)\s([^>]*)>`)
+	mdTestAttribute = regexp.MustCompile(`^(?:(?:src|alt|href)="[^"<>]*"\s*)+$`)
+)
+
+// 图片的alt中嵌套链接时，生成的链接不能截断图片的属性
+func TestRenderMarkdownNestedImageLink(t *testing.T) {
+	payloads := []string{
+		"![[x](http://a)](x/onerror=location='javascript:alert%281%29'//)",
+		"[![x](http://a)](x/onerror=alert(1)//)",
+		"![x\" onerror=\"alert(1)](http://a)",
+		"[x](http://a\"onmouseover=\"alert(1))",
+		"**![*x*](http://a)**",
+	}
+	for _, src := range payloads {
+		got := string(RenderMarkdown(src))
+		for _, tag := range mdTestTag.FindAllStringSubmatch(got, -1) {
+			if !mdTestAttribute.MatchString(tag[1]) {
+				t.Errorf("RenderMarkdown(%q) = %q，属性被截断", src, got)
+			}
+		}
+		if strings.Contains(got, mdPlaceholder) {
+			t.Errorf("RenderMarkdown(%q) = %q，占位符没有被替换", src, got)
+		}
+	}
+	got := string(RenderMarkdown(payloads[0]))
+	want := `<p><img src="http://a" alt="[x">](x/onerror=location=&#39;javascript:alert%281%29&#39;//)</p>` + "\n"
+	if got != want {
+		t.Fatalf("RenderMarkdown(%q) = %q，want %q", payloads[0], got, want)
+	}
+	got = string(RenderMarkdown(payloads[1]))
+	want = `<p><a href="x/onerror=alert(1"><img src="http://a" alt="x"></a>//)</p>` + "\n"
+	if got != want {
+		t.Fatalf("RenderMarkdown(%q) = %q，want %q", payloads[1], got, want)
+	}
+}
+
+func TestMarkdownText(t *testing.T) {
+	if got := MarkdownText("# 标题\n\n**粗体** [链接](/a) & ![图片](/b.png)"); got != "标题 粗体 链接 &" {
+		t.Fatalf("MarkdownText = %q", got)
+	}
+}
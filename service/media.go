@@ -0,0 +1,568 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// MediaPathPrefix 媒体文件的访问路径
+const MediaPathPrefix = "/media"
+
+// 上传图片允许的最大像素数量，防止解码超大图片耗尽内存
+const maxMediaPixels = 40000000
+
+// 媒体列表每页最多的数量
+const maxMediaPerPage = 100
+
+// 媒体库支持的文件类型及保存时使用的扩展名，类型根据文件内容判断，与文件名无关
+var mediaTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// 文章内容中引用的媒体文件地址，文件名以内容的SHA-256开头
+var mediaRefPattern = regexp.MustCompile(`/media/[0-9a-f]{2}/([0-9a-f]{64})`)
+
+// 可以访问的媒体文件名：原图、缩略图和不同宽度的图片
+var mediaFilePattern = regexp.MustCompile(`^[0-9a-f]{2}/[0-9a-f]{64}(-thumb|-w[0-9]+)?\.(jpg|png|gif)$`)
+
+var errUnsupportedMedia = &models.APIError{
+	Code:    http.StatusUnsupportedMediaType,
+	Message: "文件格式不支持",
+	Details: "媒体库仅支持JPEG、PNG和GIF图片，像素数量不能超过4000万",
+}
+
+// ErrMediaTooLarge 上传的媒体文件超出大小限制
+var ErrMediaTooLarge = &models.APIError{
+	Code:    http.StatusRequestEntityTooLarge,
+	Message: "文件过大",
+	Details: "媒体文件大小超出限制",
+}
+
+var errMediaInUse = &models.APIError{
+	Code:    http.StatusConflict,
+	Message: "媒体文件正在被文章使用",
+	Details: "请先从引用该文件的文章中删除后再删除文件",
+}
+
+// MediaVariantItem 接口返回的缩略图或不同宽度的图片
+type MediaVariantItem struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// MediaItem 接口返回的媒体文件
+type MediaItem struct {
+	ID           uint               `json:"id"`
+	UserID       uint               `json:"user_id"`
+	FileName     string             `json:"file_name"`
+	MimeType     string             `json:"mime_type"`
+	Size         int64              `json:"size"`
+	Width        int                `json:"width"`
+	Height       int                `json:"height"`
+	URL          string             `json:"url"`
+	ThumbnailURL string             `json:"thumbnail_url,omitempty"`
+	Variants     []MediaVariantItem `json:"variants"`
+	// 可以直接用于img标签的srcset属性
+	Srcset string `json:"srcset,omitempty"`
+	// 引用该文件的文章
+	PostIDs   []uint    `json:"post_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MediaPage 分页的媒体列表
+type MediaPage struct {
+	Media      []MediaItem `json:"media"`
+	Total      int64       `json:"total"`
+	Page       int         `json:"page"`
+	PageSize   int         `json:"page_size"`
+	TotalPages int         `json:"total_pages"`
+}
+
+// MediaGCResult 清理未使用的媒体文件的结果
+type MediaGCResult struct {
+	// 删除的媒体记录数量
+	Media int `json:"media"`
+	// 删除的文件数量和原图的总大小
+	Files  int   `json:"files"`
+	Bytes  int64 `json:"bytes"`
+	DryRun bool  `json:"dry_run"`
+}
+
+// MediaMaxBytes 上传媒体文件允许的最大文件大小
+func MediaMaxBytes() int64 {
+	return int64(config.Conf.Media.MaxMB) << 20
+}
+
+// MediaURL 媒体文件的访问地址，path为文件在媒体目录中的路径
+func MediaURL(path string) string {
+	return MediaPathPrefix + "/" + path
+}
+
+func newMediaItem(media models.Media, postIDs []uint) MediaItem {
+	item := MediaItem{
+		ID:        media.ID,
+		UserID:    media.UserID,
+		FileName:  media.FileName,
+		MimeType:  media.MimeType,
+		Size:      media.Size,
+		Width:     media.Width,
+		Height:    media.Height,
+		URL:       MediaURL(media.Path),
+		Variants:  make([]MediaVariantItem, 0, len(media.Variants)),
+		PostIDs:   postIDs,
+		CreatedAt: media.CreatedAt,
+	}
+	if item.PostIDs == nil {
+		item.PostIDs = []uint{}
+	}
+	var srcset []string
+	for _, v := range media.Variants {
+		url := MediaURL(v.Path)
+		item.Variants = append(item.Variants, MediaVariantItem{Name: v.Name, Width: v.Width, Height: v.Height, URL: url})
+		if v.Name == "thumb" {
+			item.ThumbnailURL = url
+			continue
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", url, v.Width))
+	}
+	if len(srcset) > 0 {
+		item.Srcset = strings.Join(append(srcset, fmt.Sprintf("%s %dw", item.URL, media.Width)), ", ")
+	}
+	return item
+}
+
+// 媒体文件在存储中的键名
+func mediaKey(path string) string {
+	return mediaKeyPrefix + path
+}
+
+// 保存媒体文件，文件按内容命名，已存在时不再写入
+func writeMediaFile(path string, data []byte) error {
+	s, err := getStorage()
+	if err != nil {
+		return err
+	}
+	if _, err := s.Stat(mediaKey(path)); err == nil {
+		return nil
+	}
+	return s.Put(mediaKey(path), bytes.NewReader(data), int64(len(data)), mime.TypeByExtension(filepath.Ext(path)))
+}
+
+// 删除媒体文件的原图和生成的图片，返回删除的文件数量
+func removeMediaFiles(media models.Media) int {
+	paths := []string{media.Path}
+	for _, v := range media.Variants {
+		paths = append(paths, v.Path)
+	}
+	removed := 0
+	for _, path := range paths {
+		if err := deleteFile(mediaKey(path)); err != nil {
+			models.Log.Warning("删除媒体文件失败:", path, err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// 生成的图片的编码：JPEG保持JPEG，PNG和GIF使用PNG（GIF只取第一帧）
+func encodeMediaVariant(img image.Image, mimeType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if mimeType == "image/jpeg" {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), ".jpg", err
+	}
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), ".png", err
+}
+
+// 处理上传的图片：检查格式和尺寸，去除元数据，生成缩略图和不同宽度的图片并保存
+func processMedia(media *models.Media, data []byte) *models.APIError {
+	mimeType := http.DetectContentType(data)
+	ext, ok := mediaTypes[mimeType]
+	if !ok {
+		models.Log.Warning("媒体文件类型不支持:", mimeType)
+		return errUnsupportedMedia
+	}
+	// 先读取图片尺寸，尺寸过大时不解码
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || "image/"+format != mimeType || cfg.Width*cfg.Height > maxMediaPixels {
+		models.Log.Warning("媒体图片无效:", mimeType, err)
+		return errUnsupportedMedia
+	}
+
+	stored, orientation := data, 1
+	switch mimeType {
+	case "image/jpeg":
+		stored, orientation, ok = stripJPEGMetadata(data)
+	case "image/png":
+		stored, ok = stripPNGMetadata(data)
+	}
+	if !ok {
+		models.Log.Warning("解析媒体图片失败:", mimeType)
+		return errUnsupportedMedia
+	}
+	src, _, err := image.Decode(bytes.NewReader(stored))
+	if err != nil {
+		models.Log.Warning("解码媒体图片失败:", err)
+		return errUnsupportedMedia
+	}
+	img := applyOrientation(src, orientation)
+	if orientation > 1 {
+		// 去除EXIF后方向信息随之丢失，按方向旋转后重新编码
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			models.Log.Error("编码媒体图片失败:", err)
+			return models.ErrInternalServer
+		}
+		stored = buf.Bytes()
+	}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	base := media.Hash[:2] + "/" + media.Hash
+	media.MimeType = mimeType
+	media.Size = int64(len(stored))
+	media.Width, media.Height = width, height
+	media.Path = base + ext
+	media.Variants = nil
+	files := map[string][]byte{media.Path: stored}
+
+	thumbSize := min(config.Conf.Media.ThumbnailSize, width, height)
+	if thumbSize > 0 {
+		thumb, thumbExt, err := encodeMediaVariant(resizeSquare(img, thumbSize), mimeType)
+		if err != nil {
+			models.Log.Error("生成缩略图失败:", err)
+			return models.ErrInternalServer
+		}
+		variant := models.MediaVariant{Name: "thumb", Path: base + "-thumb" + thumbExt, Width: thumbSize, Height: thumbSize}
+		media.Variants = append(media.Variants, variant)
+		files[variant.Path] = thumb
+	}
+	widths := append([]int(nil), config.Conf.Media.Widths...)
+	sort.Ints(widths)
+	for _, w := range widths {
+		if w <= 0 || w >= width {
+			continue
+		}
+		h := max(height*w/width, 1)
+		data, variantExt, err := encodeMediaVariant(resizeRGBA(img, w, h), mimeType)
+		if err != nil {
+			models.Log.Error("生成图片失败:", w, err)
+			return models.ErrInternalServer
+		}
+		variant := models.MediaVariant{Name: fmt.Sprintf("w%d", w), Path: fmt.Sprintf("%s-w%d%s", base, w, variantExt), Width: w, Height: h}
+		media.Variants = append(media.Variants, variant)
+		files[variant.Path] = data
+	}
+
+	for path, data := range files {
+		if err := writeMediaFile(path, data); err != nil {
+			models.Log.Error("保存媒体文件失败:", path, err)
+			removeMediaFiles(*media)
+			return models.ErrInternalServer
+		}
+	}
+	return nil
+}
+
+// UploadMedia 上传媒体文件。同一用户重复上传同一文件时返回已有的文件，第二个返回值为true
+func UploadMedia(userID uint, fileName string, file io.Reader) (MediaItem, bool, *models.APIError) {
+	data, err := io.ReadAll(io.LimitReader(file, MediaMaxBytes()+1))
+	if err != nil {
+		models.Log.Warning("读取媒体文件失败:", err)
+		return MediaItem{}, false, models.ErrInvalidRequest
+	}
+	if int64(len(data)) > MediaMaxBytes() {
+		models.Log.Warning("媒体文件过大:", userID, len(data))
+		return MediaItem{}, false, ErrMediaTooLarge
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	var existing models.Media
+	err = models.DB.Where("user_id = ? AND hash = ?", userID, hash).First(&existing).Error
+	if err == nil {
+		postIDs, err := mediaPostIDs([]uint{existing.ID})
+		if err != nil {
+			models.Log.Error("查询媒体文件的引用失败:", err)
+			return MediaItem{}, false, models.ErrInternalServer
+		}
+		return newMediaItem(existing, postIDs[existing.ID]), true, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		models.Log.Error("查询媒体文件失败:", err)
+		return MediaItem{}, false, models.ErrInternalServer
+	}
+
+	media := models.Media{UserID: userID, Hash: hash, FileName: truncateUTF8(filepath.Base(fileName), 255)}
+	// 其他用户上传过同一文件时共用已保存的文件
+	var shared models.Media
+	err = models.DB.Where("hash = ?", hash).First(&shared).Error
+	switch {
+	case err == nil:
+		media.MimeType, media.Size, media.Width, media.Height = shared.MimeType, shared.Size, shared.Width, shared.Height
+		media.Path, media.Variants = shared.Path, shared.Variants
+	case err == gorm.ErrRecordNotFound:
+		if apiErr := processMedia(&media, data); apiErr != nil {
+			return MediaItem{}, false, apiErr
+		}
+	default:
+		models.Log.Error("查询媒体文件失败:", err)
+		return MediaItem{}, false, models.ErrInternalServer
+	}
+	if err := models.DB.Create(&media).Error; err != nil {
+		models.Log.Error("保存媒体记录失败:", err)
+		return MediaItem{}, false, models.ErrInternalServer
+	}
+	models.Log.Info("用户上传了媒体文件:", userID, media.ID, media.Path)
+	return newMediaItem(media, nil), false, nil
+}
+
+// 引用各媒体文件的文章，已删除的文章不计算在内
+func mediaPostIDs(mediaIDs []uint) (map[uint][]uint, error) {
+	result := map[uint][]uint{}
+	if len(mediaIDs) == 0 {
+		return result, nil
+	}
+	var rows []models.PostMedia
+	err := models.DB.Model(&models.PostMedia{}).Select("post_id, media_id").
+		Joins("JOIN posts ON posts.id = post_id AND posts.deleted_at IS NULL").
+		Where("media_id IN ?", mediaIDs).Order("post_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.MediaID] = append(result[row.MediaID], row.PostID)
+	}
+	return result, nil
+}
+
+// GetMediaList 分页获取媒体文件，按上传时间倒序排列。可以编辑任意文章的用户可以看到全部用户上传的文件
+func GetMediaList(userID interface{}, page, pageSize int) (MediaPage, *models.APIError) {
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	if pageSize > maxMediaPerPage {
+		pageSize = maxMediaPerPage
+	}
+	if page <= 0 {
+		page = 1
+	}
+	result := MediaPage{Media: []MediaItem{}, Page: page, PageSize: pageSize}
+	query := models.DB.Model(&models.Media{})
+	if !UserCan(userID, models.PermPostEditAny) {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Count(&result.Total).Error; err != nil {
+		models.Log.Error("统计媒体文件数量失败:", err)
+		return result, models.ErrInternalServer
+	}
+	result.TotalPages = int((result.Total + int64(pageSize) - 1) / int64(pageSize))
+
+	var list []models.Media
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list).Error; err != nil {
+		models.Log.Error("获取媒体文件失败:", err)
+		return result, models.ErrInternalServer
+	}
+	ids := make([]uint, 0, len(list))
+	for _, media := range list {
+		ids = append(ids, media.ID)
+	}
+	postIDs, err := mediaPostIDs(ids)
+	if err != nil {
+		models.Log.Error("查询媒体文件的引用失败:", err)
+		return result, models.ErrInternalServer
+	}
+	for _, media := range list {
+		result.Media = append(result.Media, newMediaItem(media, postIDs[media.ID]))
+	}
+	return result, nil
+}
+
+// DeleteMedia 删除媒体文件，被文章引用时不能删除。上传者和可以删除任意文章的用户可以删除
+func DeleteMedia(id uint, userID interface{}) *models.APIError {
+	var media models.Media
+	if err := models.DB.First(&media, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("媒体文件不存在:", id)
+			return models.ErrMediaNotFound
+		}
+		models.Log.Error("查询媒体文件失败:", err)
+		return models.ErrInternalServer
+	}
+	if media.UserID != userID && !UserCan(userID, models.PermPostDeleteAny) {
+		models.Log.Warning("无权删除媒体文件:", id, userID)
+		return models.ErrForbidden
+	}
+	postIDs, err := mediaPostIDs([]uint{media.ID})
+	if err != nil {
+		models.Log.Error("查询媒体文件的引用失败:", err)
+		return models.ErrInternalServer
+	}
+	if len(postIDs[media.ID]) > 0 {
+		models.Log.Warning("媒体文件正在被文章使用:", id, postIDs[media.ID])
+		return errMediaInUse
+	}
+	if _, _, err := deleteMedia([]models.Media{media}, false); err != nil {
+		models.Log.Error("删除媒体文件失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("媒体文件被删除:", id, userID)
+	return nil
+}
+
+// 删除媒体记录，同一文件没有其他记录时删除文件。dryRun为true时只统计不删除
+func deleteMedia(list []models.Media, dryRun bool) (int, int64, error) {
+	if len(list) == 0 {
+		return 0, 0, nil
+	}
+	ids := make([]uint, 0, len(list))
+	byHash := map[string]models.Media{}
+	for _, media := range list {
+		ids = append(ids, media.ID)
+		byHash[media.Hash] = media
+	}
+	if !dryRun {
+		err := models.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("media_id IN ?", ids).Delete(&models.PostMedia{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", ids).Delete(&models.Media{}).Error
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	files, size := 0, int64(0)
+	for hash, media := range byHash {
+		var remaining int64
+		if err := models.DB.Model(&models.Media{}).Where("hash = ? AND id NOT IN ?", hash, ids).Count(&remaining).Error; err != nil {
+			return files, size, err
+		}
+		if remaining > 0 {
+			continue
+		}
+		size += media.Size
+		if dryRun {
+			files += 1 + len(media.Variants)
+			continue
+		}
+		files += removeMediaFiles(media)
+	}
+	return files, size, nil
+}
+
+// 没有被文章引用的媒体文件的查询
+func unusedMedia() *gorm.DB {
+	used := models.DB.Model(&models.PostMedia{}).Select("media_id").
+		Joins("JOIN posts ON posts.id = post_id AND posts.deleted_at IS NULL")
+	return models.DB.Where("id NOT IN (?)", used)
+}
+
+// CollectUnusedMedia 清理上传超过olderThan仍没有被文章引用的媒体文件，dryRun为true时只统计不删除
+func CollectUnusedMedia(olderThan time.Duration, dryRun bool) (MediaGCResult, *models.APIError) {
+	result := MediaGCResult{DryRun: dryRun}
+	var list []models.Media
+	if err := unusedMedia().Where("created_at < ?", time.Now().Add(-olderThan)).Find(&list).Error; err != nil {
+		models.Log.Error("查询未使用的媒体文件失败:", err)
+		return result, models.ErrInternalServer
+	}
+	files, size, err := deleteMedia(list, dryRun)
+	if err != nil {
+		models.Log.Error("清理媒体文件失败:", err)
+		return result, models.ErrInternalServer
+	}
+	result.Media, result.Files, result.Bytes = len(list), files, size
+	if !dryRun && len(list) > 0 {
+		models.Log.Info("清理了未使用的媒体文件:", result.Media, result.Files, result.Bytes)
+	}
+	return result, nil
+}
+
+// StartMediaGC 启动未使用媒体文件的定期清理，每小时检查一次
+func StartMediaGC() {
+	hours := config.Conf.Media.GCAfterHours
+	if hours <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			CollectUnusedMedia(time.Duration(hours)*time.Hour, false)
+		}
+	}()
+}
+
+// 删除用户上传的没有被文章引用的媒体文件，用于删除账号
+func removeUserMedia(userID uint) {
+	var list []models.Media
+	if err := unusedMedia().Where("user_id = ?", userID).Find(&list).Error; err != nil {
+		models.Log.Error("查询用户的媒体文件失败:", err)
+		return
+	}
+	if _, _, err := deleteMedia(list, false); err != nil {
+		models.Log.Error("删除用户的媒体文件失败:", err)
+	}
+}
+
+// 根据文章内容和分享图片更新文章引用的媒体文件
+func syncPostMedia(tx *gorm.DB, postID uint, texts ...string) error {
+	seen := map[string]bool{}
+	var hashes []string
+	for _, text := range texts {
+		for _, m := range mediaRefPattern.FindAllStringSubmatch(text, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				hashes = append(hashes, m[1])
+			}
+		}
+	}
+	if err := tx.Where("post_id = ?", postID).Delete(&models.PostMedia{}).Error; err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	var ids []uint
+	if err := tx.Model(&models.Media{}).Where("hash IN ?", hashes).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows := make([]models.PostMedia, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, models.PostMedia{PostID: postID, MediaID: id})
+	}
+	return tx.Create(&rows).Error
+}
+
+// MediaFile 根据访问地址中的文件名打开媒体文件
+func MediaFile(name string) (StoredFile, *models.APIError) {
+	if !mediaFilePattern.MatchString(name) {
+		return StoredFile{}, models.ErrMediaNotFound
+	}
+	return openFile(mediaKey(name), models.ErrMediaNotFound)
+}
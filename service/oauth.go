@@ -0,0 +1,541 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 授权服务器签发的令牌前缀，用于和JWT、个人访问令牌区分
+const (
+	OAuthAccessTokenPrefix  = "gbo_"
+	OAuthRefreshTokenPrefix = "gbr_"
+)
+
+// 授权码的有效期
+const oauthCodeTTL = time.Minute
+
+// OAuthError 按OAuth2规范（RFC 6749）返回的错误，令牌、内省和吊销接口使用
+type OAuthError struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code
+}
+
+func oauthError(status int, code, description string) *OAuthError {
+	return &OAuthError{Status: status, Code: code, Description: description}
+}
+
+var errOAuthClientNotFound = &models.APIError{
+	Code:    404,
+	Message: "客户端不存在",
+	Details: "指定的客户端未找到",
+}
+
+var errOAuthAuthorizationNotFound = &models.APIError{
+	Code:    404,
+	Message: "授权不存在",
+	Details: "指定的授权未找到或已吊销",
+}
+
+func oauthAccessTTL() time.Duration {
+	return time.Duration(config.Conf.OAuth.AccessTTL) * time.Minute
+}
+
+func oauthRefreshTTL() time.Duration {
+	return time.Duration(config.Conf.OAuth.RefreshTTL) * time.Hour
+}
+
+// IsOAuthAccessToken 判断令牌是否为授权服务器签发的访问令牌
+func IsOAuthAccessToken(token string) bool {
+	return strings.HasPrefix(token, OAuthAccessTokenPrefix)
+}
+
+// 解析空格分隔的权限范围，去掉重复项
+func parseScopes(scope string) []string {
+	var scopes []string
+	seen := map[string]bool{}
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验回调地址：必须是不带片段的绝对地址，http只允许本机地址。移动应用可以使用
+// 反向域名形式的自定义协议（RFC 8252），javascript:、data:、file:等其他协议一律拒绝
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		ip := net.ParseIP(host)
+		return host == "localhost" || (ip != nil && ip.IsLoopback())
+	}
+	// 反向域名的每一段都不能为空，例如com.example.app
+	labels := strings.Split(u.Scheme, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateOAuthClient 注册客户端，客户端密钥只在创建时返回一次，公开客户端没有密钥
+func CreateOAuthClient(userID uint, name string, redirectURIs, scopes []string, public bool) (string, models.OAuthClient, *models.APIError) {
+	client := models.OAuthClient{
+		Name:   truncateUTF8(strings.TrimSpace(name), 64),
+		Public: public,
+		UserID: userID,
+	}
+	if client.Name == "" || len(redirectURIs) == 0 || len(scopes) == 0 {
+		return "", client, models.ErrInvalidRequest
+	}
+	for _, uri := range redirectURIs {
+		if !validRedirectURI(uri) || len(uri) > 255 {
+			models.Log.Warning("客户端回调地址无效:", uri)
+			return "", client, models.ErrInvalidRequest
+		}
+		if !containsString(client.RedirectURIs, uri) {
+			client.RedirectURIs = append(client.RedirectURIs, uri)
+		}
+	}
+	for _, scope := range scopes {
+		if _, ok := models.ScopePermissions[scope]; !ok {
+			models.Log.Warning("客户端权限范围不存在:", scope)
+			return "", client, models.ErrInvalidRequest
+		}
+		if !containsString(client.Scopes, scope) {
+			client.Scopes = append(client.Scopes, scope)
+		}
+	}
+
+	client.ClientID = RandomToken(16)
+	secret := ""
+	if !public {
+		secret = RandomToken(32)
+		client.SecretHash = HashToken(secret)
+	}
+	if err := models.DB.Create(&client).Error; err != nil {
+		models.Log.Error("注册客户端失败:", err)
+		return "", client, models.ErrInternalServer
+	}
+	models.Log.Info("用户注册了客户端:", userID, client.ClientID, client.Name)
+	return secret, client, nil
+}
+
+// GetOAuthClients 获取用户注册的客户端
+func GetOAuthClients(userID uint) ([]models.OAuthClient, *models.APIError) {
+	var clients []models.OAuthClient
+	if err := models.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&clients).Error; err != nil {
+		models.Log.Error("获取客户端失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient 删除客户端，客户端的授权码和令牌同时失效
+func DeleteOAuthClient(userID, id uint) *models.APIError {
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&models.OAuthClient{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Where("client_id = ?", id).Delete(&models.OAuthCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("client_id = ?", id).Delete(&models.OAuthToken{}).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return errOAuthClientNotFound
+	}
+	if err != nil {
+		models.Log.Error("删除客户端失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("用户删除了客户端:", userID, id)
+	return nil
+}
+
+// AuthorizeRequest 授权请求的参数
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// AuthorizeContext 校验通过的授权请求，用于显示授权页面和签发授权码
+type AuthorizeContext struct {
+	Client      models.OAuthClient
+	RedirectURI string
+	Scopes      []string
+	State       string
+	Challenge   string
+}
+
+// ValidateAuthorizeRequest 校验授权请求。客户端或回调地址无效时返回的上下文中RedirectURI为空，
+// 此时不能跳转回客户端，只能直接显示错误；其他错误需要通过回调地址返回给客户端
+func ValidateAuthorizeRequest(req AuthorizeRequest) (AuthorizeContext, *OAuthError) {
+	ctx := AuthorizeContext{State: req.State}
+	err := models.DB.Where("client_id = ?", req.ClientID).First(&ctx.Client).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			models.Log.Error("查询客户端失败:", err)
+		}
+		return ctx, oauthError(400, "invalid_client", "客户端不存在")
+	}
+	// 只登记了一个回调地址时可以省略
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(ctx.Client.RedirectURIs) == 1 {
+		redirectURI = ctx.Client.RedirectURIs[0]
+	}
+	// 之前登记的回调地址同样需要符合当前的规则
+	if !containsString(ctx.Client.RedirectURIs, redirectURI) || !validRedirectURI(redirectURI) {
+		models.Log.Warning("授权请求的回调地址未登记:", req.ClientID, req.RedirectURI)
+		return ctx, oauthError(400, "invalid_request", "回调地址未登记")
+	}
+	ctx.RedirectURI = redirectURI
+
+	if req.ResponseType != "code" {
+		return ctx, oauthError(400, "unsupported_response_type", "只支持授权码模式")
+	}
+	// 所有客户端都必须使用PKCE
+	if req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) < 43 || len(req.CodeChallenge) > 128 {
+		return ctx, oauthError(400, "invalid_request", "需要使用S256方式的PKCE")
+	}
+	ctx.Challenge = req.CodeChallenge
+	ctx.Scopes = parseScopes(req.Scope)
+	if len(ctx.Scopes) == 0 {
+		ctx.Scopes = ctx.Client.Scopes
+	}
+	for _, scope := range ctx.Scopes {
+		if !containsString(ctx.Client.Scopes, scope) {
+			return ctx, oauthError(400, "invalid_scope", "客户端不能申请权限范围: "+scope)
+		}
+	}
+	return ctx, nil
+}
+
+// OAuthRedirect 生成跳转回客户端的地址
+func OAuthRedirect(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// GrantableScopes 去掉用户角色没有的权限范围，授权不会超出用户本身的权限
+func GrantableScopes(userID uint, scopes []string) ([]string, *models.APIError) {
+	role, apiErr := GetUserRole(userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	granted := []string{}
+	for _, scope := range scopes {
+		if models.HasPermission(role, models.ScopePermissions[scope]) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted, nil
+}
+
+// ApproveAuthorization 用户同意授权后签发授权码
+func ApproveAuthorization(userID uint, ctx AuthorizeContext) (string, *models.APIError) {
+	scopes, apiErr := GrantableScopes(userID, ctx.Scopes)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	code := RandomToken(32)
+	// 顺便清理过期的授权码
+	if err := models.DB.Where("expires_at < ?", time.Now()).Delete(&models.OAuthCode{}).Error; err != nil {
+		models.Log.Error("清理授权码失败:", err)
+	}
+	err := models.DB.Create(&models.OAuthCode{
+		CodeHash:      HashToken(code),
+		ClientID:      ctx.Client.ID,
+		UserID:        userID,
+		RedirectURI:   ctx.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: ctx.Challenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	}).Error
+	if err != nil {
+		models.Log.Error("保存授权码失败:", err)
+		return "", models.ErrInternalServer
+	}
+	models.Log.Info("用户授权了客户端:", userID, ctx.Client.ClientID, scopes)
+	return code, nil
+}
+
+// AuthenticateOAuthClient 校验客户端身份，公开客户端只需要client_id，其他客户端必须提供正确的密钥
+func AuthenticateOAuthClient(clientID, secret string) (models.OAuthClient, *OAuthError) {
+	var client models.OAuthClient
+	err := models.DB.Where("client_id = ?", clientID).First(&client).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			models.Log.Error("查询客户端失败:", err)
+			return client, oauthError(500, "server_error", "")
+		}
+		return client, oauthError(401, "invalid_client", "")
+	}
+	if !client.Public && subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		models.Log.Warning("客户端密钥错误:", clientID)
+		return client, oauthError(401, "invalid_client", "")
+	}
+	return client, nil
+}
+
+// 签发新的访问令牌和刷新令牌，record为空时创建新的授权，否则更换已有授权的令牌。
+// 更换时通过条件更新保证同一个刷新令牌只能使用一次，已被其他请求更换时返回gorm.ErrRecordNotFound
+func issueOAuthTokens(tx *gorm.DB, record *models.OAuthToken) (map[string]interface{}, error) {
+	accessToken := OAuthAccessTokenPrefix + RandomToken(32)
+	refreshToken := OAuthRefreshTokenPrefix + RandomToken(32)
+	previousRefreshHash := record.RefreshHash
+	record.AccessHash = HashToken(accessToken)
+	record.AccessExpiresAt = time.Now().Add(oauthAccessTTL())
+	record.RefreshHash = HashToken(refreshToken)
+	record.RefreshExpiresAt = time.Now().Add(oauthRefreshTTL())
+	if record.ID == 0 {
+		if err := tx.Omit("Client").Create(record).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		res := tx.Model(&models.OAuthToken{}).
+			Where("id = ? AND refresh_hash = ?", record.ID, previousRefreshHash).
+			Updates(map[string]interface{}{
+				"access_hash":        record.AccessHash,
+				"access_expires_at":  record.AccessExpiresAt,
+				"refresh_hash":       record.RefreshHash,
+				"refresh_expires_at": record.RefreshExpiresAt,
+			})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+	return map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(oauthAccessTTL().Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         strings.Join(record.Scopes, " "),
+	}, nil
+}
+
+// ExchangeAuthorizationCode 使用授权码和PKCE的code_verifier换取令牌，授权码只能使用一次
+func ExchangeAuthorizationCode(client models.OAuthClient, code, redirectURI, verifier string) (map[string]interface{}, *OAuthError) {
+	var record models.OAuthCode
+	err := models.DB.Where("code_hash = ?", HashToken(code)).First(&record).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			models.Log.Error("查询授权码失败:", err)
+			return nil, oauthError(500, "server_error", "")
+		}
+		return nil, oauthError(400, "invalid_grant", "授权码无效")
+	}
+	deleted := models.DB.Delete(&models.OAuthCode{}, record.ID)
+	if deleted.Error != nil {
+		models.Log.Error("删除授权码失败:", deleted.Error)
+		return nil, oauthError(500, "server_error", "")
+	}
+	if deleted.RowsAffected == 0 || time.Now().After(record.ExpiresAt) || record.ClientID != client.ID || record.RedirectURI != redirectURI {
+		models.Log.Warning("授权码已使用、已过期或与客户端不一致:", client.ClientID)
+		return nil, oauthError(400, "invalid_grant", "授权码无效")
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(record.CodeChallenge)) != 1 {
+		models.Log.Warning("PKCE校验失败:", client.ClientID)
+		return nil, oauthError(400, "invalid_grant", "code_verifier错误")
+	}
+	token := models.OAuthToken{ClientID: client.ID, UserID: record.UserID, Scopes: record.Scopes}
+	res, err := issueOAuthTokens(models.DB, &token)
+	if err != nil {
+		models.Log.Error("签发令牌失败:", err)
+		return nil, oauthError(500, "server_error", "")
+	}
+	return res, nil
+}
+
+// RefreshOAuthToken 使用刷新令牌换取新的令牌，旧的访问令牌和刷新令牌随即失效
+func RefreshOAuthToken(client models.OAuthClient, refreshToken string) (map[string]interface{}, *OAuthError) {
+	var res map[string]interface{}
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		var record models.OAuthToken
+		err := tx.Where("refresh_hash = ? AND client_id = ?", HashToken(refreshToken), client.ID).First(&record).Error
+		if err != nil {
+			return err
+		}
+		if time.Now().After(record.RefreshExpiresAt) {
+			return gorm.ErrRecordNotFound
+		}
+		res, err = issueOAuthTokens(tx, &record)
+		return err
+	})
+	if err == gorm.ErrRecordNotFound {
+		models.Log.Warning("刷新令牌无效、已过期或已被使用:", client.ClientID)
+		return nil, oauthError(400, "invalid_grant", "刷新令牌无效")
+	}
+	if err != nil {
+		models.Log.Error("刷新令牌失败:", err)
+		return nil, oauthError(500, "server_error", "")
+	}
+	return res, nil
+}
+
+// 按访问令牌或刷新令牌查找授权
+func findOAuthToken(token string) (models.OAuthToken, error) {
+	var record models.OAuthToken
+	column := "access_hash"
+	if strings.HasPrefix(token, OAuthRefreshTokenPrefix) {
+		column = "refresh_hash"
+	}
+	err := models.DB.Where(column+" = ?", HashToken(token)).First(&record).Error
+	return record, err
+}
+
+// IntrospectOAuthToken 令牌内省（RFC 7662），只返回属于该客户端且仍然有效的令牌信息
+func IntrospectOAuthToken(client models.OAuthClient, token string) map[string]interface{} {
+	inactive := map[string]interface{}{"active": false}
+	record, err := findOAuthToken(token)
+	if err != nil || record.ClientID != client.ID {
+		return inactive
+	}
+	expiresAt := record.AccessExpiresAt
+	tokenType := "access_token"
+	if strings.HasPrefix(token, OAuthRefreshTokenPrefix) {
+		expiresAt = record.RefreshExpiresAt
+		tokenType = "refresh_token"
+	}
+	if time.Now().After(expiresAt) {
+		return inactive
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return inactive
+	}
+	return map[string]interface{}{
+		"active":     true,
+		"scope":      strings.Join(record.Scopes, " "),
+		"client_id":  client.ClientID,
+		"username":   user.UserName,
+		"sub":        strconv.FormatUint(uint64(user.ID), 10),
+		"token_type": tokenType,
+		"exp":        expiresAt.Unix(),
+		"iat":        record.UpdatedAt.Unix(),
+	}
+}
+
+// RevokeOAuthToken 吊销令牌（RFC 7009），访问令牌和刷新令牌都会失效。
+// 令牌不存在或不属于该客户端时同样视为成功
+func RevokeOAuthToken(client models.OAuthClient, token string) *OAuthError {
+	record, err := findOAuthToken(token)
+	if err != nil || record.ClientID != client.ID {
+		return nil
+	}
+	if err := models.DB.Delete(&models.OAuthToken{}, record.ID).Error; err != nil {
+		models.Log.Error("吊销令牌失败:", err)
+		return oauthError(503, "temporarily_unavailable", "")
+	}
+	models.Log.Info("客户端吊销了令牌:", client.ClientID, record.ID)
+	return nil
+}
+
+// VerifyOAuthAccessToken 校验授权服务器签发的访问令牌，返回授权记录和所属用户
+func VerifyOAuthAccessToken(token string) (models.OAuthToken, models.User, *models.APIError) {
+	var user models.User
+	record, err := findOAuthToken(token)
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			models.Log.Error("查询令牌失败:", err)
+			return record, user, models.ErrInternalServer
+		}
+		return record, user, errInvalidAccessToken
+	}
+	if time.Now().After(record.AccessExpiresAt) {
+		return record, user, errInvalidAccessToken
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return record, user, errInvalidAccessToken
+	}
+	if record.LastUsedAt == nil || time.Since(*record.LastUsedAt) > accessTokenTouchInterval {
+		if err := models.DB.Model(&models.OAuthToken{}).Where("id = ?", record.ID).UpdateColumn("last_used_at", time.Now()).Error; err != nil {
+			models.Log.Error("更新令牌使用时间失败:", err)
+		}
+	}
+	return record, user, nil
+}
+
+// GetOAuthAuthorizations 获取用户授权过的客户端
+func GetOAuthAuthorizations(userID uint) ([]models.OAuthToken, *models.APIError) {
+	var tokens []models.OAuthToken
+	err := models.DB.Preload("Client").Where("user_id = ? AND refresh_expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").Find(&tokens).Error
+	if err != nil {
+		models.Log.Error("获取授权列表失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return tokens, nil
+}
+
+// RevokeOAuthAuthorization 用户取消对客户端的授权
+func RevokeOAuthAuthorization(userID, id uint) *models.APIError {
+	res := models.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.OAuthToken{})
+	if res.Error != nil {
+		models.Log.Error("取消授权失败:", res.Error)
+		return models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 {
+		return errOAuthAuthorizationNotFound
+	}
+	models.Log.Info("用户取消了授权:", userID, id)
+	return nil
+}
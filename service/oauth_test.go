@@ -0,0 +1,247 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"http://localhost:3000/callback", true},
+		{"http://127.0.0.1:8000/cb", true},
+		{"http://[::1]/cb", true},
+		{"com.example.app:/oauth2redirect", true},
+		{"com.example.app://callback", true},
+		{"http://app.example.com/callback", false},
+		{"https:///callback", false},
+		{"https://app.example.com/callback#frag", false},
+		{"/callback", false},
+		{"javascript:alert(1)//", false},
+		{"JavaScript:alert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"file:///etc/passwd", false},
+		{"vbscript:msgbox(1)", false},
+		{"myapp://callback", false},
+		{"com..example:/cb", false},
+		{".example:/cb", false},
+	}
+	for _, tt := range tests {
+		if got := validRedirectURI(tt.uri); got != tt.want {
+			t.Errorf("validRedirectURI(%q) = %v, want %v", tt.uri, got, tt.want)
+		}
+	}
+}
+
+// PKCE的code_challenge
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// 注册客户端并完成一次授权，返回客户端和授权码
+func authorizeOAuthClient(t *testing.T, user models.User, verifier string) (models.OAuthClient, string) {
+	t.Helper()
+	_, client, apiErr := CreateOAuthClient(user.ID, "app", []string{"https://app.example.com/callback"}, []string{models.ScopePostsWrite}, true)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	ctx, oauthErr := ValidateAuthorizeRequest(AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		CodeChallenge:       pkceChallenge(verifier),
+		CodeChallengeMethod: "S256",
+	})
+	if oauthErr != nil {
+		t.Fatal(oauthErr)
+	}
+	code, apiErr := ApproveAuthorization(user.ID, ctx)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	return client, code
+}
+
+func TestCreateOAuthClientRedirectURI(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	for _, uri := range []string{"javascript:alert(document.cookie)//", "data:text/html,x", "http://app.example.com/cb"} {
+		if _, _, apiErr := CreateOAuthClient(user.ID, "app", []string{uri}, []string{models.ScopePostsWrite}, true); apiErr != models.ErrInvalidRequest {
+			t.Errorf("回调地址 %q = %v", uri, apiErr)
+		}
+	}
+	// 规则收紧前登记的回调地址在授权时同样被拒绝
+	_, client, _ := CreateOAuthClient(user.ID, "app", []string{"https://app.example.com/cb"}, []string{models.ScopePostsWrite}, true)
+	models.DB.Model(&client).Update("redirect_uris", []string{"javascript:alert(1)//"})
+	ctx, oauthErr := ValidateAuthorizeRequest(AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID})
+	if oauthErr == nil || ctx.RedirectURI != "" {
+		t.Fatalf("使用无效的回调地址授权 = %+v, %v", ctx, oauthErr)
+	}
+}
+
+func TestValidateAuthorizeRequest(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	_, client, _ := CreateOAuthClient(user.ID, "app", []string{"https://app.example.com/callback"}, []string{models.ScopePostsWrite}, true)
+	challenge := pkceChallenge(RandomToken(48))
+	tests := []struct {
+		name     string
+		req      AuthorizeRequest
+		code     string
+		redirect bool
+	}{
+		{"客户端不存在", AuthorizeRequest{ResponseType: "code", ClientID: "unknown", CodeChallenge: challenge, CodeChallengeMethod: "S256"}, "invalid_client", false},
+		{"回调地址未登记", AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, RedirectURI: "https://evil.example.com/callback", CodeChallenge: challenge, CodeChallengeMethod: "S256"}, "invalid_request", false},
+		{"回调地址前缀相同", AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, RedirectURI: "https://app.example.com/callback/../evil", CodeChallenge: challenge, CodeChallengeMethod: "S256"}, "invalid_request", false},
+		{"缺少PKCE", AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID}, "invalid_request", true},
+		{"plain方式的PKCE", AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, CodeChallenge: challenge, CodeChallengeMethod: "plain"}, "invalid_request", true},
+		{"code_challenge过短", AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, CodeChallenge: "short", CodeChallengeMethod: "S256"}, "invalid_request", true},
+		{"隐式模式", AuthorizeRequest{ResponseType: "token", ClientID: client.ClientID, CodeChallenge: challenge, CodeChallengeMethod: "S256"}, "unsupported_response_type", true},
+		{"客户端没有的权限范围", AuthorizeRequest{ResponseType: "code", ClientID: client.ClientID, Scope: models.ScopeUsersRead, CodeChallenge: challenge, CodeChallengeMethod: "S256"}, "invalid_scope", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, oauthErr := ValidateAuthorizeRequest(tt.req)
+			if oauthErr == nil || oauthErr.Code != tt.code {
+				t.Fatalf("ValidateAuthorizeRequest = %v, want %s", oauthErr, tt.code)
+			}
+			if (ctx.RedirectURI != "") != tt.redirect {
+				t.Fatalf("RedirectURI = %q", ctx.RedirectURI)
+			}
+		})
+	}
+}
+
+func TestExchangeAuthorizationCode(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	verifier := RandomToken(48)
+
+	// code_verifier错误，授权码随即失效
+	client, code := authorizeOAuthClient(t, user, verifier)
+	if _, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/callback", RandomToken(48)); oauthErr == nil || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("错误的code_verifier = %v", oauthErr)
+	}
+	if _, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/callback", verifier); oauthErr == nil {
+		t.Fatal("PKCE校验失败后授权码仍然可以使用")
+	}
+
+	// 回调地址与授权时不一致
+	client, code = authorizeOAuthClient(t, user, verifier)
+	if _, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/other", verifier); oauthErr == nil {
+		t.Fatal("回调地址不一致时换取了令牌")
+	}
+
+	client, code = authorizeOAuthClient(t, user, verifier)
+	res, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/callback", verifier)
+	if oauthErr != nil {
+		t.Fatal(oauthErr)
+	}
+	if _, _, apiErr := VerifyOAuthAccessToken(res["access_token"].(string)); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	// 授权码只能使用一次
+	if _, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/callback", verifier); oauthErr == nil {
+		t.Fatal("授权码可以使用两次")
+	}
+}
+
+func TestRefreshOAuthToken(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	verifier := RandomToken(48)
+	client, code := authorizeOAuthClient(t, user, verifier)
+	first, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/callback", verifier)
+	if oauthErr != nil {
+		t.Fatal(oauthErr)
+	}
+
+	second, oauthErr := RefreshOAuthToken(client, first["refresh_token"].(string))
+	if oauthErr != nil {
+		t.Fatal(oauthErr)
+	}
+	if _, oauthErr := RefreshOAuthToken(client, first["refresh_token"].(string)); oauthErr == nil || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("旧的刷新令牌 = %v", oauthErr)
+	}
+	if _, _, apiErr := VerifyOAuthAccessToken(first["access_token"].(string)); apiErr == nil {
+		t.Fatal("刷新后旧的访问令牌仍然有效")
+	}
+	if _, _, apiErr := VerifyOAuthAccessToken(second["access_token"].(string)); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	// 其他客户端不能使用该刷新令牌
+	other, _ := authorizeOAuthClient(t, user, verifier)
+	if _, oauthErr := RefreshOAuthToken(other, second["refresh_token"].(string)); oauthErr == nil {
+		t.Fatal("其他客户端使用了刷新令牌")
+	}
+}
+
+func TestConcurrentOAuthRefresh(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	verifier := RandomToken(48)
+	client, code := authorizeOAuthClient(t, user, verifier)
+	tokens, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/callback", verifier)
+	if oauthErr != nil {
+		t.Fatal(oauthErr)
+	}
+	refreshToken := tokens["refresh_token"].(string)
+
+	const n = 10
+	results := make(chan map[string]interface{}, n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			<-start
+			res, _ := RefreshOAuthToken(client, refreshToken)
+			results <- res
+		}()
+	}
+	close(start)
+	var issued []map[string]interface{}
+	for i := 0; i < n; i++ {
+		if res := <-results; res != nil {
+			issued = append(issued, res)
+		}
+	}
+	if len(issued) != 1 {
+		t.Fatalf("同一刷新令牌换取了%d次令牌", len(issued))
+	}
+	if _, _, apiErr := VerifyOAuthAccessToken(issued[0]["access_token"].(string)); apiErr != nil {
+		t.Fatal("成功的刷新请求返回的访问令牌无效:", apiErr)
+	}
+}
+
+// 两个请求在对方更换令牌之前读取了同一条授权记录，只有先更新的请求可以签发令牌
+func TestOAuthRefreshStaleRecord(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	verifier := RandomToken(48)
+	client, code := authorizeOAuthClient(t, user, verifier)
+	tokens, oauthErr := ExchangeAuthorizationCode(client, code, "https://app.example.com/callback", verifier)
+	if oauthErr != nil {
+		t.Fatal(oauthErr)
+	}
+	var first, second models.OAuthToken
+	models.DB.Where("refresh_hash = ?", HashToken(tokens["refresh_token"].(string))).First(&first)
+	second = first
+
+	if _, err := issueOAuthTokens(models.DB, &first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issueOAuthTokens(models.DB, &second); err != gorm.ErrRecordNotFound {
+		t.Fatalf("使用过期的记录更换令牌 = %v", err)
+	}
+	var saved models.OAuthToken
+	models.DB.First(&saved, first.ID)
+	if saved.RefreshHash != first.RefreshHash {
+		t.Fatal("后一个请求覆盖了先签发的令牌")
+	}
+}
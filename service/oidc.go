@@ -0,0 +1,633 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 跳转到身份提供方后完成登录的有效期
+const oidcStateTTL = 10 * time.Minute
+
+// 回调后换取登录令牌的一次性令牌有效期
+const ssoLoginTTL = 2 * time.Minute
+
+// 遇到未知的签名密钥时，两次重新获取JWKS的最小间隔
+const oidcKeysRefreshInterval = time.Minute
+
+// ID令牌允许的签名算法
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// 请求身份提供方使用的HTTP客户端
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var errOIDCDisabled = &models.APIError{
+	Code:    404,
+	Message: "未启用单点登录",
+	Details: "请使用用户名和密码登录",
+}
+
+var errOIDCFailed = &models.APIError{
+	Code:    400,
+	Message: "单点登录失败",
+	Details: "请重新登录",
+}
+
+var errOIDCNoAccount = &models.APIError{
+	Code:    403,
+	Message: "没有对应的账号",
+	Details: "身份提供方返回的邮箱没有对应的账号或尚未验证，请联系管理员",
+}
+
+var errOIDCUnverifiedAccount = &models.APIError{
+	Code:    403,
+	Message: "账号邮箱尚未验证",
+	Details: "该邮箱已有账号但尚未验证，请先使用用户名和密码登录并验证邮箱",
+}
+
+// oidcDiscovery 身份提供方的配置信息，来自 /.well-known/openid-configuration
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// 缓存的身份提供方配置和签名公钥
+var oidcCache struct {
+	sync.Mutex
+	issuer      string
+	discovery   *oidcDiscovery
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// oidcIdentity 从ID令牌和用户信息接口中得到的用户身份
+type oidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Groups        []string
+}
+
+// 回调地址
+func oidcRedirectURL() string {
+	if config.Conf.OIDC.RedirectURL != "" {
+		return config.Conf.OIDC.RedirectURL
+	}
+	return strings.TrimRight(config.Conf.Site.BaseURL, "/") + "/auth/oidc/callback"
+}
+
+// 请求身份提供方并解析JSON响应
+func oidcGetJSON(req *http.Request, v interface{}) error {
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回 %d: %s", req.URL.Redacted(), resp.StatusCode, truncateUTF8(string(body), 200))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// 获取身份提供方配置，签发者变更时重新获取
+func getOIDCDiscovery() (*oidcDiscovery, error) {
+	issuer := config.Conf.OIDC.Issuer
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+	if oidcCache.discovery != nil && oidcCache.issuer == issuer {
+		return oidcCache.discovery, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var discovery oidcDiscovery
+	if err := oidcGetJSON(req, &discovery); err != nil {
+		return nil, err
+	}
+	// 配置信息中的签发者必须与配置一致，防止使用其他身份提供方签发的令牌
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("签发者不一致: %s", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("身份提供方配置不完整")
+	}
+	oidcCache.issuer = issuer
+	oidcCache.discovery = &discovery
+	oidcCache.keys = nil
+	return &discovery, nil
+}
+
+// jsonWebKey JWKS中的公钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// 将JWK转换为RSA或ECDSA公钥
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("不支持的曲线: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+}
+
+// 获取ID令牌的签名公钥，遇到未知的kid时重新获取JWKS，以支持身份提供方轮换密钥
+func oidcKey(discovery *oidcDiscovery, kid string) (interface{}, error) {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+	if key, ok := oidcCache.keys[kid]; ok {
+		return key, nil
+	}
+	if oidcCache.keys != nil && time.Since(oidcCache.keysFetched) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("签名密钥不存在: %s", kid)
+	}
+	req, err := http.NewRequest(http.MethodGet, discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := oidcGetJSON(req, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			models.Log.Warning("忽略无法解析的签名密钥:", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	oidcCache.keys = keys
+	oidcCache.keysFetched = time.Now()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("签名密钥不存在: %s", kid)
+}
+
+// BeginOIDCLogin 开始单点登录，返回身份提供方的授权地址和需要写入Cookie的state
+func BeginOIDCLogin(redirect string) (string, string, *models.APIError) {
+	if !config.Conf.OIDC.Enabled {
+		return "", "", errOIDCDisabled
+	}
+	discovery, err := getOIDCDiscovery()
+	if err != nil {
+		models.Log.Error("获取身份提供方配置失败:", err)
+		return "", "", errOIDCFailed
+	}
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		models.Log.Error("身份提供方授权地址无效:", err)
+		return "", "", errOIDCFailed
+	}
+
+	state := RandomToken(32)
+	record := models.OIDCState{
+		StateHash:    HashToken(state),
+		Nonce:        RandomToken(16),
+		CodeVerifier: RandomToken(48),
+		Redirect:     truncateUTF8(redirect, 255),
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	// 顺便清理过期的状态
+	if err := models.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCState{}).Error; err != nil {
+		models.Log.Error("清理单点登录状态失败:", err)
+	}
+	if err := models.DB.Create(&record).Error; err != nil {
+		models.Log.Error("保存单点登录状态失败:", err)
+		return "", "", models.ErrInternalServer
+	}
+
+	challenge := sha256.Sum256([]byte(record.CodeVerifier))
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", config.Conf.OIDC.ClientID)
+	query.Set("redirect_uri", oidcRedirectURL())
+	query.Set("scope", strings.Join(config.Conf.OIDC.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", record.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), state, nil
+}
+
+// 取出并删除state对应的记录，state只能使用一次
+func takeOIDCState(state string) (models.OIDCState, *models.APIError) {
+	var record models.OIDCState
+	err := models.DB.Where("state_hash = ?", HashToken(state)).First(&record).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("单点登录状态不存在")
+			return record, errOIDCFailed
+		}
+		models.Log.Error("查询单点登录状态失败:", err)
+		return record, models.ErrInternalServer
+	}
+	res := models.DB.Delete(&models.OIDCState{}, record.ID)
+	if res.Error != nil {
+		models.Log.Error("删除单点登录状态失败:", res.Error)
+		return record, models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 || time.Now().After(record.ExpiresAt) {
+		return record, errOIDCFailed
+	}
+	return record, nil
+}
+
+// 使用授权码和PKCE的code_verifier换取令牌
+func exchangeOIDCCode(discovery *oidcDiscovery, code, verifier string) (idToken, accessToken string, err error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirectURL()},
+		"code_verifier": {verifier},
+		"client_id":     {config.Conf.OIDC.ClientID},
+	}
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.Conf.OIDC.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.Conf.OIDC.ClientID), url.QueryEscape(config.Conf.OIDC.ClientSecret))
+	}
+	var res struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := oidcGetJSON(req, &res); err != nil {
+		return "", "", err
+	}
+	if res.IDToken == "" {
+		return "", "", errors.New("响应中没有ID令牌")
+	}
+	return res.IDToken, res.AccessToken, nil
+}
+
+// 校验ID令牌的签名、签发者、接收方、有效期和nonce
+func verifyIDToken(discovery *oidcDiscovery, idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(oidcSigningMethods))
+	_, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return oidcKey(discovery, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	clientID := config.Conf.OIDC.ClientID
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, errors.New("签发者不一致")
+	}
+	if !claims.VerifyAudience(clientID, true) {
+		return nil, errors.New("接收方不一致")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != clientID {
+		return nil, errors.New("授权方不一致")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("ID令牌已过期")
+	}
+	if n, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce不一致")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("缺少sub")
+	}
+	return claims, nil
+}
+
+// ID令牌中没有邮箱时从用户信息接口获取，sub必须一致
+func mergeOIDCUserinfo(discovery *oidcDiscovery, claims jwt.MapClaims, accessToken string) error {
+	if _, ok := claims["email"]; ok || discovery.UserinfoEndpoint == "" || accessToken == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, discovery.UserinfoEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var info map[string]interface{}
+	if err := oidcGetJSON(req, &info); err != nil {
+		return err
+	}
+	if info["sub"] != claims["sub"] {
+		return errors.New("用户信息的sub不一致")
+	}
+	for k, v := range info {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return nil
+}
+
+// 从声明中读取用户身份，用户组可以是字符串数组或单个字符串
+func oidcIdentityFromClaims(claims jwt.MapClaims) oidcIdentity {
+	identity := oidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	for _, name := range []string{"preferred_username", "nickname", "name"} {
+		if v, ok := claims[name].(string); ok && v != "" {
+			identity.Username = v
+			break
+		}
+	}
+	switch v := claims[config.Conf.OIDC.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range v {
+			if s, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	case string:
+		identity.Groups = []string{v}
+	}
+	return identity
+}
+
+// 根据用户组得到角色，匹配多个时使用权限最多的角色
+func oidcGroupRole(groups []string) (string, bool) {
+	role := ""
+	for _, group := range groups {
+		r, ok := config.Conf.OIDC.GroupRoles[group]
+		if !ok || !models.IsValidRole(r) {
+			continue
+		}
+		if role == "" || len(models.RolePermissions[r]) > len(models.RolePermissions[role]) {
+			role = r
+		}
+	}
+	return role, role != ""
+}
+
+// 用户名只保留字母、数字、下划线、短横线和点
+var usernameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+// 为自动创建的用户生成不重复的用户名
+func uniqueUsername(identity oidcIdentity) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = truncateUTF8(usernameInvalidChars.ReplaceAllString(base, ""), 32)
+	for len(base) < 3 {
+		base += "_"
+	}
+	name := base
+	for i := 2; ; i++ {
+		var count int64
+		if err := models.DB.Model(&models.User{}).Where("user_name = ?", name).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// 查找外部身份对应的用户：已关联的直接使用，否则按已验证的邮箱关联邮箱同样已验证的本地账号，或自动创建
+func findOIDCUser(issuer string, identity oidcIdentity) (models.User, *models.APIError) {
+	var user models.User
+	var link models.UserIdentity
+	err := models.DB.Where("issuer = ? AND subject = ?", issuer, identity.Subject).First(&link).Error
+	if err == nil {
+		return findUser(link.UserID)
+	}
+	if err != gorm.ErrRecordNotFound {
+		models.Log.Error("查询外部身份失败:", err)
+		return user, models.ErrInternalServer
+	}
+	// 未验证的邮箱可能属于他人，不能用来关联或创建账号
+	if identity.Email == "" || !identity.EmailVerified {
+		models.Log.Warning("身份提供方没有返回已验证的邮箱:", identity.Subject)
+		return user, errOIDCNoAccount
+	}
+
+	err = models.DB.Where("email = ?", identity.Email).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		if !config.Conf.OIDC.AutoProvision {
+			models.Log.Warning("单点登录的邮箱没有对应的账号:", identity.Email)
+			return user, errOIDCNoAccount
+		}
+		name, err := uniqueUsername(identity)
+		if err != nil {
+			models.Log.Error("生成用户名失败:", err)
+			return user, models.ErrInternalServer
+		}
+		// 随机密码无法用于登录，需要时可以通过找回密码设置
+		user = models.User{
+			UserName:      name,
+			Email:         identity.Email,
+			Password:      EncryptPassword(RandomToken(32)),
+			EmailVerified: true,
+		}
+		if err := createRegisteredUser(&user); err != nil {
+			models.Log.Error("创建单点登录用户失败:", err)
+			return user, models.ErrInternalServer
+		}
+		models.Log.Info("单点登录自动创建用户:", user.UserName, identity.Email)
+	} else if err != nil {
+		models.Log.Error("查询用户失败:", err)
+		return user, models.ErrInternalServer
+	} else if !user.EmailVerified {
+		// 本地账号的邮箱未验证时可能是他人抢先用该邮箱注册的，自动关联会让对方的密码和会话继续有效
+		models.Log.Warning("本地账号的邮箱未验证，不能自动关联外部身份:", user.UserName, identity.Email)
+		return user, errOIDCUnverifiedAccount
+	}
+
+	link = models.UserIdentity{UserID: user.ID, Issuer: issuer, Subject: identity.Subject}
+	if err := models.DB.Create(&link).Error; err != nil {
+		models.Log.Error("关联外部身份失败:", err)
+		return user, models.ErrInternalServer
+	}
+	models.Log.Info("用户关联了外部身份:", user.UserName, issuer)
+	return user, nil
+}
+
+// 按用户组同步角色，不会移除最后一个管理员
+func syncOIDCRole(user *models.User, groups []string) {
+	if len(config.Conf.OIDC.GroupRoles) == 0 {
+		return
+	}
+	role, ok := oidcGroupRole(groups)
+	if !ok {
+		role = config.Conf.User.DefaultRole
+		if !models.IsValidRole(role) {
+			role = models.RoleAuthor
+		}
+	}
+	if role == user.Role {
+		return
+	}
+	if user.Role == models.RoleAdmin {
+		var count int64
+		models.DB.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count)
+		if count <= 1 {
+			models.Log.Warning("不能移除最后一个管理员，保留角色:", user.UserName)
+			return
+		}
+	}
+	if err := models.DB.Model(user).Update("role", role).Error; err != nil {
+		models.Log.Error("同步用户角色失败:", err)
+		return
+	}
+	models.Log.Info("按用户组同步角色:", user.UserName, role)
+}
+
+// FinishOIDCLogin 处理身份提供方的回调，校验state（需要与Cookie中的一致）后换取并校验ID令牌，
+// 返回一次性的登录令牌和登录后跳转的地址，浏览器使用登录令牌调用 /api/login/sso 完成登录
+func FinishOIDCLogin(state, cookieState, code string) (string, string, *models.APIError) {
+	if !config.Conf.OIDC.Enabled {
+		return "", "", errOIDCDisabled
+	}
+	// state必须与发起登录的浏览器一致，防止登录CSRF
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		models.Log.Warning("单点登录state与Cookie不一致")
+		return "", "", errOIDCFailed
+	}
+	record, apiErr := takeOIDCState(state)
+	if apiErr != nil {
+		return "", "", apiErr
+	}
+	if code == "" {
+		return "", record.Redirect, errOIDCFailed
+	}
+	discovery, err := getOIDCDiscovery()
+	if err != nil {
+		models.Log.Error("获取身份提供方配置失败:", err)
+		return "", record.Redirect, errOIDCFailed
+	}
+	idToken, accessToken, err := exchangeOIDCCode(discovery, code, record.CodeVerifier)
+	if err != nil {
+		models.Log.Error("换取ID令牌失败:", err)
+		return "", record.Redirect, errOIDCFailed
+	}
+	claims, err := verifyIDToken(discovery, idToken, record.Nonce)
+	if err != nil {
+		models.Log.Warning("ID令牌校验失败:", err)
+		return "", record.Redirect, errOIDCFailed
+	}
+	if err := mergeOIDCUserinfo(discovery, claims, accessToken); err != nil {
+		models.Log.Error("获取用户信息失败:", err)
+		return "", record.Redirect, errOIDCFailed
+	}
+
+	identity := oidcIdentityFromClaims(claims)
+	user, apiErr := findOIDCUser(discovery.Issuer, identity)
+	if apiErr != nil {
+		return "", record.Redirect, apiErr
+	}
+	syncOIDCRole(&user, identity.Groups)
+	now := time.Now()
+	err = models.DB.Model(&models.UserIdentity{}).Where("issuer = ? AND subject = ?", discovery.Issuer, identity.Subject).
+		Updates(map[string]interface{}{"email": identity.Email, "last_login_at": now}).Error
+	if err != nil {
+		models.Log.Error("更新外部身份失败:", err)
+	}
+
+	token, err := createUserToken(user.ID, models.TokenPurposeSSOLogin, user.Email, ssoLoginTTL)
+	if err != nil {
+		models.Log.Error("生成单点登录令牌失败:", err)
+		return "", record.Redirect, models.ErrInternalServer
+	}
+	return token, record.Redirect, nil
+}
+
+// CompleteSSOLogin 使用回调得到的一次性令牌完成登录，启用了两步验证的用户仍然需要输入验证码
+func CompleteSSOLogin(token, ip, userAgent string) (map[string]interface{}, *models.APIError) {
+	record, apiErr := consumeUserToken(token, models.TokenPurposeSSOLogin)
+	if apiErr != nil {
+		return nil, errOIDCFailed
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return nil, errOIDCFailed
+	}
+	if user.TOTPEnabled {
+		return twoFactorChallenge(user)
+	}
+	recordLoginAttempt(user.ID, loginKey(user.UserName), ip, userAgent, models.LoginResultSuccess)
+	return loginResponse(user, ip, userAgent)
+}
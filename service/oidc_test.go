@@ -0,0 +1,228 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/oidctest"
+)
+
+// 启动本地身份提供方并启用单点登录，结束后恢复配置
+func setupOIDC(t *testing.T) *oidctest.Provider {
+	t.Helper()
+	provider, server := oidctest.NewServer("blog", "blog-secret")
+	previous := config.Conf.OIDC
+	config.Conf.OIDC.Enabled = true
+	config.Conf.OIDC.Issuer = provider.Issuer
+	config.Conf.OIDC.ClientID = provider.ClientID
+	config.Conf.OIDC.ClientSecret = provider.ClientSecret
+	config.Conf.OIDC.RedirectURL = "http://localhost:8080/auth/oidc/callback"
+	t.Cleanup(func() {
+		server.Close()
+		config.Conf.OIDC = previous
+		oidcCache.Lock()
+		oidcCache.issuer, oidcCache.discovery, oidcCache.keys = "", nil, nil
+		oidcCache.Unlock()
+	})
+	return provider
+}
+
+// 发起单点登录并在身份提供方完成授权，返回回调中的state和授权码
+func authorizeOIDC(t *testing.T) (string, string) {
+	t.Helper()
+	authURL, state, apiErr := BeginOIDCLogin("/dashboard")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("身份提供方没有返回授权码: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatal("回调中的state与发起时不一致")
+	}
+	return state, callback.Query().Get("code")
+}
+
+// 完成一次单点登录，返回登录的用户
+func oidcLogin(t *testing.T) (models.User, *models.APIError) {
+	t.Helper()
+	state, code := authorizeOIDC(t)
+	token, redirect, apiErr := FinishOIDCLogin(state, state, code)
+	if apiErr != nil {
+		return models.User{}, apiErr
+	}
+	if redirect != "/dashboard" {
+		t.Fatalf("登录后跳转到 %q", redirect)
+	}
+	resp, apiErr := CompleteSSOLogin(token, "127.0.0.1", "test")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	data, ok := resp["data"].(UserResponse)
+	if !ok || resp["token"] == nil {
+		t.Fatalf("单点登录没有返回访问令牌: %v", resp)
+	}
+	user, apiErr := findUser(data.UserID)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	return user, nil
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	setupTestDB(t)
+	provider := setupOIDC(t)
+	provider.User = &oidctest.User{Subject: "sub-1", Email: "sso@example.com", EmailVerified: true, Username: "sso.user"}
+
+	user, apiErr := oidcLogin(t)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if user.UserName != "sso.user" || !user.EmailVerified {
+		t.Fatalf("自动创建的用户 = %+v", user)
+	}
+	// 已关联的外部身份直接登录同一账号，即使身份提供方中的邮箱已变更
+	provider.User = &oidctest.User{Subject: "sub-1", Email: "changed@example.com", EmailVerified: true}
+	if again, apiErr := oidcLogin(t); apiErr != nil || again.ID != user.ID {
+		t.Fatalf("再次登录 = %+v, %v", again, apiErr)
+	}
+	var count int64
+	models.DB.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("创建了%d个用户", count)
+	}
+}
+
+func TestOIDCStateAndPKCE(t *testing.T) {
+	setupTestDB(t)
+	provider := setupOIDC(t)
+	provider.User = &oidctest.User{Subject: "sub-1", Email: "sso@example.com", EmailVerified: true}
+
+	// Cookie中的state不一致
+	state, code := authorizeOIDC(t)
+	if _, _, apiErr := FinishOIDCLogin(state, "other", code); apiErr != errOIDCFailed {
+		t.Fatalf("state与Cookie不一致 = %v", apiErr)
+	}
+	// state只能使用一次
+	if _, _, apiErr := FinishOIDCLogin(state, state, code); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, _, apiErr := FinishOIDCLogin(state, state, code); apiErr != errOIDCFailed {
+		t.Fatalf("重复使用state = %v", apiErr)
+	}
+	// 过期的state
+	state, code = authorizeOIDC(t)
+	models.DB.Model(&models.OIDCState{}).Where("state_hash = ?", HashToken(state)).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, _, apiErr := FinishOIDCLogin(state, state, code); apiErr != errOIDCFailed {
+		t.Fatalf("过期的state = %v", apiErr)
+	}
+	// code_verifier与授权时的code_challenge不一致，身份提供方拒绝换取令牌
+	state, code = authorizeOIDC(t)
+	models.DB.Model(&models.OIDCState{}).Where("state_hash = ?", HashToken(state)).Update("code_verifier", RandomToken(48))
+	if _, _, apiErr := FinishOIDCLogin(state, state, code); apiErr != errOIDCFailed {
+		t.Fatalf("PKCE校验失败时 = %v", apiErr)
+	}
+	// 发起登录时生成的nonce被篡改，ID令牌中的nonce不一致
+	state, code = authorizeOIDC(t)
+	models.DB.Model(&models.OIDCState{}).Where("state_hash = ?", HashToken(state)).Update("nonce", "other")
+	if _, _, apiErr := FinishOIDCLogin(state, state, code); apiErr != errOIDCFailed {
+		t.Fatalf("nonce不一致时 = %v", apiErr)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	setupTestDB(t)
+	provider := setupOIDC(t)
+	discovery, err := getOIDCDiscovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(modify func(jwt.MapClaims)) string {
+		c := jwt.MapClaims{
+			"iss":   provider.Issuer,
+			"aud":   provider.ClientID,
+			"sub":   "sub-1",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce-1",
+		}
+		if modify != nil {
+			modify(c)
+		}
+		token, err := provider.Sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	if _, err := verifyIDToken(discovery, claims(nil), "nonce-1"); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"nonce不一致": claims(nil),
+		"缺少nonce":  claims(func(c jwt.MapClaims) { delete(c, "nonce") }),
+		"签发者不一致":   claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }),
+		"接收方不一致":   claims(func(c jwt.MapClaims) { c["aud"] = "other-client" }),
+		"授权方不一致":   claims(func(c jwt.MapClaims) { c["azp"] = "other-client" }),
+		"已过期":      claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }),
+		"缺少sub":    claims(func(c jwt.MapClaims) { delete(c, "sub") }),
+	}
+	for name, token := range tests {
+		nonce := "nonce-1"
+		if name == "nonce不一致" || name == "缺少nonce" {
+			nonce = "nonce-2"
+		}
+		if _, err := verifyIDToken(discovery, token, nonce); err == nil {
+			t.Errorf("%s时ID令牌校验通过", name)
+		}
+	}
+}
+
+func TestOIDCAccountLinking(t *testing.T) {
+	setupTestDB(t)
+	provider := setupOIDC(t)
+	verified := createTestUser(t, "alice", models.RoleAuthor)
+	unverified := createTestUser(t, "mallory", models.RoleAuthor)
+	models.DB.Model(&unverified).Update("email_verified", false)
+
+	// 身份提供方返回的邮箱未验证
+	provider.User = &oidctest.User{Subject: "sub-1", Email: verified.Email, EmailVerified: false}
+	if _, apiErr := oidcLogin(t); apiErr != errOIDCNoAccount {
+		t.Fatalf("未验证的外部邮箱 = %v", apiErr)
+	}
+	// 本地账号的邮箱未验证，可能是他人抢先注册的，不能自动关联
+	provider.User = &oidctest.User{Subject: "sub-2", Email: unverified.Email, EmailVerified: true}
+	if _, apiErr := oidcLogin(t); apiErr != errOIDCUnverifiedAccount {
+		t.Fatalf("关联邮箱未验证的本地账号 = %v", apiErr)
+	}
+	var links int64
+	models.DB.Model(&models.UserIdentity{}).Count(&links)
+	if links != 0 {
+		t.Fatalf("登录失败时关联了%d个外部身份", links)
+	}
+	models.DB.First(&unverified, unverified.ID)
+	if unverified.EmailVerified {
+		t.Fatal("单点登录将本地账号的邮箱标记为已验证")
+	}
+
+	// 邮箱都已验证时关联已有账号
+	provider.User = &oidctest.User{Subject: "sub-3", Email: verified.Email, EmailVerified: true}
+	user, apiErr := oidcLogin(t)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if user.ID != verified.ID {
+		t.Fatalf("关联到了用户 %d，want %d", user.ID, verified.ID)
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码哈希算法
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+// bcrypt只使用密码的前72个字节，为了两种算法的行为一致，密码最长为72个字节
+const maxPasswordBytes = 72
+
+// argon2id的盐和哈希长度（字节）
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// argon2id哈希的参数，编码为PHC字符串格式：$argon2id$v=19$m=65536,t=3,p=2$盐$哈希
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func currentArgon2Params() argon2Params {
+	cfg := config.Conf.Password
+	return argon2Params{memory: cfg.Argon2Memory, time: cfg.Argon2Time, threads: cfg.Argon2Threads}
+}
+
+// EncryptPassword 使用配置的算法加密密码
+func EncryptPassword(p string) string {
+	if config.Conf.Password.Algorithm == PasswordBcrypt {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(p), config.Conf.Password.BcryptCost)
+		if err != nil {
+			models.Log.Error("密码加密失败")
+			panic(err)
+		}
+		return string(hashedPassword)
+	}
+	params := currentArgon2Params()
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		models.Log.Error("生成密码盐失败")
+		panic(err)
+	}
+	key := argon2.IDKey([]byte(p), salt, params.time, params.memory, params.threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// 解析argon2id哈希，返回参数、盐和哈希值
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, bool) {
+	var params argon2Params
+	var version int
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PasswordArgon2id {
+		return params, nil, nil, false
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, false
+	}
+	return params, salt, key, true
+}
+
+// VerifyPassword 校验密码，同时支持argon2id和bcrypt哈希。
+// 密码正确但哈希的算法或参数与当前配置不同时needsRehash为true，调用方应使用新的哈希替换
+func VerifyPassword(hash, password string) (ok bool, needsRehash bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, valid := parseArgon2Hash(hash)
+		if !valid {
+			models.Log.Error("密码哈希格式错误")
+			return false, false
+		}
+		actual := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return false, false
+		}
+		return true, config.Conf.Password.Algorithm != PasswordArgon2id || params != currentArgon2Params()
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, config.Conf.Password.Algorithm != PasswordBcrypt || err != nil || cost != config.Conf.Password.BcryptCost
+}
+
+// 使用当前配置重新哈希密码，失败时只记录日志，不影响登录
+func rehashPassword(user models.User, password string) {
+	err := models.DB.Model(&models.User{}).Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", EncryptPassword(password)).Error
+	if err != nil {
+		models.Log.Error("更新密码哈希失败:", err)
+		return
+	}
+	models.Log.Info("用户的密码哈希已升级:", user.ID)
+}
+
+// 已泄露密码的SHA-1哈希集合，首次使用时从配置的文件中加载
+var breachedPasswords struct {
+	once   sync.Once
+	hashes map[string]struct{}
+}
+
+func loadBreachedPasswords() map[string]struct{} {
+	breachedPasswords.once.Do(func() {
+		breachedPasswords.hashes = map[string]struct{}{}
+		path := config.Conf.Password.BreachedList
+		if path == "" {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			models.Log.Error("读取泄露密码列表失败:", err)
+			return
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			hash, _, _ := strings.Cut(line, ":")
+			breachedPasswords.hashes[strings.ToUpper(hash)] = struct{}{}
+		}
+		if err := scanner.Err(); err != nil {
+			models.Log.Error("读取泄露密码列表失败:", err)
+		}
+		models.Log.Info("已加载泄露密码列表:", len(breachedPasswords.hashes))
+	})
+	return breachedPasswords.hashes
+}
+
+// 密码是否在泄露密码列表中
+func isBreachedPassword(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	_, found := loadBreachedPasswords()[strings.ToUpper(hex.EncodeToString(sum[:]))]
+	return found
+}
+
+// 页面直接显示错误信息，因此将具体原因放在Message中
+func passwordPolicyError(message string) *models.APIError {
+	return &models.APIError{
+		Code:    400,
+		Message: message,
+		Details: "密码不符合密码策略，请更换其他密码",
+	}
+}
+
+// CheckPasswordPolicy 检查新密码是否符合密码策略：长度、不能与用户名相同、不能是已泄露的密码
+func CheckPasswordPolicy(username, password string) *models.APIError {
+	minLength := config.Conf.Password.MinLength
+	if utf8.RuneCountInString(password) < minLength {
+		models.Log.Warning("密码长度不足:", username)
+		return passwordPolicyError(fmt.Sprintf("密码长度至少为%d个字符", minLength))
+	}
+	if len(password) > maxPasswordBytes {
+		models.Log.Warning("密码过长:", username)
+		return passwordPolicyError(fmt.Sprintf("密码长度不能超过%d个字节", maxPasswordBytes))
+	}
+	if strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(username)) {
+		models.Log.Warning("密码与用户名相同:", username)
+		return passwordPolicyError("密码不能与用户名相同")
+	}
+	if isBreachedPassword(password) {
+		models.Log.Warning("密码在泄露密码列表中:", username)
+		return passwordPolicyError("该密码过于常见或已在数据泄露中公开，请更换其他密码")
+	}
+	return nil
+}
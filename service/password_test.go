@@ -0,0 +1,114 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 使用与当前配置不同的argon2id参数生成哈希
+func oldArgon2Hash(password string) string {
+	previous := config.Conf.Password.Argon2Memory
+	config.Conf.Password.Argon2Memory = previous * 2
+	defer func() { config.Conf.Password.Argon2Memory = previous }()
+	return EncryptPassword(password)
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestVerifyPassword(t *testing.T) {
+	tests := []struct {
+		name       string
+		hash       string
+		password   string
+		ok, rehash bool
+	}{
+		{"当前参数", EncryptPassword("secret-password"), "secret-password", true, false},
+		{"旧的argon2id参数", oldArgon2Hash("secret-password"), "secret-password", true, true},
+		{"bcrypt", bcryptHash(t, "secret-password"), "secret-password", true, true},
+		{"密码错误", EncryptPassword("secret-password"), "wrong-password", false, false},
+		{"bcrypt密码错误", bcryptHash(t, "secret-password"), "wrong-password", false, false},
+		{"哈希格式错误", "$argon2id$v=19$m=1024$salt$key", "secret-password", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := VerifyPassword(tt.hash, tt.password)
+			if ok != tt.ok || rehash != tt.rehash {
+				t.Fatalf("VerifyPassword = %v, %v, want %v, %v", ok, rehash, tt.ok, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	for name, hash := range map[string]string{
+		"bcrypt":       bcryptHash(t, "alice-password"),
+		"旧的argon2id参数": oldArgon2Hash("alice-password"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			setupTestDB(t)
+			user := createTestUser(t, "alice", models.RoleAuthor)
+			models.DB.Model(&user).Update("password", hash)
+
+			if _, apiErr := LoginUser("alice", "alice-password", "127.0.0.1", "test"); apiErr != nil {
+				t.Fatal(apiErr)
+			}
+			models.DB.First(&user, user.ID)
+			if !strings.HasPrefix(user.Password, "$argon2id$") {
+				t.Fatal("登录后没有使用argon2id重新哈希:", user.Password)
+			}
+			if ok, rehash := VerifyPassword(user.Password, "alice-password"); !ok || rehash {
+				t.Fatalf("重新哈希的结果 = %v, %v", ok, rehash)
+			}
+			// 使用当前参数的哈希不会再次更新
+			rehashed := user.Password
+			if _, apiErr := LoginUser("alice", "alice-password", "127.0.0.1", "test"); apiErr != nil {
+				t.Fatal(apiErr)
+			}
+			models.DB.First(&user, user.ID)
+			if user.Password != rehashed {
+				t.Fatal("当前参数的哈希被重新生成")
+			}
+		})
+	}
+}
+
+func TestLoginFailureKeepsPasswordHash(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	hash := bcryptHash(t, "alice-password")
+	models.DB.Model(&user).Update("password", hash)
+
+	if _, apiErr := LoginUser("alice", "wrong-password", "127.0.0.1", "test"); apiErr != models.ErrInvalidCredentials {
+		t.Fatalf("密码错误时登录 = %v", apiErr)
+	}
+	models.DB.First(&user, user.ID)
+	if user.Password != hash {
+		t.Fatal("密码错误时更新了密码哈希")
+	}
+}
+
+func TestRehashPasswordKeepsNewerPassword(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	stale := user
+	stale.Password = bcryptHash(t, "alice-password")
+
+	// 登录期间密码已被修改，重新哈希不能覆盖新密码
+	rehashPassword(stale, "alice-password")
+	var saved models.User
+	models.DB.First(&saved, user.ID)
+	if saved.Password != user.Password {
+		t.Fatal("重新哈希覆盖了新修改的密码")
+	}
+}
@@ -1,13 +1,45 @@
 package service
 
 import (
+	"time"
+
 	"github.com/xiaohan1995/Gin-blog/models"
 	"gorm.io/gorm"
 )
 
+// 文章状态为空时视为已发布
+func validPostStatus(status string) bool {
+	return status == "" || status == models.PostStatusDraft || status == models.PostStatusPublished
+}
+
+// 用户是否可以查看文章，草稿只有作者和可以编辑任意文章的用户可以查看
+func canViewPost(post models.Post, userID interface{}) bool {
+	return post.Status != models.PostStatusDraft || post.UserID == userID || UserCan(userID, models.PermPostEditAny)
+}
+
 func CreatePost(post models.Post) *models.APIError {
+	if !validPostStatus(post.Status) {
+		models.Log.Warning("无效的文章状态:", post.Status)
+		return models.ErrInvalidRequest
+	}
+	if !validPostSEO(post) {
+		models.Log.Warning("无效的文章SEO设置:", post.CanonicalURL, post.SocialImage)
+		return models.ErrInvalidRequest
+	}
+	if post.Status == "" {
+		post.Status = models.PostStatusPublished
+	}
+	if post.Status == models.PostStatusPublished {
+		now := time.Now()
+		post.PublishedAt = &now
+	}
 	DB := models.DB
-	err := DB.Create(&post).Error
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		return syncPostMedia(tx, post.ID, post.Content, post.SocialImage)
+	})
 	if err != nil {
 		models.Log.Error("创建文章失败", err)
 		return models.ErrInternalServer
@@ -16,12 +48,16 @@ func CreatePost(post models.Post) *models.APIError {
 	return nil
 }
 
-func GetPosts() ([]models.Post, int, *models.APIError) {
+// GetPosts 获取文章列表，包括当前用户的草稿，可以编辑任意文章的用户可以看到全部草稿
+func GetPosts(userID interface{}) ([]models.Post, int, *models.APIError) {
 	var posts []models.Post
-	DB := models.DB
-	err := DB.Preload("User", func(db *gorm.DB) *gorm.DB {
+	DB := models.DB.Preload("User", func(db *gorm.DB) *gorm.DB {
 		return db.Omit("Password")
-	}).Find(&posts).Error
+	}).Preload("Tags")
+	if !UserCan(userID, models.PermPostEditAny) {
+		DB = DB.Where("status = ? OR user_id = ?", models.PostStatusPublished, userID)
+	}
+	err := DB.Find(&posts).Error
 	if err != nil {
 		models.Log.Error("获取文章列表失败:", err)
 		return posts, 0, models.ErrInternalServer
@@ -35,7 +71,7 @@ func GetPost(id uint, userID interface{}) (models.Post, *models.APIError) {
 	DB := models.DB
 	err := DB.Preload("User", func(db *gorm.DB) *gorm.DB {
 		return db.Omit("Password")
-	}).Where("id = ?", id).First(&post).Error
+	}).Preload("Tags").Where("id = ?", id).First(&post).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			models.Log.Warning("未找到文章ID:", id)
@@ -44,11 +80,21 @@ func GetPost(id uint, userID interface{}) (models.Post, *models.APIError) {
 		models.Log.Error("获取文章失败:", err)
 		return post, models.ErrInternalServer
 	}
+	if !canViewPost(post, userID) {
+		models.Log.Warning("无权查看草稿:", id, userID)
+		return models.Post{}, models.ErrPostNotFound
+	}
 	models.Log.Info("获取文章成功:", post.ID)
 	return post, nil
 }
 
-func UpdatePost(id uint, userID interface{}, post models.Post) *models.APIError {
+// UpdatePost 更新文章，post.Status为空时不改变状态，post.Tags为nil时不改变标签
+// UpdatePost 更新文章，post.Tags为nil时不修改标签，seo中为nil的字段不修改
+func UpdatePost(id uint, userID interface{}, post models.Post, seo PostSEO) *models.APIError {
+	if !validPostStatus(post.Status) {
+		models.Log.Warning("无效的文章状态:", post.Status)
+		return models.ErrInvalidRequest
+	}
 	DB := models.DB
 	// 先检查文章是否存在
 	var existingPost models.Post
@@ -63,12 +109,41 @@ func UpdatePost(id uint, userID interface{}, post models.Post) *models.APIError
 		return models.ErrInternalServer
 	}
 
-	// 判断文章user_id是否等于当前用户
-	if existingPost.UserID != userID {
+	// 判断文章user_id是否等于当前用户，管理员和编辑可以修改任意文章
+	if existingPost.UserID != userID && !UserCan(userID, models.PermPostEditAny) {
 		models.Log.Error("无权修改该文章:", existingPost.UserID, "Current user:", userID)
 		return models.ErrForbidden
 	}
-	err = DB.Where("id = ? and user_id = ?", id, userID).Updates(&post).Error
+	// 修改他人文章时不改变文章作者
+	post.UserID = existingPost.UserID
+	merged := existingPost
+	seo.Apply(&merged)
+	if !validPostSEO(merged) {
+		models.Log.Warning("无效的文章SEO设置:", merged.CanonicalURL, merged.SocialImage)
+		return models.ErrInvalidRequest
+	}
+	// 首次发布时记录发布时间，之后重新发布不改变
+	if post.Status == models.PostStatusPublished && existingPost.PublishedAt == nil {
+		now := time.Now()
+		post.PublishedAt = &now
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&existingPost).Omit("Tags").Updates(&post).Error; err != nil {
+			return err
+		}
+		if columns := seo.columns(merged); len(columns) > 0 {
+			if err := tx.Model(&existingPost).Updates(columns).Error; err != nil {
+				return err
+			}
+		}
+		if err := syncPostMedia(tx, existingPost.ID, post.Content, merged.SocialImage); err != nil {
+			return err
+		}
+		if post.Tags == nil {
+			return nil
+		}
+		return replacePostTags(tx, &existingPost, post.Tags)
+	})
 	if err != nil {
 		models.Log.Error("更新文章失败:", err)
 		return models.ErrInternalServer
@@ -93,12 +168,12 @@ func DeletePost(id uint, userID interface{}) *models.APIError {
 		return models.ErrInternalServer
 	}
 
-	// 判断文章user_id是否等于当前用户
-	if existingPost.UserID != userID {
+	// 判断文章user_id是否等于当前用户，管理员和编辑可以删除任意文章
+	if existingPost.UserID != userID && !UserCan(userID, models.PermPostDeleteAny) {
 		models.Log.Error("无权删除该文章:", existingPost.UserID, "Current user:", userID)
 		return models.ErrForbidden
 	}
-	err = DB.Where("id = ? and user_id = ?", id, userID).Delete(&post).Error
+	err = DB.Where("id = ?", id).Delete(&post).Error
 	if err != nil {
 		models.Log.Error("删除文章失败:", err)
 	}
@@ -112,10 +187,40 @@ func GetPostComments(id int) ([]models.Comment, *models.APIError) {
 	DB := models.DB
 	err := DB.Preload("User", func(db *gorm.DB) *gorm.DB {
 		return db.Select("id,user_name")
-	}).Where("post_id = ?", id).Find(&comments).Error
+	}).Where("post_id = ? AND status = ?", id, models.CommentStatusApproved).Find(&comments).Error
 	if err != nil {
 		models.Log.Error("获取文章评论失败:", err)
 		return nil, models.ErrInternalServer
 	}
 	return comments, nil
 }
+
+// SetPostCommentModeration 设置文章的评论审核模式，mode为空表示使用站点默认配置
+func SetPostCommentModeration(id uint, userID uint, mode string) *models.APIError {
+	if mode != "" && !IsValidModeration(mode) {
+		models.Log.Warning("无效的审核模式:", mode)
+		return models.ErrInvalidRequest
+	}
+	DB := models.DB
+	var existingPost models.Post
+	err := DB.Where("id = ?", id).First(&existingPost).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("文章不存在:", id)
+			return models.ErrPostNotFound
+		}
+		models.Log.Error("查询文章失败:", err)
+		return models.ErrInternalServer
+	}
+	if existingPost.UserID != userID && !UserCan(userID, models.PermCommentModerate) {
+		models.Log.Error("无权修改该文章的审核模式:", existingPost.UserID, "Current user:", userID)
+		return models.ErrForbidden
+	}
+	err = DB.Model(&existingPost).Update("comment_moderation", mode).Error
+	if err != nil {
+		models.Log.Error("更新审核模式失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("文章审核模式被更新:", id, mode)
+	return nil
+}
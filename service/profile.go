@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 个人资料各字段的长度限制（字符数）
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxSocialLinks       = 10
+)
+
+// 社交账号的平台名称只允许小写字母、数字、下划线和短横线
+var socialNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ProfileUpdate 可以修改的个人资料
+type ProfileUpdate struct {
+	DisplayName string            `json:"display_name"`
+	Bio         string            `json:"bio"`
+	Website     string            `json:"website"`
+	SocialLinks map[string]string `json:"social_links"`
+}
+
+// PublicProfile 对外公开的个人资料，不包含邮箱等隐私信息
+type PublicProfile struct {
+	ID          uint              `json:"id"`
+	Username    string            `json:"username"`
+	DisplayName string            `json:"display_name"`
+	Bio         string            `json:"bio"`
+	Website     string            `json:"website"`
+	SocialLinks map[string]string `json:"social_links"`
+	AvatarURL   string            `json:"avatar_url"`
+	JoinedAt    time.Time         `json:"joined_at"`
+}
+
+// AvatarURL 用户头像的访问地址，更换头像后地址随之变化，避免浏览器使用缓存的旧头像
+func AvatarURL(user models.User) string {
+	if user.Avatar == "" {
+		return fmt.Sprintf("/avatars/%d", user.ID)
+	}
+	return fmt.Sprintf("/avatars/%d?v=%s", user.ID, strings.TrimSuffix(user.Avatar, ".png"))
+}
+
+// NewPublicProfile 生成用户的公开资料，没有设置显示名称时使用用户名
+func NewPublicProfile(user models.User) PublicProfile {
+	profile := PublicProfile{
+		ID:          user.ID,
+		Username:    user.UserName,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Website:     user.Website,
+		SocialLinks: user.SocialLinks,
+		AvatarURL:   AvatarURL(user),
+		JoinedAt:    user.CreatedAt,
+	}
+	if profile.DisplayName == "" {
+		profile.DisplayName = user.UserName
+	}
+	if profile.SocialLinks == nil {
+		profile.SocialLinks = map[string]string{}
+	}
+	return profile
+}
+
+// GetProfile 获取用户的个人资料
+func GetProfile(userID uint) (PublicProfile, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return PublicProfile{}, apiErr
+	}
+	return NewPublicProfile(user), nil
+}
+
+// 个人网站和社交账号只允许http和https链接，防止在页面中插入javascript:等链接
+func validProfileURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && len(raw) <= 255
+}
+
+// UpdateProfile 修改个人资料，空字符串表示清除对应字段
+func UpdateProfile(userID uint, update ProfileUpdate) (PublicProfile, *models.APIError) {
+	update.DisplayName = strings.TrimSpace(update.DisplayName)
+	update.Bio = strings.TrimSpace(update.Bio)
+	update.Website = strings.TrimSpace(update.Website)
+	if utf8.RuneCountInString(update.DisplayName) > maxDisplayNameLength || utf8.RuneCountInString(update.Bio) > maxBioLength {
+		models.Log.Warning("个人资料过长:", userID)
+		return PublicProfile{}, models.ErrInvalidRequest
+	}
+	if update.Website != "" && !validProfileURL(update.Website) {
+		models.Log.Warning("个人网站地址无效:", userID, update.Website)
+		return PublicProfile{}, models.ErrInvalidRequest
+	}
+	if len(update.SocialLinks) > maxSocialLinks {
+		models.Log.Warning("社交账号数量过多:", userID, len(update.SocialLinks))
+		return PublicProfile{}, models.ErrInvalidRequest
+	}
+	links := map[string]string{}
+	for name, link := range update.SocialLinks {
+		name = strings.ToLower(strings.TrimSpace(name))
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		if !socialNamePattern.MatchString(name) || !validProfileURL(link) {
+			models.Log.Warning("社交账号链接无效:", userID, name)
+			return PublicProfile{}, models.ErrInvalidRequest
+		}
+		links[name] = link
+	}
+
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return PublicProfile{}, apiErr
+	}
+	user.DisplayName = update.DisplayName
+	user.Bio = update.Bio
+	user.Website = update.Website
+	user.SocialLinks = links
+	err := models.DB.Model(&user).Select("DisplayName", "Bio", "Website", "SocialLinks").Updates(&user).Error
+	if err != nil {
+		models.Log.Error("修改个人资料失败:", err)
+		return PublicProfile{}, models.ErrInternalServer
+	}
+	models.Log.Info("用户修改了个人资料:", userID)
+	return NewPublicProfile(user), nil
+}
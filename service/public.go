@@ -0,0 +1,330 @@
+package service
+
+import (
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 公开接口每页最多的文章数量
+const maxPostsPerPage = 50
+
+// PublicTag 公开接口返回的标签
+type PublicTag struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	// 已发布的文章数量，只在标签列表中返回
+	PostCount int64 `json:"post_count,omitempty"`
+}
+
+// PublicPost 公开接口返回的文章，列表中只返回摘要，文章详情中返回全文
+type PublicPost struct {
+	ID           uint          `json:"id"`
+	Title        string        `json:"title"`
+	Excerpt      string        `json:"excerpt"`
+	Content      string        `json:"content,omitempty"`
+	Author       PublicProfile `json:"author"`
+	Tags         []PublicTag   `json:"tags"`
+	CommentCount int64         `json:"comment_count"`
+	PublishedAt  time.Time     `json:"published_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	// 当前用户可以编辑该文章，只在携带令牌访问时返回
+	CanEdit bool `json:"can_edit,omitempty"`
+	// 文章的SEO设置，没有设置时不返回
+	MetaDescription string `json:"meta_description,omitempty"`
+	CanonicalURL    string `json:"canonical_url,omitempty"`
+	SocialImage     string `json:"social_image,omitempty"`
+}
+
+// PublicComment 公开接口返回的评论
+type PublicComment struct {
+	ID        uint          `json:"id"`
+	Content   string        `json:"content"`
+	Author    PublicProfile `json:"author"`
+	CreatedAt time.Time     `json:"created_at"`
+	// 当前用户自己的评论为true，等待审核的评论只有评论者自己可以看到
+	Mine   bool   `json:"mine,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// PostQuery 公开文章列表的查询条件，Tag为标签的Slug，Author为作者的用户名
+type PostQuery struct {
+	Page     int
+	PageSize int
+	Tag      string
+	Author   string
+	// 列表中同时返回文章全文，用于订阅源
+	Content bool
+}
+
+// PostPage 分页的文章列表
+type PostPage struct {
+	Posts      []PublicPost `json:"posts"`
+	Total      int64        `json:"total"`
+	Page       int          `json:"page"`
+	PageSize   int          `json:"page_size"`
+	TotalPages int          `json:"total_pages"`
+}
+
+// 文章摘要，截取去掉Markdown格式后的前300个字节
+func postExcerpt(content string) string {
+	content = MarkdownText(content)
+	excerpt := truncateUTF8(content, 300)
+	if len(excerpt) < len(content) {
+		excerpt += "…"
+	}
+	return excerpt
+}
+
+// 文章的发布时间，没有记录时使用创建时间
+func publishedTime(post models.Post) time.Time {
+	if post.PublishedAt != nil {
+		return *post.PublishedAt
+	}
+	return post.CreatedAt
+}
+
+// 已发布文章的查询
+func publishedPosts() *gorm.DB {
+	return models.DB.Model(&models.Post{}).Where("posts.status = ?", models.PostStatusPublished)
+}
+
+// 各文章已通过审核的评论数量
+func approvedCommentCounts(postIDs []uint) (map[uint]int64, error) {
+	counts := map[uint]int64{}
+	if len(postIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		PostID uint
+		Count  int64
+	}
+	err := models.DB.Model(&models.Comment{}).Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ? AND status = ?", postIDs, models.CommentStatusApproved).
+		Group("post_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.PostID] = row.Count
+	}
+	return counts, nil
+}
+
+func newPublicPost(post models.Post, viewerID interface{}) PublicPost {
+	item := PublicPost{
+		ID:          post.ID,
+		Title:       post.Title,
+		Excerpt:     postExcerpt(post.Content),
+		Author:      NewPublicProfile(post.User),
+		Tags:        make([]PublicTag, 0, len(post.Tags)),
+		PublishedAt: publishedTime(post),
+		UpdatedAt:   post.UpdatedAt,
+
+		MetaDescription: post.MetaDescription,
+		CanonicalURL:    post.CanonicalURL,
+		SocialImage:     post.SocialImage,
+	}
+	for _, tag := range post.Tags {
+		item.Tags = append(item.Tags, PublicTag{Name: tag.Name, Slug: tag.Slug})
+	}
+	if viewerID != nil {
+		item.CanEdit = post.UserID == viewerID || UserCan(viewerID, models.PermPostEditAny)
+	}
+	return item
+}
+
+// ListPublishedPosts 分页获取已发布的文章，按发布时间倒序排列。viewerID为当前用户，匿名访问时为nil
+func ListPublishedPosts(q PostQuery, viewerID interface{}) (PostPage, *models.APIError) {
+	if q.PageSize <= 0 {
+		q.PageSize = config.Conf.Site.PostsPerPage
+	}
+	if q.PageSize > maxPostsPerPage {
+		q.PageSize = maxPostsPerPage
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	page := PostPage{Posts: []PublicPost{}, Page: q.Page, PageSize: q.PageSize}
+
+	query := publishedPosts()
+	if q.Tag != "" {
+		query = query.Where("posts.id IN (?)", models.DB.Table("post_tags").Select("post_tags.post_id").
+			Joins("JOIN tags ON tags.id = post_tags.tag_id").Where("tags.slug = ?", q.Tag))
+	}
+	if q.Author != "" {
+		query = query.Where("posts.user_id IN (?)", models.DB.Model(&models.User{}).Select("id").Where("user_name = ?", q.Author))
+	}
+	if err := query.Count(&page.Total).Error; err != nil {
+		models.Log.Error("统计文章数量失败:", err)
+		return page, models.ErrInternalServer
+	}
+	page.TotalPages = int((page.Total + int64(q.PageSize) - 1) / int64(q.PageSize))
+
+	var posts []models.Post
+	err := query.Preload("User").Preload("Tags").Order("posts.published_at DESC, posts.id DESC").
+		Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).Find(&posts).Error
+	if err != nil {
+		models.Log.Error("获取已发布文章失败:", err)
+		return page, models.ErrInternalServer
+	}
+	ids := make([]uint, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	counts, err := approvedCommentCounts(ids)
+	if err != nil {
+		models.Log.Error("统计评论数量失败:", err)
+		return page, models.ErrInternalServer
+	}
+	for _, post := range posts {
+		item := newPublicPost(post, viewerID)
+		item.CommentCount = counts[post.ID]
+		if q.Content {
+			item.Content = post.Content
+		}
+		page.Posts = append(page.Posts, item)
+	}
+	return page, nil
+}
+
+// GetPublishedPost 获取已发布文章的全文，草稿返回不存在
+func GetPublishedPost(id uint, viewerID interface{}) (PublicPost, *models.APIError) {
+	var post models.Post
+	err := publishedPosts().Preload("User").Preload("Tags").Where("posts.id = ?", id).First(&post).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("未找到已发布的文章:", id)
+			return PublicPost{}, models.ErrPostNotFound
+		}
+		models.Log.Error("获取文章失败:", err)
+		return PublicPost{}, models.ErrInternalServer
+	}
+	counts, err := approvedCommentCounts([]uint{post.ID})
+	if err != nil {
+		models.Log.Error("统计评论数量失败:", err)
+		return PublicPost{}, models.ErrInternalServer
+	}
+	item := newPublicPost(post, viewerID)
+	item.Content = post.Content
+	item.CommentCount = counts[post.ID]
+	return item, nil
+}
+
+// GetPublicComments 获取已发布文章的评论，按时间正序排列。登录用户同时可以看到自己等待审核的评论
+func GetPublicComments(postID uint, viewerID interface{}) ([]PublicComment, *models.APIError) {
+	var count int64
+	if err := publishedPosts().Where("posts.id = ?", postID).Count(&count).Error; err != nil {
+		models.Log.Error("查询文章失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	if count == 0 {
+		models.Log.Warning("未找到已发布的文章:", postID)
+		return nil, models.ErrPostNotFound
+	}
+	query := models.DB.Preload("User").Where("post_id = ?", postID)
+	if viewerID != nil {
+		query = query.Where("status = ? OR (user_id = ? AND status = ?)", models.CommentStatusApproved, viewerID, models.CommentStatusPending)
+	} else {
+		query = query.Where("status = ?", models.CommentStatusApproved)
+	}
+	var comments []models.Comment
+	if err := query.Order("created_at, id").Find(&comments).Error; err != nil {
+		models.Log.Error("获取文章评论失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	items := make([]PublicComment, 0, len(comments))
+	for _, comment := range comments {
+		item := PublicComment{
+			ID:        comment.ID,
+			Content:   comment.Content,
+			Author:    NewPublicProfile(comment.User),
+			CreatedAt: comment.CreatedAt,
+			Mine:      viewerID != nil && comment.UserID == viewerID,
+		}
+		if comment.Status != models.CommentStatusApproved {
+			item.Status = comment.Status
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// GetPublicTags 获取有已发布文章的标签及文章数量，按文章数量倒序排列
+func GetPublicTags() ([]PublicTag, *models.APIError) {
+	tags := []PublicTag{}
+	err := models.DB.Table("tags").Select("tags.name, tags.slug, COUNT(posts.id) AS post_count").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL AND posts.status = ?", models.PostStatusPublished).
+		Group("tags.id, tags.name, tags.slug").Order("post_count DESC, tags.name").Scan(&tags).Error
+	if err != nil {
+		models.Log.Error("获取标签列表失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return tags, nil
+}
+
+// GetTag 按Slug获取标签
+func GetTag(slug string) (PublicTag, *models.APIError) {
+	var tag models.Tag
+	if err := models.DB.Where("slug = ?", slug).First(&tag).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("标签不存在:", slug)
+			return PublicTag{}, models.ErrTagNotFound
+		}
+		models.Log.Error("获取标签失败:", err)
+		return PublicTag{}, models.ErrInternalServer
+	}
+	return PublicTag{Name: tag.Name, Slug: tag.Slug}, nil
+}
+
+// GetPublicAuthor 按用户名获取作者的公开资料
+func GetPublicAuthor(username string) (PublicProfile, *models.APIError) {
+	var user models.User
+	if err := models.DB.Where("user_name = ?", username).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("作者不存在:", username)
+			return PublicProfile{}, models.ErrUserNotFound
+		}
+		models.Log.Error("查询作者失败:", err)
+		return PublicProfile{}, models.ErrInternalServer
+	}
+	return NewPublicProfile(user), nil
+}
+
+// ArchivePost 归档中的文章
+type ArchivePost struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// ArchiveMonth 归档中一个月发布的文章
+type ArchiveMonth struct {
+	Year  int           `json:"year"`
+	Month int           `json:"month"`
+	Posts []ArchivePost `json:"posts"`
+}
+
+// GetArchive 获取全部已发布文章的标题，按发布月份分组，按发布时间倒序排列
+func GetArchive() ([]ArchiveMonth, *models.APIError) {
+	var posts []models.Post
+	err := publishedPosts().Select("id, title, published_at, created_at").
+		Order("published_at DESC, id DESC").Find(&posts).Error
+	if err != nil {
+		models.Log.Error("获取文章归档失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	months := []ArchiveMonth{}
+	for _, post := range posts {
+		t := publishedTime(post)
+		if n := len(months); n == 0 || months[n-1].Year != t.Year() || months[n-1].Month != int(t.Month()) {
+			months = append(months, ArchiveMonth{Year: t.Year(), Month: int(t.Month())})
+		}
+		last := &months[len(months)-1]
+		last.Posts = append(last.Posts, ArchivePost{ID: post.ID, Title: post.Title, PublishedAt: t})
+	}
+	return months, nil
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetUserRole 获取用户当前的角色
+func GetUserRole(userID uint) (string, *models.APIError) {
+	var user models.User
+	err := models.DB.Select("id,role").Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("用户不存在:", userID)
+			return "", models.ErrUserNotFound
+		}
+		models.Log.Error("查询用户角色失败:", err)
+		return "", models.ErrInternalServer
+	}
+	return user.Role, nil
+}
+
+// UserCan 判断用户是否拥有指定权限，查询失败时视为没有权限
+func UserCan(userID interface{}, permission string) bool {
+	id, ok := userID.(uint)
+	if !ok {
+		return false
+	}
+	role, apiErr := GetUserRole(id)
+	if apiErr != nil {
+		return false
+	}
+	return models.HasPermission(role, permission)
+}
+
+// 创建新注册的用户并分配角色，忽略user中原有的角色。
+// 事务中先锁定设置表中的注册锁记录，再统计用户数量和创建用户，并发注册时只有第一个用户成为管理员。
+// 不能直接对用户表加锁：MySQL中空表上的SELECT COUNT(*) FOR UPDATE只加间隙锁，间隙锁之间互不阻塞，
+// 两个首次注册会同时看到0个用户，或者在插入时互相死锁
+func createRegisteredUser(user *models.User) error {
+	// 锁记录在事务外创建，已存在时忽略，避免并发插入同一主键时相互等待
+	err := models.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.Setting{Name: models.SettingRegistrationLock, Value: "null"}).Error
+	if err != nil {
+		return err
+	}
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		var lock models.Setting
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("name = ?", models.SettingRegistrationLock).First(&lock).Error
+		if err != nil {
+			return err
+		}
+		role, err := registerRole(tx)
+		if err != nil {
+			return err
+		}
+		user.Role = role
+		return tx.Create(user).Error
+	})
+}
+
+// 新注册用户的角色，第一个注册的用户自动成为管理员。调用方需要持有注册锁
+func registerRole(tx *gorm.DB) (string, error) {
+	var count int64
+	if err := tx.Model(&models.User{}).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return models.RoleAdmin, nil
+	}
+	if models.IsValidRole(config.Conf.User.DefaultRole) {
+		return config.Conf.User.DefaultRole, nil
+	}
+	return models.RoleAuthor, nil
+}
+
+// EnsureAdmin 系统中没有管理员时，将最早注册的用户设置为管理员
+func EnsureAdmin() {
+	var count int64
+	if err := models.DB.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count).Error; err != nil {
+		models.Log.Error("查询管理员失败:", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+	var user models.User
+	if err := models.DB.Order("id").First(&user).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			models.Log.Error("查询用户失败:", err)
+		}
+		return
+	}
+	if err := models.DB.Model(&user).Update("role", models.RoleAdmin).Error; err != nil {
+		models.Log.Error("设置管理员失败:", err)
+		return
+	}
+	models.Log.Info("已将最早注册的用户设置为管理员:", user.UserName)
+}
+
+// SetUserRole 为用户分配角色，不允许移除最后一个管理员
+func SetUserRole(operatorID uint, userID uint, role string) *models.APIError {
+	if !models.IsValidRole(role) {
+		models.Log.Warning("无效的角色:", role)
+		return models.ErrInvalidRequest
+	}
+	DB := models.DB
+	var user models.User
+	if err := DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("用户不存在:", userID)
+			return models.ErrUserNotFound
+		}
+		models.Log.Error("查询用户失败:", err)
+		return models.ErrInternalServer
+	}
+	if user.Role == models.RoleAdmin && role != models.RoleAdmin {
+		var count int64
+		if err := DB.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count).Error; err != nil {
+			models.Log.Error("查询管理员失败:", err)
+			return models.ErrInternalServer
+		}
+		if count <= 1 {
+			models.Log.Warning("不能移除最后一个管理员:", userID)
+			return &models.APIError{
+				Code:    409,
+				Message: "不能移除最后一个管理员",
+				Details: "系统中至少需要保留一个管理员",
+			}
+		}
+	}
+	if err := DB.Model(&user).Update("role", role).Error; err != nil {
+		models.Log.Error("更新用户角色失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("用户角色被更新:", user.UserName, role, "操作人:", operatorID)
+	return nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+func TestRegisterUserRole(t *testing.T) {
+	setupTestDB(t)
+	for _, name := range []string{"alice", "bob"} {
+		// 请求中携带的角色被忽略
+		user := models.User{UserName: name, Email: name + "@example.com", Password: "correct-horse-" + name, Role: models.RoleAdmin}
+		if apiErr := RegisterUser(user); apiErr != nil {
+			t.Fatal("注册失败:", name, apiErr.Message)
+		}
+	}
+	var lock models.Setting
+	if err := models.DB.Where("name = ?", models.SettingRegistrationLock).First(&lock).Error; err != nil {
+		t.Fatal("没有创建注册锁记录:", err)
+	}
+	for name, want := range map[string]string{"alice": models.RoleAdmin, "bob": config.Conf.User.DefaultRole} {
+		var user models.User
+		models.DB.Where("user_name = ?", name).First(&user)
+		if user.Role != want {
+			t.Errorf("%s的角色为%q，应为%q", name, user.Role, want)
+		}
+	}
+}
+
+// SQLite会忽略FOR UPDATE，写事务之间由数据库整体的写锁串行执行，
+// 因此本测试只能检查结果，无法覆盖MySQL中注册锁记录的行锁，需要在MySQL上另行验证
+func TestConcurrentFirstRegistration(t *testing.T) {
+	setupTestDB(t)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("user%d", i)
+			user := models.User{UserName: name, Email: name + "@example.com", Password: "-"}
+			<-start
+			// 并发时部分注册可能因为数据库锁冲突失败，但不能产生多个管理员
+			createRegisteredUser(&user)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	var users, admins int64
+	models.DB.Model(&models.User{}).Count(&users)
+	models.DB.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&admins)
+	if users == 0 || admins != 1 {
+		t.Fatalf("注册了%d个用户，其中%d个管理员，应只有1个管理员", users, admins)
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 文章SEO设置的最大长度
+const (
+	maxMetaDescriptionLen = 300
+	maxSEOURLLen          = 500
+)
+
+// PostSEO 文章的SEO设置，更新文章时为nil的字段不修改，为空字符串时清除
+type PostSEO struct {
+	MetaDescription *string `json:"meta_description"`
+	CanonicalURL    *string `json:"canonical_url"`
+	SocialImage     *string `json:"social_image"`
+}
+
+// Apply 将设置写入文章
+func (s PostSEO) Apply(post *models.Post) {
+	if s.MetaDescription != nil {
+		post.MetaDescription = strings.TrimSpace(*s.MetaDescription)
+	}
+	if s.CanonicalURL != nil {
+		post.CanonicalURL = strings.TrimSpace(*s.CanonicalURL)
+	}
+	if s.SocialImage != nil {
+		post.SocialImage = strings.TrimSpace(*s.SocialImage)
+	}
+}
+
+// 需要更新的字段，用map保证空字符串也会写入
+func (s PostSEO) columns(post models.Post) map[string]interface{} {
+	columns := map[string]interface{}{}
+	if s.MetaDescription != nil {
+		columns["meta_description"] = post.MetaDescription
+	}
+	if s.CanonicalURL != nil {
+		columns["canonical_url"] = post.CanonicalURL
+	}
+	if s.SocialImage != nil {
+		columns["social_image"] = post.SocialImage
+	}
+	return columns
+}
+
+// 是否为完整的http或https地址
+func isAbsoluteHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// 校验文章的SEO设置：规范地址必须是完整的地址，分享图片可以是完整地址或以/开头的站内地址
+func validPostSEO(post models.Post) bool {
+	if utf8.RuneCountInString(post.MetaDescription) > maxMetaDescriptionLen {
+		return false
+	}
+	if len(post.CanonicalURL) > maxSEOURLLen || post.CanonicalURL != "" && !isAbsoluteHTTPURL(post.CanonicalURL) {
+		return false
+	}
+	if len(post.SocialImage) > maxSEOURLLen {
+		return false
+	}
+	return post.SocialImage == "" || isAbsoluteHTTPURL(post.SocialImage) ||
+		strings.HasPrefix(post.SocialImage, "/") && !strings.HasPrefix(post.SocialImage, "//")
+}
+
+// PostMeta 文章页面中的SEO元数据，地址均为完整地址
+type PostMeta struct {
+	Canonical   string
+	Image       string
+	AuthorURL   string
+	Published   time.Time
+	Modified    time.Time
+	Tags        []string
+	TwitterCard string
+	TwitterSite string
+	// 结构化数据，在模板中输出为JSON
+	JSONLD BlogPosting
+}
+
+// BlogPosting schema.org的BlogPosting结构化数据
+type BlogPosting struct {
+	Context          string     `json:"@context"`
+	Type             string     `json:"@type"`
+	Headline         string     `json:"headline"`
+	Description      string     `json:"description,omitempty"`
+	URL              string     `json:"url"`
+	MainEntityOfPage string     `json:"mainEntityOfPage"`
+	Image            string     `json:"image,omitempty"`
+	DatePublished    string     `json:"datePublished"`
+	DateModified     string     `json:"dateModified"`
+	Keywords         string     `json:"keywords,omitempty"`
+	Author           SchemaNode `json:"author"`
+	Publisher        SchemaNode `json:"publisher"`
+}
+
+// SchemaNode 结构化数据中的人物或组织
+type SchemaNode struct {
+	Type  string `json:"@type"`
+	Name  string `json:"name"`
+	URL   string `json:"url,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+// 文章内容中第一张可以公开访问的图片
+func firstImage(content string) string {
+	for _, m := range mdImage.FindAllStringSubmatch(content, -1) {
+		if isAbsoluteHTTPURL(m[2]) || strings.HasPrefix(m[2], "/") && !strings.HasPrefix(m[2], "//") {
+			return m[2]
+		}
+	}
+	return ""
+}
+
+// PostDescription 文章的描述，没有设置时使用摘要
+func PostDescription(post PublicPost) string {
+	if post.MetaDescription != "" {
+		return post.MetaDescription
+	}
+	return post.Excerpt
+}
+
+// PostCanonicalURL 文章的规范地址，没有设置时为文章页面的地址
+func PostCanonicalURL(post PublicPost) string {
+	if post.CanonicalURL != "" {
+		return post.CanonicalURL
+	}
+	return absoluteURL(PostPath(post.ID))
+}
+
+// NewPostMeta 生成文章页面的SEO元数据，post需要包含全文
+func NewPostMeta(post PublicPost) PostMeta {
+	meta := PostMeta{
+		Canonical:   PostCanonicalURL(post),
+		AuthorURL:   absoluteURL(AuthorPath(post.Author.Username)),
+		Published:   post.PublishedAt,
+		Modified:    post.UpdatedAt,
+		Tags:        make([]string, 0, len(post.Tags)),
+		TwitterCard: "summary",
+		TwitterSite: config.Conf.SEO.TwitterSite,
+	}
+	image := post.SocialImage
+	if image == "" {
+		image = firstImage(post.Content)
+	}
+	if image != "" {
+		meta.Image = absoluteURL(image)
+		meta.TwitterCard = "summary_large_image"
+	}
+	for _, tag := range post.Tags {
+		meta.Tags = append(meta.Tags, tag.Name)
+	}
+	meta.JSONLD = BlogPosting{
+		Context:          "https://schema.org",
+		Type:             "BlogPosting",
+		Headline:         post.Title,
+		Description:      PostDescription(post),
+		URL:              absoluteURL(PostPath(post.ID)),
+		MainEntityOfPage: meta.Canonical,
+		Image:            meta.Image,
+		DatePublished:    post.PublishedAt.Format(time.RFC3339),
+		DateModified:     post.UpdatedAt.Format(time.RFC3339),
+		Keywords:         strings.Join(meta.Tags, ","),
+		Author: SchemaNode{
+			Type:  "Person",
+			Name:  post.Author.DisplayName,
+			URL:   meta.AuthorURL,
+			Image: absoluteURL(post.Author.AvatarURL),
+		},
+		Publisher: SchemaNode{
+			Type: "Organization",
+			Name: config.Conf.Site.Name,
+			URL:  absoluteURL("/"),
+		},
+	}
+	return meta
+}
+
+// RobotsTxt robots.txt的内容，没有配置完整内容时根据禁止抓取的路径生成，并附上sitemap的地址
+func RobotsTxt() string {
+	if robots := config.Conf.SEO.Robots; robots != "" {
+		return strings.TrimRight(robots, "\n") + "\n"
+	}
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if len(config.Conf.SEO.RobotsDisallow) == 0 {
+		b.WriteString("Disallow:\n")
+	}
+	for _, path := range config.Conf.SEO.RobotsDisallow {
+		b.WriteString("Disallow: " + path + "\n")
+	}
+	b.WriteString("\nSitemap: " + absoluteURL(SitemapPath) + "\n")
+	return b.String()
+}
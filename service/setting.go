@@ -0,0 +1,34 @@
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetSetting 读取系统设置并解析到value中，设置不存在时value保持不变
+func GetSetting(name string, value interface{}) error {
+	var setting models.Setting
+	err := models.DB.Where("name = ?", name).First(&setting).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal([]byte(setting.Value), value)
+}
+
+// SaveSetting 保存系统设置
+func SaveSetting(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return models.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&models.Setting{Name: name, Value: string(data)}).Error
+}
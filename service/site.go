@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// SitePage 公开页面使用的主题模板和模板数据
+type SitePage struct {
+	Template string
+	Data     map[string]interface{}
+}
+
+// Pagination 分页链接，没有上一页或下一页时对应的地址为空
+type Pagination struct {
+	Page       int
+	TotalPages int
+	Prev       string
+	Next       string
+}
+
+// PostPath 文章页面的地址
+func PostPath(id uint) string {
+	return fmt.Sprintf("/post/%d", id)
+}
+
+// TagPath 标签页面的地址
+func TagPath(slug string) string {
+	return "/tags/" + url.PathEscape(slug)
+}
+
+// AuthorPath 作者主页的地址
+func AuthorPath(username string) string {
+	return "/authors/" + url.PathEscape(username)
+}
+
+// PagedPath 列表第page页的地址，第一页为列表本身的地址
+func PagedPath(base string, page int) string {
+	if page <= 1 {
+		if base == "" {
+			return "/"
+		}
+		return base
+	}
+	return fmt.Sprintf("%s/page/%d", base, page)
+}
+
+func newPagination(base string, page PostPage) Pagination {
+	p := Pagination{Page: page.Page, TotalPages: page.TotalPages}
+	if page.Page > 1 {
+		p.Prev = PagedPath(base, page.Page-1)
+	}
+	if page.Page < page.TotalPages {
+		p.Next = PagedPath(base, page.Page+1)
+	}
+	return p
+}
+
+// 所有页面共用的模板数据，Feeds为页面中订阅源的自动发现链接
+func newSitePage(template, title string) SitePage {
+	return SitePage{
+		Template: template,
+		Data: map[string]interface{}{
+			"Site":        config.Conf.Site,
+			"Title":       title,
+			"Description": config.Conf.Site.Description,
+			"Feeds":       feedLinks("", config.Conf.Site.Name),
+		},
+	}
+}
+
+// 超出范围的页码返回页面不存在，没有文章时第一页仍然可以访问
+func listPage(q PostQuery, viewerID interface{}) (PostPage, *models.APIError) {
+	page, apiErr := ListPublishedPosts(q, viewerID)
+	if apiErr != nil {
+		return page, apiErr
+	}
+	if page.Page > 1 && page.Page > page.TotalPages {
+		return page, models.ErrPostNotFound
+	}
+	return page, nil
+}
+
+// SiteHome 首页，分页显示已发布的文章
+func SiteHome(pageNum int, viewerID interface{}) (SitePage, *models.APIError) {
+	posts, apiErr := listPage(PostQuery{Page: pageNum}, viewerID)
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	title := ""
+	if posts.Page > 1 {
+		title = fmt.Sprintf("第%d页", posts.Page)
+	}
+	page := newSitePage("index", title)
+	page.Data["Posts"] = posts
+	page.Data["Pagination"] = newPagination("", posts)
+	return page, nil
+}
+
+// SitePost 文章页面，包括文章全文和已通过审核的评论
+func SitePost(id uint, viewerID interface{}) (SitePage, *models.APIError) {
+	post, apiErr := GetPublishedPost(id, viewerID)
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	comments, apiErr := GetPublicComments(id, viewerID)
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	page := newSitePage("post", post.Title)
+	page.Data["Description"] = PostDescription(post)
+	page.Data["Post"] = post
+	page.Data["SEO"] = NewPostMeta(post)
+	page.Data["Comments"] = comments
+	return page, nil
+}
+
+// SiteTags 标签列表页面
+func SiteTags() (SitePage, *models.APIError) {
+	tags, apiErr := GetPublicTags()
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	page := newSitePage("tags", "标签")
+	page.Data["Tags"] = tags
+	return page, nil
+}
+
+// SiteTag 标签页面，分页显示标签下已发布的文章
+func SiteTag(slug string, pageNum int, viewerID interface{}) (SitePage, *models.APIError) {
+	tag, apiErr := GetTag(slug)
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	posts, apiErr := listPage(PostQuery{Page: pageNum, Tag: tag.Slug}, viewerID)
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	page := newSitePage("tag", "标签："+tag.Name)
+	page.Data["Tag"] = tag
+	page.Data["Feeds"] = append(feedLinks(TagPath(tag.Slug), "标签："+tag.Name), feedLinks("", config.Conf.Site.Name)...)
+	page.Data["Posts"] = posts
+	page.Data["Pagination"] = newPagination(TagPath(tag.Slug), posts)
+	return page, nil
+}
+
+// SiteArchive 归档页面，按月列出全部已发布的文章
+func SiteArchive() (SitePage, *models.APIError) {
+	archive, apiErr := GetArchive()
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	page := newSitePage("archive", "归档")
+	page.Data["Archive"] = archive
+	return page, nil
+}
+
+// SiteAuthor 作者主页，显示作者资料并分页显示作者已发布的文章
+func SiteAuthor(username string, pageNum int, viewerID interface{}) (SitePage, *models.APIError) {
+	author, apiErr := GetPublicAuthor(username)
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	posts, apiErr := listPage(PostQuery{Page: pageNum, Author: author.Username}, viewerID)
+	if apiErr != nil {
+		return SitePage{}, apiErr
+	}
+	page := newSitePage("author", author.DisplayName)
+	if author.Bio != "" {
+		page.Data["Description"] = author.Bio
+	}
+	page.Data["Author"] = author
+	page.Data["Feeds"] = append(feedLinks(AuthorPath(author.Username), author.DisplayName), feedLinks("", config.Conf.Site.Name)...)
+	page.Data["Posts"] = posts
+	page.Data["Pagination"] = newPagination(AuthorPath(author.Username), posts)
+	return page, nil
+}
+
+// SiteNotFound 页面不存在
+func SiteNotFound() SitePage {
+	return newSitePage("404", "页面不存在")
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// SitemapPath sitemap的地址，地址数量超过上限时为sitemap索引
+const SitemapPath = "/sitemap.xml"
+
+// 单个sitemap文件最多的地址数量，由sitemap协议规定
+var sitemapMaxURLs = 50000
+
+// SitemapURL sitemap中的一个地址
+type SitemapURL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+// SitemapPartPath 拆分后第n个sitemap文件的地址，从1开始
+func SitemapPartPath(n int) string {
+	return fmt.Sprintf("/sitemaps/%d.xml", n)
+}
+
+// 需要被搜索引擎收录的全部地址：首页、标签列表、归档、已发布的文章、标签页面和作者主页，
+// 修改时间为对应文章最近的修改时间。规范地址指向其他站点的文章不收录
+func sitemapURLs() ([]SitemapURL, error) {
+	var posts []models.Post
+	err := publishedPosts().Select("posts.id, posts.user_id, posts.updated_at, posts.canonical_url").
+		Order("posts.id").Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	var latest time.Time
+	authorMod := map[uint]time.Time{}
+	postMod := map[uint]time.Time{}
+	for _, post := range posts {
+		postMod[post.ID] = post.UpdatedAt
+		if post.UpdatedAt.After(latest) {
+			latest = post.UpdatedAt
+		}
+		if post.UpdatedAt.After(authorMod[post.UserID]) {
+			authorMod[post.UserID] = post.UpdatedAt
+		}
+	}
+
+	urls := []SitemapURL{
+		{Loc: absoluteURL("/"), LastMod: latest},
+		{Loc: absoluteURL("/tags"), LastMod: latest},
+		{Loc: absoluteURL("/archive"), LastMod: latest},
+	}
+	for _, post := range posts {
+		if post.CanonicalURL != "" {
+			continue
+		}
+		urls = append(urls, SitemapURL{Loc: absoluteURL(PostPath(post.ID)), LastMod: post.UpdatedAt})
+	}
+
+	var postTags []struct {
+		PostID uint
+		Slug   string
+	}
+	err = models.DB.Table("post_tags").Select("post_tags.post_id, tags.slug").
+		Joins("JOIN tags ON tags.id = post_tags.tag_id").Order("tags.slug").Scan(&postTags).Error
+	if err != nil {
+		return nil, err
+	}
+	var slugs []string
+	tagMod := map[string]time.Time{}
+	for _, pt := range postTags {
+		mod, ok := postMod[pt.PostID]
+		if !ok {
+			continue
+		}
+		if _, seen := tagMod[pt.Slug]; !seen {
+			slugs = append(slugs, pt.Slug)
+		}
+		if mod.After(tagMod[pt.Slug]) {
+			tagMod[pt.Slug] = mod
+		}
+	}
+	for _, slug := range slugs {
+		urls = append(urls, SitemapURL{Loc: absoluteURL(TagPath(slug)), LastMod: tagMod[slug]})
+	}
+
+	if len(authorMod) > 0 {
+		ids := make([]uint, 0, len(authorMod))
+		for id := range authorMod {
+			ids = append(ids, id)
+		}
+		var users []models.User
+		if err := models.DB.Select("id, user_name").Where("id IN ?", ids).Order("id").Find(&users).Error; err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			urls = append(urls, SitemapURL{Loc: absoluteURL(AuthorPath(user.UserName)), LastMod: authorMod[user.ID]})
+		}
+	}
+	return urls, nil
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name         `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapElement `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name         `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapElement `xml:"sitemap"`
+}
+
+type sitemapElement struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func newSitemapElement(loc string, mod time.Time) sitemapElement {
+	e := sitemapElement{Loc: loc}
+	if !mod.IsZero() {
+		e.LastMod = mod.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// 一组地址中最近的修改时间
+func latestMod(urls []SitemapURL) time.Time {
+	var latest time.Time
+	for _, u := range urls {
+		if u.LastMod.After(latest) {
+			latest = u.LastMod
+		}
+	}
+	return latest
+}
+
+func encodeURLSet(urls []SitemapURL) ([]byte, error) {
+	set := sitemapURLSet{URLs: make([]sitemapElement, 0, len(urls))}
+	for _, u := range urls {
+		set.URLs = append(set.URLs, newSitemapElement(u.Loc, u.LastMod))
+	}
+	return marshalXML(set)
+}
+
+// Sitemap 生成sitemap，地址数量超过上限时生成sitemap索引，指向拆分后的各个文件。同时返回最近的修改时间
+func Sitemap() ([]byte, time.Time, *models.APIError) {
+	urls, err := sitemapURLs()
+	if err != nil {
+		models.Log.Error("生成sitemap失败:", err)
+		return nil, time.Time{}, models.ErrInternalServer
+	}
+	var data []byte
+	if len(urls) <= sitemapMaxURLs {
+		data, err = encodeURLSet(urls)
+	} else {
+		index := sitemapIndex{}
+		for n := 1; (n-1)*sitemapMaxURLs < len(urls); n++ {
+			part := urls[(n-1)*sitemapMaxURLs : min(n*sitemapMaxURLs, len(urls))]
+			index.Sitemaps = append(index.Sitemaps, newSitemapElement(absoluteURL(SitemapPartPath(n)), latestMod(part)))
+		}
+		data, err = marshalXML(index)
+	}
+	if err != nil {
+		models.Log.Error("生成sitemap失败:", err)
+		return nil, time.Time{}, models.ErrInternalServer
+	}
+	return data, latestMod(urls), nil
+}
+
+// SitemapPart 拆分后的第n个sitemap文件，地址数量没有超过上限时不拆分，返回不存在
+func SitemapPart(n int) ([]byte, time.Time, *models.APIError) {
+	urls, err := sitemapURLs()
+	if err != nil {
+		models.Log.Error("生成sitemap失败:", err)
+		return nil, time.Time{}, models.ErrInternalServer
+	}
+	if len(urls) <= sitemapMaxURLs || n < 1 || (n-1)*sitemapMaxURLs >= len(urls) {
+		return nil, time.Time{}, models.ErrPostNotFound
+	}
+	part := urls[(n-1)*sitemapMaxURLs : min(n*sitemapMaxURLs, len(urls))]
+	data, err := encodeURLSet(part)
+	if err != nil {
+		models.Log.Error("生成sitemap失败:", err)
+		return nil, time.Time{}, models.ErrInternalServer
+	}
+	return data, latestMod(part), nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// SpamRule 垃圾评论检测规则，返回该规则给出的得分和原因，得分为0表示未命中
+type SpamRule interface {
+	Name() string
+	Check(comment *models.Comment) (float64, string, error)
+}
+
+// SpamResult 垃圾评论检测结果
+type SpamResult struct {
+	Score   float64
+	Reasons []string
+}
+
+// 已注册的检测规则，按注册顺序执行
+var spamRules []SpamRule
+
+// RegisterSpamRule 注册自定义的垃圾评论检测规则
+func RegisterSpamRule(rule SpamRule) {
+	spamRules = append(spamRules, rule)
+}
+
+func init() {
+	RegisterSpamRule(linkRule{})
+	RegisterSpamRule(blocklistRule{})
+	RegisterSpamRule(duplicateRule{})
+	RegisterSpamRule(velocityRule{})
+	RegisterSpamRule(bayesRule{})
+}
+
+// CheckSpam 依次执行所有检测规则并累加得分，单条规则出错时跳过该规则
+func CheckSpam(comment *models.Comment) SpamResult {
+	var result SpamResult
+	if !config.Conf.Spam.Enabled {
+		return result
+	}
+	for _, rule := range spamRules {
+		score, reason, err := rule.Check(comment)
+		if err != nil {
+			models.Log.Error("垃圾评论检测规则执行失败:", rule.Name(), err)
+			continue
+		}
+		if score > 0 {
+			result.Score += score
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%s: %s", rule.Name(), reason))
+		}
+	}
+	return result
+}
+
+// 根据检测得分得出评论状态，未达到阈值时返回空字符串
+func spamStatus(score float64) string {
+	conf := config.Conf.Spam
+	if conf.SpamScore > 0 && score >= conf.SpamScore {
+		return models.CommentStatusSpam
+	}
+	if conf.HoldScore > 0 && score >= conf.HoldScore {
+		return models.CommentStatusPending
+	}
+	return ""
+}
+
+// ContentHash 计算评论内容的指纹，忽略大小写和空白差异
+func ContentHash(content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// 按字节长度截断字符串，不会截断多字节字符
+func truncateUTF8(s string, n int) string {
+	for len(s) > n {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// 链接数量检测
+type linkRule struct{}
+
+func (linkRule) Name() string { return "links" }
+
+func (linkRule) Check(comment *models.Comment) (float64, string, error) {
+	count := len(linkPattern.FindAllString(comment.Content, -1))
+	maxLinks := config.Conf.Spam.MaxLinks
+	if count <= maxLinks {
+		return 0, "", nil
+	}
+	// 超出一个链接记1分，之后每多一个加0.25分
+	return 1 + 0.25*float64(count-maxLinks-1), fmt.Sprintf("包含%d个链接", count), nil
+}
+
+// 屏蔽词检测
+type blocklistRule struct{}
+
+func (blocklistRule) Name() string { return "blocklist" }
+
+func (blocklistRule) Check(comment *models.Comment) (float64, string, error) {
+	content := strings.ToLower(comment.Content)
+	var hits []string
+	for _, word := range config.Conf.Spam.Blocklist {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" && strings.Contains(content, word) {
+			hits = append(hits, word)
+		}
+	}
+	if len(hits) == 0 {
+		return 0, "", nil
+	}
+	return 2 * float64(len(hits)), "命中屏蔽词 " + strings.Join(hits, ","), nil
+}
+
+// 重复内容检测
+type duplicateRule struct{}
+
+func (duplicateRule) Name() string { return "duplicate" }
+
+func (duplicateRule) Check(comment *models.Comment) (float64, string, error) {
+	if comment.ContentHash == "" {
+		comment.ContentHash = ContentHash(comment.Content)
+	}
+	query := models.DB.Model(&models.Comment{}).Where("content_hash = ?", comment.ContentHash)
+	if window := config.Conf.Spam.DuplicateWindow; window > 0 {
+		query = query.Where("created_at > ?", time.Now().Add(-time.Duration(window)*time.Minute))
+	}
+	var duplicates []models.Comment
+	if err := query.Select("id,user_id").Limit(20).Find(&duplicates).Error; err != nil {
+		return 0, "", err
+	}
+	if len(duplicates) == 0 {
+		return 0, "", nil
+	}
+	for _, duplicate := range duplicates {
+		if duplicate.UserID == comment.UserID {
+			return 2, "重复发布相同内容", nil
+		}
+	}
+	return 1, fmt.Sprintf("与%d条其他用户的评论内容相同", len(duplicates)), nil
+}
+
+// 评论频率检测，按IP和用户分别统计
+type velocityRule struct{}
+
+func (velocityRule) Name() string { return "velocity" }
+
+func (velocityRule) Check(comment *models.Comment) (float64, string, error) {
+	conf := config.Conf.Spam
+	if conf.VelocityWindow <= 0 {
+		return 0, "", nil
+	}
+	since := time.Now().Add(-time.Duration(conf.VelocityWindow) * time.Second)
+	var score float64
+	var reasons []string
+
+	if conf.IPLimit > 0 && comment.IP != "" {
+		var count int64
+		err := models.DB.Model(&models.Comment{}).
+			Where("ip = ? AND created_at > ?", comment.IP, since).
+			Count(&count).Error
+		if err != nil {
+			return 0, "", err
+		}
+		if count >= int64(conf.IPLimit) {
+			score += 1.5
+			reasons = append(reasons, fmt.Sprintf("IP在%d秒内已发布%d条评论", conf.VelocityWindow, count))
+		}
+	}
+
+	if conf.UserLimit > 0 {
+		var count int64
+		err := models.DB.Model(&models.Comment{}).
+			Where("user_id = ? AND created_at > ?", comment.UserID, since).
+			Count(&count).Error
+		if err != nil {
+			return 0, "", err
+		}
+		if count >= int64(conf.UserLimit) {
+			score += 1.5
+			reasons = append(reasons, fmt.Sprintf("用户在%d秒内已发布%d条评论", conf.VelocityWindow, count))
+		}
+	}
+	return score, strings.Join(reasons, ","), nil
+}
+
+// 贝叶斯分类器检测
+type bayesRule struct{}
+
+func (bayesRule) Name() string { return "bayes" }
+
+func (bayesRule) Check(comment *models.Comment) (float64, string, error) {
+	probability, trained, err := SpamProbability(comment.Content)
+	if err != nil || !trained || probability <= 0.5 {
+		return 0, "", err
+	}
+	// 概率从0.5到1映射为0到2分
+	return (probability - 0.5) * 4, fmt.Sprintf("垃圾评论概率%.2f", probability), nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// StaticPost 导出静态站点时的文章页面
+type StaticPost struct {
+	ID uint
+	// 页面内容最近的修改时间：文章、作者、标签以及评论和评论者资料中最新的修改时间
+	ModTime time.Time
+}
+
+// StaticSitePosts 全部已发布的文章及其页面的修改时间，用于增量生成静态站点
+func StaticSitePosts() ([]StaticPost, *models.APIError) {
+	var posts []models.Post
+	if err := publishedPosts().Preload("User").Order("posts.id").Find(&posts).Error; err != nil {
+		models.Log.Error("获取已发布文章失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	if len(posts) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	// 评论的修改和删除以及评论者修改资料都会改变文章页面
+	var comments []struct {
+		PostID    uint
+		UpdatedAt time.Time
+		DeletedAt *time.Time
+		UserMod   time.Time
+	}
+	err := models.DB.Model(&models.Comment{}).Unscoped().
+		Select("comments.post_id, comments.updated_at, comments.deleted_at, users.updated_at AS user_mod").
+		Joins("JOIN users ON users.id = comments.user_id").
+		Where("comments.post_id IN ?", ids).Scan(&comments).Error
+	if err != nil {
+		models.Log.Error("获取文章评论失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	mod := map[uint]time.Time{}
+	latest := func(id uint, t time.Time) {
+		if t.After(mod[id]) {
+			mod[id] = t
+		}
+	}
+	for _, post := range posts {
+		latest(post.ID, post.UpdatedAt)
+		latest(post.ID, post.User.UpdatedAt)
+	}
+	for _, c := range comments {
+		latest(c.PostID, c.UpdatedAt)
+		latest(c.PostID, c.UserMod)
+		if c.DeletedAt != nil {
+			latest(c.PostID, *c.DeletedAt)
+		}
+	}
+	// 标签没有修改时间，新建的标签以创建时间计算
+	var tags []struct {
+		PostID    uint
+		CreatedAt time.Time
+	}
+	err = models.DB.Table("post_tags").Select("post_tags.post_id, tags.created_at").
+		Joins("JOIN tags ON tags.id = post_tags.tag_id").Where("post_tags.post_id IN ?", ids).Scan(&tags).Error
+	if err != nil {
+		models.Log.Error("获取文章标签失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	for _, t := range tags {
+		latest(t.PostID, t.CreatedAt)
+	}
+
+	items := make([]StaticPost, 0, len(posts))
+	for _, post := range posts {
+		items = append(items, StaticPost{ID: post.ID, ModTime: mod[post.ID]})
+	}
+	return items, nil
+}
+
+// StaticSiteAuthors 有已发布文章的作者的用户名
+func StaticSiteAuthors() ([]string, *models.APIError) {
+	var names []string
+	err := models.DB.Model(&models.User{}).Distinct("users.user_name").
+		Joins("JOIN posts ON posts.user_id = users.id AND posts.deleted_at IS NULL AND posts.status = ?", models.PostStatusPublished).
+		Order("users.user_name").Pluck("users.user_name", &names).Error
+	if err != nil {
+		models.Log.Error("获取作者列表失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return names, nil
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/storage"
+	"gorm.io/gorm/clause"
+)
+
+// 存储中各类文件的键名前缀
+const (
+	avatarKeyPrefix = "avatars/"
+	mediaKeyPrefix  = "media/"
+	exportKeyPrefix = "exports/"
+)
+
+// StorageFilesPath 本地存储签名链接的访问路径
+const StorageFilesPath = "/files"
+
+// StorageKeyPrefixes 存储中全部文件的键名前缀，迁移存储时使用
+var StorageKeyPrefixes = []string{avatarKeyPrefix, mediaKeyPrefix, exportKeyPrefix}
+
+var (
+	storageMu   sync.Mutex
+	fileStorage storage.Storage
+)
+
+// NewStorage 根据配置创建指定方式的存储，用于读写文件；本地存储不能生成签名链接
+func NewStorage(driver string) (storage.Storage, error) {
+	switch driver {
+	case "local":
+		return storage.NewLocal(config.Conf.Storage.Local.Dir, StorageFilesPath, nil), nil
+	case "s3":
+		s3 := config.Conf.Storage.S3
+		return storage.NewS3(storage.S3Options{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			PathStyle: s3.PathStyle,
+		})
+	}
+	return nil, fmt.Errorf("不支持的存储方式: %s", driver)
+}
+
+// 上传文件使用的存储，首次使用时根据配置创建
+func getStorage() (storage.Storage, error) {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	if fileStorage != nil {
+		return fileStorage, nil
+	}
+	s, err := NewStorage(config.Conf.Storage.Driver)
+	if err != nil {
+		return nil, err
+	}
+	if local, ok := s.(*storage.Local); ok {
+		secret, err := storageSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		local.Secret = secret
+	}
+	fileStorage = s
+	return s, nil
+}
+
+// InitStorage 初始化上传文件的存储，配置有误时记录错误
+func InitStorage() {
+	if _, err := getStorage(); err != nil {
+		models.Log.Error("初始化文件存储失败:", err)
+		return
+	}
+	models.Log.Info("文件存储:", config.Conf.Storage.Driver)
+}
+
+// 本地存储签名链接使用的密钥，首次使用时生成并保存在系统设置中，多个实例共用
+func storageSigningKey() ([]byte, error) {
+	var key string
+	if err := GetSetting(models.SettingStorageSigningKey, &key); err != nil {
+		return nil, err
+	}
+	if key == "" {
+		data := fmt.Sprintf("%q", RandomToken(32))
+		// 其他实例同时生成时以先写入的为准
+		err := models.DB.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.Setting{Name: models.SettingStorageSigningKey, Value: data}).Error
+		if err != nil {
+			return nil, err
+		}
+		if err := GetSetting(models.SettingStorageSigningKey, &key); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(key), nil
+}
+
+// 写入文件
+func putFile(key string, data []byte, contentType string) error {
+	s, err := getStorage()
+	if err != nil {
+		return err
+	}
+	return s.Put(key, bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// 读取文件的全部内容
+func readFile(key string) ([]byte, error) {
+	s, err := getStorage()
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := s.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// 删除文件，文件不存在时不返回错误
+func deleteFile(key string) error {
+	s, err := getStorage()
+	if err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
+
+// StoredFile 从存储中读取的文件，使用后需要关闭
+type StoredFile struct {
+	io.ReadCloser
+	storage.Info
+}
+
+// 打开文件，文件不存在时返回notFound
+func openFile(key string, notFound *models.APIError) (StoredFile, *models.APIError) {
+	s, err := getStorage()
+	if err != nil {
+		models.Log.Error("初始化文件存储失败:", err)
+		return StoredFile{}, models.ErrInternalServer
+	}
+	r, info, err := s.Open(key)
+	if err != nil {
+		if err == storage.ErrNotExist || err == storage.ErrInvalidKey {
+			return StoredFile{}, notFound
+		}
+		models.Log.Error("读取文件失败:", key, err)
+		return StoredFile{}, models.ErrInternalServer
+	}
+	if info.ContentType == "" {
+		info.ContentType = "application/octet-stream"
+	}
+	return StoredFile{ReadCloser: r, Info: info}, nil
+}
+
+// 私有文件的签名下载链接，downloadName为下载的文件名
+func signedFileURL(key, downloadName string) (string, error) {
+	s, err := getStorage()
+	if err != nil {
+		return "", err
+	}
+	return s.SignedURL(key, time.Duration(config.Conf.Storage.SignedURLTTL)*time.Minute, downloadName)
+}
+
+// OpenSignedFile 验证本地存储的签名链接并打开文件，只有使用本地存储时可用
+func OpenSignedFile(key, expires, downloadName, signature string) (StoredFile, *models.APIError) {
+	notFound := &models.APIError{Code: http.StatusNotFound, Message: "文件不存在", Details: "下载链接无效或已过期"}
+	s, err := getStorage()
+	if err != nil {
+		models.Log.Error("初始化文件存储失败:", err)
+		return StoredFile{}, models.ErrInternalServer
+	}
+	local, ok := s.(*storage.Local)
+	if !ok || !local.Verify(key, expires, downloadName, signature) {
+		models.Log.Warning("签名链接无效:", key)
+		return StoredFile{}, notFound
+	}
+	return openFile(key, notFound)
+}
+
+// StorageMigration 迁移存储的结果
+type StorageMigration struct {
+	Files   int
+	Bytes   int64
+	Skipped int
+}
+
+// MigrateStorage 将src中指定前缀的文件复制到dst，dst中已存在且大小相同的文件跳过；
+// remove为true时复制成功后删除src中的文件，dryRun为true时只统计不复制
+func MigrateStorage(src, dst storage.Storage, prefixes []string, remove, dryRun bool, progress func(storage.Info)) (StorageMigration, error) {
+	var result StorageMigration
+	for _, prefix := range prefixes {
+		err := src.List(prefix, func(info storage.Info) error {
+			if existing, err := dst.Stat(info.Key); err == nil && existing.Size == info.Size {
+				result.Skipped++
+			} else {
+				if err != nil && err != storage.ErrNotExist {
+					return err
+				}
+				if !dryRun {
+					if _, err := storage.Copy(src, dst, info.Key); err != nil {
+						return fmt.Errorf("%s: %w", info.Key, err)
+					}
+				}
+				result.Files++
+				result.Bytes += info.Size
+				if progress != nil {
+					progress(info)
+				}
+			}
+			if remove && !dryRun {
+				return src.Delete(info.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/s3test"
+	"github.com/xiaohan1995/Gin-blog/storage"
+)
+
+// 使用临时目录作为本地存储，并启动本地对象存储，结束后恢复配置
+func setupTestStorage(t *testing.T) {
+	t.Helper()
+	_, server := s3test.NewServer("access", "secret", "blog")
+	previous := config.Conf.Storage
+	config.Conf.Storage.Driver = "local"
+	config.Conf.Storage.Local.Dir = t.TempDir()
+	config.Conf.Storage.S3 = config.S3StorageConfig{Endpoint: server.URL, Bucket: "blog", AccessKey: "access", SecretKey: "secret", PathStyle: true}
+	resetStorage := func() {
+		storageMu.Lock()
+		fileStorage = nil
+		storageMu.Unlock()
+	}
+	resetStorage()
+	t.Cleanup(func() {
+		server.Close()
+		config.Conf.Storage = previous
+		resetStorage()
+	})
+}
+
+func TestOpenSignedFile(t *testing.T) {
+	setupTestDB(t)
+	setupTestStorage(t)
+	if err := putFile("exports/1/export.zip", []byte("archive"), "application/zip"); err != nil {
+		t.Fatal(err)
+	}
+	link, err := signedFileURL("exports/1/export.zip", "导出.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasPrefix(u.Path, StorageFilesPath+"/") {
+		t.Fatalf("签名链接 = %s", link)
+	}
+	key := strings.TrimPrefix(u.Path, StorageFilesPath+"/")
+	q := u.Query()
+
+	file, apiErr := OpenSignedFile(key, q.Get("expires"), q.Get("name"), q.Get("signature"))
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if string(data) != "archive" || file.ContentType != "application/zip" {
+		t.Fatalf("下载的文件 = %q, %+v", data, file.Info)
+	}
+	if _, apiErr := OpenSignedFile("exports/2/export.zip", q.Get("expires"), q.Get("name"), q.Get("signature")); apiErr == nil || apiErr.Code != 404 {
+		t.Fatalf("使用其他文件的签名 = %v", apiErr)
+	}
+	if _, apiErr := OpenSignedFile(key, "1", q.Get("name"), q.Get("signature")); apiErr == nil || apiErr.Code != 404 {
+		t.Fatalf("修改过期时间 = %v", apiErr)
+	}
+}
+
+func TestMigrateStorage(t *testing.T) {
+	setupTestDB(t)
+	setupTestStorage(t)
+	src, err := NewStorage("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := NewStorage("s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"avatars/1.png":       "png",
+		"media/ab/hash.jpg":   "jpeg",
+		"exports/1/data.zip":  "zip",
+		"other/not-uploaded":  "x",
+		"media/cd/hash-2.jpg": "jpeg-2",
+	}
+	for key, content := range files {
+		if err := src.Put(key, strings.NewReader(content), int64(len(content)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// dry-run只统计，不复制
+	result, err := MigrateStorage(src, dst, StorageKeyPrefixes, false, true, nil)
+	if err != nil || result.Files != 4 {
+		t.Fatalf("dry-run = %+v, %v", result, err)
+	}
+	if _, err := dst.Stat("avatars/1.png"); err != storage.ErrNotExist {
+		t.Fatal("dry-run复制了文件")
+	}
+
+	var migrated []string
+	result, err = MigrateStorage(src, dst, StorageKeyPrefixes, false, false, func(info storage.Info) {
+		migrated = append(migrated, info.Key)
+	})
+	if err != nil || result.Files != 4 || result.Bytes != 3+4+3+6 || len(migrated) != 4 {
+		t.Fatalf("迁移结果 = %+v, %v", result, err)
+	}
+	for key, content := range files {
+		r, _, err := dst.Open(key)
+		if key == "other/not-uploaded" {
+			if err != storage.ErrNotExist {
+				t.Error("迁移了不属于上传文件的前缀")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(key, err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if string(data) != content {
+			t.Errorf("%s = %q", key, data)
+		}
+	}
+
+	// 中断后重新运行时跳过已存在的文件，大小不同的文件重新复制
+	dst.Put("media/ab/hash.jpg", strings.NewReader("partial"), 7, "")
+	result, err = MigrateStorage(src, dst, StorageKeyPrefixes, true, false, nil)
+	if err != nil || result.Files != 1 || result.Skipped != 3 {
+		t.Fatalf("重新迁移 = %+v, %v", result, err)
+	}
+	// 复制成功后删除源文件
+	for _, prefix := range StorageKeyPrefixes {
+		src.List(prefix, func(info storage.Info) error {
+			t.Errorf("源存储中仍有文件: %s", info.Key)
+			return nil
+		})
+	}
+	if _, err := src.Stat("other/not-uploaded"); err != nil {
+		t.Fatal("删除了不属于上传文件的前缀:", err)
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 每篇文章最多的标签数量和标签名称的最大长度
+const (
+	maxPostTags   = 10
+	maxTagNameLen = 32
+)
+
+// TagSlug 生成标签的Slug：字母和数字转为小写，其他字符替换为连字符，支持中文等非ASCII字符
+func TagSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.TrimSpace(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// ResolveTags 根据标签名称查找标签，不存在时创建。重复的标签只保留一个
+func ResolveTags(names []string) ([]models.Tag, *models.APIError) {
+	tags := []models.Tag{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		slug := TagSlug(name)
+		if slug == "" || utf8.RuneCountInString(name) > maxTagNameLen {
+			models.Log.Warning("标签名称无效:", name)
+			return nil, models.ErrInvalidRequest
+		}
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		tags = append(tags, models.Tag{Name: name, Slug: truncateUTF8(slug, 64)})
+	}
+	if len(tags) > maxPostTags {
+		models.Log.Warning("标签数量过多:", len(tags))
+		return nil, models.ErrInvalidRequest
+	}
+	for i := range tags {
+		err := models.DB.Where("slug = ?", tags[i].Slug).Attrs(models.Tag{Name: tags[i].Name}).FirstOrCreate(&tags[i]).Error
+		if err != nil {
+			// 并发创建同一标签时唯一索引冲突，重新查询已创建的标签
+			err = models.DB.Where("slug = ?", tags[i].Slug).First(&tags[i]).Error
+		}
+		if err != nil {
+			models.Log.Error("创建标签失败:", err)
+			return nil, models.ErrInternalServer
+		}
+	}
+	return tags, nil
+}
+
+// 替换文章的标签
+func replacePostTags(db *gorm.DB, post *models.Post, tags []models.Tag) error {
+	return db.Model(post).Association("Tags").Replace(tags)
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TokenPair 登录或刷新后返回的令牌
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// 访问令牌的有效期（秒）
+	ExpiresIn int `json:"expires_in"`
+}
+
+// RandomToken 生成指定字节数的随机令牌，使用URL安全的Base64编码
+func RandomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		models.Log.Error("生成随机令牌失败")
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// HashToken 计算令牌的哈希值，数据库中只保存哈希
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func accessTTL() time.Duration {
+	return time.Duration(config.Conf.JWT.AccessTTL) * time.Minute
+}
+
+func refreshTTL() time.Duration {
+	return time.Duration(config.Conf.JWT.RefreshTTL) * time.Hour
+}
+
+// 在指定的令牌家族中签发一对新令牌，返回新刷新令牌的记录ID
+func issueTokenPair(tx *gorm.DB, user models.User, familyID, ip, userAgent string) (TokenPair, uint, error) {
+	accessToken, err := GenerateJWT(user.ID, user.UserName, familyID)
+	if err != nil {
+		return TokenPair{}, 0, err
+	}
+	refreshToken := RandomToken(32)
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: HashToken(refreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(refreshTTL()),
+		IP:        ip,
+		UserAgent: truncateUTF8(userAgent, 255),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return TokenPair{}, 0, err
+	}
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTTL().Seconds()),
+	}, record.ID, nil
+}
+
+// IssueTokens 为登录成功的用户创建新的会话（令牌家族）并签发令牌
+func IssueTokens(user models.User, ip, userAgent string) (TokenPair, error) {
+	pair, _, err := issueTokenPair(models.DB, user, RandomToken(16), ip, userAgent)
+	return pair, err
+}
+
+// RefreshTokens 使用刷新令牌换取新的令牌，旧刷新令牌随即失效。
+// 已使用或已吊销的刷新令牌再次出现时，认为令牌已泄露，吊销整个令牌家族
+func RefreshTokens(refreshToken, ip, userAgent string) (TokenPair, *models.APIError) {
+	DB := models.DB
+	var record models.RefreshToken
+	err := DB.Where("token_hash = ?", HashToken(refreshToken)).First(&record).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("刷新令牌不存在")
+			return TokenPair{}, models.ErrInvalidRefreshToken
+		}
+		models.Log.Error("查询刷新令牌失败:", err)
+		return TokenPair{}, models.ErrInternalServer
+	}
+	if record.UsedAt != nil || record.RevokedAt != nil {
+		models.Log.Warning("检测到刷新令牌重复使用，吊销令牌家族:", record.FamilyID, "用户:", record.UserID, "IP:", ip)
+		if err := RevokeTokenFamily(record.FamilyID); err != nil {
+			models.Log.Error("吊销令牌家族失败:", err)
+		}
+		return TokenPair{}, models.ErrInvalidRefreshToken
+	}
+	if time.Now().After(record.ExpiresAt) {
+		models.Log.Warning("刷新令牌已过期:", record.ID)
+		return TokenPair{}, models.ErrInvalidRefreshToken
+	}
+	var user models.User
+	if err := DB.Where("id = ?", record.UserID).First(&user).Error; err != nil {
+		models.Log.Warning("刷新令牌对应的用户不存在:", record.UserID)
+		return TokenPair{}, models.ErrInvalidRefreshToken
+	}
+
+	var pair TokenPair
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// 通过条件更新保证同一个刷新令牌只能使用一次
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", record.ID).
+			Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return models.ErrInvalidRefreshToken
+		}
+		var newID uint
+		var err error
+		pair, newID, err = issueTokenPair(tx, user, record.FamilyID, ip, userAgent)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).Where("id = ?", record.ID).Update("replaced_by", newID).Error
+	})
+	if err != nil {
+		if err == models.ErrInvalidRefreshToken {
+			models.Log.Warning("刷新令牌并发重复使用，吊销令牌家族:", record.FamilyID)
+			if err := RevokeTokenFamily(record.FamilyID); err != nil {
+				models.Log.Error("吊销令牌家族失败:", err)
+			}
+			return TokenPair{}, models.ErrInvalidRefreshToken
+		}
+		models.Log.Error("刷新令牌失败:", err)
+		return TokenPair{}, models.ErrInternalServer
+	}
+	return pair, nil
+}
+
+// 将令牌ID或会话ID加入吊销列表，并清理已过期的记录
+func revokeTokenIDs(expiresAt time.Time, tokenIDs ...string) error {
+	DB := models.DB
+	if err := DB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		models.Log.Error("清理吊销列表失败:", err)
+	}
+	rows := make([]models.RevokedToken, 0, len(tokenIDs))
+	for _, id := range tokenIDs {
+		if id != "" {
+			rows = append(rows, models.RevokedToken{TokenID: id, ExpiresAt: expiresAt})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+	}).Create(&rows).Error
+}
+
+// RevokeTokenFamily 吊销整个令牌家族：所有刷新令牌失效，已签发的访问令牌通过会话ID进入吊销列表
+func RevokeTokenFamily(familyID string) error {
+	err := models.DB.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	// 访问令牌最晚在最后一次签发后accessTTL过期
+	return revokeTokenIDs(time.Now().Add(accessTTL()), familyID)
+}
+
+// RevokeUserTokens 吊销用户的全部会话，exceptFamily不为空时保留该会话
+func RevokeUserTokens(userID uint, exceptFamily string) error {
+	var families []string
+	query := models.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now())
+	if exceptFamily != "" {
+		query = query.Where("family_id <> ?", exceptFamily)
+	}
+	if err := query.Distinct().Pluck("family_id", &families).Error; err != nil {
+		return err
+	}
+	for _, family := range families {
+		if err := RevokeTokenFamily(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Logout 退出当前会话
+func Logout(claims *JWTClaims) *models.APIError {
+	expiresAt := time.Now().Add(accessTTL())
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := revokeTokenIDs(expiresAt, claims.ID); err != nil {
+		models.Log.Error("吊销访问令牌失败:", err)
+		return models.ErrInternalServer
+	}
+	if claims.SessionID != "" {
+		if err := RevokeTokenFamily(claims.SessionID); err != nil {
+			models.Log.Error("吊销会话失败:", err)
+			return models.ErrInternalServer
+		}
+	}
+	models.Log.Info("用户退出登录:", claims.Username)
+	return nil
+}
+
+// LogoutAll 退出用户的全部会话
+func LogoutAll(claims *JWTClaims) *models.APIError {
+	if err := RevokeUserTokens(claims.UserID, ""); err != nil {
+		models.Log.Error("吊销用户会话失败:", err)
+		return models.ErrInternalServer
+	}
+	// 当前令牌可能不属于任何会话，单独吊销
+	if apiErr := Logout(claims); apiErr != nil {
+		return apiErr
+	}
+	models.Log.Info("用户退出全部会话:", claims.Username)
+	return nil
+}
+
+// IsTokenRevoked 检查访问令牌的jti或所属会话是否已被吊销
+func IsTokenRevoked(claims *JWTClaims) (bool, error) {
+	ids := []string{claims.ID}
+	if claims.SessionID != "" {
+		ids = append(ids, claims.SessionID)
+	}
+	var count int64
+	err := models.DB.Model(&models.RevokedToken{}).
+		Where("token_id IN ? AND expires_at > ?", ids, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
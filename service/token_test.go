@@ -0,0 +1,129 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 访问令牌是否有效且未被吊销
+func accessTokenValid(t *testing.T, token string) bool {
+	t.Helper()
+	claims, err := ParseJWT(token)
+	if err != nil {
+		return false
+	}
+	revoked, err := IsTokenRevoked(claims)
+	if err != nil {
+		t.Fatal("查询吊销列表失败:", err)
+	}
+	return !revoked
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	first, err := IssueTokens(user, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, apiErr := RefreshTokens(first.RefreshToken, "127.0.0.1", "test")
+	if apiErr != nil {
+		t.Fatal("刷新失败:", apiErr.Message)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("刷新后应签发新的刷新令牌")
+	}
+	if !accessTokenValid(t, second.AccessToken) {
+		t.Fatal("新的访问令牌无效")
+	}
+	third, apiErr := RefreshTokens(second.RefreshToken, "127.0.0.1", "test")
+	if apiErr != nil {
+		t.Fatal("使用新的刷新令牌刷新失败:", apiErr.Message)
+	}
+	if _, apiErr := RefreshTokens("unknown", "127.0.0.1", "test"); apiErr != models.ErrInvalidRefreshToken {
+		t.Fatal("不存在的刷新令牌应该无效")
+	}
+	if !accessTokenValid(t, third.AccessToken) {
+		t.Fatal("无效的刷新令牌不应影响现有会话")
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	first, _ := IssueTokens(user, "127.0.0.1", "test")
+	other, _ := IssueTokens(user, "127.0.0.1", "other")
+	second, apiErr := RefreshTokens(first.RefreshToken, "127.0.0.1", "test")
+	if apiErr != nil {
+		t.Fatal(apiErr.Message)
+	}
+
+	// 已使用的刷新令牌再次出现，整个会话被吊销
+	if _, apiErr := RefreshTokens(first.RefreshToken, "10.0.0.1", "attacker"); apiErr != models.ErrInvalidRefreshToken {
+		t.Fatal("重复使用的刷新令牌应该无效")
+	}
+	if _, apiErr := RefreshTokens(second.RefreshToken, "127.0.0.1", "test"); apiErr != models.ErrInvalidRefreshToken {
+		t.Fatal("重复使用后同一会话的新刷新令牌也应失效")
+	}
+	if accessTokenValid(t, second.AccessToken) || accessTokenValid(t, first.AccessToken) {
+		t.Fatal("重复使用后同一会话的访问令牌应被吊销")
+	}
+	// 同一用户的其他会话不受影响
+	if !accessTokenValid(t, other.AccessToken) {
+		t.Fatal("其他会话的访问令牌不应被吊销")
+	}
+	if _, apiErr := RefreshTokens(other.RefreshToken, "127.0.0.1", "other"); apiErr != nil {
+		t.Fatal("其他会话应该可以刷新:", apiErr.Message)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	pair, _ := IssueTokens(user, "127.0.0.1", "test")
+	other, _ := IssueTokens(user, "127.0.0.1", "other")
+	claims, err := ParseJWT(pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiErr := Logout(claims); apiErr != nil {
+		t.Fatal(apiErr.Message)
+	}
+	if accessTokenValid(t, pair.AccessToken) {
+		t.Fatal("退出后访问令牌应被吊销")
+	}
+	if _, apiErr := RefreshTokens(pair.RefreshToken, "127.0.0.1", "test"); apiErr == nil {
+		t.Fatal("退出后刷新令牌应该无效")
+	}
+
+	claims, _ = ParseJWT(other.AccessToken)
+	if apiErr := LogoutAll(claims); apiErr != nil {
+		t.Fatal(apiErr.Message)
+	}
+	if accessTokenValid(t, other.AccessToken) {
+		t.Fatal("退出全部会话后访问令牌应被吊销")
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	pair, _ := IssueTokens(user, "127.0.0.1", "test")
+	results := make(chan *models.APIError, 5)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, apiErr := RefreshTokens(pair.RefreshToken, "127.0.0.1", "test")
+			results <- apiErr
+		}()
+	}
+	succeeded := 0
+	for i := 0; i < cap(results); i++ {
+		if <-results == nil {
+			succeeded++
+		}
+	}
+	if succeeded > 1 {
+		t.Fatalf("同一个刷新令牌被使用了%d次", succeeded)
+	}
+}
@@ -0,0 +1,478 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// TOTP的时间步长（秒）和允许的时钟偏差（步数）
+	totpPeriod = 30
+	totpSkew   = 1
+	// 登录挑战令牌的有效期
+	challengeTTL = 5 * time.Minute
+	// 每次生成的恢复码数量
+	recoveryCodeCount = 10
+)
+
+// TwoFactorSetup 开始设置两步验证时返回的密钥信息
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URL    string `json:"otpauth_url"`
+	// 二维码PNG图片的data URL
+	QRCode string `json:"qr_code"`
+}
+
+// 根据用户保存的密钥生成otpauth://地址
+func totpKey(user models.User) (*otp.Key, error) {
+	issuer := config.Conf.Site.Name
+	query := url.Values{}
+	query.Set("secret", user.TOTPSecret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", "6")
+	query.Set("period", "30")
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + user.UserName,
+		RawQuery: query.Encode(),
+	}
+	return otp.NewKeyFromURL(u.String())
+}
+
+// 生成二维码PNG图片
+func totpQRCode(key *otp.Key) ([]byte, error) {
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 根据ID查找用户
+func findUser(userID uint) (models.User, *models.APIError) {
+	var user models.User
+	if err := models.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("用户不存在:", userID)
+			return user, models.ErrUserNotFound
+		}
+		models.Log.Error("查询用户失败:", err)
+		return user, models.ErrInternalServer
+	}
+	return user, nil
+}
+
+var errTwoFactorEnabled = &models.APIError{
+	Code:    409,
+	Message: "两步验证已启用",
+	Details: "如需更换设备，请先关闭两步验证",
+}
+
+var errTwoFactorDisabled = &models.APIError{
+	Code:    409,
+	Message: "两步验证未启用",
+	Details: "请先启用两步验证",
+}
+
+// SetupTwoFactor 生成新的TOTP密钥，验证码确认后才会启用
+func SetupTwoFactor(userID uint) (TwoFactorSetup, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return TwoFactorSetup{}, apiErr
+	}
+	if user.TOTPEnabled {
+		return TwoFactorSetup{}, errTwoFactorEnabled
+	}
+	generated, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.Conf.Site.Name,
+		AccountName: user.UserName,
+	})
+	if err != nil {
+		models.Log.Error("生成TOTP密钥失败:", err)
+		return TwoFactorSetup{}, models.ErrInternalServer
+	}
+	user.TOTPSecret = generated.Secret()
+	if err := models.DB.Model(&user).Update("totp_secret", user.TOTPSecret).Error; err != nil {
+		models.Log.Error("保存TOTP密钥失败:", err)
+		return TwoFactorSetup{}, models.ErrInternalServer
+	}
+	key, err := totpKey(user)
+	if err != nil {
+		models.Log.Error("生成TOTP地址失败:", err)
+		return TwoFactorSetup{}, models.ErrInternalServer
+	}
+	image, err := totpQRCode(key)
+	if err != nil {
+		models.Log.Error("生成二维码失败:", err)
+		return TwoFactorSetup{}, models.ErrInternalServer
+	}
+	return TwoFactorSetup{
+		Secret: user.TOTPSecret,
+		URL:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(image),
+	}, nil
+}
+
+// TwoFactorQRCode 获取待确认密钥的二维码PNG图片
+func TwoFactorQRCode(userID uint) ([]byte, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if user.TOTPEnabled {
+		return nil, errTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, &models.APIError{
+			Code:    404,
+			Message: "密钥不存在",
+			Details: "请先生成两步验证密钥",
+		}
+	}
+	key, err := totpKey(user)
+	if err != nil {
+		models.Log.Error("生成TOTP地址失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	image, err := totpQRCode(key)
+	if err != nil {
+		models.Log.Error("生成二维码失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return image, nil
+}
+
+// 校验TOTP验证码，同一时间步的验证码只能使用一次
+func verifyTOTP(user models.User, code string) bool {
+	if user.TOTPSecret == "" || len(code) != 6 {
+		return false
+	}
+	now := time.Now()
+	for i := -totpSkew; i <= totpSkew; i++ {
+		t := now.Add(time.Duration(i*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(user.TOTPSecret, t, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil || subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+		step := t.Unix() / totpPeriod
+		res := models.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if res.Error != nil {
+			models.Log.Error("更新TOTP时间步失败:", res.Error)
+			return false
+		}
+		if res.RowsAffected == 0 {
+			models.Log.Warning("TOTP验证码重复使用:", user.ID)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// 恢复码统一为小写并去掉分隔符
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// 使用恢复码，每个恢复码只能使用一次
+func useRecoveryCode(user models.User, code string) bool {
+	res := models.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		models.Log.Error("使用恢复码失败:", res.Error)
+		return false
+	}
+	if res.RowsAffected > 0 {
+		models.Log.Info("用户使用了恢复码:", user.UserName)
+		return true
+	}
+	return false
+}
+
+// 校验第二步验证，6位数字为TOTP验证码，其余视为恢复码
+func verifySecondFactor(user models.User, code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 && strings.Trim(code, "0123456789") == "" {
+		return verifyTOTP(user, code)
+	}
+	return code != "" && useRecoveryCode(user, code)
+}
+
+// 重新生成恢复码，之前的恢复码全部失效
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: HashToken(raw)})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// EnableTwoFactor 使用验证码确认密钥并启用两步验证，返回恢复码
+func EnableTwoFactor(userID uint, code string) ([]string, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if user.TOTPEnabled {
+		return nil, errTwoFactorEnabled
+	}
+	if !verifyTOTP(user, strings.TrimSpace(code)) {
+		models.Log.Warning("启用两步验证时验证码错误:", user.UserName)
+		return nil, models.ErrInvalidTwoFactorCode
+	}
+	var codes []string
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		models.Log.Error("启用两步验证失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	models.Log.Info("用户启用了两步验证:", user.UserName)
+	return codes, nil
+}
+
+// DisableTwoFactor 关闭两步验证，需要验证码或恢复码，所在角色要求两步验证时不能关闭
+func DisableTwoFactor(userID uint, code string) *models.APIError {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !user.TOTPEnabled {
+		return errTwoFactorDisabled
+	}
+	required, apiErr := roleRequiresTwoFactor(user.Role)
+	if apiErr != nil {
+		return apiErr
+	}
+	if required {
+		models.Log.Warning("角色要求两步验证，不能关闭:", user.UserName)
+		return &models.APIError{
+			Code:    403,
+			Message: "不能关闭两步验证",
+			Details: "您所在的角色要求启用两步验证",
+		}
+	}
+	if !verifySecondFactor(user, code) {
+		models.Log.Warning("关闭两步验证时验证码错误:", user.UserName)
+		return models.ErrInvalidTwoFactorCode
+	}
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		models.Log.Error("关闭两步验证失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("用户关闭了两步验证:", user.UserName)
+	return nil
+}
+
+// RegenerateRecoveryCodes 使用TOTP验证码重新生成恢复码
+func RegenerateRecoveryCodes(userID uint, code string) ([]string, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if !user.TOTPEnabled {
+		return nil, errTwoFactorDisabled
+	}
+	if !verifyTOTP(user, strings.TrimSpace(code)) {
+		models.Log.Warning("重新生成恢复码时验证码错误:", user.UserName)
+		return nil, models.ErrInvalidTwoFactorCode
+	}
+	codes, err := generateRecoveryCodes(models.DB, user.ID)
+	if err != nil {
+		models.Log.Error("生成恢复码失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return codes, nil
+}
+
+// CountRecoveryCodes 获取剩余可用的恢复码数量
+func CountRecoveryCodes(userID uint) (int64, *models.APIError) {
+	var count int64
+	err := models.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	if err != nil {
+		models.Log.Error("查询恢复码失败:", err)
+		return 0, models.ErrInternalServer
+	}
+	return count, nil
+}
+
+// 密码校验通过后生成登录挑战令牌，客户端需要携带该令牌和验证码完成登录
+func twoFactorChallenge(user models.User) (map[string]interface{}, *models.APIError) {
+	token, err := createUserToken(user.ID, models.TokenPurposeLoginChallenge, user.Email, challengeTTL)
+	if err != nil {
+		models.Log.Error("生成登录挑战令牌失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return map[string]interface{}{
+		"state":               1,
+		"message":             "请输入两步验证码",
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_in":          int(challengeTTL.Seconds()),
+	}, nil
+}
+
+// CompleteTwoFactorLogin 使用挑战令牌和验证码（或恢复码）完成登录。
+// 验证码错误计入登录失败次数，与密码错误使用相同的退避和锁定规则
+func CompleteTwoFactorLogin(challengeToken, code, ip, userAgent string) (map[string]interface{}, *models.APIError) {
+	record, apiErr := findUserToken(challengeToken, models.TokenPurposeLoginChallenge)
+	if apiErr != nil {
+		return nil, models.ErrInvalidChallenge
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return nil, models.ErrInvalidChallenge
+	}
+	key := loginKey(user.UserName)
+	if apiErr := checkLoginAllowed(key, ip); apiErr != nil {
+		recordLoginAttempt(user.ID, key, ip, userAgent, models.LoginResultBlocked)
+		return nil, apiErr
+	}
+	if !user.TOTPEnabled || !verifySecondFactor(user, code) {
+		models.Log.Warning("两步验证失败:", user.UserName)
+		recordLoginAttempt(user.ID, key, ip, userAgent, models.LoginResultFailed)
+		return nil, models.ErrInvalidTwoFactorCode
+	}
+	if _, apiErr := consumeUserToken(challengeToken, models.TokenPurposeLoginChallenge); apiErr != nil {
+		return nil, models.ErrInvalidChallenge
+	}
+	recordLoginAttempt(user.ID, key, ip, userAgent, models.LoginResultSuccess)
+	return loginResponse(user, ip, userAgent)
+}
+
+// GetTwoFactorRoles 获取必须启用两步验证的角色
+func GetTwoFactorRoles() ([]string, *models.APIError) {
+	roles := []string{}
+	if err := GetSetting(models.SettingTwoFactorRoles, &roles); err != nil {
+		models.Log.Error("读取两步验证设置失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return roles, nil
+}
+
+// SetTwoFactorRoles 设置必须启用两步验证的角色
+func SetTwoFactorRoles(operatorID uint, roles []string) *models.APIError {
+	for _, role := range roles {
+		if !models.IsValidRole(role) {
+			models.Log.Warning("无效的角色:", role)
+			return models.ErrInvalidRequest
+		}
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	if err := SaveSetting(models.SettingTwoFactorRoles, roles); err != nil {
+		models.Log.Error("保存两步验证设置失败:", err)
+		return models.ErrInternalServer
+	}
+	models.Log.Info("必须启用两步验证的角色被更新:", roles, "操作人:", operatorID)
+	return nil
+}
+
+// 角色是否要求启用两步验证
+func roleRequiresTwoFactor(role string) (bool, *models.APIError) {
+	roles, apiErr := GetTwoFactorRoles()
+	if apiErr != nil {
+		return false, apiErr
+	}
+	for _, r := range roles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 用户所在角色要求两步验证但尚未启用，没有密钥时即使标记为已启用也需要重新设置
+func twoFactorSetupRequired(user models.User) (bool, *models.APIError) {
+	if user.TOTPEnabled && user.TOTPSecret != "" {
+		return false, nil
+	}
+	return roleRequiresTwoFactor(user.Role)
+}
+
+// TwoFactorSetupRequired 用户是否需要先设置两步验证才能使用其他功能
+func TwoFactorSetupRequired(userID uint) (bool, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return false, apiErr
+	}
+	return twoFactorSetupRequired(user)
+}
+
+// GetUserSecurity 获取用户的两步验证状态
+func GetUserSecurity(userID uint) (map[string]interface{}, *models.APIError) {
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	remaining, apiErr := CountRecoveryCodes(user.ID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	required, apiErr := roleRequiresTwoFactor(user.Role)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return map[string]interface{}{
+		"enabled":        user.TOTPEnabled,
+		"required":       required,
+		"recovery_codes": remaining,
+	}, nil
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/xiaohan1995/Gin-blog/models"
+)
+
+// 生成指定时间的TOTP验证码
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, at, totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// 为用户启用两步验证，返回密钥和恢复码
+func enableTestTwoFactor(t *testing.T, user models.User) (string, []string) {
+	t.Helper()
+	setup, apiErr := SetupTwoFactor(user.ID)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	codes, apiErr := EnableTwoFactor(user.ID, totpCode(t, setup.Secret, time.Now()))
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	return setup.Secret, codes
+}
+
+// 登录后返回两步验证的挑战令牌
+func loginChallenge(t *testing.T, user models.User) string {
+	t.Helper()
+	resp, apiErr := LoginUser(user.UserName, user.UserName+"-password", "127.0.0.1", "test")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	token, _ := resp["challenge_token"].(string)
+	if token == "" {
+		t.Fatal("启用两步验证后登录没有返回挑战令牌")
+	}
+	return token
+}
+
+func TestTOTPReplay(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	secret, _ := enableTestTwoFactor(t, user)
+
+	// 启用时使用过的验证码不能再用于登录
+	if _, apiErr := CompleteTwoFactorLogin(loginChallenge(t, user), totpCode(t, secret, time.Now()), "127.0.0.1", "test"); apiErr == nil {
+		t.Fatal("启用时使用过的验证码可以再次使用")
+	}
+	// 允许的时钟偏差内下一个时间步的验证码可以使用一次
+	next := totpCode(t, secret, time.Now().Add(totpPeriod*time.Second))
+	challenge := loginChallenge(t, user)
+	if _, apiErr := CompleteTwoFactorLogin(challenge, next, "127.0.0.1", "test"); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, apiErr := CompleteTwoFactorLogin(loginChallenge(t, user), next, "127.0.0.1", "test"); apiErr == nil {
+		t.Fatal("同一验证码可以登录两次")
+	}
+	// 挑战令牌只能使用一次
+	if _, apiErr := CompleteTwoFactorLogin(challenge, next, "127.0.0.1", "test"); apiErr == nil {
+		t.Fatal("挑战令牌可以重复使用")
+	}
+	// 早于最近一次使用的时间步的验证码同样被拒绝
+	var reloaded models.User
+	models.DB.First(&reloaded, user.ID)
+	if verifyTOTP(reloaded, totpCode(t, secret, time.Now().Add(-totpPeriod*time.Second))) {
+		t.Fatal("旧时间步的验证码可以使用")
+	}
+}
+
+func TestConcurrentTOTPUse(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	secret, _ := enableTestTwoFactor(t, user)
+	models.DB.First(&user, user.ID)
+	code := totpCode(t, secret, time.Now().Add(totpPeriod*time.Second))
+
+	const n = 10
+	results := make(chan bool, n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			<-start
+			results <- verifyTOTP(user, code)
+		}()
+	}
+	close(start)
+	accepted := 0
+	for i := 0; i < n; i++ {
+		if <-results {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("同一验证码被接受了%d次", accepted)
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	_, codes := enableTestTwoFactor(t, user)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("生成了%d个恢复码", len(codes))
+	}
+
+	// 恢复码不区分大小写和分隔符
+	if _, apiErr := CompleteTwoFactorLogin(loginChallenge(t, user), " "+codes[0]+" ", "127.0.0.1", "test"); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, apiErr := CompleteTwoFactorLogin(loginChallenge(t, user), codes[0], "127.0.0.1", "test"); apiErr == nil {
+		t.Fatal("恢复码可以使用两次")
+	}
+	if count, _ := CountRecoveryCodes(user.ID); count != recoveryCodeCount-1 {
+		t.Fatalf("剩余恢复码 = %d", count)
+	}
+}
+
+func TestTwoFactorSetupRequired(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	author := createTestUser(t, "alice", models.RoleAuthor)
+	if apiErr := SetTwoFactorRoles(admin.ID, []string{models.RoleAuthor}); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+
+	if required, _ := TwoFactorSetupRequired(author.ID); !required {
+		t.Fatal("角色要求两步验证时未要求设置")
+	}
+	// 只有启用标记而没有密钥的账号仍然需要设置
+	models.DB.Model(&author).Update("totp_enabled", true)
+	if required, _ := TwoFactorSetupRequired(author.ID); !required {
+		t.Fatal("没有密钥的账号不需要设置两步验证")
+	}
+	models.DB.Model(&author).Update("totp_enabled", false)
+	enableTestTwoFactor(t, author)
+	if required, _ := TwoFactorSetupRequired(author.ID); required {
+		t.Fatal("启用两步验证后仍然要求设置")
+	}
+	if required, _ := TwoFactorSetupRequired(admin.ID); required {
+		t.Fatal("不要求两步验证的角色被要求设置")
+	}
+	// 角色要求两步验证时不能关闭
+	if apiErr := DisableTwoFactor(author.ID, "whatever"); apiErr == nil || apiErr.Code != 403 {
+		t.Fatalf("DisableTwoFactor = %v", apiErr)
+	}
+}
@@ -5,14 +5,17 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/xiaohan1995/Gin-blog/config"
 	"github.com/xiaohan1995/Gin-blog/models"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserResponse struct {
 	UserID    uint      `json:"id"`
 	UserName  string    `json:"user_name"`
 	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Verified  bool      `json:"email_verified"`
+	TwoFactor bool      `json:"totp_enabled"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -23,6 +26,9 @@ func RegisterUser(user models.User) *models.APIError {
 		models.Log.Warning("用户名、邮箱、密码不能为空")
 		return models.ErrInvalidRequest
 	}
+	if apiErr := CheckPasswordPolicy(user.UserName, user.Password); apiErr != nil {
+		return apiErr
+	}
 	// 用户名、邮箱是否注册过
 	var existingUser models.User
 	res := models.DB.Where("user_name = ? OR email =?", user.UserName, user.Email).First(&existingUser)
@@ -34,33 +40,59 @@ func RegisterUser(user models.User) *models.APIError {
 			Details: "用户名、邮箱已存在",
 		}
 	}
-	//加密密码
-	user.Password = EncryptPassword(user.Password)
+	// 只使用用户名、邮箱和密码，邮箱验证状态、两步验证等字段不能由注册请求设置，同时加密密码
+	user = models.User{UserName: user.UserName, Email: user.Email, Password: EncryptPassword(user.Password)}
 
-	if err := models.DB.Create(&user).Error; err != nil {
+	//分配角色，忽略请求中携带的角色
+	if err := createRegisteredUser(&user); err != nil {
 		models.Log.Error("创建新用户失败", err)
 		return models.ErrInternalServer
 	}
 	models.Log.Info("新用户被创建:", user.UserName)
+	// 邮件发送失败不影响注册，用户可以稍后重新发送验证邮件
+	SendVerificationEmail(user)
 	return nil
 }
 
-func LoginUser(username, password string) (map[string]interface{}, *models.APIError) {
+// LoginUser 用户登录，用户不存在和密码错误返回相同的错误。
+// 连续失败后需要等待一段时间才能再次尝试，失败次数过多时账号被临时锁定
+func LoginUser(username, password, ip, userAgent string) (map[string]interface{}, *models.APIError) {
+	key := loginKey(username)
 	//查找用户
 	var user models.User
 	res := models.DB.Where("user_name = ?", username).First(&user)
+	if apiErr := checkLoginAllowed(key, ip); apiErr != nil {
+		recordLoginAttempt(user.ID, key, ip, userAgent, models.LoginResultBlocked)
+		return nil, apiErr
+	}
 	if res.Error != nil {
-		models.Log.Error("用户不存在:", username)
-		return nil, models.ErrUserNotFound
+		models.Log.Warning("用户不存在:", username)
+		VerifyPassword(dummyPasswordHash(), password)
+		recordLoginAttempt(0, key, ip, userAgent, models.LoginResultFailed)
+		return nil, models.ErrInvalidCredentials
 	}
-	//验证密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		models.Log.Error("密码错误:", username)
+	//验证密码，旧算法或旧参数的哈希在验证通过后使用当前配置重新哈希
+	ok, needsRehash := VerifyPassword(user.Password, password)
+	if !ok {
+		models.Log.Warning("密码错误:", username)
+		recordLoginAttempt(user.ID, key, ip, userAgent, models.LoginResultFailed)
 		return nil, models.ErrInvalidCredentials
 	}
+	if needsRehash {
+		rehashPassword(user, password)
+	}
+	//启用两步验证时先返回挑战令牌，验证码通过后再签发令牌
+	if user.TOTPEnabled {
+		return twoFactorChallenge(user)
+	}
+	recordLoginAttempt(user.ID, key, ip, userAgent, models.LoginResultSuccess)
+	return loginResponse(user, ip, userAgent)
+}
 
-	//生成JWT token
-	token, err := GenerateJWT(user.ID, user.UserName)
+// 登录成功，签发令牌并返回用户信息
+func loginResponse(user models.User, ip, userAgent string) (map[string]interface{}, *models.APIError) {
+	//生成访问令牌和刷新令牌
+	tokens, err := IssueTokens(user, ip, userAgent)
 	if err != nil {
 		models.Log.Error("生成JWT失败:", err)
 		return nil, models.ErrInternalServer
@@ -69,14 +101,24 @@ func LoginUser(username, password string) (map[string]interface{}, *models.APIEr
 		UserID:    user.ID,
 		UserName:  user.UserName,
 		Email:     user.Email,
+		Role:      user.Role,
+		Verified:  user.EmailVerified,
+		TwoFactor: user.TOTPEnabled,
 		CreatedAt: user.CreatedAt,
 	}
-	return map[string]interface{}{
-		"state":   0,
-		"message": "登录成功",
-		"data":    UserResponse,
-		"token":   token,
-	}, nil
+	res := map[string]interface{}{
+		"state":         0,
+		"message":       "登录成功",
+		"data":          UserResponse,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	}
+	//所在角色要求两步验证但尚未启用时提示用户设置
+	if required, apiErr := twoFactorSetupRequired(user); apiErr == nil && required {
+		res["two_factor_setup_required"] = true
+	}
+	return res, nil
 }
 
 // 获取用户列表
@@ -93,28 +135,31 @@ func GetUsers() ([]models.User, *models.APIError) {
 type JWTClaims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
+	// 会话ID，即刷新令牌家族ID，用于吊销整个会话
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT 生成JWT Token
-func GenerateJWT(userID uint, username string) (string, error) {
-	// 设置过期时间为24小时
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateJWT 生成JWT Token，每个令牌都有唯一的jti用于吊销
+func GenerateJWT(userID uint, username string, sessionID string) (string, error) {
+	// 访问令牌为短期令牌，过期后使用刷新令牌换取
+	expirationTime := time.Now().Add(accessTTL())
 
 	claims := &JWTClaims{
-		UserID:   userID,
-		Username: username,
+		UserID:    userID,
+		Username:  username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        RandomToken(16),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   "user_token",
+			Issuer:    config.Conf.JWT.Issuer,
+			Audience:  jwt.ClaimStrings{config.Conf.JWT.Audience},
 		},
 	}
-	// 创建token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// 使用密钥签名token，实际项目中应该从环境变量读取
-	tokenString, err := token.SignedString([]byte("your_secret_key_here"))
+	// 使用当前签名密钥签名token
+	tokenString, err := signJWT(claims)
 	if err != nil {
 		models.Log.Error("生成JWT Token失败：", err)
 		return "", err
@@ -122,13 +167,12 @@ func GenerateJWT(userID uint, username string) (string, error) {
 	return tokenString, err
 }
 
-// ParseJWT 解析JWT Token
+// ParseJWT 解析JWT Token，校验签名算法、签发者和接收方
 func ParseJWT(tokenString string) (*JWTClaims, error) {
 	claims := &JWTClaims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte("your_secret_key_here"), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, jwtKeyFunc,
+		jwt.WithValidMethods([]string{jwtAlgorithm()}))
 
 	if err != nil {
 		return nil, err
@@ -139,15 +183,15 @@ func ParseJWT(tokenString string) (*JWTClaims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
-	return claims, nil
-}
+	if !claims.VerifyIssuer(config.Conf.JWT.Issuer, true) {
+		models.Log.Warning("JWT签发者不匹配:", claims.Issuer)
+		return nil, fmt.Errorf("invalid issuer")
+	}
 
-// EncryptPassword 加密密码
-func EncryptPassword(p string) string {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(p), bcrypt.DefaultCost)
-	if err != nil {
-		models.Log.Error("密码加密失败")
-		panic(err)
+	if !claims.VerifyAudience(config.Conf.JWT.Audience, true) {
+		models.Log.Warning("JWT接收方不匹配:", claims.Audience)
+		return nil, fmt.Errorf("invalid audience")
 	}
-	return string(hashedPassword)
+
+	return claims, nil
 }
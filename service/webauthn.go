@@ -0,0 +1,436 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"gorm.io/gorm"
+)
+
+// 通行密钥注册、登录过程的有效期
+const webAuthnSessionTTL = 5 * time.Minute
+
+// 通行密钥会话的用途
+const (
+	webAuthnPurposeRegister = "register"
+	webAuthnPurposeLogin    = "login"
+)
+
+// WebAuthnBegin 开始注册或登录时返回给浏览器的信息，
+// Options传给navigator.credentials.create()或get()，完成时需要携带Session
+type WebAuthnBegin struct {
+	Session string      `json:"session"`
+	Options interface{} `json:"options"`
+}
+
+var errWebAuthnFailed = &models.APIError{
+	Code:    400,
+	Message: "通行密钥验证失败",
+	Details: "请重新尝试",
+}
+
+var errWebAuthnSessionExpired = &models.APIError{
+	Code:    400,
+	Message: "通行密钥请求已过期",
+	Details: "请重新开始",
+}
+
+var webAuthnInstance struct {
+	sync.Mutex
+	w *webauthn.WebAuthn
+}
+
+// 根据配置创建WebAuthn实例
+func getWebAuthn() (*webauthn.WebAuthn, error) {
+	webAuthnInstance.Lock()
+	defer webAuthnInstance.Unlock()
+	if webAuthnInstance.w != nil {
+		return webAuthnInstance.w, nil
+	}
+	conf := config.Conf.WebAuthn
+	name := conf.RPName
+	if name == "" {
+		name = config.Conf.Site.Name
+	}
+	origins := conf.Origins
+	if len(origins) == 0 {
+		origins = []string{strings.TrimRight(config.Conf.Site.BaseURL, "/")}
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          conf.RPID,
+		RPDisplayName: name,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return nil, err
+	}
+	webAuthnInstance.w = w
+	return w, nil
+}
+
+// 实现webauthn.User接口
+type webAuthnUser struct {
+	user        models.User
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(u.user.WebAuthnHandle)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.UserName
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.UserName
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// 加载用户及其全部通行密钥
+func loadWebAuthnUser(user models.User) (*webAuthnUser, error) {
+	var records []models.WebAuthnCredential
+	if err := models.DB.Where("user_id = ?", user.ID).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	u := &webAuthnUser{user: user}
+	for _, record := range records {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(record.Data), &credential); err != nil {
+			models.Log.Error("解析通行密钥失败:", record.ID, err)
+			continue
+		}
+		u.credentials = append(u.credentials, credential)
+	}
+	return u, nil
+}
+
+// 保存注册或登录过程中的会话数据，返回会话令牌
+func saveWebAuthnSession(userID uint, purpose, name string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	// 顺便清理过期的会话
+	if err := models.DB.Where("expires_at < ?", time.Now()).Delete(&models.WebAuthnSession{}).Error; err != nil {
+		models.Log.Error("清理通行密钥会话失败:", err)
+	}
+	token := RandomToken(32)
+	err = models.DB.Create(&models.WebAuthnSession{
+		TokenHash: HashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		Name:      name,
+		Data:      string(data),
+		ExpiresAt: time.Now().Add(webAuthnSessionTTL),
+	}).Error
+	return token, err
+}
+
+// 取出并删除会话数据，每个会话只能使用一次
+func takeWebAuthnSession(token, purpose string) (models.WebAuthnSession, webauthn.SessionData, *models.APIError) {
+	var record models.WebAuthnSession
+	var session webauthn.SessionData
+	err := models.DB.Where("token_hash = ? AND purpose = ?", HashToken(token), purpose).First(&record).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			models.Log.Warning("通行密钥会话不存在")
+			return record, session, errWebAuthnSessionExpired
+		}
+		models.Log.Error("查询通行密钥会话失败:", err)
+		return record, session, models.ErrInternalServer
+	}
+	res := models.DB.Delete(&models.WebAuthnSession{}, record.ID)
+	if res.Error != nil {
+		models.Log.Error("删除通行密钥会话失败:", res.Error)
+		return record, session, models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 || time.Now().After(record.ExpiresAt) {
+		return record, session, errWebAuthnSessionExpired
+	}
+	if err := json.Unmarshal([]byte(record.Data), &session); err != nil {
+		models.Log.Error("解析通行密钥会话失败:", err)
+		return record, session, models.ErrInternalServer
+	}
+	return record, session, nil
+}
+
+// BeginWebAuthnRegistration 开始注册通行密钥
+func BeginWebAuthnRegistration(userID uint, name string) (WebAuthnBegin, *models.APIError) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "通行密钥"
+	}
+	name = truncateUTF8(name, 64)
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return WebAuthnBegin{}, apiErr
+	}
+	// 首次注册时生成随机的用户标识，不使用数据库ID，避免泄露用户信息
+	if user.WebAuthnHandle == "" {
+		user.WebAuthnHandle = RandomToken(32)
+		if err := models.DB.Model(&user).Update("web_authn_handle", user.WebAuthnHandle).Error; err != nil {
+			models.Log.Error("保存通行密钥用户标识失败:", err)
+			return WebAuthnBegin{}, models.ErrInternalServer
+		}
+	}
+	w, err := getWebAuthn()
+	if err != nil {
+		models.Log.Error("初始化WebAuthn失败:", err)
+		return WebAuthnBegin{}, models.ErrInternalServer
+	}
+	wu, err := loadWebAuthnUser(user)
+	if err != nil {
+		models.Log.Error("加载通行密钥失败:", err)
+		return WebAuthnBegin{}, models.ErrInternalServer
+	}
+	// 要求可发现凭证，登录时无需输入用户名；要求用户验证（PIN或生物识别），通行密钥登录不再进行两步验证；
+	// 排除已注册的凭证，避免同一设备重复注册
+	creation, session, err := w.BeginRegistration(wu,
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{UserVerification: protocol.VerificationRequired}),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(wu.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		models.Log.Error("开始注册通行密钥失败:", err)
+		return WebAuthnBegin{}, models.ErrInternalServer
+	}
+	token, err := saveWebAuthnSession(user.ID, webAuthnPurposeRegister, name, session)
+	if err != nil {
+		models.Log.Error("保存通行密钥会话失败:", err)
+		return WebAuthnBegin{}, models.ErrInternalServer
+	}
+	return WebAuthnBegin{Session: token, Options: creation}, nil
+}
+
+// FinishWebAuthnRegistration 校验浏览器返回的注册结果并保存通行密钥
+func FinishWebAuthnRegistration(userID uint, sessionToken string, body []byte) (models.WebAuthnCredential, *models.APIError) {
+	var record models.WebAuthnCredential
+	sessionRecord, session, apiErr := takeWebAuthnSession(sessionToken, webAuthnPurposeRegister)
+	if apiErr != nil {
+		return record, apiErr
+	}
+	if sessionRecord.UserID != userID {
+		models.Log.Warning("通行密钥会话不属于当前用户:", userID)
+		return record, errWebAuthnSessionExpired
+	}
+	user, apiErr := findUser(userID)
+	if apiErr != nil {
+		return record, apiErr
+	}
+	w, err := getWebAuthn()
+	if err != nil {
+		models.Log.Error("初始化WebAuthn失败:", err)
+		return record, models.ErrInternalServer
+	}
+	wu, err := loadWebAuthnUser(user)
+	if err != nil {
+		models.Log.Error("加载通行密钥失败:", err)
+		return record, models.ErrInternalServer
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+	if err != nil {
+		models.Log.Warning("解析通行密钥注册结果失败:", err)
+		return record, errWebAuthnFailed
+	}
+	if !parsed.Response.AttestationObject.AuthData.Flags.HasUserVerified() {
+		models.Log.Warning("注册通行密钥时未进行用户验证:", user.UserName)
+		return record, errWebAuthnFailed
+	}
+	credential, err := w.CreateCredential(wu, session, parsed)
+	if err != nil {
+		models.Log.Warning("通行密钥注册校验失败:", err)
+		return record, errWebAuthnFailed
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		models.Log.Error("序列化通行密钥失败:", err)
+		return record, models.ErrInternalServer
+	}
+	record = models.WebAuthnCredential{
+		UserID:       user.ID,
+		Name:         sessionRecord.Name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Data:         string(data),
+	}
+	if err := models.DB.Create(&record).Error; err != nil {
+		models.Log.Error("保存通行密钥失败:", err)
+		return record, models.ErrInternalServer
+	}
+	models.Log.Info("用户注册了通行密钥:", user.UserName, record.Name)
+	return record, nil
+}
+
+// BeginWebAuthnLogin 开始使用通行密钥登录。填写了用户名且该用户有通行密钥时只允许使用其通行密钥，
+// 否则由浏览器选择可发现凭证，两种情况返回的格式相同，不会泄露用户名是否存在
+func BeginWebAuthnLogin(username string) (WebAuthnBegin, *models.APIError) {
+	w, err := getWebAuthn()
+	if err != nil {
+		models.Log.Error("初始化WebAuthn失败:", err)
+		return WebAuthnBegin{}, models.ErrInternalServer
+	}
+	var wu *webAuthnUser
+	if username != "" {
+		var user models.User
+		if err := models.DB.Where("user_name = ?", username).First(&user).Error; err == nil && user.WebAuthnHandle != "" {
+			wu, err = loadWebAuthnUser(user)
+			if err != nil {
+				models.Log.Error("加载通行密钥失败:", err)
+				return WebAuthnBegin{}, models.ErrInternalServer
+			}
+		}
+	}
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	uv := webauthn.WithUserVerification(protocol.VerificationRequired)
+	if wu != nil && len(wu.credentials) > 0 {
+		assertion, session, err = w.BeginLogin(wu, uv)
+	} else {
+		assertion, session, err = w.BeginDiscoverableLogin(uv)
+	}
+	if err != nil {
+		models.Log.Error("开始通行密钥登录失败:", err)
+		return WebAuthnBegin{}, models.ErrInternalServer
+	}
+	token, err := saveWebAuthnSession(0, webAuthnPurposeLogin, "", session)
+	if err != nil {
+		models.Log.Error("保存通行密钥会话失败:", err)
+		return WebAuthnBegin{}, models.ErrInternalServer
+	}
+	return WebAuthnBegin{Session: token, Options: assertion}, nil
+}
+
+// FinishWebAuthnLogin 校验浏览器返回的签名并登录。认证器必须完成用户验证，
+// 持有设备和PIN或生物识别相当于两个因素，因此无需再进行两步验证
+func FinishWebAuthnLogin(sessionToken string, body []byte, ip, userAgent string) (map[string]interface{}, *models.APIError) {
+	_, session, apiErr := takeWebAuthnSession(sessionToken, webAuthnPurposeLogin)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	w, err := getWebAuthn()
+	if err != nil {
+		models.Log.Error("初始化WebAuthn失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		models.Log.Warning("解析通行密钥登录结果失败:", err)
+		return nil, errWebAuthnFailed
+	}
+	// 未进行用户验证时只证明持有设备，不能代替两步验证
+	if !parsed.Response.AuthenticatorData.Flags.HasUserVerified() {
+		models.Log.Warning("通行密钥登录未进行用户验证:", parsed.ID)
+		return nil, errWebAuthnFailed
+	}
+	// 通过凭证ID找到对应的用户，并确认与凭证中的用户标识一致
+	var record models.WebAuthnCredential
+	err = models.DB.Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(parsed.RawID)).First(&record).Error
+	if err != nil {
+		models.Log.Warning("通行密钥不存在:", parsed.ID)
+		return nil, errWebAuthnFailed
+	}
+	user, apiErr := findUser(record.UserID)
+	if apiErr != nil {
+		return nil, errWebAuthnFailed
+	}
+	wu, err := loadWebAuthnUser(user)
+	if err != nil {
+		models.Log.Error("加载通行密钥失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	var credential *webauthn.Credential
+	if len(session.UserID) == 0 {
+		credential, err = w.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			if string(userHandle) != user.WebAuthnHandle {
+				return nil, fmt.Errorf("用户标识不匹配")
+			}
+			return wu, nil
+		}, session, parsed)
+	} else {
+		credential, err = w.ValidateLogin(wu, session, parsed)
+	}
+	if err != nil {
+		models.Log.Warning("通行密钥登录校验失败:", user.UserName, err)
+		return nil, errWebAuthnFailed
+	}
+	// 签名计数异常说明凭证可能被复制
+	if credential.Authenticator.CloneWarning {
+		models.Log.Warning("通行密钥签名计数异常，可能已被复制:", user.UserName, record.Name)
+		return nil, errWebAuthnFailed
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		models.Log.Error("序列化通行密钥失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	now := time.Now()
+	err = models.DB.Model(&record).Updates(map[string]interface{}{
+		"data":         string(data),
+		"last_used_at": &now,
+	}).Error
+	if err != nil {
+		models.Log.Error("更新通行密钥失败:", err)
+	}
+	recordLoginAttempt(user.ID, loginKey(user.UserName), ip, userAgent, models.LoginResultSuccess)
+	models.Log.Info("用户使用通行密钥登录:", user.UserName, record.Name)
+	return loginResponse(user, ip, userAgent)
+}
+
+// GetWebAuthnCredentials 获取用户的通行密钥列表
+func GetWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, *models.APIError) {
+	var credentials []models.WebAuthnCredential
+	if err := models.DB.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error; err != nil {
+		models.Log.Error("获取通行密钥失败:", err)
+		return nil, models.ErrInternalServer
+	}
+	return credentials, nil
+}
+
+var errWebAuthnCredentialNotFound = &models.APIError{
+	Code:    404,
+	Message: "通行密钥不存在",
+	Details: "指定的通行密钥未找到",
+}
+
+// RenameWebAuthnCredential 修改通行密钥的名称
+func RenameWebAuthnCredential(userID, id uint, name string) *models.APIError {
+	name = truncateUTF8(strings.TrimSpace(name), 64)
+	if name == "" {
+		return models.ErrInvalidRequest
+	}
+	res := models.DB.Model(&models.WebAuthnCredential{}).Where("id = ? AND user_id = ?", id, userID).Update("name", name)
+	if res.Error != nil {
+		models.Log.Error("修改通行密钥名称失败:", res.Error)
+		return models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 {
+		return errWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential 删除通行密钥
+func DeleteWebAuthnCredential(userID, id uint) *models.APIError {
+	res := models.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if res.Error != nil {
+		models.Log.Error("删除通行密钥失败:", res.Error)
+		return models.ErrInternalServer
+	}
+	if res.RowsAffected == 0 {
+		return errWebAuthnCredentialNotFound
+	}
+	models.Log.Info("用户删除了通行密钥:", userID, id)
+	return nil
+}
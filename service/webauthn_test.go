@@ -0,0 +1,234 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/softauthn"
+)
+
+const testWebAuthnOrigin = "http://localhost:8080"
+
+// 使用测试的依赖方配置，结束后恢复
+func useWebAuthnConfig(t *testing.T) {
+	t.Helper()
+	previous := config.Conf.WebAuthn
+	config.Conf.WebAuthn = config.WebAuthnConfig{RPID: "localhost", Origins: []string{testWebAuthnOrigin}}
+	webAuthnInstance.w = nil
+	t.Cleanup(func() {
+		config.Conf.WebAuthn = previous
+		webAuthnInstance.w = nil
+	})
+}
+
+func marshalOptions(t *testing.T, begin WebAuthnBegin) []byte {
+	t.Helper()
+	options, err := json.Marshal(begin.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return options
+}
+
+// 使用软件认证器为用户注册通行密钥
+func registerPasskey(t *testing.T, authenticator *softauthn.Authenticator, user models.User) models.WebAuthnCredential {
+	t.Helper()
+	begin, apiErr := BeginWebAuthnRegistration(user.ID, "测试密钥")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	body, err := authenticator.Register(marshalOptions(t, begin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, apiErr := FinishWebAuthnRegistration(user.ID, begin.Session, body)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	return credential
+}
+
+// 使用软件认证器登录，username为空时使用可发现凭证
+func loginWithPasskey(t *testing.T, authenticator *softauthn.Authenticator, username string) (map[string]interface{}, *models.APIError) {
+	t.Helper()
+	begin, apiErr := BeginWebAuthnLogin(username)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	body, err := authenticator.Login(marshalOptions(t, begin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return FinishWebAuthnLogin(begin.Session, body, "127.0.0.1", "test")
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	setupTestDB(t)
+	useWebAuthnConfig(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	authenticator := softauthn.New(testWebAuthnOrigin)
+
+	credential := registerPasskey(t, authenticator, user)
+	if credential.Name != "测试密钥" || credential.UserID != user.ID {
+		t.Fatalf("保存的通行密钥 = %+v", credential)
+	}
+	// 同一认证器不能重复注册
+	begin, apiErr := BeginWebAuthnRegistration(user.ID, "")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, err := authenticator.Register(marshalOptions(t, begin)); err == nil {
+		t.Fatal("注册选项没有排除已注册的凭证")
+	}
+
+	for _, username := range []string{"alice", ""} {
+		resp, apiErr := loginWithPasskey(t, authenticator, username)
+		if apiErr != nil {
+			t.Fatalf("用户名%q登录失败: %v", username, apiErr)
+		}
+		if resp["token"] == nil {
+			t.Fatalf("登录没有返回访问令牌: %v", resp)
+		}
+	}
+	var saved models.WebAuthnCredential
+	models.DB.First(&saved, credential.ID)
+	if saved.LastUsedAt == nil {
+		t.Fatal("登录后没有记录使用时间")
+	}
+}
+
+func TestWebAuthnRegistrationRejected(t *testing.T) {
+	setupTestDB(t)
+	useWebAuthnConfig(t)
+	alice := createTestUser(t, "alice", models.RoleAuthor)
+	bob := createTestUser(t, "bob", models.RoleAuthor)
+
+	// 来源不在允许列表中
+	begin, _ := BeginWebAuthnRegistration(alice.ID, "")
+	body, err := softauthn.New("https://evil.example.com").Register(marshalOptions(t, begin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, apiErr := FinishWebAuthnRegistration(alice.ID, begin.Session, body); apiErr != errWebAuthnFailed {
+		t.Fatalf("其他来源的注册结果 = %v", apiErr)
+	}
+
+	// 会话属于其他用户
+	begin, _ = BeginWebAuthnRegistration(alice.ID, "")
+	body, err = softauthn.New(testWebAuthnOrigin).Register(marshalOptions(t, begin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, apiErr := FinishWebAuthnRegistration(bob.ID, begin.Session, body); apiErr != errWebAuthnSessionExpired {
+		t.Fatalf("使用其他用户的会话注册 = %v", apiErr)
+	}
+	// 会话只能使用一次
+	if _, apiErr := FinishWebAuthnRegistration(alice.ID, begin.Session, body); apiErr != errWebAuthnSessionExpired {
+		t.Fatalf("重复使用会话 = %v", apiErr)
+	}
+	if credentials, _ := GetWebAuthnCredentials(alice.ID); len(credentials) != 0 {
+		t.Fatalf("注册失败时保存了%d个通行密钥", len(credentials))
+	}
+}
+
+func TestWebAuthnLoginRejected(t *testing.T) {
+	setupTestDB(t)
+	useWebAuthnConfig(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	authenticator := softauthn.New(testWebAuthnOrigin)
+	registerPasskey(t, authenticator, user)
+
+	// 登录会话只能使用一次
+	begin, _ := BeginWebAuthnLogin("alice")
+	body, err := authenticator.Login(marshalOptions(t, begin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, apiErr := FinishWebAuthnLogin(begin.Session, body, "127.0.0.1", "test"); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, apiErr := FinishWebAuthnLogin(begin.Session, body, "127.0.0.1", "test"); apiErr != errWebAuthnSessionExpired {
+		t.Fatalf("重复使用登录会话 = %v", apiErr)
+	}
+
+	// 签名对应的是其他登录请求的挑战
+	first, _ := BeginWebAuthnLogin("")
+	second, _ := BeginWebAuthnLogin("")
+	body, err = authenticator.Login(marshalOptions(t, first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, apiErr := FinishWebAuthnLogin(second.Session, body, "127.0.0.1", "test"); apiErr != errWebAuthnFailed {
+		t.Fatalf("挑战不匹配时登录 = %v", apiErr)
+	}
+}
+
+func TestWebAuthnCloneDetection(t *testing.T) {
+	setupTestDB(t)
+	useWebAuthnConfig(t)
+	user := createTestUser(t, "alice", models.RoleAuthor)
+	authenticator := softauthn.New(testWebAuthnOrigin)
+	registerPasskey(t, authenticator, user)
+
+	for i := 0; i < 2; i++ {
+		if _, apiErr := loginWithPasskey(t, authenticator, "alice"); apiErr != nil {
+			t.Fatal(apiErr)
+		}
+	}
+	// 复制出的认证器的签名计数落后于服务端记录的计数
+	authenticator.Credentials()[0].SignCount = 0
+	if _, apiErr := loginWithPasskey(t, authenticator, "alice"); apiErr != errWebAuthnFailed {
+		t.Fatalf("签名计数回退时登录 = %v", apiErr)
+	}
+}
+
+func TestWebAuthnUserVerification(t *testing.T) {
+	setupTestDB(t)
+	useWebAuthnConfig(t)
+	user := createTestUser(t, "alice", models.RoleAdmin)
+	enableTestTwoFactor(t, user)
+	authenticator := softauthn.New(testWebAuthnOrigin)
+
+	// 注册和登录选项都要求用户验证
+	begin, apiErr := BeginWebAuthnRegistration(user.ID, "")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if creation := begin.Options.(*protocol.CredentialCreation); creation.Response.AuthenticatorSelection.UserVerification != protocol.VerificationRequired {
+		t.Fatalf("注册选项的用户验证要求 = %q", creation.Response.AuthenticatorSelection.UserVerification)
+	}
+	// 未进行用户验证的认证器不能注册
+	authenticator.NoUserVerification = true
+	body, err := authenticator.Register(marshalOptions(t, begin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, apiErr := FinishWebAuthnRegistration(user.ID, begin.Session, body); apiErr != errWebAuthnFailed {
+		t.Fatalf("未进行用户验证时注册 = %v", apiErr)
+	}
+
+	authenticator = softauthn.New(testWebAuthnOrigin)
+	registerPasskey(t, authenticator, user)
+	for _, username := range []string{"alice", ""} {
+		begin, apiErr := BeginWebAuthnLogin(username)
+		if apiErr != nil {
+			t.Fatal(apiErr)
+		}
+		if assertion := begin.Options.(*protocol.CredentialAssertion); assertion.Response.UserVerification != protocol.VerificationRequired {
+			t.Fatalf("登录选项的用户验证要求 = %q", assertion.Response.UserVerification)
+		}
+		// 只证明持有设备的签名不能跳过两步验证登录
+		authenticator.NoUserVerification = true
+		resp, apiErr := loginWithPasskey(t, authenticator, username)
+		if apiErr != errWebAuthnFailed || resp != nil {
+			t.Fatalf("用户名%q未进行用户验证时登录 = %v, %v", username, resp, apiErr)
+		}
+		authenticator.NoUserVerification = false
+		if _, apiErr := loginWithPasskey(t, authenticator, username); apiErr != nil {
+			t.Fatalf("用户名%q完成用户验证后登录 = %v", username, apiErr)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package softauthn 软件实现的WebAuthn认证器，用于在Go测试中模拟浏览器和安全密钥完成通行密钥的注册和登录。
+//
+// 使用方式：将 /api/protected/webauthn/register/begin 返回的 options 传给 Register，
+// 将得到的结果作为 /api/protected/webauthn/register/finish 的请求体；登录时同理使用 Login。
+// 认证器只生成ES256密钥，使用none格式的证明，并且始终设置用户在场标志；
+// 默认同时设置用户验证标志，NoUserVerification为true时模拟未进行用户验证的认证器。
+package softauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+// 认证器数据中的标志位
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Credential 认证器中保存的凭证
+type Credential struct {
+	ID         []byte
+	RPID       string
+	UserHandle []byte
+	PrivateKey *ecdsa.PrivateKey
+	SignCount  uint32
+}
+
+// Authenticator 软件认证器，Origin为模拟的浏览器页面来源，需要在服务端允许的来源列表中
+type Authenticator struct {
+	Origin string
+	// 不设置用户验证标志，例如关闭了PIN的安全密钥
+	NoUserVerification bool
+
+	mu          sync.Mutex
+	credentials []*Credential
+}
+
+// New 创建软件认证器
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Credentials 返回认证器中保存的凭证
+func (a *Authenticator) Credentials() []*Credential {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*Credential(nil), a.credentials...)
+}
+
+// 生成客户端数据，与浏览器生成的格式一致
+func (a *Authenticator) clientData(ceremony protocol.CeremonyType, challenge []byte) ([]byte, error) {
+	return json.Marshal(protocol.CollectedClientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.Origin,
+	})
+}
+
+// 生成认证器数据：依赖方ID哈希、标志位、签名计数，以及注册时的凭证数据
+func authenticatorData(rpID string, flags byte, signCount uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attested...)
+}
+
+// 认证器数据中的用户在场和用户验证标志
+func (a *Authenticator) flags() byte {
+	if a.NoUserVerification {
+		return flagUserPresent
+	}
+	return flagUserPresent | flagUserVerified
+}
+
+// 将P-256公钥编码为COSE格式
+func coseKey(key *ecdsa.PublicKey) ([]byte, error) {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: x,
+		-3: y,
+	})
+}
+
+// 支持ES256的依赖方才能使用本认证器
+func supportsES256(params []protocol.CredentialParameter) bool {
+	if len(params) == 0 {
+		return true
+	}
+	for _, param := range params {
+		if param.Type == protocol.PublicKeyCredentialType && param.Algorithm == -7 {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析UserEntity中的用户标识，JSON中为Base64URL编码的字符串
+func userHandle(id interface{}) ([]byte, error) {
+	switch v := id.(type) {
+	case string:
+		return base64.RawURLEncoding.DecodeString(v)
+	case []byte:
+		return v, nil
+	}
+	return nil, fmt.Errorf("无法解析用户标识: %T", id)
+}
+
+// Register 根据服务端返回的注册选项（navigator.credentials.create()的参数，JSON格式）生成新凭证，
+// 返回与浏览器PublicKeyCredential序列化结果相同格式的JSON
+func (a *Authenticator) Register(options []byte) ([]byte, error) {
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal(options, &creation); err != nil {
+		return nil, err
+	}
+	opts := creation.Response
+	if !supportsES256(opts.Parameters) {
+		return nil, errors.New("依赖方不支持ES256")
+	}
+	handle, err := userHandle(opts.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	// 与浏览器一致，已注册的凭证不能重复注册
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, excluded := range opts.CredentialExcludeList {
+		for _, credential := range a.credentials {
+			if string(credential.ID) == string(excluded.CredentialID) {
+				return nil, errors.New("凭证已注册")
+			}
+		}
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credential := &Credential{
+		ID:         make([]byte, 16),
+		RPID:       opts.RelyingParty.ID,
+		UserHandle: handle,
+		PrivateKey: privateKey,
+	}
+	if _, err := rand.Read(credential.ID); err != nil {
+		return nil, err
+	}
+	publicKey, err := coseKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	// 凭证数据：AAGUID（全零）、凭证ID长度、凭证ID、COSE公钥
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(credential.ID)))
+	attested = append(attested, credential.ID...)
+	attested = append(attested, publicKey...)
+	authData := authenticatorData(credential.RPID, a.flags()|flagAttestedData, credential.SignCount, attested)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	clientData, err := a.clientData(protocol.CreateCeremony, opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	a.credentials = append(a.credentials, credential)
+
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+	return json.Marshal(map[string]interface{}{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// Login 根据服务端返回的登录选项（navigator.credentials.get()的参数，JSON格式）使用已有凭证签名，
+// 选项中没有指定凭证时使用该依赖方最近注册的凭证
+func (a *Authenticator) Login(options []byte) ([]byte, error) {
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal(options, &assertion); err != nil {
+		return nil, err
+	}
+	opts := assertion.Response
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var credential *Credential
+	for i := len(a.credentials) - 1; i >= 0 && credential == nil; i-- {
+		c := a.credentials[i]
+		if c.RPID != opts.RelyingPartyID {
+			continue
+		}
+		if len(opts.AllowedCredentials) == 0 {
+			credential = c
+		}
+		for _, allowed := range opts.AllowedCredentials {
+			if string(allowed.CredentialID) == string(c.ID) {
+				credential = c
+			}
+		}
+	}
+	if credential == nil {
+		return nil, errors.New("没有可用的凭证")
+	}
+
+	credential.SignCount++
+	authData := authenticatorData(credential.RPID, a.flags(), credential.SignCount, nil)
+	clientData, err := a.clientData(protocol.AssertCeremony, opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, credential.PrivateKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+	return json.Marshal(map[string]interface{}{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(credential.UserHandle),
+		},
+	})
+}
@@ -52,6 +52,100 @@ const Ajax = {
    * @returns {Promise}
    */
   request(method, url, params = {}, data = {}) {
+    return this.send(method, url, params, data).catch(error => {
+      // 访问令牌过期时使用刷新令牌（本地保存的或Cookie中的）换取新令牌后重试一次
+      if (error.status !== 401 || !this.isLoggedIn()) {
+        throw error;
+      }
+      return this.refreshToken().then(() => this.send(method, url, params, data));
+    });
+  },
+
+  /**
+   * 使用刷新令牌换取新的访问令牌，并发请求共用同一次刷新
+   * @returns {Promise}
+   */
+  refreshToken() {
+    if (!this.refreshing) {
+      const body = {};
+      if (localStorage.getItem('refresh_token')) {
+        body.refresh_token = localStorage.getItem('refresh_token');
+      }
+      this.refreshing = fetch('/api/token/refresh', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          'X-CSRF-Token': this.csrfToken()
+        },
+        body: JSON.stringify(body)
+      })
+        .then(response => response.json().then(data => {
+          if (!response.ok) {
+            // 刷新令牌失效，需要重新登录
+            this.clearToken();
+            window.location.href = '/login';
+            throw new Error(data.message || '登录已过期');
+          }
+          // 仅使用Cookie会话时响应中不包含令牌
+          if (data.token) {
+            localStorage.setItem('token', data.token);
+            localStorage.setItem('refresh_token', data.refresh_token);
+          }
+        }))
+        .finally(() => {
+          this.refreshing = null;
+        });
+    }
+    return this.refreshing;
+  },
+
+  /**
+   * 读取 CSRF 令牌 Cookie
+   * @returns {string}
+   */
+  csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : '';
+  },
+
+  /**
+   * 是否已登录：本地保存了令牌，或持有 Cookie 会话
+   * @returns {boolean}
+   */
+  isLoggedIn() {
+    return !!localStorage.getItem('token') || !!localStorage.getItem('refresh_token') || !!this.csrfToken();
+  },
+
+  /**
+   * 清除本地保存的令牌和用户信息
+   */
+  clearToken() {
+    localStorage.removeItem('token');
+    localStorage.removeItem('refresh_token');
+    localStorage.removeItem('userinfo');
+  },
+
+  /**
+   * 退出登录，all 为 true 时退出全部会话
+   * @param {boolean} all - 是否退出全部会话
+   */
+  logout(all = false) {
+    const done = () => {
+      this.clearToken();
+      window.location.href = '/login';
+    };
+    this.post(all ? '/api/logout/all' : '/api/logout').then(done, done);
+  },
+
+  /**
+   * 发送单次请求
+   * @param {string} method - 请求方法
+   * @param {string} url - 请求地址
+   * @param {object} params - 请求参数
+   * @param {object} data - 请求体数据
+   * @returns {Promise}
+   */
+  send(method, url, params = {}, data = {}) {
     return new Promise((resolve, reject) => {
       // 构建完整的 URL 和查询参数
       let fullUrl = url;
@@ -66,16 +160,24 @@ const Ajax = {
       // 初始化请求
       xhr.open(method, fullUrl, true);
 
-      // 设置默认请求头
-      xhr.setRequestHeader('Content-Type', 'application/json;charset=UTF-8');
+      // 设置默认请求头，上传文件时由浏览器设置 multipart 请求头
+      const isForm = data instanceof FormData;
+      if (!isForm) {
+        xhr.setRequestHeader('Content-Type', 'application/json;charset=UTF-8');
+      }
       xhr.setRequestHeader('Accept', 'application/json');
 
-      // 获取并设置 token
+      // 获取并设置 token，没有 token 时使用 Cookie 会话
       const token = localStorage.getItem('token');
       if (token) {
         xhr.setRequestHeader('Authorization', 'Bearer ' + token);
       }
 
+      // 状态修改请求携带 CSRF 令牌
+      if (method !== 'GET') {
+        xhr.setRequestHeader('X-CSRF-Token', this.csrfToken());
+      }
+
       // 处理响应
       xhr.onreadystatechange = function () {
         if (xhr.readyState === XMLHttpRequest.DONE) {
@@ -87,12 +189,15 @@ const Ajax = {
               resolve(xhr.responseText);
             }
           } else {
+            let error;
             try {
               const errorResponse = JSON.parse(xhr.responseText);
-              reject(new Error(errorResponse.message || '请求失败'));
+              error = new Error(errorResponse.message || errorResponse.error || '请求失败');
             } catch (e) {
-              reject(new Error('请求失败'));
+              error = new Error('请求失败');
             }
+            error.status = xhr.status;
+            reject(error);
           }
         }
       };
@@ -104,7 +209,7 @@ const Ajax = {
 
       // 发送请求
       if (method === 'POST' || method === 'PUT') {
-        xhr.send(JSON.stringify(data));
+        xhr.send(isForm ? data : JSON.stringify(data));
       } else {
         xhr.send();
       }
@@ -0,0 +1,110 @@
+/**
+ * 通行密钥（WebAuthn）注册和登录
+ */
+
+const Passkey = {
+  /**
+   * 浏览器是否支持通行密钥
+   * @returns {boolean}
+   */
+  supported() {
+    return !!window.PublicKeyCredential;
+  },
+
+  /**
+   * Base64URL 字符串转 ArrayBuffer
+   * @param {string} value
+   * @returns {ArrayBuffer}
+   */
+  decode(value) {
+    const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    const padded = base64 + '='.repeat((4 - base64.length % 4) % 4);
+    return Uint8Array.from(atob(padded), c => c.charCodeAt(0)).buffer;
+  },
+
+  /**
+   * ArrayBuffer 转 Base64URL 字符串
+   * @param {ArrayBuffer} buffer
+   * @returns {string}
+   */
+  encode(buffer) {
+    const bytes = String.fromCharCode(...new Uint8Array(buffer));
+    return btoa(bytes).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  },
+
+  /**
+   * 发送 POST 请求，已登录时携带 Authorization 头
+   * @param {string} url
+   * @param {object} body
+   * @returns {Promise}
+   */
+  post(url, body) {
+    const headers = {
+      'Content-Type': 'application/json',
+      'X-CSRF-Token': decodeURIComponent((document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/) || [])[1] || '')
+    };
+    const token = localStorage.getItem('token');
+    if (token) {
+      headers['Authorization'] = 'Bearer ' + token;
+    }
+    return fetch(url, { method: 'POST', headers: headers, body: JSON.stringify(body) })
+      .then(response => response.json().then(data => {
+        if (!response.ok) {
+          throw new Error(data.message || '请求失败');
+        }
+        return data;
+      }));
+  },
+
+  /**
+   * 注册通行密钥
+   * @param {string} name - 通行密钥名称
+   * @returns {Promise}
+   */
+  register(name) {
+    return this.post('/api/protected/webauthn/register/begin', { name: name }).then(begin => {
+      const options = begin.options.publicKey;
+      options.challenge = this.decode(options.challenge);
+      options.user.id = this.decode(options.user.id);
+      (options.excludeCredentials || []).forEach(c => { c.id = this.decode(c.id); });
+      return navigator.credentials.create({ publicKey: options }).then(credential => {
+        return this.post('/api/protected/webauthn/register/finish?session=' + encodeURIComponent(begin.session), {
+          id: credential.id,
+          rawId: this.encode(credential.rawId),
+          type: credential.type,
+          response: {
+            clientDataJSON: this.encode(credential.response.clientDataJSON),
+            attestationObject: this.encode(credential.response.attestationObject),
+            transports: credential.response.getTransports ? credential.response.getTransports() : []
+          }
+        });
+      });
+    });
+  },
+
+  /**
+   * 使用通行密钥登录，username 为空时由浏览器选择通行密钥
+   * @param {string} username - 用户名
+   * @returns {Promise}
+   */
+  login(username) {
+    return this.post('/api/login/webauthn/begin', { username: username || '' }).then(begin => {
+      const options = begin.options.publicKey;
+      options.challenge = this.decode(options.challenge);
+      (options.allowCredentials || []).forEach(c => { c.id = this.decode(c.id); });
+      return navigator.credentials.get({ publicKey: options }).then(credential => {
+        return this.post('/api/login/webauthn/finish?session=' + encodeURIComponent(begin.session), {
+          id: credential.id,
+          rawId: this.encode(credential.rawId),
+          type: credential.type,
+          response: {
+            clientDataJSON: this.encode(credential.response.clientDataJSON),
+            authenticatorData: this.encode(credential.response.authenticatorData),
+            signature: this.encode(credential.response.signature),
+            userHandle: credential.response.userHandle ? this.encode(credential.response.userHandle) : null
+          }
+        });
+      });
+    });
+  }
+};
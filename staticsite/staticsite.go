@@ -0,0 +1,561 @@
+// Package staticsite 将公开页面导出为静态站点，用于在只支持静态文件的托管服务上部署只读镜像。
+//
+// 导出的内容包括首页、文章、标签、归档和作者主页（含分页）、404页面、订阅源、sitemap和robots.txt，
+// 以及主题静态资源、statics目录和页面中引用的媒体文件和头像。页面保存为 <地址>/index.html，
+// 页面中以/开头的站内链接按静态站点地址的路径重写。
+//
+// 输出目录中的 .build.json 记录上次生成的时间、参数和每个文件的哈希。再次生成时，
+// 上次生成之后没有修改的文章页面直接保留，内容没有变化的文件不会重新写入，不再存在的页面和文件会被删除。
+// 静态站点地址、主题模板或站点配置变化时重新生成全部页面。
+package staticsite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/config"
+	"github.com/xiaohan1995/Gin-blog/models"
+	"github.com/xiaohan1995/Gin-blog/service"
+	"github.com/xiaohan1995/Gin-blog/theme"
+)
+
+// ManifestName 输出目录中记录上次生成结果的文件
+const ManifestName = ".build.json"
+
+// 页面中以/开头的站内链接，不包括以//开头的协议相对地址
+var sitePathAttr = regexp.MustCompile(`(\s(?:href|src|action)=")/([^/])`)
+
+// Options 生成静态站点的参数
+type Options struct {
+	// 输出目录
+	Dir string
+	// 静态站点的访问地址，如 https://mirror.example.com/blog
+	BaseURL string
+	// 主题目录
+	ThemeDir string
+	// 复制到静态站点/statics/下的目录，为空时不复制
+	StaticsDir string
+	// 忽略上次生成的结果，重新生成全部页面
+	Full bool
+}
+
+// Result 生成的结果
+type Result struct {
+	// 渲染的页面数量
+	Pages int
+	// 没有修改而保留的文章页面数量
+	Skipped int
+	// 写入的文件数量，内容没有变化的文件不会写入
+	Written int
+	// 删除的文件数量
+	Removed int
+}
+
+type manifest struct {
+	BuiltAt time.Time `json:"built_at"`
+	BaseURL string    `json:"base_url"`
+	// 主题模板和站点配置的哈希，变化时重新生成全部页面
+	Theme  string            `json:"theme"`
+	Config string            `json:"config"`
+	Files  map[string]string `json:"files"`
+}
+
+type builder struct {
+	opts     Options
+	basePath string
+	site     *theme.Theme
+	old      manifest
+	files    map[string]string
+	// 页面中引用的媒体文件和头像
+	refs    map[string]bool
+	refAttr *regexp.Regexp
+	result  Result
+}
+
+// Build 生成静态站点
+func Build(opts Options) (Result, error) {
+	base, err := url.Parse(strings.TrimRight(opts.BaseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" || base.RawQuery != "" || base.Fragment != "" {
+		return Result{}, fmt.Errorf("静态站点地址必须是完整的http或https地址: %q", opts.BaseURL)
+	}
+	baseURL := base.String()
+	site, err := theme.New(opts.ThemeDir, false, "")
+	if err != nil {
+		return Result{}, err
+	}
+	themeHash, err := hashTemplates(opts.ThemeDir)
+	if err != nil {
+		return Result{}, err
+	}
+	configHash, err := hashJSON(config.Conf.Site, config.Conf.SEO, config.Conf.Feed)
+	if err != nil {
+		return Result{}, err
+	}
+	b := &builder{
+		opts:     opts,
+		basePath: base.EscapedPath(),
+		site:     site,
+		files:    map[string]string{},
+		refs:     map[string]bool{},
+	}
+	prefix := regexp.QuoteMeta(baseURL) + "|" + regexp.QuoteMeta(b.basePath)
+	b.refAttr = regexp.MustCompile(`(?:href|src|content)="(?:` + prefix + `)(/media/[0-9a-f]{2}/[0-9a-f]{64}(?:-thumb|-w[0-9]+)?\.(?:jpg|png|gif)|/avatars/[0-9]+)`)
+	if err := b.readManifest(); err != nil {
+		return Result{}, err
+	}
+	full := opts.Full || b.old.BaseURL != baseURL || b.old.Theme != themeHash || b.old.Config != configHash
+
+	// 页面、订阅源和sitemap中的完整地址使用静态站点的地址
+	siteBaseURL := config.Conf.Site.BaseURL
+	config.Conf.Site.BaseURL = baseURL
+	defer func() { config.Conf.Site.BaseURL = siteBaseURL }()
+
+	// 开始生成之后修改的文章在下次生成时重新渲染，时间精度按数据库保存的秒计算
+	builtAt := time.Now().Truncate(time.Second)
+	steps := []func() error{
+		func() error { return b.posts(full) },
+		b.lists,
+		b.feeds,
+		b.sitemap,
+		b.assets,
+		b.uploads,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return b.result, err
+		}
+	}
+	if err := b.removeStale(); err != nil {
+		return b.result, err
+	}
+	m := manifest{BuiltAt: builtAt, BaseURL: baseURL, Theme: themeHash, Config: configHash, Files: b.files}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return b.result, err
+	}
+	return b.result, os.WriteFile(filepath.Join(opts.Dir, ManifestName), data, 0644)
+}
+
+// 读取上次生成的结果，不存在时为首次生成
+func (b *builder) readManifest() error {
+	data, err := os.ReadFile(filepath.Join(b.opts.Dir, ManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		b.old.Files = map[string]string{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &b.old); err != nil {
+		return fmt.Errorf("读取%s失败: %w", ManifestName, err)
+	}
+	if b.old.Files == nil {
+		b.old.Files = map[string]string{}
+	}
+	return nil
+}
+
+// 文章页面，上次生成之后没有修改的文章保留原来的页面
+func (b *builder) posts(full bool) error {
+	posts, apiErr := service.StaticSitePosts()
+	if err := check(apiErr); err != nil {
+		return err
+	}
+	for _, post := range posts {
+		p := service.PostPath(post.ID)
+		if !full && post.ModTime.Before(b.old.BuiltAt) && b.keep(pageFile(p)) {
+			b.result.Skipped++
+			continue
+		}
+		page, apiErr := service.SitePost(post.ID, nil)
+		if err := b.page(p, page, apiErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 首页、标签、归档、作者主页和404页面，列表页面依赖多篇文章，每次都重新渲染
+func (b *builder) lists() error {
+	err := b.paged("", func(n int) (service.SitePage, *models.APIError) {
+		return service.SiteHome(n, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	page, apiErr := service.SiteTags()
+	if err := b.page("/tags", page, apiErr); err != nil {
+		return err
+	}
+	tags, apiErr := service.GetPublicTags()
+	if err := check(apiErr); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		slug := tag.Slug
+		err := b.paged(service.TagPath(slug), func(n int) (service.SitePage, *models.APIError) {
+			return service.SiteTag(slug, n, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	page, apiErr = service.SiteArchive()
+	if err := b.page("/archive", page, apiErr); err != nil {
+		return err
+	}
+
+	authors, apiErr := service.StaticSiteAuthors()
+	if err := check(apiErr); err != nil {
+		return err
+	}
+	for _, username := range authors {
+		err := b.paged(service.AuthorPath(username), func(n int) (service.SitePage, *models.APIError) {
+			return service.SiteAuthor(username, n, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 静态托管服务通常使用根目录下的404.html作为页面不存在时的响应
+	data, err := b.render(service.SiteNotFound())
+	if err != nil {
+		return fmt.Errorf("生成404页面失败: %w", err)
+	}
+	return b.write("404.html", data)
+}
+
+// 分页的列表页面，依次生成到最后一页
+func (b *builder) paged(base string, get func(n int) (service.SitePage, *models.APIError)) error {
+	for n := 1; ; n++ {
+		page, apiErr := get(n)
+		if err := b.page(service.PagedPath(base, n), page, apiErr); err != nil {
+			return err
+		}
+		if pagination, _ := page.Data["Pagination"].(service.Pagination); pagination.Next == "" {
+			return nil
+		}
+	}
+}
+
+// 全站、标签和作者的各种格式的订阅源
+func (b *builder) feeds() error {
+	full := config.Conf.Feed.FullContent
+	tags, apiErr := service.GetPublicTags()
+	if err := check(apiErr); err != nil {
+		return err
+	}
+	authors, apiErr := service.StaticSiteAuthors()
+	if err := check(apiErr); err != nil {
+		return err
+	}
+	for _, format := range []string{service.FeedRSS, service.FeedAtom, service.FeedJSON} {
+		if err := b.feed(service.GetSiteFeed(format, full)); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if err := b.feed(service.GetTagFeed(tag.Slug, format, full)); err != nil {
+				return err
+			}
+		}
+		for _, username := range authors {
+			if err := b.feed(service.GetAuthorFeed(username, format, full)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *builder) feed(feed service.Feed, apiErr *models.APIError) error {
+	if err := check(apiErr); err != nil {
+		return fmt.Errorf("生成订阅源失败: %w", err)
+	}
+	data, err := feed.Encode()
+	if err != nil {
+		return fmt.Errorf("生成订阅源失败: %w", err)
+	}
+	base := feed.Path
+	if base == "/" {
+		base = ""
+	}
+	return b.write(sitePathFile(service.FeedPath(base, feed.Format)), data)
+}
+
+// sitemap、拆分后的sitemap文件和robots.txt
+func (b *builder) sitemap() error {
+	data, _, apiErr := service.Sitemap()
+	if err := check(apiErr); err != nil {
+		return err
+	}
+	if err := b.write(sitePathFile(service.SitemapPath), data); err != nil {
+		return err
+	}
+	for n := 1; ; n++ {
+		data, _, apiErr := service.SitemapPart(n)
+		if apiErr == models.ErrPostNotFound {
+			break
+		}
+		if err := check(apiErr); err != nil {
+			return err
+		}
+		if err := b.write(sitePathFile(service.SitemapPartPath(n)), data); err != nil {
+			return err
+		}
+	}
+	return b.write("robots.txt", []byte(service.RobotsTxt()))
+}
+
+// 主题静态资源和statics目录
+func (b *builder) assets() error {
+	if err := b.copyDir(b.site.AssetsDir(), strings.TrimPrefix(theme.AssetsPath, "/")); err != nil {
+		return err
+	}
+	if b.opts.StaticsDir == "" {
+		return nil
+	}
+	return b.copyDir(b.opts.StaticsDir, "statics")
+}
+
+func (b *builder) copyDir(dir, target string) error {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return b.write(path.Join(target, filepath.ToSlash(rel)), data)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// 页面中引用的媒体文件和头像。媒体文件的地址包含内容的哈希，已复制过的不再读取
+func (b *builder) uploads() error {
+	refs := make([]string, 0, len(b.refs))
+	for ref := range b.refs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		rel := strings.TrimPrefix(ref, "/")
+		if name, ok := strings.CutPrefix(ref, service.MediaPathPrefix+"/"); ok {
+			if b.keep(rel) {
+				continue
+			}
+			file, apiErr := service.MediaFile(name)
+			if apiErr != nil {
+				models.Log.Warning("页面中引用的媒体文件不存在:", ref)
+				continue
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("读取媒体文件%s失败: %w", ref, err)
+			}
+			if err := b.write(rel, data); err != nil {
+				return err
+			}
+			continue
+		}
+		id, err := strconv.ParseUint(path.Base(ref), 10, 64)
+		if err != nil {
+			continue
+		}
+		file, data, apiErr := service.GetAvatar(uint(id))
+		if apiErr != nil {
+			models.Log.Warning("页面中引用的头像不存在:", ref)
+			continue
+		}
+		if file != nil {
+			data, err = io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("读取头像%s失败: %w", ref, err)
+			}
+		}
+		if err := b.write(rel, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除上次生成而这次没有生成的文件，以及删除后为空的目录
+func (b *builder) removeStale() error {
+	for rel := range b.old.Files {
+		if _, ok := b.files[rel]; ok || !filepath.IsLocal(filepath.FromSlash(rel)) {
+			continue
+		}
+		full := filepath.Join(b.opts.Dir, filepath.FromSlash(rel))
+		if err := os.Remove(full); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		b.result.Removed++
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if os.Remove(filepath.Join(b.opts.Dir, filepath.FromSlash(dir))) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// 渲染页面并保存为 <地址>/index.html
+func (b *builder) page(p string, page service.SitePage, apiErr *models.APIError) error {
+	if err := check(apiErr); err != nil {
+		return fmt.Errorf("生成页面%s失败: %w", p, err)
+	}
+	data, err := b.render(page)
+	if err != nil {
+		return fmt.Errorf("生成页面%s失败: %w", p, err)
+	}
+	return b.write(pageFile(p), data)
+}
+
+// 渲染页面，重写站内链接并记录引用的媒体文件和头像
+func (b *builder) render(page service.SitePage) ([]byte, error) {
+	// 静态站点中不显示需要登录的功能
+	page.Data["Static"] = true
+	var buf bytes.Buffer
+	if err := b.site.Render(&buf, page.Template, page.Data); err != nil {
+		return nil, err
+	}
+	b.result.Pages++
+	data := buf.Bytes()
+	if b.basePath != "" {
+		data = sitePathAttr.ReplaceAll(data, []byte("${1}"+b.basePath+"/${2}"))
+	}
+	b.scan(data)
+	return data, nil
+}
+
+func (b *builder) scan(data []byte) {
+	for _, m := range b.refAttr.FindAllSubmatch(data, -1) {
+		b.refs[string(m[1])] = true
+	}
+}
+
+// 保留上次生成的文件，文件已被删除时返回false
+func (b *builder) keep(rel string) bool {
+	sum, ok := b.old.Files[rel]
+	if !ok {
+		return false
+	}
+	full := filepath.Join(b.opts.Dir, filepath.FromSlash(rel))
+	if strings.HasSuffix(rel, ".html") {
+		// 保留的页面中引用的文件也需要保留
+		data, err := os.ReadFile(full)
+		if err != nil {
+			return false
+		}
+		b.scan(data)
+	} else if _, err := os.Stat(full); err != nil {
+		return false
+	}
+	b.files[rel] = sum
+	return true
+}
+
+// 保存文件，内容与上次生成的相同且文件仍然存在时不重新写入
+func (b *builder) write(rel string, data []byte) error {
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return fmt.Errorf("文件路径不在输出目录中: %s", rel)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	b.files[rel] = hash
+	full := filepath.Join(b.opts.Dir, filepath.FromSlash(rel))
+	if b.old.Files[rel] == hash {
+		if _, err := os.Stat(full); err == nil {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(full, data, 0644); err != nil {
+		return err
+	}
+	b.result.Written++
+	return nil
+}
+
+// 页面地址对应的文件，如 /post/1 保存为 post/1/index.html
+func pageFile(p string) string {
+	rel := sitePathFile(p)
+	if rel == "" {
+		return "index.html"
+	}
+	return rel + "/index.html"
+}
+
+// 站内地址对应的文件路径，地址中转义的字符还原为文件名
+func sitePathFile(p string) string {
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+	return strings.Trim(p, "/")
+}
+
+// 主题模板的哈希，静态资源按文件内容单独比较
+func hashTemplates(dir string) (string, error) {
+	h := sha256.New()
+	for _, sub := range []string{"layouts", "partials"} {
+		files, err := filepath.Glob(filepath.Join(dir, sub, "*.html"))
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s/%s\n%d\n", sub, filepath.Base(file), len(data))
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashJSON(values ...interface{}) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func check(apiErr *models.APIError) error {
+	if apiErr == nil {
+		return nil
+	}
+	return errors.New(apiErr.Message)
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local 保存在本地目录中的文件存储
+type Local struct {
+	// 文件保存的根目录
+	Dir string
+	// 签名链接的地址前缀，如 /files 或 https://blog.example.com/files，链接由应用验证签名后提供下载
+	URLPrefix string
+	// 签名链接使用的密钥，为空时不能生成签名链接
+	Secret []byte
+}
+
+// NewLocal 创建本地文件存储
+func NewLocal(dir, urlPrefix string, secret []byte) *Local {
+	return &Local{Dir: dir, URLPrefix: strings.TrimRight(urlPrefix, "/"), Secret: secret}
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	full, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	// 先写入临时文件再重命名，避免读取到不完整的文件
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	tmp := full + ".tmp-" + hex.EncodeToString(suffix)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = os.Rename(tmp, full)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (l *Local) Open(key string) (io.ReadCloser, Info, error) {
+	full, err := l.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, Info{}, localError(err)
+	}
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		f.Close()
+		return nil, Info{}, ErrNotExist
+	}
+	return f, localInfo(key, stat), nil
+}
+
+func (l *Local) Stat(key string) (Info, error) {
+	full, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(full)
+	if err != nil {
+		return Info{}, localError(err)
+	}
+	if stat.IsDir() {
+		return Info{}, ErrNotExist
+	}
+	return localInfo(key, stat), nil
+}
+
+func (l *Local) Delete(key string) error {
+	full, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(full); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) List(prefix string, fn func(Info) error) error {
+	err := filepath.WalkDir(l.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(l.Dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			// 跳过与前缀无关的目录
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// 忽略未写完的临时文件
+		if !strings.HasPrefix(key, prefix) || strings.Contains(path.Base(key), ".tmp-") {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(localInfo(key, stat))
+	})
+	return err
+}
+
+// SignedURL 生成带有过期时间和签名的链接，由应用调用Verify验证后提供下载
+func (l *Local) SignedURL(key string, ttl time.Duration, downloadName string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	if len(l.Secret) == 0 {
+		return "", errors.New("storage: signing secret is not configured")
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{"expires": {expires}, "signature": {l.sign(key, expires, downloadName)}}
+	if downloadName != "" {
+		q.Set("name", downloadName)
+	}
+	return l.URLPrefix + "/" + escapeKey(key) + "?" + q.Encode(), nil
+}
+
+// Verify 验证签名链接中的过期时间、下载文件名和签名
+func (l *Local) Verify(key, expires, downloadName, signature string) bool {
+	if len(l.Secret) == 0 || !ValidKey(key) {
+		return false
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(l.sign(key, expires, downloadName)))
+}
+
+func (l *Local) sign(key, expires, downloadName string) string {
+	mac := hmac.New(sha256.New, l.Secret)
+	mac.Write([]byte(key + "\n" + expires + "\n" + downloadName))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func localInfo(key string, stat fs.FileInfo) Info {
+	return Info{
+		Key:         key,
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+	}
+}
+
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	return err
+}
+
+// 对键名的每个路径段进行转义
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
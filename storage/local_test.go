@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalPutOpenListDelete(t *testing.T) {
+	l := NewLocal(t.TempDir(), "/files/", []byte("secret"))
+	for _, key := range []string{"media/ab/1.jpg", "media/cd/2.jpg", "avatars/1.png"} {
+		if err := l.Put(key, strings.NewReader(key), int64(len(key)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, info := readAll(t, l, "media/ab/1.jpg")
+	if data != "media/ab/1.jpg" || info.ContentType != "image/jpeg" {
+		t.Fatalf("Open = %q, %+v", data, info)
+	}
+	// 大小不一致时不写入文件
+	if err := l.Put("media/short", strings.NewReader("abc"), 10, ""); err == nil {
+		t.Fatal("内容不完整时写入成功")
+	}
+	if _, err := l.Stat("media/short"); err != ErrNotExist {
+		t.Fatalf("不完整的文件 = %v", err)
+	}
+	// 未写完的临时文件不会被列出
+	os.WriteFile(filepath.Join(l.Dir, "media", "ab", "3.jpg.tmp-abc"), []byte("x"), 0644)
+
+	var keys []string
+	l.List("media/", func(info Info) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if strings.Join(keys, ",") != "media/ab/1.jpg,media/cd/2.jpg" {
+		t.Fatalf("List = %v", keys)
+	}
+
+	if err := l.Delete("media/ab/1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Open("media/ab/1.jpg"); err != ErrNotExist {
+		t.Fatalf("删除后Open = %v", err)
+	}
+	if err := l.Delete("media/ab/1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Open("../outside"); err != ErrInvalidKey {
+		t.Fatalf("非法键名 = %v", err)
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	l := NewLocal(t.TempDir(), "/files", []byte("secret"))
+	link, err := l.SignedURL("exports/1/a b.zip", time.Minute, "导出.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Path != "/files/exports/1/a b.zip" {
+		t.Fatalf("签名链接 = %s", link)
+	}
+	q := u.Query()
+	if !l.Verify("exports/1/a b.zip", q.Get("expires"), q.Get("name"), q.Get("signature")) {
+		t.Fatal("签名验证失败")
+	}
+	if l.Verify("exports/1/other.zip", q.Get("expires"), q.Get("name"), q.Get("signature")) {
+		t.Fatal("其他文件使用了该签名")
+	}
+	if l.Verify("exports/1/a b.zip", q.Get("expires"), "other.zip", q.Get("signature")) {
+		t.Fatal("修改下载文件名后签名仍然有效")
+	}
+	later := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	if l.Verify("exports/1/a b.zip", later, q.Get("name"), q.Get("signature")) {
+		t.Fatal("延长有效期后签名仍然有效")
+	}
+
+	// 过期的链接
+	link, _ = l.SignedURL("exports/1/a b.zip", -time.Second, "")
+	u, _ = url.Parse(link)
+	if l.Verify("exports/1/a b.zip", u.Query().Get("expires"), "", u.Query().Get("signature")) {
+		t.Fatal("过期的签名链接验证通过")
+	}
+	if _, err := NewLocal(t.TempDir(), "/files", nil).SignedURL("a", time.Minute, ""); err == nil {
+		t.Fatal("没有密钥时生成了签名链接")
+	}
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 不对请求内容计算哈希，内容的完整性由Content-Length和传输层保证
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// 签名链接的最长有效期，由S3协议规定
+const maxPresignTTL = 7 * 24 * time.Hour
+
+// S3Options 兼容S3协议的对象存储的连接参数
+type S3Options struct {
+	// 服务地址，如 https://s3.us-east-1.amazonaws.com 或 http://localhost:9100
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// 使用 服务地址/存储桶/键名 形式的地址，MinIO等自建服务通常需要开启
+	PathStyle bool
+}
+
+// S3 兼容S3协议的对象存储，使用AWS Signature Version 4签名请求
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 创建对象存储客户端
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimRight(opts.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("storage: invalid s3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, errors.New("storage: s3 bucket is required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	return &S3{opts: opts, endpoint: endpoint, client: &http.Client{Timeout: 5 * time.Minute}}, nil
+}
+
+// 对象的访问地址，key为空时为存储桶的地址
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.opts.PathStyle {
+		u.Path = s.endpoint.Path + "/" + s.opts.Bucket + "/" + key
+	} else {
+		u.Host = s.opts.Bucket + "." + s.endpoint.Host
+		u.Path = s.endpoint.Path + "/" + key
+	}
+	return &u
+}
+
+func (s *S3) do(method, key string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+	u.RawPath = escapePath(u.Path)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		signed = append(signed, "content-type")
+		headers["content-type"] = ct
+	}
+	sort.Strings(signed)
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	scope := s.scope(now)
+	canonicalRequest := strings.Join([]string{
+		method, escapePath(u.Path), u.RawQuery, canonicalHeaders.String(), strings.Join(signed, ";"), unsignedPayload,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKey, scope, strings.Join(signed, ";"), s.signature(now, scope, canonicalRequest)))
+	return s.client.Do(req)
+}
+
+// 签名范围：日期/区域/服务/aws4_request
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.opts.Region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(t time.Time, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// S3Error 对象存储返回的错误
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("storage: s3 error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// 读取错误响应
+func responseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+	e := &S3Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(data, e)
+	return e
+}
+
+func (s *S3) Put(key string, r io.Reader, size int64, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	// 内容为空时使用NoBody，请求中仍会带上为0的Content-Length
+	if size == 0 {
+		r = http.NoBody
+	}
+	resp, err := s.do(http.MethodPut, key, nil, r, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (s *S3) Open(key string) (io.ReadCloser, Info, error) {
+	if !ValidKey(key) {
+		return nil, Info{}, ErrInvalidKey
+	}
+	resp, err := s.do(http.MethodGet, key, nil, nil, 0, nil)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, Info{}, responseError(resp)
+	}
+	return resp.Body, headerInfo(key, resp), nil
+}
+
+func (s *S3) Stat(key string) (Info, error) {
+	if !ValidKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil, 0, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, responseError(resp)
+	}
+	return headerInfo(key, resp), nil
+}
+
+func headerInfo(key string, resp *http.Response) Info {
+	info := Info{Key: key, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info
+}
+
+func (s *S3) Delete(key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp)
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(prefix string, fn func(Info) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := responseError(resp)
+			resp.Body.Close()
+			if err == ErrNotExist {
+				return fmt.Errorf("storage: s3 bucket %q does not exist", s.opts.Bucket)
+			}
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, obj := range result.Contents {
+			if err := fn(Info{Key: obj.Key, Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// SignedURL 生成预签名的下载链接，客户端直接从对象存储下载，最长有效期为7天
+func (s *S3) SignedURL(key string, ttl time.Duration, downloadName string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	if ttl <= 0 || ttl > maxPresignTTL {
+		return "", fmt.Errorf("storage: invalid signed url ttl %s", ttl)
+	}
+	now := time.Now().UTC()
+	scope := s.scope(now)
+	u := s.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.opts.AccessKey + "/" + scope},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if downloadName != "" {
+		query.Set("response-content-disposition", Attachment(downloadName))
+	}
+	u.RawQuery = canonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, escapePath(u.Path), u.RawQuery, "host:" + u.Host + "\n", "host", unsignedPayload,
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + s.signature(now, scope, canonicalRequest)
+	u.RawPath = escapePath(u.Path)
+	return u.String(), nil
+}
+
+// 按签名规则编码：只保留字母、数字和-._~
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || keepSlash && c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func escapePath(p string) string {
+	return awsEscape(p, true)
+}
+
+// 按参数名排序并编码的查询字符串
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/s3test"
+)
+
+// 启动本地对象存储并创建客户端
+func newTestS3(t *testing.T) *S3 {
+	t.Helper()
+	_, server := s3test.NewServer("access", "secret", "blog")
+	t.Cleanup(server.Close)
+	s, err := NewS3(S3Options{Endpoint: server.URL, Bucket: "blog", AccessKey: "access", SecretKey: "secret", PathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func readAll(t *testing.T, s Storage, key string) (string, Info) {
+	t.Helper()
+	r, info, err := s.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), info
+}
+
+func TestS3PutOpenDelete(t *testing.T) {
+	s := newTestS3(t)
+	key := "media/ab/测试 文件.txt"
+	if err := s.Put(key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	data, info := readAll(t, s, key)
+	if data != "hello" || info.Size != 5 || info.ContentType != "text/plain" || info.ModTime.IsZero() {
+		t.Fatalf("Open = %q, %+v", data, info)
+	}
+	// 大小未知的内容先读入内存，空文件同样可以写入
+	if err := s.Put("media/unknown", strings.NewReader("unknown size"), -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("media/empty", strings.NewReader(""), 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Stat("media/empty"); err != nil || info.Size != 0 {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Open(key); err != ErrNotExist {
+		t.Fatalf("删除后Open = %v", err)
+	}
+	if _, err := s.Stat(key); err != ErrNotExist {
+		t.Fatalf("删除后Stat = %v", err)
+	}
+	// 删除不存在的文件不返回错误
+	if err := s.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("../escape", strings.NewReader("x"), 1, ""); err != ErrInvalidKey {
+		t.Fatalf("非法键名 = %v", err)
+	}
+}
+
+func TestS3WrongCredentials(t *testing.T) {
+	_, server := s3test.NewServer("access", "secret", "blog")
+	defer server.Close()
+	s, _ := NewS3(S3Options{Endpoint: server.URL, Bucket: "blog", AccessKey: "access", SecretKey: "wrong", PathStyle: true})
+	err := s.Put("media/a", strings.NewReader("x"), 1, "")
+	if e, ok := err.(*S3Error); !ok || e.StatusCode != http.StatusForbidden {
+		t.Fatalf("错误的密钥 = %v", err)
+	}
+}
+
+func TestS3List(t *testing.T) {
+	s := newTestS3(t)
+	// 超过一页的数量，需要使用continuation-token继续列举
+	const n = 1005
+	for i := 0; i < n; i++ {
+		if err := s.Put(fmt.Sprintf("media/%04d", i), strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Put("avatars/1.png", strings.NewReader("png"), 3, "image/png")
+
+	var keys []string
+	if err := s.List("media/", func(info Info) error {
+		keys = append(keys, info.Key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != n || keys[0] != "media/0000" || keys[n-1] != fmt.Sprintf("media/%04d", n-1) {
+		t.Fatalf("列举了%d个文件", len(keys))
+	}
+	// fn返回错误时停止
+	stop := fmt.Errorf("stop")
+	count := 0
+	err := s.List("", func(Info) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Fatalf("List = %v, %d", err, count)
+	}
+
+	missing, _ := NewS3(S3Options{Endpoint: s.opts.Endpoint, Bucket: "missing", AccessKey: "access", SecretKey: "secret", PathStyle: true})
+	if err := missing.List("", func(Info) error { return nil }); err == nil {
+		t.Fatal("不存在的存储桶没有返回错误")
+	}
+}
+
+func TestS3SignedURL(t *testing.T) {
+	s := newTestS3(t)
+	key := "exports/1/数据 导出.zip"
+	s.Put(key, strings.NewReader("archive"), 7, "application/zip")
+
+	link, err := s.SignedURL(key, time.Minute, "导出.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "archive" {
+		t.Fatalf("签名链接 = %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Disposition") != Attachment("导出.zip") {
+		t.Fatalf("Content-Disposition = %q", resp.Header.Get("Content-Disposition"))
+	}
+
+	// 修改下载文件名后签名失效
+	tampered := strings.Replace(link, "response-content-disposition=", "response-content-disposition=x", 1)
+	if resp, err := http.Get(tampered); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("篡改的签名链接 = %v, %v", resp.StatusCode, err)
+	}
+
+	for _, ttl := range []time.Duration{0, -time.Second, 8 * 24 * time.Hour} {
+		if _, err := s.SignedURL(key, ttl, ""); err == nil {
+			t.Errorf("有效期%s没有返回错误", ttl)
+		}
+	}
+}
+
+func TestS3SignedURLExpiry(t *testing.T) {
+	s := newTestS3(t)
+	s.Put("media/a.txt", strings.NewReader("a"), 1, "text/plain")
+	link, err := s.SignedURL("media/a.txt", time.Second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// X-Amz-Date精确到秒，等待超过有效期
+	time.Sleep(2100 * time.Millisecond)
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("过期的签名链接 = %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,79 @@
+// Package storage 上传文件的存储，支持本地目录和兼容S3协议的对象存储。
+//
+// 文件使用以/分隔的键名标识，例如 avatars/1-abc.png、media/ab/<哈希>.jpg。
+// 私有文件不能直接访问，通过SignedURL生成有时效的签名链接下载。
+package storage
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"time"
+)
+
+// ErrNotExist 文件不存在
+var ErrNotExist = errors.New("storage: file does not exist")
+
+// ErrInvalidKey 键名不合法
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Info 文件的元数据
+type Info struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Storage 文件存储
+type Storage interface {
+	// Put 写入文件，文件已存在时覆盖。size为内容的字节数
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Open 读取文件，文件不存在时返回ErrNotExist
+	Open(key string) (io.ReadCloser, Info, error)
+	// Stat 获取文件的元数据，文件不存在时返回ErrNotExist
+	Stat(key string) (Info, error)
+	// Delete 删除文件，文件不存在时不返回错误
+	Delete(key string) error
+	// List 遍历键名以prefix开头的全部文件，fn返回错误时停止遍历
+	List(prefix string, fn func(Info) error) error
+	// SignedURL 生成在ttl内有效的下载链接，downloadName不为空时作为下载的文件名
+	SignedURL(key string, ttl time.Duration, downloadName string) (string, error)
+}
+
+// ValidKey 检查键名：不能为空、不能以/开头或结尾，不能包含空的、.或..路径段以及反斜杠和控制字符
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 1024 || strings.ContainsAny(key, "\\") {
+		return false
+	}
+	for _, r := range key {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy 将文件从src复制到dst
+func Copy(src, dst Storage, key string) (Info, error) {
+	r, info, err := src.Open(key)
+	if err != nil {
+		return info, err
+	}
+	defer r.Close()
+	return info, dst.Put(key, r, info.Size, info.ContentType)
+}
+
+// Attachment 下载文件时使用的Content-Disposition
+func Attachment(name string) string {
+	if name == "" {
+		return "attachment"
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
@@ -0,0 +1,130 @@
+// Package theme 使用html/template渲染公开页面的主题。
+//
+// 主题目录的结构：
+//
+//	layouts/base.html    页面的整体布局，定义名为base的模板，在其中引用content模板
+//	layouts/<页面>.html   各页面的内容，定义名为content的模板，可以重新定义head模板添加额外的标签
+//	partials/*.html      页面中共用的模板片段
+//	assets/              样式、脚本和图片等静态资源，通过/theme/访问
+package theme
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaohan1995/Gin-blog/service"
+)
+
+// AssetsPath 主题静态资源的访问路径
+const AssetsPath = "/theme"
+
+// Theme 加载后的主题
+type Theme struct {
+	dir string
+	// 开发模式下每次渲染前重新加载模板
+	reload bool
+	// 页面中链接的前缀，为空时使用以/开头的站内地址
+	baseURL string
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+// New 加载dir目录中的主题，baseURL为页面中链接的前缀，reload为true时每次渲染前重新加载模板
+func New(dir string, reload bool, baseURL string) (*Theme, error) {
+	t := &Theme{dir: dir, reload: reload, baseURL: strings.TrimSuffix(baseURL, "/")}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// 模板中可以使用的函数
+func (t *Theme) funcs() template.FuncMap {
+	return template.FuncMap{
+		"url":       t.URL,
+		"postURL":   func(id uint) string { return t.URL(service.PostPath(id)) },
+		"tagURL":    func(slug string) string { return t.URL(service.TagPath(slug)) },
+		"authorURL": func(username string) string { return t.URL(service.AuthorPath(username)) },
+		"asset":     func(name string) string { return t.URL(AssetsPath + "/" + strings.TrimPrefix(name, "/")) },
+		"markdown":  service.RenderMarkdown,
+		"date": func(t time.Time, layout string) string {
+			return t.Format(layout)
+		},
+		"year": func() int { return time.Now().Year() },
+	}
+}
+
+// URL 站内地址加上链接前缀
+func (t *Theme) URL(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
+	return t.baseURL + path
+}
+
+// 解析全部页面模板，每个页面模板和布局、公共片段组成一个模板集合
+func (t *Theme) load() error {
+	base := filepath.Join(t.dir, "layouts", "base.html")
+	layouts, err := filepath.Glob(filepath.Join(t.dir, "layouts", "*.html"))
+	if err != nil {
+		return err
+	}
+	partials, err := filepath.Glob(filepath.Join(t.dir, "partials", "*.html"))
+	if err != nil {
+		return err
+	}
+	pages := map[string]*template.Template{}
+	for _, layout := range layouts {
+		if layout == base {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(layout), ".html")
+		files := append([]string{base}, partials...)
+		files = append(files, layout)
+		tmpl, err := template.New(name).Funcs(t.funcs()).ParseFiles(files...)
+		if err != nil {
+			return fmt.Errorf("解析主题模板%s失败: %w", name, err)
+		}
+		pages[name] = tmpl
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("主题目录%s中没有页面模板", t.dir)
+	}
+	t.mu.Lock()
+	t.pages = pages
+	t.mu.Unlock()
+	return nil
+}
+
+// Render 渲染页面，先渲染到缓冲区，出错时不会输出不完整的页面
+func (t *Theme) Render(w io.Writer, page string, data interface{}) error {
+	if t.reload {
+		if err := t.load(); err != nil {
+			return err
+		}
+	}
+	t.mu.RLock()
+	tmpl, ok := t.pages[page]
+	t.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("主题中没有页面模板%s", page)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "base", data); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// AssetsDir 主题静态资源所在的目录
+func (t *Theme) AssetsDir() string {
+	return filepath.Join(t.dir, "assets")
+}